@@ -3,6 +3,7 @@ module server
 go 1.23.0
 
 require (
+	github.com/beevik/etree v1.7.0
 	github.com/emersion/go-ical v0.0.0-20240127095438-fc1c9d8fb2b6
 	github.com/emersion/go-webdav v0.6.0
 	github.com/go-playground/validator v9.31.0+incompatible
@@ -10,6 +11,9 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
+	github.com/russellhaering/goxmldsig v1.6.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/teambition/rrule-go v1.8.2
 	golang.org/x/crypto v0.32.0
 	golang.org/x/oauth2 v0.25.0
 )
@@ -17,7 +21,7 @@ require (
 require (
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
+	github.com/jonboulle/clockwork v0.5.0 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
-	github.com/teambition/rrule-go v1.8.2 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 )
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+type APITokenRepository struct {
+}
+
+type APITokenScope string
+
+const (
+	APITokenScopeBookingsRead  APITokenScope = "bookings:read"
+	APITokenScopeBookingsWrite APITokenScope = "bookings:write"
+	APITokenScopeAdminSpaces   APITokenScope = "admin:spaces"
+)
+
+var APITokenScopes = []APITokenScope{
+	APITokenScopeBookingsRead,
+	APITokenScopeBookingsWrite,
+	APITokenScopeAdminSpaces,
+}
+
+// APITokenPrefix distinguishes personal API tokens from JWT access tokens in
+// the Authorization header.
+const APITokenPrefix = "sst_"
+
+// APIToken is a long-lived personal access token. Only a hash of the token is
+// stored, the plain token is shown once on creation.
+type APIToken struct {
+	ID             string
+	UserID         string
+	OrganizationID string
+	Name           string
+	TokenHash      string
+	Scopes         []APITokenScope
+	Created        time.Time
+	Expires        *time.Time
+	LastUsed       *time.Time
+}
+
+var apiTokenRepository *APITokenRepository
+var apiTokenRepositoryOnce sync.Once
+
+func GetAPITokenRepository() *APITokenRepository {
+	apiTokenRepositoryOnce.Do(func() {
+		apiTokenRepository = &APITokenRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS api_tokens (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"user_id uuid NOT NULL, " +
+			"organization_id uuid NOT NULL, " +
+			"name VARCHAR NOT NULL, " +
+			"token_hash VARCHAR NOT NULL, " +
+			"scopes VARCHAR NOT NULL, " +
+			"created TIMESTAMP NOT NULL, " +
+			"expires TIMESTAMP NULL, " +
+			"last_used TIMESTAMP NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens(token_hash)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return apiTokenRepository
+}
+
+func (r *APITokenRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *APITokenRepository) Create(e *APIToken) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO api_tokens "+
+		"(user_id, organization_id, name, token_hash, scopes, created, expires) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) "+
+		"RETURNING id",
+		e.UserID, e.OrganizationID, e.Name, e.TokenHash, r.joinScopes(e.Scopes), e.Created, e.Expires).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *APITokenRepository) GetOne(id string) (*APIToken, error) {
+	return r.getOne("WHERE id = $1", id)
+}
+
+// GetByToken returns the token matching the plain token specified.
+func (r *APITokenRepository) GetByToken(token string) (*APIToken, error) {
+	return r.getOne("WHERE token_hash = $1", GetAPITokenHash(token))
+}
+
+func (r *APITokenRepository) getOne(where string, arg string) (*APIToken, error) {
+	e := &APIToken{}
+	var scopes string
+	err := GetDatabase().DB().QueryRow("SELECT id, user_id, organization_id, name, token_hash, scopes, created, expires, last_used "+
+		"FROM api_tokens "+
+		where,
+		arg).Scan(&e.ID, &e.UserID, &e.OrganizationID, &e.Name, &e.TokenHash, &scopes, &e.Created, &e.Expires, &e.LastUsed)
+	if err != nil {
+		return nil, err
+	}
+	e.Scopes = r.splitScopes(scopes)
+	return e, nil
+}
+
+func (r *APITokenRepository) GetAllByUser(userID string) ([]*APIToken, error) {
+	return r.getAll("WHERE user_id = $1", userID)
+}
+
+func (r *APITokenRepository) GetAllByOrganization(organizationID string) ([]*APIToken, error) {
+	return r.getAll("WHERE organization_id = $1", organizationID)
+}
+
+func (r *APITokenRepository) getAll(where string, arg string) ([]*APIToken, error) {
+	var result []*APIToken
+	rows, err := GetDatabase().DB().Query("SELECT id, user_id, organization_id, name, token_hash, scopes, created, expires, last_used "+
+		"FROM api_tokens "+
+		where+" "+
+		"ORDER BY created", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &APIToken{}
+		var scopes string
+		err = rows.Scan(&e.ID, &e.UserID, &e.OrganizationID, &e.Name, &e.TokenHash, &scopes, &e.Created, &e.Expires, &e.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		e.Scopes = r.splitScopes(scopes)
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *APITokenRepository) UpdateLastUsed(e *APIToken, lastUsed time.Time) error {
+	_, err := GetDatabase().DB().Exec("UPDATE api_tokens SET last_used = $1 WHERE id = $2", lastUsed, e.ID)
+	return err
+}
+
+func (r *APITokenRepository) Delete(e *APIToken) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM api_tokens WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *APITokenRepository) DeleteOfUser(u *User) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM api_tokens WHERE user_id = $1", u.ID)
+	return err
+}
+
+func (r *APITokenRepository) joinScopes(scopes []APITokenScope) string {
+	list := []string{}
+	for _, scope := range scopes {
+		list = append(list, string(scope))
+	}
+	return strings.Join(list, ",")
+}
+
+func (r *APITokenRepository) splitScopes(s string) []APITokenScope {
+	list := []APITokenScope{}
+	for _, scope := range strings.Split(s, ",") {
+		if scope != "" {
+			list = append(list, APITokenScope(scope))
+		}
+	}
+	return list
+}
+
+// HasScope returns true if the token has been granted the scope.
+func (e *APIToken) HasScope(scope APITokenScope) bool {
+	for _, s := range e.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *APIToken) IsExpired(now time.Time) bool {
+	return e.Expires != nil && !now.Before(*e.Expires)
+}
+
+// GetAPIToken returns a new random plain token.
+func GetAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// GetAPITokenHash returns the hash stored for a plain token. As tokens are
+// random with enough entropy, a fast unsalted hash is sufficient.
+func GetAPITokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type APITokenRouter struct {
+}
+
+type CreateAPITokenRequest struct {
+	Name    string     `json:"name" validate:"required"`
+	Scopes  []string   `json:"scopes" validate:"required"`
+	Expires *time.Time `json:"expires"`
+}
+
+type GetAPITokenResponse struct {
+	ID       string     `json:"id"`
+	UserID   string     `json:"userId"`
+	Name     string     `json:"name"`
+	Scopes   []string   `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	Expires  *time.Time `json:"expires"`
+	LastUsed *time.Time `json:"lastUsed"`
+}
+
+type CreateAPITokenResponse struct {
+	Token string `json:"token"`
+	GetAPITokenResponse
+}
+
+func (router *APITokenRouter) setupRoutes(s *mux.Router) {
+	RequirePermission(s.HandleFunc("/org", router.getAllForOrg).Methods("GET"), PermissionOrgAdmin)
+	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
+	s.HandleFunc("/", router.create).Methods("POST")
+	s.HandleFunc("/", router.getAll).Methods("GET")
+}
+
+func (router *APITokenRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	list, err := GetAPITokenRepository().GetAllByUser(GetRequestUserID(r))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	router.sendList(w, list)
+}
+
+func (router *APITokenRouter) getAllForOrg(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	list, err := GetAPITokenRepository().GetAllByOrganization(user.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	router.sendList(w, list)
+}
+
+// create issues a new token for the requesting user. Tokens can't be issued
+// for other users, as this would allow admins to act on their behalf. The
+// plain token is only returned in the response to this request.
+func (router *APITokenRouter) create(w http.ResponseWriter, r *http.Request) {
+	var m CreateAPITokenRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	if !router.isValidRequest(&m, user) {
+		SendBadRequest(w)
+		return
+	}
+	plainToken, err := GetAPIToken()
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	e := &APIToken{
+		UserID:         user.ID,
+		OrganizationID: user.OrganizationID,
+		Name:           m.Name,
+		TokenHash:      GetAPITokenHash(plainToken),
+		Created:        time.Now().UTC(),
+	}
+	for _, scope := range m.Scopes {
+		e.Scopes = append(e.Scopes, APITokenScope(scope))
+	}
+	if m.Expires != nil {
+		expires := m.Expires.UTC()
+		e.Expires = &expires
+	}
+	if err := GetAPITokenRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityAPIToken, e.ID, nil, router.copyToRestModel(e))
+	res := &CreateAPITokenResponse{
+		Token:               plainToken,
+		GetAPITokenResponse: *router.copyToRestModel(e),
+	}
+	w.Header().Set("X-Object-ID", e.ID)
+	SendJSONWithStatus(w, http.StatusCreated, res)
+}
+
+func (router *APITokenRouter) delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := GetAPITokenRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	user := GetRequestUser(r)
+	if e.UserID != user.ID && !CanAdminOrg(user, e.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	if err := GetAPITokenRepository().Delete(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityAPIToken, e.ID, router.copyToRestModel(e), nil)
+	SendUpdated(w)
+}
+
+// isValidRequest checks the requested scopes. Scopes beyond the permissions of
+// the token's user can't be granted.
+func (router *APITokenRouter) isValidRequest(m *CreateAPITokenRequest, tokenUser *User) bool {
+	if len(m.Scopes) == 0 {
+		return false
+	}
+	if m.Expires != nil && !m.Expires.After(time.Now()) {
+		return false
+	}
+	for _, scope := range m.Scopes {
+		if !router.isValidScope(scope) {
+			return false
+		}
+		if !router.canGrantScope(tokenUser, APITokenScope(scope)) {
+			return false
+		}
+	}
+	return true
+}
+
+// canGrantScope returns true if the user has been granted at least one of the
+// permissions covered by the scope.
+func (router *APITokenRouter) canGrantScope(tokenUser *User, scope APITokenScope) bool {
+	for _, permission := range apiTokenScopePermissions[scope] {
+		if HasPermission(tokenUser, nil, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+func (router *APITokenRouter) isValidScope(scope string) bool {
+	for _, s := range APITokenScopes {
+		if string(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (router *APITokenRouter) sendList(w http.ResponseWriter, list []*APIToken) {
+	res := []*GetAPITokenResponse{}
+	for _, e := range list {
+		m := router.copyToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *APITokenRouter) copyToRestModel(e *APIToken) *GetAPITokenResponse {
+	m := &GetAPITokenResponse{}
+	m.ID = e.ID
+	m.UserID = e.UserID
+	m.Name = e.Name
+	m.Scopes = []string{}
+	for _, scope := range e.Scopes {
+		m.Scopes = append(m.Scopes, string(scope))
+	}
+	m.Created = e.Created
+	m.Expires = e.Expires
+	m.LastUsed = e.LastUsed
+	return m
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func createAPITokenTestToken(t *testing.T, user *User, scopes string) *CreateAPITokenResponse {
+	payload := `{"name": "Test", "scopes": [` + scopes + `]}`
+	req := newHTTPRequest("POST", "/api-token/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var resBody *CreateAPITokenResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	return resBody
+}
+
+func TestAPITokensCreateAndUse(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	token := createAPITokenTestToken(t, user, `"bookings:read"`)
+	checkTestBool(t, true, len(token.Token) > len(APITokenPrefix))
+	checkTestString(t, "bookings:read", token.Scopes[0])
+
+	// Only the hash is stored
+	e, _ := GetAPITokenRepository().GetOne(token.ID)
+	checkTestString(t, GetAPITokenHash(token.Token), e.TokenHash)
+	checkTestBool(t, true, e.TokenHash != token.Token)
+
+	req := newHTTPRequestWithAccessToken("GET", "/booking/", token.Token, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/user/me", token.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var me *GetUserResponse
+	json.Unmarshal(res.Body.Bytes(), &me)
+	checkTestString(t, user.ID, me.ID)
+
+	// Missing scope
+	payload := `{"spaceId": "` + s.ID + `", "enter": "2030-09-02T08:00:00Z", "leave": "2030-09-02T17:00:00Z"}`
+	req = newHTTPRequestWithAccessToken("POST", "/booking/", token.Token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Routes not covered by any scope
+	req = newHTTPRequestWithAccessToken("GET", "/api-token/", token.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/booking/", APITokenPrefix+"invalid", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	e, _ = GetAPITokenRepository().GetOne(token.ID)
+	checkTestBool(t, true, e.LastUsed != nil)
+}
+
+func TestAPITokensWriteScope(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	token := createAPITokenTestToken(t, user, `"bookings:read", "bookings:write"`)
+
+	payload := `{"spaceId": "` + s.ID + `", "enter": "2030-09-02T08:00:00Z", "leave": "2030-09-02T17:00:00Z"}`
+	req := newHTTPRequestWithAccessToken("POST", "/booking/", token.Token, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id := res.Header().Get("X-Object-Id")
+
+	booking, _ := GetBookingRepository().GetOne(id)
+	checkTestString(t, user.ID, booking.UserID)
+
+	// Space admin routes require admin:spaces
+	payload = `{"name": "Test"}`
+	req = newHTTPRequestWithAccessToken("POST", "/location/", token.Token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestAPITokensAdminSpacesScope(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+
+	// Scopes can't exceed the user's permissions
+	payload := `{"name": "Test", "scopes": ["admin:spaces"]}`
+	req := newHTTPRequest("POST", "/api-token/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	token := createAPITokenTestToken(t, admin, `"admin:spaces"`)
+	payload = `{"name": "Test"}`
+	req = newHTTPRequestWithAccessToken("POST", "/location/", token.Token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+}
+
+func TestAPITokensInvalid(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+
+	payloads := []string{
+		`{"name": "Test", "scopes": []}`,
+		`{"name": "Test", "scopes": ["unknown"]}`,
+		`{"name": "Test", "scopes": ["bookings:read"], "expires": "2000-01-01T00:00:00Z"}`,
+		`{"scopes": ["bookings:read"]}`,
+	}
+	for _, payload := range payloads {
+		req := newHTTPRequest("POST", "/api-token/", user.ID, bytes.NewBufferString(payload))
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestAPITokensExpired(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	token := createAPITokenTestToken(t, user, `"bookings:read"`)
+
+	expires := time.Now().UTC().Add(-time.Minute)
+	GetDatabase().DB().Exec("UPDATE api_tokens SET expires = $1 WHERE id = $2", expires, token.ID)
+	req := newHTTPRequestWithAccessToken("GET", "/booking/", token.Token, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestAPITokensUserDisabled(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	token := createAPITokenTestToken(t, user, `"bookings:read"`)
+
+	req := newHTTPRequestWithAccessToken("GET", "/booking/", token.Token, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	user.Disabled = true
+	GetUserRepository().Update(user)
+	req = newHTTPRequestWithAccessToken("GET", "/booking/", token.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	banExpiry := time.Now().Add(time.Minute)
+	user.Disabled = false
+	user.BanExpiry = &banExpiry
+	GetUserRepository().Update(user)
+	req = newHTTPRequestWithAccessToken("GET", "/booking/", token.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestAPITokensListAndRevoke(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	token := createAPITokenTestToken(t, user, `"bookings:read"`)
+	createAPITokenTestToken(t, user2, `"bookings:read"`)
+
+	req := newHTTPRequest("GET", "/api-token/", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetAPITokenResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, token.ID, resBody[0].ID)
+
+	req = newHTTPRequest("GET", "/api-token/org", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/api-token/org", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 2, len(resBody))
+
+	// Other users can't revoke the token
+	req = newHTTPRequest("DELETE", "/api-token/"+token.ID, user2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/api-token/"+token.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/booking/", token.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestAPITokensCreateForOtherUser(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+
+	// Tokens are always issued for the requesting user
+	payload := `{"name": "Test", "scopes": ["bookings:read"], "userId": "` + user.ID + `"}`
+	req := newHTTPRequest("POST", "/api-token/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var resBody *CreateAPITokenResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, admin.ID, resBody.UserID)
+
+	req = newHTTPRequest("GET", "/api-token/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var list []*GetAPITokenResponse
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 0, len(list))
+}
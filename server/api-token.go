@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	ErrAPITokenInvalid = errors.New("API token verification failed: invalid token")
+	ErrAPITokenExpired = errors.New("API token verification failed: token expired")
+	ErrAPITokenBanned  = errors.New("API token verification failed: user disabled")
+)
+
+// APITokenLastUsedInterval limits how often the last usage of a token is
+// written to the database.
+const APITokenLastUsedInterval = time.Minute * 5
+
+// verifyAPIToken returns the API token matching the plain token specified and
+// its user if it exists, hasn't expired and the user is neither disabled nor
+// banned.
+func verifyAPIToken(token string) (*APIToken, *User, error) {
+	e, err := GetAPITokenRepository().GetByToken(token)
+	if err != nil {
+		return nil, nil, ErrAPITokenInvalid
+	}
+	now := time.Now().UTC()
+	if e.IsExpired(now) {
+		return nil, nil, ErrAPITokenExpired
+	}
+	user, err := GetUserRepository().GetOne(e.UserID)
+	if err != nil {
+		return nil, nil, ErrAPITokenInvalid
+	}
+	if user.Disabled || (user.BanExpiry != nil && user.BanExpiry.After(now)) {
+		return nil, nil, ErrAPITokenBanned
+	}
+	if e.LastUsed == nil || e.LastUsed.Add(APITokenLastUsedInterval).Before(now) {
+		if err := GetAPITokenRepository().UpdateLastUsed(e, now); err != nil {
+			log.Println(err)
+		}
+	}
+	return e, user, nil
+}
@@ -27,8 +27,15 @@ func GetApp() *App {
 }
 
 type App struct {
-	Router        *mux.Router
-	CleanupTicker *time.Ticker
+	Router          *mux.Router
+	CleanupTicker   *time.Ticker
+	CheckInTicker   *time.Ticker
+	CalDavTicker    *time.Ticker
+	ReconcileTicker *time.Ticker
+	ReminderTicker  *time.Ticker
+	WebhookTicker   *time.Ticker
+	ChatTicker      *time.Ticker
+	DigestTicker    *time.Ticker
 }
 
 func (a *App) InitializeDatabases() {
@@ -45,6 +52,8 @@ func (a *App) InitializeRouter() {
 	routers["/location/"] = &LocationRouter{}
 	routers["/booking/"] = &BookingRouter{}
 	routers["/buddy/"] = &BuddyRouter{}
+	routers["/waitlist/"] = &WaitlistRouter{}
+	routers["/ical/"] = &ICalRouter{}
 	routers["/organization/"] = &OrganizationRouter{}
 	routers["/auth-provider/"] = &AuthProviderRouter{}
 	routers["/auth/"] = &AuthRouter{}
@@ -55,6 +64,12 @@ func (a *App) InitializeRouter() {
 	routers["/setting/"] = &SettingsRouter{}
 	routers["/space-attribute/"] = &SpaceAttributeRouter{}
 	routers["/confluence/"] = &ConfluenceRouter{}
+	routers["/webhook/"] = &WebhookRouter{}
+	routers["/audit-log/"] = &AuditLogRouter{}
+	routers["/api-token/"] = &APITokenRouter{}
+	routers["/group/"] = &GroupRouter{}
+	routers["/scim-token/"] = &SCIMTokenRouter{}
+	routers["/scim/v2/"] = &SCIMRouter{}
 	routers["/uc/"] = &CheckUpdateRouter{}
 	if config.OrgSignupEnabled {
 		routers["/signup/"] = &SignupRouter{}
@@ -71,6 +86,7 @@ func (a *App) InitializeRouter() {
 	a.Router.PathPrefix("/").Methods("OPTIONS").HandlerFunc(CorsHandler)
 	a.Router.Use(CorsMiddleware)
 	a.Router.Use(VerifyAuthMiddleware)
+	a.Router.Use(VerifyPermissionMiddleware)
 }
 
 func (a *App) RedirectRootPath(w http.ResponseWriter, r *http.Request) {
@@ -118,6 +134,27 @@ func (a *App) InitializeTimers() {
 			if err := GetRefreshTokenRepository().DeleteExpired(); err != nil {
 				log.Println(err)
 			}
+			if err := GetWaitlistRepository().DeleteExpired(); err != nil {
+				log.Println(err)
+			}
+			if err := GetBookingSeriesRepository().DeleteOrphaned(); err != nil {
+				log.Println(err)
+			}
+			if err := GetCalDAVSyncRepository().DeleteExpired(); err != nil {
+				log.Println(err)
+			}
+			if err := GetCalDAVLogRepository().DeleteExpired(); err != nil {
+				log.Println(err)
+			}
+			if err := GetBookingReminderRepository().DeleteExpired(); err != nil {
+				log.Println(err)
+			}
+			if err := GetWebhookDeliveryRepository().DeleteExpired(); err != nil {
+				log.Println(err)
+			}
+			if err := GetChatMessageRepository().DeleteExpired(); err != nil {
+				log.Println(err)
+			}
 			if err := GetUserRepository().enableUsersWithExpiredBan(); err != nil {
 				log.Println(err)
 			}
@@ -130,6 +167,89 @@ func (a *App) InitializeTimers() {
 			}
 		}
 	}()
+	a.CheckInTicker = time.NewTicker(time.Minute * 1)
+	go func() {
+		for {
+			<-a.CheckInTicker.C
+			bookingRouter := &BookingRouter{}
+			num, err := bookingRouter.releaseNoShowBookings()
+			if err != nil {
+				log.Println(err)
+			}
+			if num > 0 {
+				log.Printf("Released %d bookings without check-in", num)
+			}
+		}
+	}()
+	a.ReminderTicker = time.NewTicker(time.Minute * 1)
+	go func() {
+		for {
+			<-a.ReminderTicker.C
+			bookingRouter := &BookingRouter{}
+			num, err := bookingRouter.sendBookingReminders()
+			if err != nil {
+				log.Println(err)
+			}
+			if num > 0 {
+				log.Printf("Sent %d booking reminders", num)
+			}
+		}
+	}()
+	a.CalDavTicker = time.NewTicker(time.Second * 15)
+	go func() {
+		for {
+			<-a.CalDavTicker.C
+			bookingRouter := &BookingRouter{}
+			if _, err := bookingRouter.processCalDavSyncQueue(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	a.WebhookTicker = time.NewTicker(time.Second * 15)
+	go func() {
+		for {
+			<-a.WebhookTicker.C
+			if _, err := processWebhookQueue(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	a.ChatTicker = time.NewTicker(time.Second * 15)
+	go func() {
+		for {
+			<-a.ChatTicker.C
+			if _, err := processChatMessageQueue(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	a.DigestTicker = time.NewTicker(time.Minute * 1)
+	go func() {
+		for {
+			<-a.DigestTicker.C
+			num, err := sendLocationDigests()
+			if err != nil {
+				log.Println(err)
+			}
+			if num > 0 {
+				log.Printf("Queued %d daily location digests", num)
+			}
+		}
+	}()
+	a.ReconcileTicker = time.NewTicker(time.Minute * 15)
+	go func() {
+		for {
+			<-a.ReconcileTicker.C
+			bookingRouter := &BookingRouter{}
+			num, err := bookingRouter.reconcileCalDav()
+			if err != nil {
+				log.Println(err)
+			}
+			if num > 0 {
+				log.Printf("Reconciled %d bookings with CalDAV calendars", num)
+			}
+		}
+	}()
 }
 
 func (a *App) bookingUIProxyHandler(w http.ResponseWriter, r *http.Request) {
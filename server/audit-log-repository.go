@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+type AuditLogRepository struct {
+}
+
+const (
+	AuditActionCreate         = "create"
+	AuditActionUpdate         = "update"
+	AuditActionDelete         = "delete"
+	AuditActionBookOnBehalf   = "book_on_behalf"
+	AuditActionSetPassword    = "set_password"
+	AuditActionVerify         = "verify"
+	AuditActionMerge          = "merge"
+	AuditActionLoadSampleData = "load_sample_data"
+	AuditActionRotate         = "rotate"
+	AuditActionEnableTOTP     = "enable_totp"
+	AuditActionDisableTOTP    = "disable_totp"
+)
+
+const (
+	AuditEntityAPIToken             = "api_token"
+	AuditEntityAuthProvider         = "auth_provider"
+	AuditEntityAuthProviderRule     = "auth_provider_rule"
+	AuditEntityBooking              = "booking"
+	AuditEntityBuddy                = "buddy"
+	AuditEntityDomain               = "domain"
+	AuditEntityGroup                = "group"
+	AuditEntityGroupMember          = "group_member"
+	AuditEntityGroupRestriction     = "group_restriction"
+	AuditEntityICalFeed             = "ical_feed"
+	AuditEntityLocation             = "location"
+	AuditEntityLocationAdmin        = "location_admin"
+	AuditEntityLocationAttribute    = "location_attribute"
+	AuditEntityLocationNotification = "location_notification"
+	AuditEntityOrganization         = "organization"
+	AuditEntitySCIMToken            = "scim_token"
+	AuditEntitySetting              = "setting"
+	AuditEntitySpace                = "space"
+	AuditEntitySpaceAttribute       = "space_attribute"
+	AuditEntityUser                 = "user"
+	AuditEntityWaitlist             = "waitlist"
+	AuditEntityWebhook              = "webhook"
+)
+
+// AuditLogEntry records a single change. Before and After hold a JSON
+// representation of the entity or are empty if not applicable. The actor's
+// email address is stored as well, so that entries remain meaningful after
+// the user has been deleted.
+type AuditLogEntry struct {
+	ID             string
+	OrganizationID string
+	ActorUserID    string
+	ActorEmail     string
+	Action         string
+	EntityType     string
+	EntityID       string
+	Before         string
+	After          string
+	Timestamp      time.Time
+	IPAddress      string
+}
+
+type AuditLogFilter struct {
+	ActorUserID string
+	Action      string
+	EntityType  string
+	EntityID    string
+	From        *time.Time
+	To          *time.Time
+	Limit       int
+	Offset      int
+}
+
+var auditLogRepository *AuditLogRepository
+var auditLogRepositoryOnce sync.Once
+
+func GetAuditLogRepository() *AuditLogRepository {
+	auditLogRepositoryOnce.Do(func() {
+		auditLogRepository = &AuditLogRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS audit_log (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"organization_id uuid NOT NULL, " +
+			"actor_user_id VARCHAR NOT NULL DEFAULT '', " +
+			"actor_email VARCHAR NOT NULL DEFAULT '', " +
+			"action VARCHAR NOT NULL, " +
+			"entity_type VARCHAR NOT NULL, " +
+			"entity_id VARCHAR NOT NULL DEFAULT '', " +
+			"before_data TEXT NOT NULL DEFAULT '', " +
+			"after_data TEXT NOT NULL DEFAULT '', " +
+			"timestamp TIMESTAMP NOT NULL, " +
+			"ip_address VARCHAR NOT NULL DEFAULT '', " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_audit_log_organization_id_timestamp ON audit_log(organization_id, timestamp)")
+		if err != nil {
+			panic(err)
+		}
+		// Entries must never be changed once they have been written
+		_, err = GetDatabase().DB().Exec("CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return auditLogRepository
+}
+
+func (r *AuditLogRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *AuditLogRepository) Create(e *AuditLogEntry) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO audit_log "+
+		"(organization_id, actor_user_id, actor_email, action, entity_type, entity_id, before_data, after_data, timestamp, ip_address) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) "+
+		"RETURNING id",
+		e.OrganizationID, e.ActorUserID, e.ActorEmail, e.Action, e.EntityType, e.EntityID, e.Before, e.After, e.Timestamp, e.IPAddress).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// GetAll returns the organization's entries matching the filter, newest first.
+func (r *AuditLogRepository) GetAll(organizationID string, filter *AuditLogFilter) ([]*AuditLogEntry, error) {
+	var result []*AuditLogEntry
+	args := []interface{}{organizationID}
+	where := "WHERE organization_id = $1"
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		where += " AND " + condition + " $" + strconv.Itoa(len(args))
+	}
+	if filter.ActorUserID != "" {
+		addCondition("actor_user_id =", filter.ActorUserID)
+	}
+	if filter.Action != "" {
+		addCondition("action =", filter.Action)
+	}
+	if filter.EntityType != "" {
+		addCondition("entity_type =", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		addCondition("entity_id =", filter.EntityID)
+	}
+	if filter.From != nil {
+		addCondition("timestamp >=", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("timestamp <=", *filter.To)
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	rows, err := GetDatabase().DB().Query("SELECT id, organization_id, actor_user_id, actor_email, action, entity_type, entity_id, before_data, after_data, timestamp, ip_address "+
+		"FROM audit_log "+
+		where+" "+
+		"ORDER BY timestamp DESC, id "+
+		"LIMIT $"+strconv.Itoa(len(args)-1)+" OFFSET $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &AuditLogEntry{}
+		err = rows.Scan(&e.ID, &e.OrganizationID, &e.ActorUserID, &e.ActorEmail, &e.Action, &e.EntityType, &e.EntityID, &e.Before, &e.After, &e.Timestamp, &e.IPAddress)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *AuditLogRepository) DeleteAll(organizationID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM audit_log WHERE organization_id = $1", organizationID)
+	return err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type AuditLogRouter struct {
+}
+
+type GetAuditLogResponse struct {
+	ID          string          `json:"id"`
+	ActorUserID string          `json:"actorUserId"`
+	ActorEmail  string          `json:"actorEmail"`
+	Action      string          `json:"action"`
+	EntityType  string          `json:"entityType"`
+	EntityID    string          `json:"entityId"`
+	Before      json.RawMessage `json:"before"`
+	After       json.RawMessage `json:"after"`
+	Timestamp   time.Time       `json:"timestamp"`
+	IPAddress   string          `json:"ipAddress"`
+}
+
+var ErrAuditLogInvalidFilter = errors.New("invalid audit log filter")
+
+const (
+	AuditLogDefaultLimit = 100
+	AuditLogMaxLimit     = 1000
+	AuditLogMaxExport    = 100000
+)
+
+func (router *AuditLogRouter) setupRoutes(s *mux.Router) {
+	RequirePermission(s.HandleFunc("/export", router.export).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionOrgAdmin)
+}
+
+func (router *AuditLogRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	filter, err := router.getFilter(r, AuditLogDefaultLimit, AuditLogMaxLimit)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	list, err := GetAuditLogRepository().GetAll(user.OrganizationID, filter)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetAuditLogResponse{}
+	for _, e := range list {
+		m := router.copyToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *AuditLogRouter) export(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	filter, err := router.getFilter(r, AuditLogMaxExport, AuditLogMaxExport)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	list, err := GetAuditLogRepository().GetAll(user.OrganizationID, filter)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit-log.csv\"")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"timestamp", "actor_user_id", "actor_email", "action", "entity_type", "entity_id", "before", "after", "ip_address"})
+	for _, e := range list {
+		writer.Write([]string{
+			e.Timestamp.Format(time.RFC3339),
+			router.escapeCSVCell(e.ActorUserID),
+			router.escapeCSVCell(e.ActorEmail),
+			router.escapeCSVCell(e.Action),
+			router.escapeCSVCell(e.EntityType),
+			router.escapeCSVCell(e.EntityID),
+			router.escapeCSVCell(e.Before),
+			router.escapeCSVCell(e.After),
+			router.escapeCSVCell(e.IPAddress),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println(err)
+	}
+}
+
+// escapeCSVCell prevents spreadsheet applications from interpreting values as
+// formulas by prefixing values starting with a formula character.
+func (router *AuditLogRouter) escapeCSVCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// getFilter parses the filter query parameters. The time range is specified in
+// UTC using the "from" and "to" parameters.
+func (router *AuditLogRouter) getFilter(r *http.Request, defaultLimit, maxLimit int) (*AuditLogFilter, error) {
+	query := r.URL.Query()
+	filter := &AuditLogFilter{
+		ActorUserID: query.Get("actorUserId"),
+		Action:      query.Get("action"),
+		EntityType:  query.Get("entityType"),
+		EntityID:    query.Get("entityId"),
+		Limit:       defaultLimit,
+	}
+	if query.Get("from") != "" {
+		from, err := ParseJSDate(query.Get("from"))
+		if err != nil {
+			return nil, err
+		}
+		filter.From = &from
+	}
+	if query.Get("to") != "" {
+		to, err := ParseJSDate(query.Get("to"))
+		if err != nil {
+			return nil, err
+		}
+		filter.To = &to
+	}
+	if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxLimit {
+			return nil, ErrAuditLogInvalidFilter
+		}
+		filter.Limit = limit
+	}
+	if query.Get("offset") != "" {
+		offset, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			return nil, ErrAuditLogInvalidFilter
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
+
+func (router *AuditLogRouter) copyToRestModel(e *AuditLogEntry) *GetAuditLogResponse {
+	m := &GetAuditLogResponse{}
+	m.ID = e.ID
+	m.ActorUserID = e.ActorUserID
+	m.ActorEmail = e.ActorEmail
+	m.Action = e.Action
+	m.EntityType = e.EntityType
+	m.EntityID = e.EntityID
+	if e.Before != "" {
+		m.Before = json.RawMessage(e.Before)
+	}
+	if e.After != "" {
+		m.After = json.RawMessage(e.After)
+	}
+	m.Timestamp = e.Timestamp
+	m.IPAddress = e.IPAddress
+	return m
+}
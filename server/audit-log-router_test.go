@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func getAuditLogTestEntries(t *testing.T, admin *User, query string) []*GetAuditLogResponse {
+	req := newHTTPRequest("GET", "/audit-log/?"+query, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetAuditLogResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	return resBody
+}
+
+func TestAuditLogForbidden(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("GET", "/audit-log/", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/audit-log/export", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestAuditLogSettings(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	GetSettingsRepository().Set(org.ID, SettingMaxBookingsPerUser.Name, "5")
+	GetSettingsRepository().Set(org.ID, SettingMaxDaysInAdvance.Name, "10")
+
+	payload := `[{"name": "` + SettingMaxBookingsPerUser.Name + `", "value": "7"}, {"name": "` + SettingMaxDaysInAdvance.Name + `", "value": "10"}]`
+	GetConfig().TrustedProxies = []string{"10.0.0.0/8"}
+	defer func() { GetConfig().TrustedProxies = []string{} }()
+	req := newHTTPRequest("PUT", "/setting/", admin.ID, bytes.NewBufferString(payload))
+	req.RemoteAddr = "10.0.0.2:41000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Unchanged settings are not logged
+	list := getAuditLogTestEntries(t, admin, "entityType="+AuditEntitySetting)
+	checkTestInt(t, 1, len(list))
+	checkTestString(t, AuditActionUpdate, list[0].Action)
+	checkTestString(t, SettingMaxBookingsPerUser.Name, list[0].EntityID)
+	checkTestString(t, admin.ID, list[0].ActorUserID)
+	checkTestString(t, admin.Email, list[0].ActorEmail)
+	checkTestString(t, "203.0.113.7", list[0].IPAddress)
+	var before, after GetSettingsResponse
+	json.Unmarshal(list[0].Before, &before)
+	json.Unmarshal(list[0].After, &after)
+	checkTestString(t, "5", before.Value)
+	checkTestString(t, "7", after.Value)
+}
+
+func TestAuditLogRequestIPAddress(t *testing.T) {
+	GetConfig().TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"}
+	defer func() { GetConfig().TrustedProxies = []string{} }()
+
+	// Header of untrusted clients is ignored
+	req := newHTTPRequest("GET", "/", "", nil)
+	req.RemoteAddr = "198.51.100.1:41000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	checkTestString(t, "198.51.100.1", getRequestIPAddress(req))
+
+	// Addresses prepended by the client are ignored
+	req.RemoteAddr = "192.0.2.1:41000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2, 10.0.0.1")
+	checkTestString(t, "198.51.100.2", getRequestIPAddress(req))
+
+	req.Header.Del("X-Forwarded-For")
+	checkTestString(t, "192.0.2.1", getRequestIPAddress(req))
+}
+
+func TestAuditLogUserDelete(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("DELETE", "/user/"+user.ID, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	list := getAuditLogTestEntries(t, admin, "action="+AuditActionDelete+"&entityId="+user.ID)
+	checkTestInt(t, 1, len(list))
+	checkTestString(t, AuditEntityUser, list[0].EntityType)
+	checkTestString(t, "null", string(list[0].After))
+	var before GetUserResponse
+	json.Unmarshal(list[0].Before, &before)
+	checkTestString(t, user.Email, before.Email)
+
+	// Entries of other orgs are not visible
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	list = getAuditLogTestEntries(t, admin2, "")
+	checkTestInt(t, 0, len(list))
+}
+
+func TestAuditLogBookOnBehalf(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+
+	payload := `{"spaceId": "` + s.ID + `", "enter": "2030-09-02T08:00:00Z", "leave": "2030-09-02T17:00:00Z", "userEmail": "` + user.Email + `"}`
+	req := newHTTPRequest("POST", "/booking/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id := res.Header().Get("X-Object-Id")
+	createCalDavSyncTestBooking(t, admin, s)
+
+	list := getAuditLogTestEntries(t, admin, "entityType="+AuditEntityBooking)
+	checkTestInt(t, 2, len(list))
+	list = getAuditLogTestEntries(t, admin, "action="+AuditActionBookOnBehalf)
+	checkTestInt(t, 1, len(list))
+	checkTestString(t, id, list[0].EntityID)
+	checkTestString(t, admin.ID, list[0].ActorUserID)
+	var after AuditLogBookingData
+	json.Unmarshal(list[0].After, &after)
+	checkTestString(t, user.ID, after.UserID)
+	checkTestString(t, "2030-09-02T08:00:00", after.Enter)
+
+	req = newHTTPRequest("DELETE", "/booking/"+id, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	list = getAuditLogTestEntries(t, admin, "entityId="+id)
+	checkTestInt(t, 2, len(list))
+	checkTestString(t, AuditActionDelete, list[0].Action)
+}
+
+func TestAuditLogFilterAndExport(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	for _, name := range []string{"Location 1", "Location 2", "Location 3"} {
+		payload := `{"name": "` + name + `"}`
+		req := newHTTPRequest("POST", "/location/", admin.ID, bytes.NewBufferString(payload))
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusCreated, res.Code)
+	}
+
+	list := getAuditLogTestEntries(t, admin, "entityType="+AuditEntityLocation+"&limit=2")
+	checkTestInt(t, 2, len(list))
+	list = getAuditLogTestEntries(t, admin, "entityType="+AuditEntityLocation+"&limit=2&offset=2")
+	checkTestInt(t, 1, len(list))
+	list = getAuditLogTestEntries(t, admin, "from=2000-01-01T00:00:00&to=2001-01-01T00:00:00")
+	checkTestInt(t, 0, len(list))
+
+	req := newHTTPRequest("GET", "/audit-log/?from=invalid", admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	req = newHTTPRequest("GET", "/audit-log/?limit=0", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	req = newHTTPRequest("GET", "/audit-log/export?entityType="+AuditEntityLocation, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestBool(t, true, strings.HasPrefix(res.Header().Get("Content-Type"), "text/csv"))
+	records, err := csv.NewReader(res.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 4, len(records))
+	checkTestString(t, "actor_email", records[0][2])
+	checkTestString(t, admin.Email, records[1][2])
+	checkTestString(t, AuditActionCreate, records[1][3])
+	checkTestBool(t, true, strings.Contains(records[1][7], `"name":"Location `))
+
+	// Values are escaped to prevent formula injection
+	router := &AuditLogRouter{}
+	checkTestString(t, "'=HYPERLINK(\"x\")", router.escapeCSVCell("=HYPERLINK(\"x\")"))
+	checkTestString(t, "'-1+1", router.escapeCSVCell("-1+1"))
+	checkTestString(t, "'@SUM(A1)", router.escapeCSVCell("@SUM(A1)"))
+	checkTestString(t, "admin@test.com", router.escapeCSVCell("admin@test.com"))
+	checkTestString(t, "", router.escapeCSVCell(""))
+}
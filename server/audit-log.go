@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// writeAuditLog appends an entry for a change made by the requesting user.
+// r is nil for changes made by background jobs, which are logged without an
+// actor. before and after are serialized to JSON, nil values are stored as
+// empty strings. Errors are logged only, so that a failing audit log doesn't
+// break the request which has already been processed.
+func writeAuditLog(r *http.Request, organizationID, action, entityType, entityID string, before, after interface{}) {
+	e := &AuditLogEntry{
+		OrganizationID: organizationID,
+		Action:         action,
+		EntityType:     entityType,
+		EntityID:       entityID,
+		Before:         getAuditLogData(before),
+		After:          getAuditLogData(after),
+		Timestamp:      time.Now().UTC(),
+	}
+	if r != nil {
+		e.IPAddress = getRequestIPAddress(r)
+		if userID := GetRequestUserID(r); userID != "" {
+			e.ActorUserID = userID
+			if user, err := GetUserRepository().GetOne(userID); err == nil {
+				e.ActorEmail = user.Email
+			}
+		}
+	}
+	if err := GetAuditLogRepository().Create(e); err != nil {
+		log.Println(err)
+	}
+}
+
+func getAuditLogData(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return string(data)
+}
+
+// getRequestIPAddress returns the client's IP address. The X-Forwarded-For
+// header can be set by any client, so it is only taken into account if the
+// request has been received from one of the configured trusted proxies.
+func getRequestIPAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	// Each proxy appends the address it has received the request from, so the
+	// client is the last address not belonging to a trusted proxy
+	forwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwardedFor[i])
+		if address == "" {
+			continue
+		}
+		host = address
+		if !isTrustedProxy(address) {
+			break
+		}
+	}
+	return host
+}
+
+// isTrustedProxy returns true if the address matches one of the IP addresses
+// or CIDR ranges configured in TRUSTED_PROXIES.
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range GetConfig().TrustedProxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditLogBookingData is the representation of a booking in the audit log.
+// Times are wall clock times in the location's time zone.
+type AuditLogBookingData struct {
+	ID          string `json:"id"`
+	UserID      string `json:"userId"`
+	SpaceID     string `json:"spaceId"`
+	Enter       string `json:"enter"`
+	Leave       string `json:"leave"`
+	SeriesID    string `json:"seriesId,omitempty"`
+	CheckInTime string `json:"checkInTime,omitempty"`
+}
+
+func getAuditLogBookingData(e *Booking) *AuditLogBookingData {
+	m := &AuditLogBookingData{
+		ID:       e.ID,
+		UserID:   e.UserID,
+		SpaceID:  e.SpaceID,
+		Enter:    ToJSDate(e.Enter),
+		Leave:    ToJSDate(e.Leave),
+		SeriesID: string(e.SeriesID),
+	}
+	if e.CheckInTime != nil {
+		m.CheckInTime = ToJSDate(*e.CheckInTime)
+	}
+	return m
+}
@@ -11,6 +11,8 @@ type AuthProviderType int
 
 const (
 	OAuth2 AuthProviderType = 1
+	SAML   AuthProviderType = 2
+	OIDC   AuthProviderType = 3
 )
 
 type AuthProvider struct {
@@ -27,6 +29,10 @@ type AuthProvider struct {
 	ClientID           string
 	ClientSecret       string
 	LogoutURL          string
+	GroupsClaim        string
+	SAMLEntityID       string
+	SAMLCertificate    string
+	IssuerURL          string
 }
 
 var authProviderRepository *AuthProviderRepository
@@ -67,15 +73,34 @@ func (r *AuthProviderRepository) RunSchemaUpgrade(curVersion, targetVersion int)
 			panic(err)
 		}
 	}
+	if curVersion < 21 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE auth_providers " +
+			"ADD COLUMN groups_claim VARCHAR NOT NULL DEFAULT ''"); err != nil {
+			panic(err)
+		}
+	}
+	if curVersion < 22 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE auth_providers " +
+			"ADD COLUMN saml_entity_id VARCHAR NOT NULL DEFAULT '', " +
+			"ADD COLUMN saml_certificate VARCHAR NOT NULL DEFAULT ''"); err != nil {
+			panic(err)
+		}
+	}
+	if curVersion < 23 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE auth_providers " +
+			"ADD COLUMN issuer_url VARCHAR NOT NULL DEFAULT ''"); err != nil {
+			panic(err)
+		}
+	}
 }
 
 func (r *AuthProviderRepository) Create(e *AuthProvider) error {
 	var id string
 	err := GetDatabase().DB().QueryRow("INSERT INTO auth_providers "+
-		"(organization_id, name, provider_type, auth_url, token_url, auth_style, scopes, userinfo_url, userinfo_email_field, client_id, client_secret, logout_url) "+
-		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) "+
+		"(organization_id, name, provider_type, auth_url, token_url, auth_style, scopes, userinfo_url, userinfo_email_field, client_id, client_secret, logout_url, groups_claim, saml_entity_id, saml_certificate, issuer_url) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) "+
 		"RETURNING id",
-		e.OrganizationID, e.Name, e.ProviderType, e.AuthURL, e.TokenURL, e.AuthStyle, e.Scopes, e.UserInfoURL, e.UserInfoEmailField, e.ClientID, e.ClientSecret, e.LogoutURL).Scan(&id)
+		e.OrganizationID, e.Name, e.ProviderType, e.AuthURL, e.TokenURL, e.AuthStyle, e.Scopes, e.UserInfoURL, e.UserInfoEmailField, e.ClientID, e.ClientSecret, e.LogoutURL, e.GroupsClaim, e.SAMLEntityID, e.SAMLCertificate, e.IssuerURL).Scan(&id)
 	if err != nil {
 		return err
 	}
@@ -85,10 +110,10 @@ func (r *AuthProviderRepository) Create(e *AuthProvider) error {
 
 func (r *AuthProviderRepository) GetOne(id string) (*AuthProvider, error) {
 	e := &AuthProvider{}
-	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, name, provider_type, auth_url, token_url, auth_style, scopes, userinfo_url, userinfo_email_field, client_id, client_secret, logout_url "+
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, name, provider_type, auth_url, token_url, auth_style, scopes, userinfo_url, userinfo_email_field, client_id, client_secret, logout_url, groups_claim, saml_entity_id, saml_certificate, issuer_url "+
 		"FROM auth_providers "+
 		"WHERE id = $1",
-		id).Scan(&e.ID, &e.OrganizationID, &e.Name, &e.ProviderType, &e.AuthURL, &e.TokenURL, &e.AuthStyle, &e.Scopes, &e.UserInfoURL, &e.UserInfoEmailField, &e.ClientID, &e.ClientSecret, &e.LogoutURL)
+		id).Scan(&e.ID, &e.OrganizationID, &e.Name, &e.ProviderType, &e.AuthURL, &e.TokenURL, &e.AuthStyle, &e.Scopes, &e.UserInfoURL, &e.UserInfoEmailField, &e.ClientID, &e.ClientSecret, &e.LogoutURL, &e.GroupsClaim, &e.SAMLEntityID, &e.SAMLCertificate, &e.IssuerURL)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +122,7 @@ func (r *AuthProviderRepository) GetOne(id string) (*AuthProvider, error) {
 
 func (r *AuthProviderRepository) GetAll(organizationID string) ([]*AuthProvider, error) {
 	var result []*AuthProvider
-	rows, err := GetDatabase().DB().Query("SELECT id, organization_id, name, provider_type, auth_url, token_url, auth_style, scopes, userinfo_url, userinfo_email_field, client_id, client_secret, logout_url "+
+	rows, err := GetDatabase().DB().Query("SELECT id, organization_id, name, provider_type, auth_url, token_url, auth_style, scopes, userinfo_url, userinfo_email_field, client_id, client_secret, logout_url, groups_claim, saml_entity_id, saml_certificate, issuer_url "+
 		"FROM auth_providers "+
 		"WHERE organization_id = $1 "+
 		"ORDER BY name", organizationID)
@@ -107,7 +132,7 @@ func (r *AuthProviderRepository) GetAll(organizationID string) ([]*AuthProvider,
 	defer rows.Close()
 	for rows.Next() {
 		e := &AuthProvider{}
-		err = rows.Scan(&e.ID, &e.OrganizationID, &e.Name, &e.ProviderType, &e.AuthURL, &e.TokenURL, &e.AuthStyle, &e.Scopes, &e.UserInfoURL, &e.UserInfoEmailField, &e.ClientID, &e.ClientSecret, &e.LogoutURL)
+		err = rows.Scan(&e.ID, &e.OrganizationID, &e.Name, &e.ProviderType, &e.AuthURL, &e.TokenURL, &e.AuthStyle, &e.Scopes, &e.UserInfoURL, &e.UserInfoEmailField, &e.ClientID, &e.ClientSecret, &e.LogoutURL, &e.GroupsClaim, &e.SAMLEntityID, &e.SAMLCertificate, &e.IssuerURL)
 		if err != nil {
 			return nil, err
 		}
@@ -129,13 +154,20 @@ func (r *AuthProviderRepository) Update(e *AuthProvider) error {
 		"userinfo_email_field = $9, "+
 		"client_id = $10, "+
 		"client_secret = $11, "+
-		"logout_url = $12 "+
-		"WHERE id = $13",
-		e.OrganizationID, e.Name, e.ProviderType, e.AuthURL, e.TokenURL, e.AuthStyle, e.Scopes, e.UserInfoURL, e.UserInfoEmailField, e.ClientID, e.ClientSecret, e.LogoutURL, e.ID)
+		"logout_url = $12, "+
+		"groups_claim = $13, "+
+		"saml_entity_id = $14, "+
+		"saml_certificate = $15, "+
+		"issuer_url = $16 "+
+		"WHERE id = $17",
+		e.OrganizationID, e.Name, e.ProviderType, e.AuthURL, e.TokenURL, e.AuthStyle, e.Scopes, e.UserInfoURL, e.UserInfoEmailField, e.ClientID, e.ClientSecret, e.LogoutURL, e.GroupsClaim, e.SAMLEntityID, e.SAMLCertificate, e.IssuerURL, e.ID)
 	return err
 }
 
 func (r *AuthProviderRepository) Delete(e *AuthProvider) error {
+	if err := GetAuthProviderRuleRepository().DeleteAllByAuthProvider(e.ID); err != nil {
+		return err
+	}
 	_, err := GetDatabase().DB().Exec("DELETE FROM auth_providers WHERE id = $1", e.ID)
 	return err
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/mux"
 
@@ -17,15 +18,23 @@ type AuthProviderRouter struct {
 type CreateAuthProviderRequest struct {
 	Name               string `json:"name" validate:"required"`
 	ProviderType       int    `json:"providerType" validate:"required"`
-	AuthURL            string `json:"authUrl" validate:"required"`
-	TokenURL           string `json:"tokenUrl" validate:"required"`
+	AuthURL            string `json:"authUrl"`
+	TokenURL           string `json:"tokenUrl"`
 	AuthStyle          int    `json:"authStyle"`
-	Scopes             string `json:"scopes" validate:"required"`
-	UserInfoURL        string `json:"userInfoUrl" validate:"required"`
-	UserInfoEmailField string `json:"userInfoEmailField" validate:"required"`
-	ClientID           string `json:"clientId" validate:"required"`
-	ClientSecret       string `json:"clientSecret" validate:"required"`
+	Scopes             string `json:"scopes"`
+	UserInfoURL        string `json:"userInfoUrl"`
+	UserInfoEmailField string `json:"userInfoEmailField"`
+	ClientID           string `json:"clientId"`
+	ClientSecret       string `json:"clientSecret"`
 	LogoutURL          string `json:"logoutUrl"`
+	GroupsClaim        string `json:"groupsClaim"`
+	SAMLEntityID       string `json:"samlEntityId"`
+	SAMLCertificate    string `json:"samlCertificate"`
+	IssuerURL          string `json:"issuerUrl"`
+}
+
+type ImportSAMLMetadataRequest struct {
+	Metadata string `json:"metadata" validate:"required"`
 }
 
 type GetAuthProviderResponse struct {
@@ -34,6 +43,19 @@ type GetAuthProviderResponse struct {
 	CreateAuthProviderRequest
 }
 
+type CreateAuthProviderRuleRequest struct {
+	Claim   string    `json:"claim" validate:"required"`
+	Value   string    `json:"value" validate:"required"`
+	Role    *UserRole `json:"role"`
+	GroupID string    `json:"groupId"`
+}
+
+type GetAuthProviderRuleResponse struct {
+	ID             string `json:"id"`
+	AuthProviderID string `json:"authProviderId"`
+	CreateAuthProviderRuleRequest
+}
+
 type GetAuthProviderPublicResponse struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
@@ -41,11 +63,16 @@ type GetAuthProviderPublicResponse struct {
 
 func (router *AuthProviderRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/org/{id}", router.listPublicForOrg).Methods("GET")
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/{id}/saml/metadata", router.importSAMLMetadata).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/rule/", router.getRules).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/rule/", router.createRule).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/rule/{ruleId}", router.updateRule).Methods("PUT"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/rule/{ruleId}", router.deleteRule).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionOrgAdmin)
 }
 
 func (router *AuthProviderRouter) listPublicForOrg(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +108,7 @@ func (router *AuthProviderRouter) getOne(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -91,10 +118,6 @@ func (router *AuthProviderRouter) getOne(w http.ResponseWriter, r *http.Request)
 
 func (router *AuthProviderRouter) getAll(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	list, err := GetAuthProviderRepository().GetAll(user.OrganizationID)
 	if err != nil {
 		log.Println(err)
@@ -110,7 +133,7 @@ func (router *AuthProviderRouter) getAll(w http.ResponseWriter, r *http.Request)
 }
 func (router *AuthProviderRouter) update(w http.ResponseWriter, r *http.Request) {
 	var m CreateAuthProviderRequest
-	if UnmarshalValidateBody(r, &m) != nil {
+	if UnmarshalValidateBody(r, &m) != nil || !router.isValidRequest(&m) {
 		SendBadRequest(w)
 		return
 	}
@@ -121,7 +144,7 @@ func (router *AuthProviderRouter) update(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -133,6 +156,7 @@ func (router *AuthProviderRouter) update(w http.ResponseWriter, r *http.Request)
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityAuthProvider, e.ID, router.getAuditLogData(e), router.getAuditLogData(eNew))
 	SendUpdated(w)
 }
 
@@ -145,7 +169,7 @@ func (router *AuthProviderRouter) delete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -154,6 +178,7 @@ func (router *AuthProviderRouter) delete(w http.ResponseWriter, r *http.Request)
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityAuthProvider, e.ID, router.getAuditLogData(e), nil)
 	SendUpdated(w)
 }
 
@@ -164,21 +189,237 @@ func (router *AuthProviderRouter) create(w http.ResponseWriter, r *http.Request)
 		SendBadRequest(w)
 		return
 	}
+	if !router.isValidRequest(&m) {
+		SendBadRequest(w)
+		return
+	}
 	user := GetRequestUser(r)
 	e := router.copyFromRestModel(&m)
 	e.OrganizationID = user.OrganizationID
-	if !CanAdminOrg(user, e.OrganizationID) {
+	if err := GetAuthProviderRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityAuthProvider, e.ID, nil, router.getAuditLogData(e))
+	SendCreated(w, e.ID)
+}
+
+// importSAMLMetadata configures a SAML provider using the identity provider's
+// metadata document.
+func (router *AuthProviderRouter) importSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	var m ImportSAMLMetadataRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetAuthProviderRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	user := GetRequestUser(r)
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
-	if err := GetAuthProviderRepository().Create(e); err != nil {
+	if e.ProviderType != int(SAML) {
+		SendBadRequest(w)
+		return
+	}
+	metadata, err := ParseSAMLIdPMetadata([]byte(m.Metadata))
+	if err != nil {
+		log.Println(err)
+		SendBadRequest(w)
+		return
+	}
+	eNew := *e
+	eNew.AuthURL = metadata.SSOURL
+	eNew.SAMLEntityID = metadata.EntityID
+	eNew.SAMLCertificate = metadata.Certificate
+	if err := GetAuthProviderRepository().Update(&eNew); err != nil {
 		log.Println(err)
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityAuthProvider, e.ID, router.getAuditLogData(e), router.getAuditLogData(&eNew))
+	SendUpdated(w)
+}
+
+func (router *AuthProviderRouter) getRules(w http.ResponseWriter, r *http.Request) {
+	provider := router.getRuleAuthProvider(w, r)
+	if provider == nil {
+		return
+	}
+	list, err := GetAuthProviderRuleRepository().GetAll(provider.ID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetAuthProviderRuleResponse{}
+	for _, e := range list {
+		m := router.copyRuleToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *AuthProviderRouter) createRule(w http.ResponseWriter, r *http.Request) {
+	var m CreateAuthProviderRuleRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	provider := router.getRuleAuthProvider(w, r)
+	if provider == nil {
+		return
+	}
+	if !router.isValidRuleRequest(&m, provider) {
+		SendBadRequest(w)
+		return
+	}
+	e := router.copyRuleFromRestModel(&m)
+	e.AuthProviderID = provider.ID
+	if err := GetAuthProviderRuleRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, provider.OrganizationID, AuditActionCreate, AuditEntityAuthProviderRule, e.ID, nil, router.copyRuleToRestModel(e))
 	SendCreated(w, e.ID)
 }
 
+func (router *AuthProviderRouter) updateRule(w http.ResponseWriter, r *http.Request) {
+	var m CreateAuthProviderRuleRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	provider := router.getRuleAuthProvider(w, r)
+	if provider == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetAuthProviderRuleRepository().GetOne(vars["ruleId"])
+	if err != nil || e.AuthProviderID != provider.ID {
+		SendNotFound(w)
+		return
+	}
+	if !router.isValidRuleRequest(&m, provider) {
+		SendBadRequest(w)
+		return
+	}
+	eNew := router.copyRuleFromRestModel(&m)
+	eNew.ID = e.ID
+	eNew.AuthProviderID = e.AuthProviderID
+	if err := GetAuthProviderRuleRepository().Update(eNew); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, provider.OrganizationID, AuditActionUpdate, AuditEntityAuthProviderRule, e.ID, router.copyRuleToRestModel(e), router.copyRuleToRestModel(eNew))
+	SendUpdated(w)
+}
+
+func (router *AuthProviderRouter) deleteRule(w http.ResponseWriter, r *http.Request) {
+	provider := router.getRuleAuthProvider(w, r)
+	if provider == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetAuthProviderRuleRepository().GetOne(vars["ruleId"])
+	if err != nil || e.AuthProviderID != provider.ID {
+		SendNotFound(w)
+		return
+	}
+	if err := GetAuthProviderRuleRepository().Delete(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, provider.OrganizationID, AuditActionDelete, AuditEntityAuthProviderRule, e.ID, router.copyRuleToRestModel(e), nil)
+	SendUpdated(w)
+}
+
+// getRuleAuthProvider returns the auth provider specified in the request if
+// the requesting user is allowed to manage its rules. Otherwise, it sends an
+// error response and returns nil.
+func (router *AuthProviderRouter) getRuleAuthProvider(w http.ResponseWriter, r *http.Request) *AuthProvider {
+	vars := mux.Vars(r)
+	provider, err := GetAuthProviderRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	if !CanAccessOrg(GetRequestUser(r), provider.OrganizationID) {
+		SendForbidden(w)
+		return nil
+	}
+	return provider
+}
+
+// isValidRuleRequest checks that the rule assigns a role and/or a group of the
+// provider's organization. Rules can't grant the super admin role.
+func (router *AuthProviderRouter) isValidRuleRequest(m *CreateAuthProviderRuleRequest, provider *AuthProvider) bool {
+	if m.Role == nil && m.GroupID == "" {
+		return false
+	}
+	if m.Role != nil && *m.Role != UserRoleUser && *m.Role != UserRoleSpaceAdmin && *m.Role != UserRoleOrgAdmin {
+		return false
+	}
+	if m.GroupID != "" {
+		group, err := GetGroupRepository().GetOne(m.GroupID)
+		if err != nil || group.OrganizationID != provider.OrganizationID {
+			return false
+		}
+	}
+	return true
+}
+
+func (router *AuthProviderRouter) copyRuleFromRestModel(m *CreateAuthProviderRuleRequest) *AuthProviderRule {
+	e := &AuthProviderRule{}
+	e.Claim = m.Claim
+	e.Value = m.Value
+	e.Role = m.Role
+	e.GroupID = NullString(m.GroupID)
+	return e
+}
+
+func (router *AuthProviderRouter) copyRuleToRestModel(e *AuthProviderRule) *GetAuthProviderRuleResponse {
+	m := &GetAuthProviderRuleResponse{}
+	m.ID = e.ID
+	m.AuthProviderID = e.AuthProviderID
+	m.Claim = e.Claim
+	m.Value = e.Value
+	m.Role = e.Role
+	m.GroupID = string(e.GroupID)
+	return m
+}
+
+func (router *AuthProviderRouter) isValidRequest(m *CreateAuthProviderRequest) bool {
+	switch AuthProviderType(m.ProviderType) {
+	case OAuth2:
+		return m.AuthURL != "" && m.TokenURL != "" && m.Scopes != "" && m.UserInfoURL != "" && m.UserInfoEmailField != "" && m.ClientID != "" && m.ClientSecret != ""
+	case SAML:
+		if m.AuthURL == "" {
+			return false
+		}
+		// The certificate can be imported from the metadata later on
+		if m.SAMLCertificate == "" {
+			return true
+		}
+		_, err := ParseSAMLCertificates(m.SAMLCertificate)
+		return err == nil
+	case OIDC:
+		// Endpoints are discovered using the issuer URL
+		u, err := url.Parse(m.IssuerURL)
+		return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && m.ClientID != ""
+	}
+	return false
+}
+
 func (router *AuthProviderRouter) copyFromRestModel(m *CreateAuthProviderRequest) *AuthProvider {
 	e := &AuthProvider{}
 	e.Name = m.Name
@@ -192,9 +433,21 @@ func (router *AuthProviderRouter) copyFromRestModel(m *CreateAuthProviderRequest
 	e.UserInfoEmailField = m.UserInfoEmailField
 	e.ProviderType = m.ProviderType
 	e.LogoutURL = m.LogoutURL
+	e.GroupsClaim = m.GroupsClaim
+	e.SAMLEntityID = m.SAMLEntityID
+	e.SAMLCertificate = m.SAMLCertificate
+	e.IssuerURL = m.IssuerURL
 	return e
 }
 
+// getAuditLogData returns the auth provider's representation for the audit
+// log, which must not contain the client secret.
+func (router *AuthProviderRouter) getAuditLogData(e *AuthProvider) *GetAuthProviderResponse {
+	m := router.copyToRestModel(e)
+	m.ClientSecret = ""
+	return m
+}
+
 func (router *AuthProviderRouter) copyToRestModel(e *AuthProvider) *GetAuthProviderResponse {
 	m := &GetAuthProviderResponse{}
 	m.ID = e.ID
@@ -210,5 +463,9 @@ func (router *AuthProviderRouter) copyToRestModel(e *AuthProvider) *GetAuthProvi
 	m.UserInfoEmailField = e.UserInfoEmailField
 	m.ProviderType = e.ProviderType
 	m.LogoutURL = e.LogoutURL
+	m.GroupsClaim = e.GroupsClaim
+	m.SAMLEntityID = e.SAMLEntityID
+	m.SAMLCertificate = e.SAMLCertificate
+	m.IssuerURL = e.IssuerURL
 	return m
 }
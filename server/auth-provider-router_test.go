@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
 	"testing"
+
+	dsig "github.com/russellhaering/goxmldsig"
 )
 
 func TestAuthProvidersEmptyResult(t *testing.T) {
@@ -157,3 +160,163 @@ func TestAuthProvidersGetPublicForOrg(t *testing.T) {
 	checkTestString(t, id2, resBody[1].ID)
 	checkTestString(t, "Test2", resBody[1].Name)
 }
+
+func TestAuthProvidersSAMLMetadataImport(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	userAdmin := createTestUserOrgAdmin(org)
+	loginResponse := loginTestUser(userAdmin.ID)
+
+	payload := `{"name": "Test", "providerType": 2, "authUrl": "https://idp.test/old", "userInfoEmailField": "mail"}`
+	req := newHTTPRequest("POST", "/auth-provider/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id := res.Header().Get("X-Object-Id")
+
+	_, cert := getTestSAMLKeyPair(t)
+	metadata := `<md:EntityDescriptor xmlns:md="` + samlNamespaceMetadata + `" xmlns:ds="` + dsig.Namespace + `" entityID="https://idp.test/">` +
+		`<md:IDPSSODescriptor protocolSupportEnumeration="` + samlNamespaceProtocol + `">` +
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(cert.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>` +
+		`<md:SingleSignOnService Binding="` + samlBindingRedirect + `" Location="https://idp.test/sso"/>` +
+		`</md:IDPSSODescriptor></md:EntityDescriptor>`
+	body, _ := json.Marshal(&ImportSAMLMetadataRequest{Metadata: metadata})
+	req = newHTTPRequest("POST", "/auth-provider/"+id+"/saml/metadata", loginResponse.UserID, bytes.NewBuffer(body))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/auth-provider/"+id, loginResponse.UserID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetAuthProviderResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, int(SAML), resBody.ProviderType)
+	checkTestString(t, "https://idp.test/sso", resBody.AuthURL)
+	checkTestString(t, "https://idp.test/", resBody.SAMLEntityID)
+	certs, err := ParseSAMLCertificates(resBody.SAMLCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, len(certs))
+
+	// SP metadata is public
+	req = newHTTPRequest("GET", "/auth/"+id+"/saml/metadata", "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	// Invalid metadata
+	req = newHTTPRequest("POST", "/auth-provider/"+id+"/saml/metadata", loginResponse.UserID, bytes.NewBufferString(`{"metadata": "<foo/>"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
+func TestAuthProvidersOIDCValidation(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	userAdmin := createTestUserOrgAdmin(org)
+	loginResponse := loginTestUser(userAdmin.ID)
+
+	// Missing issuer
+	payload := `{"name": "Test", "providerType": 3, "clientId": "test1", "clientSecret": "test2"}`
+	req := newHTTPRequest("POST", "/auth-provider/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Missing client ID
+	payload = `{"name": "Test", "providerType": 3, "issuerUrl": "https://idp.test/realms/test"}`
+	req = newHTTPRequest("POST", "/auth-provider/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	payload = `{"name": "Test", "providerType": 3, "issuerUrl": "https://idp.test/realms/test", "clientId": "test1", "clientSecret": "test2"}`
+	req = newHTTPRequest("POST", "/auth-provider/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id := res.Header().Get("X-Object-Id")
+
+	req = newHTTPRequest("GET", "/auth-provider/"+id, loginResponse.UserID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetAuthProviderResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, int(OIDC), resBody.ProviderType)
+	checkTestString(t, "https://idp.test/realms/test", resBody.IssuerURL)
+}
+
+func TestAuthProvidersRulesCRUD(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	userAdmin := createTestUserOrgAdmin(org)
+	loginResponse := loginTestUser(userAdmin.ID)
+	provider := &AuthProvider{OrganizationID: org.ID, Name: "Test", ProviderType: int(OAuth2)}
+	GetAuthProviderRepository().Create(provider)
+	group := &Group{OrganizationID: org.ID, Name: "Admins"}
+	GetGroupRepository().Create(group)
+	org2 := createTestOrg("test2.com")
+	group2 := &Group{OrganizationID: org2.ID, Name: "Admins"}
+	GetGroupRepository().Create(group2)
+
+	// Rules must assign a role or a group
+	payload := `{"claim": "groups", "value": "admins"}`
+	req := newHTTPRequest("POST", "/auth-provider/"+provider.ID+"/rule/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Super admin role can't be assigned
+	payload = `{"claim": "groups", "value": "admins", "role": 90}`
+	req = newHTTPRequest("POST", "/auth-provider/"+provider.ID+"/rule/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Group of another org
+	payload = `{"claim": "groups", "value": "admins", "groupId": "` + group2.ID + `"}`
+	req = newHTTPRequest("POST", "/auth-provider/"+provider.ID+"/rule/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Create
+	payload = `{"claim": "groups", "value": "admins", "role": 20, "groupId": "` + group.ID + `"}`
+	req = newHTTPRequest("POST", "/auth-provider/"+provider.ID+"/rule/", loginResponse.UserID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id := res.Header().Get("X-Object-Id")
+
+	// Read
+	req = newHTTPRequest("GET", "/auth-provider/"+provider.ID+"/rule/", loginResponse.UserID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetAuthProviderRuleResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, id, resBody[0].ID)
+	checkTestString(t, "groups", resBody[0].Claim)
+	checkTestString(t, "admins", resBody[0].Value)
+	checkTestInt(t, int(UserRoleOrgAdmin), int(*resBody[0].Role))
+	checkTestString(t, group.ID, resBody[0].GroupID)
+
+	// Update
+	payload = `{"claim": "department", "value": "Facilities", "role": 10}`
+	req = newHTTPRequest("PUT", "/auth-provider/"+provider.ID+"/rule/"+id, loginResponse.UserID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	rule, err := GetAuthProviderRuleRepository().GetOne(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "department", rule.Claim)
+	checkTestInt(t, int(UserRoleSpaceAdmin), int(*rule.Role))
+	checkTestString(t, "", string(rule.GroupID))
+
+	// Other org's admin
+	userAdmin2 := createTestUserOrgAdmin(org2)
+	loginResponse2 := loginTestUser(userAdmin2.ID)
+	req = newHTTPRequest("DELETE", "/auth-provider/"+provider.ID+"/rule/"+id, loginResponse2.UserID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Delete
+	req = newHTTPRequest("DELETE", "/auth-provider/"+provider.ID+"/rule/"+id, loginResponse.UserID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	_, err = GetAuthProviderRuleRepository().GetOne(id)
+	checkTestBool(t, true, err != nil)
+}
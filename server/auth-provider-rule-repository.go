@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sync"
+)
+
+type AuthProviderRuleRepository struct {
+}
+
+// AuthProviderRule maps a value of an identity provider's claim to a role
+// and/or a group membership. Rules are evaluated at every login using the
+// provider.
+type AuthProviderRule struct {
+	ID             string
+	AuthProviderID string
+	Claim          string
+	Value          string
+	Role           *UserRole
+	GroupID        NullString
+}
+
+var authProviderRuleRepository *AuthProviderRuleRepository
+var authProviderRuleRepositoryOnce sync.Once
+
+func GetAuthProviderRuleRepository() *AuthProviderRuleRepository {
+	authProviderRuleRepositoryOnce.Do(func() {
+		authProviderRuleRepository = &AuthProviderRuleRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS auth_provider_rules (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"auth_provider_id uuid NOT NULL, " +
+			"claim VARCHAR NOT NULL, " +
+			"value VARCHAR NOT NULL, " +
+			"role INTEGER NULL, " +
+			"group_id uuid NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_auth_provider_rules_auth_provider_id ON auth_provider_rules(auth_provider_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return authProviderRuleRepository
+}
+
+func (r *AuthProviderRuleRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *AuthProviderRuleRepository) Create(e *AuthProviderRule) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO auth_provider_rules "+
+		"(auth_provider_id, claim, value, role, group_id) "+
+		"VALUES ($1, $2, $3, $4, $5) "+
+		"RETURNING id",
+		e.AuthProviderID, e.Claim, e.Value, e.Role, CheckNullString(e.GroupID)).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *AuthProviderRuleRepository) GetOne(id string) (*AuthProviderRule, error) {
+	e := &AuthProviderRule{}
+	err := GetDatabase().DB().QueryRow("SELECT id, auth_provider_id, claim, value, role, group_id::text "+
+		"FROM auth_provider_rules "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.AuthProviderID, &e.Claim, &e.Value, &e.Role, &e.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *AuthProviderRuleRepository) GetAll(authProviderID string) ([]*AuthProviderRule, error) {
+	var result []*AuthProviderRule
+	rows, err := GetDatabase().DB().Query("SELECT id, auth_provider_id, claim, value, role, group_id::text "+
+		"FROM auth_provider_rules "+
+		"WHERE auth_provider_id = $1 "+
+		"ORDER BY claim, value", authProviderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &AuthProviderRule{}
+		err = rows.Scan(&e.ID, &e.AuthProviderID, &e.Claim, &e.Value, &e.Role, &e.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *AuthProviderRuleRepository) Update(e *AuthProviderRule) error {
+	_, err := GetDatabase().DB().Exec("UPDATE auth_provider_rules SET "+
+		"claim = $1, "+
+		"value = $2, "+
+		"role = $3, "+
+		"group_id = $4 "+
+		"WHERE id = $5",
+		e.Claim, e.Value, e.Role, CheckNullString(e.GroupID), e.ID)
+	return err
+}
+
+func (r *AuthProviderRuleRepository) Delete(e *AuthProviderRule) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM auth_provider_rules WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *AuthProviderRuleRepository) DeleteAllByAuthProvider(authProviderID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM auth_provider_rules WHERE auth_provider_id = $1", authProviderID)
+	return err
+}
+
+func (r *AuthProviderRuleRepository) DeleteAllByGroup(groupID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM auth_provider_rules WHERE group_id = $1", groupID)
+	return err
+}
+
+func (r *AuthProviderRuleRepository) DeleteAll(organizationID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM auth_provider_rules WHERE "+
+		"auth_provider_rules.auth_provider_id IN (SELECT auth_providers.id FROM auth_providers WHERE auth_providers.organization_id = $1)", organizationID)
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +22,11 @@ type JWTResponse struct {
 	RefreshToken string `json:"refreshToken"`
 	LongLived    bool   `json:"longLived"`
 	LogoutURL    string `json:"logoutUrl"`
+	// Instead of the tokens, a challenge is returned if a password login
+	// requires a second factor
+	RequireOTP      bool   `json:"otpRequired,omitempty"`
+	RequireOTPSetup bool   `json:"otpSetupRequired,omitempty"`
+	ChallengeID     string `json:"challengeId,omitempty"`
 }
 
 type Claims struct {
@@ -57,15 +63,32 @@ type AuthPasswordRequest struct {
 	LongLived bool   `json:"longLived"`
 }
 
+type AuthOTPRequest struct {
+	ChallengeID string `json:"challengeId" validate:"required"`
+	Code        string `json:"code" validate:"required"`
+}
+
+type AuthOTPSetupRequest struct {
+	ChallengeID string `json:"challengeId" validate:"required"`
+}
+
 type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken" validate:"required"`
 }
 
 type AuthStateLoginPayload struct {
-	UserID    string `json:"userId"`
-	LoginType string `json:"type"`
-	LongLived bool   `json:"longLived"`
-	Redirect  string `json:"redirect,omitempty"`
+	UserID    string   `json:"userId"`
+	LoginType string   `json:"type"`
+	LongLived bool     `json:"longLived"`
+	Redirect  string   `json:"redirect,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	// Role and SyncGroups result from the provider's rules. Role is nil if no
+	// rule assigns a role.
+	Role       *UserRole `json:"role,omitempty"`
+	SyncGroups bool      `json:"syncGroups,omitempty"`
+	// Nonce and CodeVerifier bind the OIDC authorization response to the login request
+	Nonce        string `json:"nonce,omitempty"`
+	CodeVerifier string `json:"codeVerifier,omitempty"`
 }
 
 type AuthRouter struct {
@@ -76,8 +99,12 @@ func (router *AuthRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/{id}/login/{type}/{longLived}", router.login).Methods("GET")
 	s.HandleFunc("/{id}/login/{type}", router.login).Methods("GET")
 	s.HandleFunc("/{id}/callback", router.callback).Methods("GET")
+	s.HandleFunc("/{id}/saml/acs", router.samlACS).Methods("POST")
+	s.HandleFunc("/{id}/saml/metadata", router.samlMetadata).Methods("GET")
 	s.HandleFunc("/preflight", router.preflight).Methods("POST")
 	s.HandleFunc("/login", router.loginPassword).Methods("POST")
+	s.HandleFunc("/login/otp", router.loginOTP).Methods("POST")
+	s.HandleFunc("/login/otp/setup", router.loginOTPSetup).Methods("POST")
 	s.HandleFunc("/initpwreset", router.initPasswordReset).Methods("POST")
 	s.HandleFunc("/pwreset/{id}", router.completePasswordReset).Methods("POST")
 	s.HandleFunc("/refresh", router.refreshAccessToken).Methods("POST")
@@ -269,6 +296,13 @@ func (router *AuthRouter) loginPassword(w http.ResponseWriter, r *http.Request)
 		SendNotFound(w)
 		return
 	}
+	totpEnabled := GetTOTPRepository().IsEnabled(user.ID)
+	if totpEnabled || isTOTPRequired(user) {
+		// The successful attempt is recorded after the second step, so that
+		// failed codes count towards the ban
+		router.sendOTPChallenge(w, user, m.LongLived, !totpEnabled)
+		return
+	}
 	GetAuthAttemptRepository().RecordLoginAttempt(user, true)
 	claims := router.createClaims(user)
 	accessToken := router.createAccessToken(claims)
@@ -280,6 +314,145 @@ func (router *AuthRouter) loginPassword(w http.ResponseWriter, r *http.Request)
 	SendJSON(w, res)
 }
 
+func (router *AuthRouter) sendOTPChallenge(w http.ResponseWriter, user *User, longLived bool, setupRequired bool) {
+	payload := &AuthStateLoginPayload{
+		UserID:    user.ID,
+		LongLived: longLived,
+	}
+	authState := &AuthState{
+		AuthProviderID: GetSettingsRepository().getNullUUID(),
+		Expiry:         time.Now().Add(TOTPChallengeExpiry),
+		AuthStateType:  AuthTOTPChallenge,
+		Payload:        marshalAuthStateLoginPayload(payload),
+	}
+	if err := GetAuthStateRepository().Create(authState); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := &JWTResponse{
+		RequireOTP:      true,
+		RequireOTPSetup: setupRequired,
+		ChallengeID:     authState.ID,
+	}
+	SendJSON(w, res)
+}
+
+// loginOTP completes a password login using the code of the user's
+// authenticator app or one of the recovery codes. If the user has been
+// required to set up two-factor authentication, the code confirms the
+// enrollment.
+func (router *AuthRouter) loginOTP(w http.ResponseWriter, r *http.Request) {
+	var m AuthOTPRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	authState, user := router.getOTPChallenge(m.ChallengeID)
+	if authState == nil {
+		SendNotFound(w)
+		return
+	}
+	// The attempt is counted before checking the code, so that concurrent
+	// requests can't exceed the limit
+	attempts, err := GetAuthStateRepository().AddAttempt(authState)
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	if attempts > TOTPMaxAttempts {
+		GetAuthStateRepository().Delete(authState)
+		SendNotFound(w)
+		return
+	}
+	e, err := GetTOTPRepository().GetOne(user.ID)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	ok, err := GetTOTPRepository().CheckCode(e, m.Code, time.Now())
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if !ok {
+		GetAuthAttemptRepository().RecordLoginAttempt(user, false)
+		if attempts == TOTPMaxAttempts {
+			GetAuthStateRepository().Delete(authState)
+		}
+		SendNotFound(w)
+		return
+	}
+	if !e.Enabled {
+		if err := GetTOTPRepository().Enable(e); err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+		writeAuditLog(r, user.OrganizationID, AuditActionEnableTOTP, AuditEntityUser, user.ID, nil, nil)
+	}
+	payload := unmarshalAuthStateLoginPayload(authState.Payload)
+	GetAuthStateRepository().Delete(authState)
+	GetAuthAttemptRepository().RecordLoginAttempt(user, true)
+	claims := router.createClaims(user)
+	accessToken := router.createAccessToken(claims)
+	refreshToken := router.createRefreshToken(claims, payload.LongLived)
+	res := &JWTResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		LongLived:    payload.LongLived,
+	}
+	SendJSON(w, res)
+}
+
+// loginOTPSetup starts the enrollment of users who are required to use
+// two-factor authentication but haven't set it up yet.
+func (router *AuthRouter) loginOTPSetup(w http.ResponseWriter, r *http.Request) {
+	var m AuthOTPSetupRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	authState, user := router.getOTPChallenge(m.ChallengeID)
+	if authState == nil {
+		SendNotFound(w)
+		return
+	}
+	// A pending enrollment can't be replaced as long as it can be confirmed
+	// using a challenge. Abandoned ones are replaced, so that the user isn't
+	// locked out.
+	if e, err := GetTOTPRepository().GetOne(user.ID); err == nil {
+		if e.Enabled || time.Since(e.Created) < TOTPChallengeExpiry {
+			SendAleadyExists(w)
+			return
+		}
+	}
+	res, err := createTOTPEnrollment(user)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	SendJSON(w, res)
+}
+
+// getOTPChallenge returns the pending challenge and its user. It returns nil
+// if the challenge doesn't exist, has expired, or the user has been disabled
+// in the meantime, i.e. due to too many failed attempts.
+func (router *AuthRouter) getOTPChallenge(id string) (*AuthState, *User) {
+	authState, err := GetAuthStateRepository().GetOne(id)
+	if err != nil || authState.AuthStateType != AuthTOTPChallenge || authState.Expiry.Before(time.Now()) {
+		return nil, nil
+	}
+	payload := unmarshalAuthStateLoginPayload(authState.Payload)
+	user, err := GetUserRepository().GetOne(payload.UserID)
+	if err != nil || user.Disabled {
+		return nil, nil
+	}
+	return authState, user
+}
+
 func (router *AuthRouter) handleAtlassianVerify(authState *AuthState, w http.ResponseWriter) {
 	payload := unmarshalAuthStateLoginPayload(authState.Payload)
 	user, err := GetUserRepository().GetByAtlassianID(payload.UserID)
@@ -341,7 +514,11 @@ func (router *AuthRouter) verify(w http.ResponseWriter, r *http.Request) {
 			OrganizationID: org.ID,
 			Role:           UserRoleUser,
 		}
+		if payload.Role != nil {
+			user.Role = *payload.Role
+		}
 		GetUserRepository().Create(user)
+		fireUserWebhookEvent(user, WebhookEventUserCreated)
 	}
 	if user.OrganizationID != provider.OrganizationID {
 		SendBadRequest(w)
@@ -351,6 +528,21 @@ func (router *AuthRouter) verify(w http.ResponseWriter, r *http.Request) {
 		SendNotFound(w)
 		return
 	}
+	if payload.Role != nil && user.Role != *payload.Role && !GetUserRepository().isSuperAdmin(user) {
+		userNew := *user
+		userNew.Role = *payload.Role
+		if err := GetUserRepository().Update(&userNew); err != nil {
+			log.Println(err)
+		} else {
+			writeAuditLog(r, user.OrganizationID, AuditActionUpdate, AuditEntityUser, user.ID, (&UserRouter{}).copyToRestModel(user, true), (&UserRouter{}).copyToRestModel(&userNew, true))
+			user = &userNew
+		}
+	}
+	if provider.GroupsClaim != "" || payload.SyncGroups {
+		if err := GetGroupRepository().SyncClaimMemberships(user, payload.Groups); err != nil {
+			log.Println(err)
+		}
+	}
 	GetAuthStateRepository().Delete(authState)
 	GetAuthAttemptRepository().RecordLoginAttempt(user, true)
 	claims := router.createClaims(user)
@@ -366,10 +558,20 @@ func (router *AuthRouter) verify(w http.ResponseWriter, r *http.Request) {
 }
 
 func (router *AuthRouter) getLogoutUrl(provider *AuthProvider) string {
+	redirectUrl := GetConfig().FrontendURL + "ui/login"
+	if provider.LogoutURL == "" && provider.ProviderType == int(OIDC) {
+		discovery, err := GetOIDCClient().GetDiscovery(provider.IssuerURL)
+		if err != nil || discovery.EndSessionEndpoint == "" {
+			return ""
+		}
+		query := url.Values{}
+		query.Set("client_id", provider.ClientID)
+		query.Set("post_logout_redirect_uri", redirectUrl)
+		return discovery.EndSessionEndpoint + "?" + query.Encode()
+	}
 	if provider.LogoutURL == "" {
 		return ""
 	}
-	redirectUrl := GetConfig().FrontendURL + "ui/login"
 	logoutUrl := strings.ReplaceAll(provider.LogoutURL, "{logoutRedirectUri}", redirectUrl)
 	return logoutUrl
 }
@@ -391,13 +593,21 @@ func (router *AuthRouter) login(w http.ResponseWriter, r *http.Request) {
 		longLived = true
 	}
 	redir := r.URL.Query().Get("redir")
-	config := router.getConfig(provider)
 	payload := &AuthStateLoginPayload{
 		LoginType: loginType,
 		UserID:    "",
 		LongLived: longLived, // TODO
 		Redirect:  redir,
 	}
+	if provider.ProviderType == int(OIDC) {
+		nonce, err := GetOIDCNonce()
+		if err != nil {
+			SendTemporaryRedirect(w, router.getRedirectFailedUrl(loginType))
+			return
+		}
+		payload.Nonce = nonce
+		payload.CodeVerifier = oauth2.GenerateVerifier()
+	}
 	authState := &AuthState{
 		AuthProviderID: provider.ID,
 		Expiry:         time.Now().Add(time.Minute * 5),
@@ -408,6 +618,28 @@ func (router *AuthRouter) login(w http.ResponseWriter, r *http.Request) {
 		SendTemporaryRedirect(w, router.getRedirectFailedUrl(loginType))
 		return
 	}
+	if provider.ProviderType == int(SAML) {
+		url, err := router.getSAMLServiceProvider(provider, authState).GetAuthnRequestURL(authState.ID)
+		if err != nil {
+			log.Println(err)
+			SendTemporaryRedirect(w, router.getRedirectFailedUrl(loginType))
+			return
+		}
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+	if provider.ProviderType == int(OIDC) {
+		config, _, err := router.getOIDCConfig(provider)
+		if err != nil {
+			log.Println(err)
+			SendTemporaryRedirect(w, router.getRedirectFailedUrl(loginType))
+			return
+		}
+		url := config.AuthCodeURL(authState.ID, oauth2.SetAuthURLParam("nonce", payload.Nonce), oauth2.S256ChallengeOption(payload.CodeVerifier))
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
+	config := router.getConfig(provider)
 	url := config.AuthCodeURL(authState.ID)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
@@ -419,28 +651,108 @@ func (router *AuthRouter) callback(w http.ResponseWriter, r *http.Request) {
 		SendTemporaryRedirect(w, router.getRedirectFailedUrl("ui"))
 		return
 	}
-	claims, payload, err := router.getUserInfo(provider, r.FormValue("state"), r.FormValue("code"))
+	claims, payload, userInfo, err := router.getUserInfo(provider, r.FormValue("state"), r.FormValue("code"))
 	if err != nil {
 		log.Println(err)
 		SendTemporaryRedirect(w, router.getRedirectFailedUrl(payload.LoginType))
 		return
 	}
-	if !router.isValidEmailForOrg(provider, claims.Email) {
-		SendTemporaryRedirect(w, router.getRedirectFailedUrl(payload.LoginType))
+	SendTemporaryRedirect(w, router.getExternalLoginRedirectUrl(provider, claims.Email, payload, userInfo))
+}
+
+// samlACS is the assertion consumer service receiving the identity provider's
+// response using the HTTP-POST binding. The RelayState refers to the auth state
+// created when the login was initiated.
+func (router *AuthRouter) samlACS(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provider, err := GetAuthProviderRepository().GetOne(vars["id"])
+	if err != nil || provider.ProviderType != int(SAML) {
+		SendSeeOther(w, router.getRedirectFailedUrl("ui"))
+		return
+	}
+	authState, err := GetAuthStateRepository().GetOne(r.PostFormValue("RelayState"))
+	if err != nil || authState.AuthProviderID != provider.ID || authState.AuthStateType != AuthRequestState {
+		SendSeeOther(w, router.getRedirectFailedUrl("ui"))
+		return
+	}
+	GetAuthStateRepository().Delete(authState)
+	payload := unmarshalAuthStateLoginPayload(authState.Payload)
+	info, err := router.getSAMLServiceProvider(provider, authState).ParseResponse(r.PostFormValue("SAMLResponse"), time.Now())
+	if err != nil {
+		log.Println("SAML response validation failed: " + err.Error())
+		SendSeeOther(w, router.getRedirectFailedUrl(payload.LoginType))
+		return
+	}
+	userInfo := make(map[string]interface{})
+	for name, values := range info.Attributes {
+		list := []interface{}{}
+		for _, value := range values {
+			list = append(list, value)
+		}
+		userInfo[name] = list
+	}
+	SendSeeOther(w, router.getExternalLoginRedirectUrl(provider, info.Email, payload, userInfo))
+}
+
+func (router *AuthRouter) samlMetadata(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	provider, err := GetAuthProviderRepository().GetOne(vars["id"])
+	if err != nil || provider.ProviderType != int(SAML) {
+		SendNotFound(w)
+		return
+	}
+	data, err := router.getSAMLServiceProvider(provider, nil).GetMetadata()
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
 		return
 	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(data)
+}
+
+// getSAMLServiceProvider returns the SAML settings for the provider. The ID of
+// the authentication request is derived from the auth state, if any.
+func (router *AuthRouter) getSAMLServiceProvider(provider *AuthProvider, authState *AuthState) *SAMLServiceProvider {
+	sp := &SAMLServiceProvider{
+		EntityID:    GetConfig().PublicURL + "auth/" + provider.ID + "/saml/metadata",
+		ACSURL:      GetConfig().PublicURL + "auth/" + provider.ID + "/saml/acs",
+		IdPEntityID: provider.SAMLEntityID,
+		IdPSSOURL:   provider.AuthURL,
+		EmailAttr:   provider.UserInfoEmailField,
+	}
+	if certs, err := ParseSAMLCertificates(provider.SAMLCertificate); err == nil {
+		sp.IdPCerts = certs
+	}
+	if authState != nil {
+		sp.RequestID = "_" + authState.ID
+	}
+	return sp
+}
+
+// getExternalLoginRedirectUrl checks whether the user authenticated by the
+// provider may log in and caches the result for the verify request. It returns
+// the URL the user is redirected to.
+func (router *AuthRouter) getExternalLoginRedirectUrl(provider *AuthProvider, email string, payload *AuthStateLoginPayload, userInfo map[string]interface{}) string {
+	if !router.isValidEmailForOrg(provider, email) {
+		return router.getRedirectFailedUrl(payload.LoginType)
+	}
 	allowAnyUser, _ := GetSettingsRepository().GetBool(provider.OrganizationID, SettingAllowAnyUser.Name)
 	if !allowAnyUser {
-		_, err := GetUserRepository().GetByEmail(claims.Email)
+		_, err := GetUserRepository().GetByEmail(email)
 		if err != nil {
-			SendTemporaryRedirect(w, router.getRedirectFailedUrl(payload.LoginType))
-			return
+			return router.getRedirectFailedUrl(payload.LoginType)
 		}
 	}
 	payloadNew := &AuthStateLoginPayload{
-		UserID:    claims.Email,
+		UserID:    email,
 		LoginType: payload.LoginType,
 		LongLived: payload.LongLived,
+		Groups:    router.getUserInfoGroups(userInfo, provider.GroupsClaim),
+	}
+	if err := router.applyRules(provider, userInfo, payloadNew); err != nil {
+		log.Println(err)
+		return router.getRedirectFailedUrl(payload.LoginType)
 	}
 	authState := &AuthState{
 		AuthProviderID: provider.ID,
@@ -450,14 +762,13 @@ func (router *AuthRouter) callback(w http.ResponseWriter, r *http.Request) {
 	}
 	if err := GetAuthStateRepository().Create(authState); err != nil {
 		log.Println(err)
-		SendTemporaryRedirect(w, router.getRedirectFailedUrl(payload.LoginType))
-		return
+		return router.getRedirectFailedUrl(payload.LoginType)
 	}
 	redirectUrl := router.getRedirectSuccessUrl(payload.LoginType, authState)
 	if payload.Redirect != "" {
 		redirectUrl = redirectUrl + "?redir=" + url.QueryEscape(payload.Redirect)
 	}
-	SendTemporaryRedirect(w, redirectUrl)
+	return redirectUrl
 }
 
 func (router *AuthRouter) getRedirectSuccessUrl(loginType string, authState *AuthState) string {
@@ -484,49 +795,223 @@ func (router *AuthRouter) isValidEmailForOrg(provider *AuthProvider, email strin
 	return GetOrganizationRepository().isValidEmailForOrg(email, org)
 }
 
-func (router *AuthRouter) getUserInfo(provider *AuthProvider, state string, code string) (*Claims, *AuthStateLoginPayload, error) {
+func (router *AuthRouter) getUserInfo(provider *AuthProvider, state string, code string) (*Claims, *AuthStateLoginPayload, map[string]interface{}, error) {
 	// Verify state string
 	authState, err := GetAuthStateRepository().GetOne(state)
 	if err != nil {
-		return nil, nil, fmt.Errorf("state not found for id %s", strings.Replace(strings.Replace(state, "\r", "", -1), "\n", "", -1))
+		return nil, nil, nil, fmt.Errorf("state not found for id %s", strings.Replace(strings.Replace(state, "\r", "", -1), "\n", "", -1))
 	}
 	if authState.AuthProviderID != provider.ID {
-		return nil, nil, fmt.Errorf("auth providers don't match")
+		return nil, nil, nil, fmt.Errorf("auth providers don't match")
 	}
 	defer GetAuthStateRepository().Delete(authState)
-	// Exchange authorization code for an access token
-	config := router.getConfig(provider)
-	token, err := config.Exchange(context.Background(), code)
-	if err != nil {
-		return nil, nil, fmt.Errorf("code exchange failed: %s", err.Error())
+	payload := unmarshalAuthStateLoginPayload(authState.Payload)
+	emailField := router.getEmailField(provider)
+	var result map[string]interface{}
+	if provider.ProviderType == int(OIDC) {
+		result, err = router.getOIDCUserInfo(provider, payload, code)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		// Exchange authorization code for an access token
+		config := router.getConfig(provider)
+		token, err := config.Exchange(context.Background(), code)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("code exchange failed: %s", err.Error())
+		}
+		// Get user info from resource server
+		result, err = router.fetchUserInfo(provider.UserInfoURL, token.AccessToken)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	// Extract email address from JSON response
+	email, _ := result[emailField].(string)
+	if strings.TrimSpace(email) == "" {
+		return nil, nil, nil, fmt.Errorf("could not read email address from field: %s", emailField)
+	}
+	claims := &Claims{
+		Email: email,
 	}
-	// Get user info from resource server
+	return claims, payload, result, nil
+}
+
+func (router *AuthRouter) fetchUserInfo(userInfoURL, accessToken string) (map[string]interface{}, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", provider.UserInfoURL, nil)
+	req, err := http.NewRequest("GET", userInfoURL, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed creating http request: %s", err.Error())
+		return nil, fmt.Errorf("failed creating http request: %s", err.Error())
 	}
-	req.Header.Add("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+accessToken)
 	response, err := client.Do(req)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed getting user info: %s", err.Error())
+		return nil, fmt.Errorf("failed getting user info: %s", err.Error())
 	}
 	defer response.Body.Close()
 	contents, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed reading response body: %s", err.Error())
+		return nil, fmt.Errorf("failed reading response body: %s", err.Error())
 	}
-	// Extract email address from JSON response
 	var result map[string]interface{}
 	json.Unmarshal([]byte(contents), &result)
-	if (result[provider.UserInfoEmailField] == nil) || (strings.TrimSpace(result[provider.UserInfoEmailField].(string)) == "") {
-		return nil, nil, fmt.Errorf("could not read email address from field: %s", provider.UserInfoEmailField)
+	return result, nil
+}
+
+// getOIDCUserInfo exchanges the authorization code using the PKCE verifier
+// and returns the claims of the validated ID token. Claims missing in the ID
+// token are taken from the user info endpoint, if available.
+func (router *AuthRouter) getOIDCUserInfo(provider *AuthProvider, payload *AuthStateLoginPayload, code string) (map[string]interface{}, error) {
+	if payload == nil || payload.Nonce == "" || payload.CodeVerifier == "" {
+		return nil, fmt.Errorf("auth state is missing nonce or code verifier")
+	}
+	config, discovery, err := router.getOIDCConfig(provider)
+	if err != nil {
+		return nil, err
 	}
-	claims := &Claims{
-		Email: result[provider.UserInfoEmailField].(string),
+	token, err := config.Exchange(context.Background(), code, oauth2.VerifierOption(payload.CodeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %s", err.Error())
 	}
-	payload := unmarshalAuthStateLoginPayload(authState.Payload)
-	return claims, payload, nil
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("token response contains no id token")
+	}
+	claims, err := GetOIDCClient().VerifyIDToken(discovery, provider.ClientID, rawIDToken, payload.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("id token validation failed: %s", err.Error())
+	}
+	result := map[string]interface{}(claims)
+	emailField := router.getEmailField(provider)
+	if result[emailField] == nil && discovery.UserInfoEndpoint != "" {
+		userInfo, err := router.fetchUserInfo(discovery.UserInfoEndpoint, token.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if sub, _ := userInfo["sub"].(string); sub == "" || sub != result["sub"] {
+			return nil, fmt.Errorf("user info subject does not match id token")
+		}
+		for key, value := range userInfo {
+			if _, ok := result[key]; !ok {
+				result[key] = value
+			}
+		}
+	}
+	if verified, ok := result["email_verified"].(bool); ok && !verified && emailField == "email" {
+		return nil, fmt.Errorf("email address is not verified")
+	}
+	return result, nil
+}
+
+// getEmailField returns the user info field containing the email address.
+// OIDC providers default to the standard email claim.
+func (router *AuthRouter) getEmailField(provider *AuthProvider) string {
+	if provider.UserInfoEmailField == "" && provider.ProviderType == int(OIDC) {
+		return "email"
+	}
+	return provider.UserInfoEmailField
+}
+
+// getUserInfoGroups returns the group names contained in the user info's
+// claim. The claim can either be a list or a comma-separated string.
+func (router *AuthRouter) getUserInfoGroups(userInfo map[string]interface{}, claim string) []string {
+	res := []string{}
+	if claim == "" {
+		return res
+	}
+	switch v := userInfo[claim].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if name, ok := item.(string); ok && strings.TrimSpace(name) != "" {
+				res = append(res, strings.TrimSpace(name))
+			}
+		}
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if strings.TrimSpace(name) != "" {
+				res = append(res, strings.TrimSpace(name))
+			}
+		}
+	}
+	return res
+}
+
+// applyRules evaluates the provider's rules against the user info. If any rule
+// assigns a role, the user gets the highest role of the matching rules, or the
+// user role if none matches. Groups of matching rules are added to the
+// payload's groups.
+func (router *AuthRouter) applyRules(provider *AuthProvider, userInfo map[string]interface{}, payload *AuthStateLoginPayload) error {
+	rules, err := GetAuthProviderRuleRepository().GetAll(provider.ID)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if rule.Role != nil && payload.Role == nil {
+			role := UserRoleUser
+			payload.Role = &role
+		}
+		if rule.GroupID != "" {
+			payload.SyncGroups = true
+		}
+		if !router.matchesRule(rule, userInfo) {
+			continue
+		}
+		if rule.Role != nil && *rule.Role > *payload.Role {
+			role := *rule.Role
+			payload.Role = &role
+		}
+		if rule.GroupID != "" {
+			group, err := GetGroupRepository().GetOne(string(rule.GroupID))
+			if err != nil {
+				return err
+			}
+			payload.Groups = append(payload.Groups, group.Name)
+		}
+	}
+	return nil
+}
+
+func (router *AuthRouter) matchesRule(rule *AuthProviderRule, userInfo map[string]interface{}) bool {
+	for _, value := range router.getUserInfoValues(userInfo, rule.Claim) {
+		if value == strings.TrimSpace(rule.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// getUserInfoValues returns the values of the user info's claim as strings.
+// Nested claims can be specified using a dot-separated path, such as
+// "realm_access.roles".
+func (router *AuthRouter) getUserInfoValues(userInfo map[string]interface{}, claim string) []string {
+	v, ok := userInfo[claim]
+	if !ok {
+		var obj interface{} = userInfo
+		for _, key := range strings.Split(claim, ".") {
+			m, isMap := obj.(map[string]interface{})
+			if !isMap {
+				return []string{}
+			}
+			obj = m[key]
+		}
+		v = obj
+	}
+	list, isList := v.([]interface{})
+	if !isList {
+		list = []interface{}{v}
+	}
+	res := []string{}
+	for _, item := range list {
+		switch value := item.(type) {
+		case string:
+			res = append(res, strings.TrimSpace(value))
+		case bool:
+			res = append(res, strconv.FormatBool(value))
+		case float64:
+			res = append(res, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	return res
 }
 
 func (router *AuthRouter) SendPasswordResetEmail(user *User, ID string, org *Organization) error {
@@ -558,6 +1043,28 @@ func (router *AuthRouter) getConfig(provider *AuthProvider) *oauth2.Config {
 	return config
 }
 
+// getOIDCConfig returns the OAuth2 config using the endpoints discovered from
+// the provider's issuer URL. The openid scope is always requested.
+func (router *AuthRouter) getOIDCConfig(provider *AuthProvider) (*oauth2.Config, *OIDCDiscovery, error) {
+	discovery, err := GetOIDCClient().GetDiscovery(provider.IssuerURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := router.getConfig(provider)
+	config.Endpoint.AuthURL = discovery.AuthorizationEndpoint
+	config.Endpoint.TokenURL = discovery.TokenEndpoint
+	config.Scopes = []string{"openid"}
+	for _, scope := range strings.Split(provider.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" && scope != "openid" {
+			config.Scopes = append(config.Scopes, scope)
+		}
+	}
+	if len(config.Scopes) == 1 {
+		config.Scopes = append(config.Scopes, "email", "profile")
+	}
+	return config, discovery, nil
+}
+
 func (router *AuthRouter) createClaims(user *User) *Claims {
 	claims := &Claims{
 		UserID:     user.ID,
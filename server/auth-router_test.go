@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
 )
 
 func TestAuthPasswordLogin(t *testing.T) {
@@ -199,3 +201,316 @@ func TestAuthSingleOrgWithMultipleOrgs(t *testing.T) {
 	res := executeTestRequest(req)
 	checkTestResponseCode(t, http.StatusNotFound, res.Code)
 }
+
+func TestAuthOIDCLogin(t *testing.T) {
+	clearTestDB()
+	p := newTestOIDCProvider(t)
+	org := createTestOrg("test.com")
+	provider := &AuthProvider{
+		OrganizationID: org.ID,
+		Name:           "OIDC",
+		ProviderType:   int(OIDC),
+		IssuerURL:      p.issuer,
+		ClientID:       "client1",
+		ClientSecret:   "secret1",
+	}
+	GetAuthProviderRepository().Create(provider)
+
+	req := newHTTPRequest("GET", "/auth/"+provider.ID+"/login/ui", "", nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusTemporaryRedirect, res.Code)
+	location, err := url.Parse(res.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, p.issuer+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	query := location.Query()
+	checkTestString(t, "client1", query.Get("client_id"))
+	checkTestString(t, "openid email profile", query.Get("scope"))
+	checkTestString(t, "S256", query.Get("code_challenge_method"))
+
+	// Nonce and code verifier are stored with the auth state
+	authState, err := GetAuthStateRepository().GetOne(query.Get("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := unmarshalAuthStateLoginPayload(authState.Payload)
+	checkTestString(t, payload.Nonce, query.Get("nonce"))
+	checkTestString(t, oauth2.S256ChallengeFromVerifier(payload.CodeVerifier), query.Get("code_challenge"))
+}
+
+func TestAuthRulesLogin(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	GetSettingsRepository().Set(org.ID, SettingAllowAnyUser.Name, "1")
+	provider := &AuthProvider{
+		OrganizationID:     org.ID,
+		Name:               "OAuth",
+		ProviderType:       int(OAuth2),
+		UserInfoEmailField: "email",
+	}
+	GetAuthProviderRepository().Create(provider)
+	group := &Group{OrganizationID: org.ID, Name: "Facilities"}
+	GetGroupRepository().Create(group)
+	orgAdmin := UserRoleOrgAdmin
+	spaceAdmin := UserRoleSpaceAdmin
+	GetAuthProviderRuleRepository().Create(&AuthProviderRule{AuthProviderID: provider.ID, Claim: "groups", Value: "admins", Role: &orgAdmin})
+	GetAuthProviderRuleRepository().Create(&AuthProviderRule{AuthProviderID: provider.ID, Claim: "org.department", Value: "Facilities", Role: &spaceAdmin, GroupID: NullString(group.ID)})
+
+	login := func(userInfo map[string]interface{}) *User {
+		router := &AuthRouter{}
+		redirectUrl := router.getExternalLoginRedirectUrl(provider, "foo@test.com", &AuthStateLoginPayload{LoginType: "ui"}, userInfo)
+		prefix := GetConfig().FrontendURL + "ui/login/success/"
+		if !strings.HasPrefix(redirectUrl, prefix) {
+			t.Fatalf("Unexpected redirect to %s", redirectUrl)
+		}
+		req := newHTTPRequest("GET", "/auth/verify/"+strings.TrimPrefix(redirectUrl, prefix), "", nil)
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusOK, res.Code)
+		user, err := GetUserRepository().GetByEmail("foo@test.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	// New user with admin group
+	user := login(map[string]interface{}{"email": "foo@test.com", "groups": []interface{}{"users", "admins"}})
+	checkTestInt(t, int(UserRoleOrgAdmin), int(user.Role))
+	checkTestBool(t, false, GetGroupRepository().IsMember(group, user.ID))
+
+	// Highest role of all matching rules wins
+	user = login(map[string]interface{}{"email": "foo@test.com", "groups": "admins", "org": map[string]interface{}{"department": "Facilities"}})
+	checkTestInt(t, int(UserRoleOrgAdmin), int(user.Role))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group, user.ID))
+
+	// Leaving the admin group revokes the role
+	user = login(map[string]interface{}{"email": "foo@test.com", "groups": []interface{}{"users"}, "org": map[string]interface{}{"department": "Facilities"}})
+	checkTestInt(t, int(UserRoleSpaceAdmin), int(user.Role))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group, user.ID))
+
+	// No matching rule
+	user = login(map[string]interface{}{"email": "foo@test.com"})
+	checkTestInt(t, int(UserRoleUser), int(user.Role))
+	checkTestBool(t, false, GetGroupRepository().IsMember(group, user.ID))
+
+	// Super admins are not demoted
+	user.Role = UserRoleSuperAdmin
+	GetUserRepository().Update(user)
+	user = login(map[string]interface{}{"email": "foo@test.com"})
+	checkTestInt(t, int(UserRoleSuperAdmin), int(user.Role))
+}
+
+func TestAuthGetUserInfoValues(t *testing.T) {
+	router := &AuthRouter{}
+	userInfo := map[string]interface{}{
+		"department":   " Sales ",
+		"groups":       []interface{}{"a", "b", 1.0},
+		"verified":     true,
+		"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}},
+		"a.b":          "flat",
+	}
+	checkTestString(t, "Sales", strings.Join(router.getUserInfoValues(userInfo, "department"), ","))
+	checkTestString(t, "a,b,1", strings.Join(router.getUserInfoValues(userInfo, "groups"), ","))
+	checkTestString(t, "true", strings.Join(router.getUserInfoValues(userInfo, "verified"), ","))
+	checkTestString(t, "admin", strings.Join(router.getUserInfoValues(userInfo, "realm_access.roles"), ","))
+	checkTestString(t, "flat", strings.Join(router.getUserInfoValues(userInfo, "a.b"), ","))
+	checkTestInt(t, 0, len(router.getUserInfoValues(userInfo, "unknown")))
+	checkTestInt(t, 0, len(router.getUserInfoValues(userInfo, "department.name")))
+}
+
+func loginTestPasswordUser(t *testing.T, user *User) *JWTResponse {
+	payload := "{ \"email\": \"" + user.Email + "\", \"password\": \"12345678\" }"
+	req := newHTTPRequest("POST", "/auth/login", "", bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *JWTResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	return resBody
+}
+
+func getTestTOTPCode(t *testing.T, secret string, step int64) string {
+	code, err := GetTOTPCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestAuthPasswordLoginTOTP(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	user.HashedPassword = NullString(GetUserRepository().GetHashedPassword("12345678"))
+	GetUserRepository().Update(user)
+
+	// Enroll
+	req := newHTTPRequest("POST", "/user/me/totp", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var enrollment *TOTPEnrollmentResponse
+	json.Unmarshal(res.Body.Bytes(), &enrollment)
+	checkTestBool(t, true, strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/"))
+	checkTestBool(t, true, strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,"))
+	checkTestInt(t, TOTPRecoveryCodeCount, len(enrollment.RecoveryCodes))
+	step := GetTOTPStep(time.Now())
+
+	// Not enabled before confirmation
+	resBody := loginTestPasswordUser(t, user)
+	checkTestBool(t, false, resBody.RequireOTP)
+	checkTestBool(t, true, len(resBody.AccessToken) > 32)
+
+	req = newHTTPRequest("POST", "/user/me/totp/verify", user.ID, bytes.NewBufferString(`{"code": "000000"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	req = newHTTPRequest("POST", "/user/me/totp/verify", user.ID, bytes.NewBufferString(`{"code": "`+getTestTOTPCode(t, enrollment.Secret, step)+`"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, true, GetTOTPRepository().IsEnabled(user.ID))
+
+	// Password only returns a challenge
+	resBody = loginTestPasswordUser(t, user)
+	checkTestBool(t, true, resBody.RequireOTP)
+	checkTestBool(t, false, resBody.RequireOTPSetup)
+	checkTestString(t, "", resBody.AccessToken)
+	challengeID := resBody.ChallengeID
+
+	// Wrong code
+	payload := `{"challengeId": "` + challengeID + `", "code": "000000"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	// Codes can't be used twice
+	payload = `{"challengeId": "` + challengeID + `", "code": "` + getTestTOTPCode(t, enrollment.Secret, step) + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	payload = `{"challengeId": "` + challengeID + `", "code": "` + getTestTOTPCode(t, enrollment.Secret, step+1) + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestBool(t, true, len(resBody.AccessToken) > 32)
+	checkTestBool(t, true, len(resBody.RefreshToken) == 36)
+
+	// Challenge can't be reused
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	// Recovery codes can be used once
+	resBody = loginTestPasswordUser(t, user)
+	payload = `{"challengeId": "` + resBody.ChallengeID + `", "code": "` + strings.ToUpper(enrollment.RecoveryCodes[0]) + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	resBody = loginTestPasswordUser(t, user)
+	payload = `{"challengeId": "` + resBody.ChallengeID + `", "code": "` + enrollment.RecoveryCodes[0] + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	req = newHTTPRequest("GET", "/user/me/totp", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var status *GetTOTPResponse
+	json.Unmarshal(res.Body.Bytes(), &status)
+	checkTestBool(t, true, status.Enabled)
+	checkTestInt(t, TOTPRecoveryCodeCount-1, status.RecoveryCodesLeft)
+}
+
+func TestAuthPasswordLoginTOTPBan(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	user.HashedPassword = NullString(GetUserRepository().GetHashedPassword("12345678"))
+	GetUserRepository().Update(user)
+	secret, _ := GetTOTPSecret()
+	GetTOTPRepository().Set(&TOTP{UserID: user.ID, Secret: secret, Enabled: true, Created: time.Now()})
+
+	challengeID := loginTestPasswordUser(t, user).ChallengeID
+	for i := 0; i < 3; i++ {
+		payload := `{"challengeId": "` + challengeID + `", "code": "000000"}`
+		req := newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusNotFound, res.Code)
+	}
+	checkTestBool(t, true, authAttemptRepositoryIsUserDisabled(t, user.ID))
+
+	// The challenge is removed after too many attempts
+	_, err := GetAuthStateRepository().GetOne(challengeID)
+	checkTestBool(t, true, err != nil)
+
+	// Would be successful, but fails cause banned
+	payload := `{"challengeId": "` + challengeID + `", "code": "` + getTestTOTPCode(t, secret, GetTOTPStep(time.Now())) + `"}`
+	req := newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestAuthPasswordLoginTOTPRequired(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	GetSettingsRepository().Set(org.ID, SettingTOTPRequiredAdmins.Name, "1")
+	admin := createTestUserOrgAdmin(org)
+	admin.HashedPassword = NullString(GetUserRepository().GetHashedPassword("12345678"))
+	GetUserRepository().Update(admin)
+	user := createTestUserInOrg(org)
+	user.HashedPassword = NullString(GetUserRepository().GetHashedPassword("12345678"))
+	GetUserRepository().Update(user)
+
+	// Users without admin role are not affected
+	resBody := loginTestPasswordUser(t, user)
+	checkTestBool(t, false, resBody.RequireOTP)
+	checkTestBool(t, true, len(resBody.AccessToken) > 32)
+
+	resBody = loginTestPasswordUser(t, admin)
+	checkTestBool(t, true, resBody.RequireOTP)
+	checkTestBool(t, true, resBody.RequireOTPSetup)
+	checkTestString(t, "", resBody.AccessToken)
+	challengeID := resBody.ChallengeID
+
+	payload := `{"challengeId": "` + challengeID + `"}`
+	req := newHTTPRequest("POST", "/auth/login/otp/setup", "", bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var enrollment *TOTPEnrollmentResponse
+	json.Unmarshal(res.Body.Bytes(), &enrollment)
+
+	// The pending enrollment can't be replaced
+	req = newHTTPRequest("POST", "/auth/login/otp/setup", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	// Recovery codes can't be used to confirm the enrollment
+	payload = `{"challengeId": "` + challengeID + `", "code": "` + enrollment.RecoveryCodes[0] + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	payload = `{"challengeId": "` + challengeID + `", "code": "` + getTestTOTPCode(t, enrollment.Secret, GetTOTPStep(time.Now())) + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestBool(t, true, len(resBody.AccessToken) > 32)
+	checkTestBool(t, true, GetTOTPRepository().IsEnabled(admin.ID))
+
+	// Setup is only possible once
+	resBody = loginTestPasswordUser(t, admin)
+	checkTestBool(t, true, resBody.RequireOTP)
+	checkTestBool(t, false, resBody.RequireOTPSetup)
+	payload = `{"challengeId": "` + resBody.ChallengeID + `"}`
+	req = newHTTPRequest("POST", "/auth/login/otp/setup", "", bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	// Can't be disabled while mandatory
+	payload = `{"code": "` + enrollment.RecoveryCodes[1] + `"}`
+	req = newHTTPRequest("POST", "/user/me/totp/disable", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	checkTestBool(t, true, GetTOTPRepository().IsEnabled(admin.ID))
+}
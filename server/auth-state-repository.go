@@ -16,6 +16,8 @@ const (
 	AuthAtlassian            AuthStateType = 3
 	AuthMergeRequest         AuthStateType = 4
 	AuthResetPasswordRequest AuthStateType = 5
+	AuthMSGraphConnect       AuthStateType = 6
+	AuthTOTPChallenge        AuthStateType = 7
 )
 
 type AuthState struct {
@@ -47,7 +49,12 @@ func GetAuthStateRepository() *AuthStateRepository {
 }
 
 func (r *AuthStateRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
-	// No updates yet
+	if curVersion < 25 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE auth_states " +
+			"ADD COLUMN attempts INT NOT NULL DEFAULT 0"); err != nil {
+			panic(err)
+		}
+	}
 }
 
 func (r *AuthStateRepository) Create(e *AuthState) error {
@@ -76,6 +83,20 @@ func (r *AuthStateRepository) GetOne(id string) (*AuthState, error) {
 	return e, nil
 }
 
+// AddAttempt counts an attempt to complete the state, i.e. to enter a code,
+// and returns the number of attempts made so far, including this one.
+func (r *AuthStateRepository) AddAttempt(e *AuthState) (int, error) {
+	var attempts int
+	err := GetDatabase().DB().QueryRow("UPDATE auth_states SET attempts = attempts + 1 "+
+		"WHERE id = $1 "+
+		"RETURNING attempts",
+		e.ID).Scan(&attempts)
+	if err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
 func (r *AuthStateRepository) Delete(e *AuthState) error {
 	_, err := GetDatabase().DB().Exec("DELETE FROM auth_states WHERE id = $1", e.ID)
 	return err
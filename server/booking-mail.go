@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+type BookingMailType int
+
+const (
+	BookingMailCreated   BookingMailType = 1
+	BookingMailChanged   BookingMailType = 2
+	BookingMailCancelled BookingMailType = 3
+)
+
+// sendBookingMails notifies the owners of the bookings about a change made by
+// actor, one mail per owner. If actor is nil, the change has been made by the
+// system. Bookings must have been loaded from the database, as mails show the
+// stored wall clock times.
+func sendBookingMails(mailType BookingMailType, list []*BookingDetails, actor *User) {
+	var userIDs []string
+	bookings := map[string][]*BookingDetails{}
+	for _, e := range list {
+		if _, ok := bookings[e.UserID]; !ok {
+			userIDs = append(userIDs, e.UserID)
+		}
+		bookings[e.UserID] = append(bookings[e.UserID], e)
+	}
+	for _, userID := range userIDs {
+		if err := sendBookingMail(mailType, bookings[userID], actor); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// sendBookingMailsByID is like sendBookingMails, but loads the bookings first.
+func sendBookingMailsByID(mailType BookingMailType, list []*Booking, actor *User) {
+	var details []*BookingDetails
+	for _, e := range list {
+		if e.ID == "" {
+			continue
+		}
+		booking, err := GetBookingRepository().GetOne(e.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		details = append(details, booking)
+	}
+	sendBookingMails(mailType, details, actor)
+}
+
+func sendBookingMail(mailType BookingMailType, list []*BookingDetails, actor *User) error {
+	user, err := GetUserRepository().GetOne(list[0].UserID)
+	if err != nil {
+		return err
+	}
+	onBehalf := mailType == BookingMailCreated && actor != nil && actor.ID != user.ID
+	if !isBookingMailEnabled(user.ID, mailType, onBehalf) {
+		return nil
+	}
+	org, err := GetOrganizationRepository().GetOne(user.OrganizationID)
+	if err != nil {
+		return err
+	}
+	templateFile := EmailTemplateBookingCreated
+	if onBehalf {
+		templateFile = EmailTemplateBookingOnBehalf
+	} else if mailType == BookingMailChanged {
+		templateFile = EmailTemplateBookingChanged
+	} else if mailType == BookingMailCancelled {
+		templateFile = EmailTemplateBookingCancelled
+	}
+	var lines []string
+	var attachments []*EmailAttachment
+	for i, e := range list {
+		lines = append(lines, e.Space.Name+", "+e.Space.Location.Name+": "+
+			e.Enter.Format("2006-01-02 15:04")+" - "+e.Leave.Format("2006-01-02 15:04"))
+		attachment, err := getBookingInvite(e, user, mailType == BookingMailCancelled)
+		if err != nil {
+			return err
+		}
+		if len(list) > 1 {
+			attachment.Filename = "invite-" + strconv.Itoa(i+1) + ".ics"
+		}
+		attachments = append(attachments, attachment)
+	}
+	vars := map[string]string{
+		"recipientName":  user.Email,
+		"recipientEmail": user.Email,
+		"bookings":       strings.Join(lines, "\n"),
+	}
+	if actor != nil {
+		vars["actorEmail"] = actor.Email
+	}
+	return sendEmailWithAttachments(user.Email, GetConfig().SMTPSenderAddress, templateFile, org.Language, vars, attachments)
+}
+
+// sendBookingReminderMail reminds the owner of an upcoming booking. The
+// booking must have been loaded from the database.
+func sendBookingReminderMail(e *BookingDetails) error {
+	user, err := GetUserRepository().GetOne(e.UserID)
+	if err != nil {
+		return err
+	}
+	org, err := GetOrganizationRepository().GetOne(user.OrganizationID)
+	if err != nil {
+		return err
+	}
+	vars := map[string]string{
+		"recipientName":  user.Email,
+		"recipientEmail": user.Email,
+		"bookings": e.Space.Name + ", " + e.Space.Location.Name + ": " +
+			e.Enter.Format("2006-01-02 15:04") + " - " + e.Leave.Format("2006-01-02 15:04"),
+	}
+	return sendEmail(user.Email, GetConfig().SMTPSenderAddress, EmailTemplateBookingReminder, org.Language, vars)
+}
+
+// isBookingMailEnabled returns false if the user has opted out of the mail.
+// Users without a stored preference receive all mails.
+func isBookingMailEnabled(userID string, mailType BookingMailType, onBehalf bool) bool {
+	preference := PreferenceMailBookingCreated
+	if onBehalf {
+		preference = PreferenceMailBookingOnBehalf
+	} else if mailType == BookingMailChanged {
+		preference = PreferenceMailBookingChanged
+	} else if mailType == BookingMailCancelled {
+		preference = PreferenceMailBookingCancelled
+	}
+	enabled, err := GetUserPreferencesRepository().GetBool(userID, preference.Name)
+	return err != nil || enabled
+}
+
+// getBookingInvite returns an iTIP invite (or cancellation) for the booking.
+// The event's UID is the booking ID, so that calendar clients update the
+// same event on subsequent mails.
+func getBookingInvite(e *BookingDetails, user *User, cancel bool) (*EmailAttachment, error) {
+	router := &ICalRouter{}
+	calendarEvent := router.getICalEvent(e, false)
+	if calendarEvent == nil {
+		return nil, ErrCalendarEventNotFound
+	}
+	method, status := "REQUEST", ical.EventConfirmed
+	if cancel {
+		method, status = "CANCEL", ical.EventCancelled
+	}
+	event := newICalEvent(calendarEvent)
+	event.Props.SetText(ical.PropStatus, string(status))
+	// Each mail must carry a higher sequence number than the previous one
+	sequence := ical.NewProp(ical.PropSequence)
+	sequence.Value = strconv.FormatInt(time.Now().Unix(), 10)
+	event.Props.Set(sequence)
+	organizer := ical.NewProp(ical.PropOrganizer)
+	organizer.Value = "mailto:" + GetConfig().SMTPSenderAddress
+	event.Props.Set(organizer)
+	attendee := ical.NewProp(ical.PropAttendee)
+	attendee.Params.Set(ical.ParamCommonName, user.Email)
+	attendee.Value = "mailto:" + user.Email
+	event.Props.Set(attendee)
+	cal := newICalCalendar()
+	cal.Props.SetText(ical.PropMethod, method)
+	cal.Children = append(cal.Children, event.Component)
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return nil, err
+	}
+	return &EmailAttachment{
+		Filename:    "invite.ics",
+		ContentType: ical.MIMEType + "; charset=UTF-8; method=" + method,
+		Data:        buf.Bytes(),
+	}, nil
+}
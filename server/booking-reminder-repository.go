@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type BookingReminderRepository struct {
+}
+
+const (
+	BookingReminderLease      = time.Minute * 5
+	BookingReminderMaxMinutes = 24 * 60
+)
+
+var bookingReminderRepository *BookingReminderRepository
+var bookingReminderRepositoryOnce sync.Once
+
+// GetBookingReminderRepository returns the repository recording which
+// reminders have been sent. Reminders are tracked per booking and enter time,
+// so that moving a booking results in a new reminder.
+func GetBookingReminderRepository() *BookingReminderRepository {
+	bookingReminderRepositoryOnce.Do(func() {
+		bookingReminderRepository = &BookingReminderRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS booking_reminders (" +
+			"booking_id uuid NOT NULL, " +
+			"enter_time TIMESTAMP NOT NULL, " +
+			"lease_until TIMESTAMP NOT NULL, " +
+			"sent TIMESTAMP NULL, " +
+			"PRIMARY KEY (booking_id, enter_time))")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return bookingReminderRepository
+}
+
+func (r *BookingReminderRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+// Claim reserves sending the reminder for the booking for the duration of
+// lease. It returns false if the reminder has already been sent or another
+// worker holds the lease. If the lease expires without MarkSent being called,
+// the reminder can be claimed again.
+func (r *BookingReminderRepository) Claim(bookingID string, enter time.Time, now time.Time, lease time.Duration) (bool, error) {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO booking_reminders "+
+		"(booking_id, enter_time, lease_until) "+
+		"VALUES ($1, $2, $3) "+
+		"ON CONFLICT (booking_id, enter_time) DO UPDATE SET lease_until = $3 "+
+		"WHERE booking_reminders.sent IS NULL AND booking_reminders.lease_until <= $4 "+
+		"RETURNING booking_id",
+		bookingID, enter, now.Add(lease), now).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *BookingReminderRepository) MarkSent(bookingID string, enter time.Time, now time.Time) error {
+	_, err := GetDatabase().DB().Exec("UPDATE booking_reminders SET sent = $3 "+
+		"WHERE booking_id = $1 AND enter_time = $2",
+		bookingID, enter, now)
+	return err
+}
+
+// DeleteExpired removes entries for bookings which have started a while ago.
+func (r *BookingReminderRepository) DeleteExpired() error {
+	enter := time.Now().UTC().Add(-time.Hour * 24 * 7)
+	_, err := GetDatabase().DB().Exec("DELETE FROM booking_reminders WHERE enter_time < $1", enter)
+	return err
+}
@@ -20,6 +20,11 @@ type Booking struct {
 	Enter    time.Time
 	Leave    time.Time
 	CalDavID string
+	// CalDavCalendar identifies the calendar the CalDavID belongs to, see
+	// CalendarConfig.Key. Empty for bookings synchronised before it was stored.
+	CalDavCalendar string
+	SeriesID       NullString
+	CheckInTime    *time.Time
 }
 
 type BookingDetails struct {
@@ -64,15 +69,71 @@ func (r *BookingRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
 			panic(err)
 		}
 	}
+	if curVersion < 19 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE bookings " +
+			"ADD COLUMN series_id uuid NULL"); err != nil {
+			panic(err)
+		}
+		if _, err := GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_bookings_series_id ON bookings(series_id)"); err != nil {
+			panic(err)
+		}
+	}
+	if curVersion < 20 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE bookings " +
+			"ADD COLUMN checkin_time TIMESTAMP NULL DEFAULT NULL"); err != nil {
+			panic(err)
+		}
+	}
+	if curVersion < 24 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE bookings " +
+			"ADD COLUMN caldav_calendar VARCHAR NOT NULL DEFAULT ''"); err != nil {
+			panic(err)
+		}
+	}
 }
 
 func (r *BookingRepository) Create(e *Booking) error {
+	return r.create(GetDatabase().DB(), e)
+}
+
+// CreateWithoutConflicts inserts the booking unless it overlaps existing
+// bookings of the space, which are returned instead.
+func (r *BookingRepository) CreateWithoutConflicts(e *Booking) ([]*Booking, error) {
+	tx, err := GetDatabase().DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := r.lockSpace(tx, e.SpaceID); err != nil {
+		return nil, err
+	}
+	conflicts, err := r.getConflicts(tx, e.SpaceID, e.Enter, e.Leave, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+	if err := r.create(tx, e); err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+// lockSpace serializes the creation of bookings for the space until the
+// transaction ends, so that checking for conflicts and inserting can't race.
+func (r *BookingRepository) lockSpace(db DBExecutor, spaceID string) error {
+	_, err := db.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", spaceID)
+	return err
+}
+
+func (r *BookingRepository) create(db DBExecutor, e *Booking) error {
 	var id string
-	err := GetDatabase().DB().QueryRow("INSERT INTO bookings "+
-		"(user_id, space_id, enter_time, leave_time, caldav_id) "+
-		"VALUES ($1, $2, $3, $4, $5) "+
+	err := db.QueryRow("INSERT INTO bookings "+
+		"(user_id, space_id, enter_time, leave_time, caldav_id, caldav_calendar, series_id, checkin_time) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) "+
 		"RETURNING id",
-		e.UserID, e.SpaceID, e.Enter, e.Leave, e.CalDavID).Scan(&id)
+		e.UserID, e.SpaceID, e.Enter, e.Leave, e.CalDavID, e.CalDavCalendar, CheckNullString(e.SeriesID), e.CheckInTime).Scan(&id)
 	if err != nil {
 		return err
 	}
@@ -82,7 +143,7 @@ func (r *BookingRepository) Create(e *Booking) error {
 
 func (r *BookingRepository) GetOne(id string) (*BookingDetails, error) {
 	e := &BookingDetails{}
-	err := GetDatabase().DB().QueryRow("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, "+
+	err := GetDatabase().DB().QueryRow("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
 		"spaces.id, spaces.location_id, spaces.name, "+
 		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
 		"users.email "+
@@ -91,7 +152,7 @@ func (r *BookingRepository) GetOne(id string) (*BookingDetails, error) {
 		"INNER JOIN locations ON spaces.location_id = locations.id "+
 		"INNER JOIN users ON bookings.user_id = users.id "+
 		"WHERE bookings.id = $1",
-		id).Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		id).Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +162,7 @@ func (r *BookingRepository) GetOne(id string) (*BookingDetails, error) {
 // Get first upcoming booking by user
 func (r *BookingRepository) GetFirstUpcomingBookingByUserID(userID string) (*BookingDetails, error) {
 	e := &BookingDetails{}
-	err := GetDatabase().DB().QueryRow("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, "+
+	err := GetDatabase().DB().QueryRow("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
 		"spaces.id, spaces.location_id, spaces.name, "+
 		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
 		"users.email "+
@@ -111,7 +172,7 @@ func (r *BookingRepository) GetFirstUpcomingBookingByUserID(userID string) (*Boo
 		"INNER JOIN users ON bookings.user_id = users.id "+
 		"WHERE bookings.user_id = $1 AND bookings.enter_time > $2 "+
 		"ORDER BY bookings.enter_time ASC LIMIT 1",
-		userID, time.Now()).Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		userID, time.Now()).Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +181,7 @@ func (r *BookingRepository) GetFirstUpcomingBookingByUserID(userID string) (*Boo
 
 func (r *BookingRepository) GetAllByOrg(organizationID string, startTime, endTime time.Time) ([]*BookingDetails, error) {
 	var result []*BookingDetails
-	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, "+
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
 		"spaces.id, spaces.location_id, spaces.name, "+
 		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
 		"users.email "+
@@ -136,7 +197,7 @@ func (r *BookingRepository) GetAllByOrg(organizationID string, startTime, endTim
 	defer rows.Close()
 	for rows.Next() {
 		e := &BookingDetails{}
-		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
 		if err != nil {
 			return nil, err
 		}
@@ -147,7 +208,7 @@ func (r *BookingRepository) GetAllByOrg(organizationID string, startTime, endTim
 
 func (r *BookingRepository) GetAllByUser(userID string, startTime time.Time) ([]*BookingDetails, error) {
 	var result []*BookingDetails
-	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, "+
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
 		"spaces.id, spaces.location_id, spaces.name, "+
 		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
 		"users.email "+
@@ -163,7 +224,63 @@ func (r *BookingRepository) GetAllByUser(userID string, startTime time.Time) ([]
 	defer rows.Close()
 	for rows.Next() {
 		e := &BookingDetails{}
-		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *BookingRepository) GetAllByLocation(locationID string, startTime time.Time) ([]*BookingDetails, error) {
+	var result []*BookingDetails
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
+		"spaces.id, spaces.location_id, spaces.name, "+
+		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
+		"users.email "+
+		"FROM bookings "+
+		"INNER JOIN spaces ON bookings.space_id = spaces.id "+
+		"INNER JOIN locations ON spaces.location_id = locations.id "+
+		"INNER JOIN users ON bookings.user_id = users.id "+
+		"WHERE locations.id = $1 AND leave_time >= $2 "+
+		"ORDER BY enter_time", locationID, startTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &BookingDetails{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// GetAllWithCalDavID returns all bookings ending after startTime which have
+// been synchronised to a CalDAV calendar, ordered by user.
+func (r *BookingRepository) GetAllWithCalDavID(startTime time.Time) ([]*BookingDetails, error) {
+	var result []*BookingDetails
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
+		"spaces.id, spaces.location_id, spaces.name, "+
+		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
+		"users.email "+
+		"FROM bookings "+
+		"INNER JOIN spaces ON bookings.space_id = spaces.id "+
+		"INNER JOIN locations ON spaces.location_id = locations.id "+
+		"INNER JOIN users ON bookings.user_id = users.id "+
+		"WHERE bookings.caldav_id != '' AND leave_time >= $1 "+
+		"ORDER BY bookings.user_id, enter_time", startTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &BookingDetails{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
 		if err != nil {
 			return nil, err
 		}
@@ -171,15 +288,139 @@ func (r *BookingRepository) GetAllByUser(userID string, startTime time.Time) ([]
 	}
 	return result, nil
 }
+
+// GetAllNotCheckedIn returns all bookings without check-in which have
+// started before enterBefore and end after leaveAfter.
+func (r *BookingRepository) GetAllNotCheckedIn(enterBefore, leaveAfter time.Time) ([]*BookingDetails, error) {
+	var result []*BookingDetails
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
+		"spaces.id, spaces.location_id, spaces.name, "+
+		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
+		"users.email "+
+		"FROM bookings "+
+		"INNER JOIN spaces ON bookings.space_id = spaces.id "+
+		"INNER JOIN locations ON spaces.location_id = locations.id "+
+		"INNER JOIN users ON bookings.user_id = users.id "+
+		"WHERE bookings.checkin_time IS NULL AND enter_time <= $1 AND leave_time >= $2 "+
+		"ORDER BY enter_time", enterBefore, leaveAfter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &BookingDetails{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// GetAllWithoutReminder returns all bookings starting in the specified
+// interval for which no reminder has been sent yet.
+func (r *BookingRepository) GetAllWithoutReminder(enterAfter, enterBefore time.Time) ([]*BookingDetails, error) {
+	var result []*BookingDetails
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
+		"spaces.id, spaces.location_id, spaces.name, "+
+		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
+		"users.email "+
+		"FROM bookings "+
+		"INNER JOIN spaces ON bookings.space_id = spaces.id "+
+		"INNER JOIN locations ON spaces.location_id = locations.id "+
+		"INNER JOIN users ON bookings.user_id = users.id "+
+		"WHERE bookings.enter_time >= $1 AND bookings.enter_time <= $2 "+
+		"AND NOT EXISTS(SELECT 1 FROM booking_reminders WHERE "+
+		"booking_reminders.booking_id = bookings.id AND booking_reminders.enter_time = bookings.enter_time AND booking_reminders.sent IS NOT NULL) "+
+		"ORDER BY enter_time", enterAfter, enterBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &BookingDetails{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// GetAllBySeries returns all bookings belonging to the specified series,
+// ordered by enter time.
+func (r *BookingRepository) GetAllBySeries(seriesID string) ([]*BookingDetails, error) {
+	var result []*BookingDetails
+	rows, err := GetDatabase().DB().Query("SELECT bookings.id, bookings.user_id, bookings.space_id, bookings.enter_time, bookings.leave_time, bookings.caldav_id, bookings.caldav_calendar, COALESCE(bookings.series_id::text, ''), bookings.checkin_time, "+
+		"spaces.id, spaces.location_id, spaces.name, "+
+		"locations.id, locations.organization_id, locations.name, locations.description, locations.tz, "+
+		"users.email "+
+		"FROM bookings "+
+		"INNER JOIN spaces ON bookings.space_id = spaces.id "+
+		"INNER JOIN locations ON spaces.location_id = locations.id "+
+		"INNER JOIN users ON bookings.user_id = users.id "+
+		"WHERE series_id = $1 "+
+		"ORDER BY enter_time", seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &BookingDetails{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime, &e.Space.ID, &e.Space.LocationID, &e.Space.Name, &e.Space.Location.ID, &e.Space.Location.OrganizationID, &e.Space.Location.Name, &e.Space.Location.Description, &e.Space.Location.Timezone, &e.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
 func (r *BookingRepository) Update(e *Booking) error {
-	_, err := GetDatabase().DB().Exec("UPDATE bookings SET "+
+	return r.update(GetDatabase().DB(), e)
+}
+
+// UpdateAll updates all bookings in a single transaction.
+func (r *BookingRepository) UpdateAll(list []*Booking) error {
+	tx, err := GetDatabase().DB().Begin()
+	if err != nil {
+		return err
+	}
+	for _, e := range list {
+		if err := r.update(tx, e); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *BookingRepository) update(db DBExecutor, e *Booking) error {
+	_, err := db.Exec("UPDATE bookings SET "+
 		"user_id = $1, "+
 		"space_id = $2, "+
 		"enter_time = $3, "+
 		"leave_time = $4, "+
-		"caldav_id = $5 "+
-		"WHERE id = $6",
-		e.UserID, e.SpaceID, e.Enter, e.Leave, e.CalDavID, e.ID)
+		"caldav_id = $5, "+
+		"caldav_calendar = $6, "+
+		"series_id = $7, "+
+		"checkin_time = $8 "+
+		"WHERE id = $9",
+		e.UserID, e.SpaceID, e.Enter, e.Leave, e.CalDavID, e.CalDavCalendar, CheckNullString(e.SeriesID), e.CheckInTime, e.ID)
+	return err
+}
+
+func (r *BookingRepository) SetCalDavID(bookingID, calDavID, calendar string) error {
+	_, err := GetDatabase().DB().Exec("UPDATE bookings SET caldav_id = $1, caldav_calendar = $2 WHERE id = $3", calDavID, calendar, bookingID)
+	return err
+}
+
+// ResetCalDavIDs forgets the calendar events of the user's bookings, i.e.
+// after the user has switched to another calendar.
+func (r *BookingRepository) ResetCalDavIDs(userID string) error {
+	_, err := GetDatabase().DB().Exec("UPDATE bookings SET caldav_id = '', caldav_calendar = '' WHERE user_id = $1", userID)
 	return err
 }
 
@@ -188,6 +429,21 @@ func (r *BookingRepository) Delete(e *BookingDetails) error {
 	return err
 }
 
+// DeleteAll deletes all bookings in a single transaction.
+func (r *BookingRepository) DeleteAll(list []*BookingDetails) error {
+	tx, err := GetDatabase().DB().Begin()
+	if err != nil {
+		return err
+	}
+	for _, e := range list {
+		if _, err := tx.Exec("DELETE FROM bookings WHERE id = $1", e.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (r *BookingRepository) GetCount(organizationID string) (int, error) {
 	var res int
 	err := GetDatabase().DB().QueryRow("SELECT COUNT(bookings.id) "+
@@ -261,7 +517,7 @@ func (r *BookingRepository) GetLoad(organizationID string, enter, leave time.Tim
 // get all bookings by a specific user which overlap with the provided time range
 func (r *BookingRepository) GetTimeRangeByUser(userID string, enter time.Time, leave time.Time, excludeBookingID string) ([]*Booking, error) {
 	var result []*Booking
-	rows, err := GetDatabase().DB().Query("SELECT id, user_id, space_id, enter_time, leave_time, caldav_id "+
+	rows, err := GetDatabase().DB().Query("SELECT id, user_id, space_id, enter_time, leave_time, caldav_id, caldav_calendar, COALESCE(series_id::text, ''), checkin_time "+
 		"FROM bookings "+
 		"WHERE id::text != $4 AND user_id = $1 AND ("+
 		"($2 <= enter_time AND $3 > enter_time) OR "+ // (overlap start, can end at same time as next start)
@@ -275,7 +531,7 @@ func (r *BookingRepository) GetTimeRangeByUser(userID string, enter time.Time, l
 	defer rows.Close()
 	for rows.Next() {
 		e := &Booking{}
-		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID)
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime)
 		if err != nil {
 			return nil, err
 		}
@@ -287,8 +543,12 @@ func (r *BookingRepository) GetTimeRangeByUser(userID string, enter time.Time, l
 // GetConflicts returns bookings for a specific space which overlap
 // with the specified enter and leave times.
 func (r *BookingRepository) GetConflicts(spaceID string, enter time.Time, leave time.Time, excludeBookingID string) ([]*Booking, error) {
+	return r.getConflicts(GetDatabase().DB(), spaceID, enter, leave, excludeBookingID)
+}
+
+func (r *BookingRepository) getConflicts(db DBExecutor, spaceID string, enter time.Time, leave time.Time, excludeBookingID string) ([]*Booking, error) {
 	var result []*Booking
-	rows, err := GetDatabase().DB().Query("SELECT id, user_id, space_id, enter_time, leave_time, caldav_id "+
+	rows, err := db.Query("SELECT id, user_id, space_id, enter_time, leave_time, caldav_id, caldav_calendar, COALESCE(series_id::text, ''), checkin_time "+
 		"FROM bookings "+
 		"WHERE id::text != $1 AND space_id = $2 AND ("+
 		"($3 >= enter_time AND $3 <= leave_time) OR "+
@@ -303,7 +563,7 @@ func (r *BookingRepository) GetConflicts(spaceID string, enter time.Time, leave
 	defer rows.Close()
 	for rows.Next() {
 		e := &Booking{}
-		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID)
+		err = rows.Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Enter, &e.Leave, &e.CalDavID, &e.CalDavCalendar, &e.SeriesID, &e.CheckInTime)
 		if err != nil {
 			return nil, err
 		}
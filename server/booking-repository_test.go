@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"testing"
 	"time"
 )
@@ -84,3 +85,33 @@ func TestBookingRepositoryPresenceReport(t *testing.T) {
 	checkTestInt(t, 0, res[2].Presence[tomorrow.Add(24*6*time.Hour).Format(DateFormat)])
 	checkTestInt(t, 0, res[2].Presence[tomorrow.Add(24*7*time.Hour).Format(DateFormat)])
 }
+
+func TestBookingRepositoryCreateWithoutConflicts(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	enter := time.Date(2030, 9, 1, 8, 0, 0, 0, time.UTC)
+
+	// Only one of the concurrent requests for the same slot succeeds
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := &Booking{UserID: user.ID, SpaceID: s.ID, Enter: enter, Leave: enter.Add(8 * time.Hour)}
+			if _, err := GetBookingRepository().CreateWithoutConflicts(e); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, enter.Add(-time.Hour))
+	checkTestInt(t, 1, len(list))
+
+	e := &Booking{UserID: user.ID, SpaceID: s.ID, Enter: enter.Add(2 * time.Hour), Leave: enter.Add(4 * time.Hour)}
+	conflicts, err := GetBookingRepository().CreateWithoutConflicts(e)
+	checkTestBool(t, true, err == nil)
+	checkTestInt(t, 1, len(conflicts))
+	checkTestString(t, list[0].ID, conflicts[0].ID)
+	checkTestString(t, "", e.ID)
+}
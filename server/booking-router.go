@@ -5,11 +5,12 @@ import (
 	"log"
 	"math"
 	"net/http"
-	"net/url"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/teambition/rrule-go"
 )
 
 type BookingRouter struct {
@@ -24,7 +25,28 @@ type BookingRequest struct {
 type CreateBookingRequest struct {
 	SpaceID string `json:"spaceId" validate:"required"`
 	BookingRequest
-	DateUntil *time.Time `json:"dateUntil"`
+	DateUntil     *time.Time `json:"dateUntil"`
+	Recurrence    string     `json:"recurrence"`
+	SkipConflicts bool       `json:"skipConflicts"`
+}
+
+type BookingConflict struct {
+	Enter time.Time `json:"enter"`
+	Leave time.Time `json:"leave"`
+	Code  int       `json:"code"`
+}
+
+type CreateRecurringBookingResponse struct {
+	SeriesID  string             `json:"seriesId"`
+	IDs       []string           `json:"ids"`
+	Conflicts []*BookingConflict `json:"conflicts"`
+}
+
+// DeleteBookingSeriesResponse lists the occurrences which have been kept
+// because they're too close to their start (SettingMaxHoursBeforeDelete).
+type DeleteBookingSeriesResponse struct {
+	IDs     []string           `json:"ids"`
+	Skipped []*BookingConflict `json:"skipped"`
 }
 
 type PreCreateBookingRequest struct {
@@ -33,13 +55,43 @@ type PreCreateBookingRequest struct {
 }
 
 type GetBookingResponse struct {
-	ID        string           `json:"id"`
-	UserID    string           `json:"userId"`
-	UserEmail string           `json:"userEmail"`
-	Space     GetSpaceResponse `json:"space"`
+	ID          string           `json:"id"`
+	UserID      string           `json:"userId"`
+	UserEmail   string           `json:"userEmail"`
+	SeriesID    string           `json:"seriesId"`
+	CheckedIn   bool             `json:"checkedIn"`
+	CheckInTime *time.Time       `json:"checkInTime"`
+	CalDavSync  string           `json:"caldavSync"`
+	CalDavError string           `json:"caldavError,omitempty"`
+	Space       GetSpaceResponse `json:"space"`
 	CreateBookingRequest
 }
 
+type GetCalDAVLogEntryResponse struct {
+	ID        string    `json:"id"`
+	BookingID string    `json:"bookingId"`
+	Action    int       `json:"action"`
+	Details   string    `json:"details"`
+	Created   time.Time `json:"created"`
+}
+
+type GetBookingSeriesResponse struct {
+	ID         string                `json:"id"`
+	UserID     string                `json:"userId"`
+	SpaceID    string                `json:"spaceId"`
+	Recurrence string                `json:"recurrence"`
+	Enter      time.Time             `json:"enter"`
+	Leave      time.Time             `json:"leave"`
+	Bookings   []*GetBookingResponse `json:"bookings"`
+}
+
+type UpdateBookingSeriesRequest struct {
+	SpaceID string    `json:"spaceId" validate:"required"`
+	Enter   time.Time `json:"enter" validate:"required"`
+	Leave   time.Time `json:"leave" validate:"required"`
+	Scope   string    `json:"scope"`
+}
+
 type GetBookingFilterRequest struct {
 	Start      time.Time `json:"start" validate:"required"`
 	End        time.Time `json:"end" validate:"required"`
@@ -65,23 +117,24 @@ type DebugTimeIssuesResponse struct {
 	Result                  time.Time `json:"result"`
 }
 
-type CaldavConfig struct {
-	URL      string
-	Username string
-	Password string
-	Path     string
-}
-
 func (router *BookingRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/debugtimeissues/", router.debugTimeIssues).Methods("POST")
-	s.HandleFunc("/report/presence/", router.getPresenceReport).Methods("POST")
-	s.HandleFunc("/filter/", router.getFiltered).Methods("POST")
-	s.HandleFunc("/precheck/", router.preBookingCreateCheck).Methods("POST")
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/report/presence/", router.getPresenceReport).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/filter/", router.getFiltered).Methods("POST"), PermissionSpacesAdmin)
+	RequirePermission(s.HandleFunc("/precheck/", router.preBookingCreateCheck).Methods("POST"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/series/{id}", router.getSeries).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/series/{id}", router.updateSeries).Methods("PUT"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/series/{id}", router.deleteSeries).Methods("DELETE"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/checkin/{spaceId}/{token}", router.checkInSpace).Methods("POST"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/caldav/resync", router.resyncCalDav).Methods("POST"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/caldav/log", router.getCalDavLog).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}/checkin", router.checkIn).Methods("POST"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/{id}/checkout", router.checkOut).Methods("POST"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionBookingsRead)
 }
 
 func (router *BookingRouter) debugTimeIssues(w http.ResponseWriter, r *http.Request) {
@@ -137,10 +190,6 @@ func (router *BookingRouter) debugTimeIssues(w http.ResponseWriter, r *http.Requ
 
 func (router *BookingRouter) getFiltered(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	var m GetBookingFilterRequest
 	if UnmarshalValidateBody(r, &m) != nil {
 		SendBadRequest(w)
@@ -178,6 +227,8 @@ func (router *BookingRouter) getOne(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res := router.copyToRestModel(e)
+	syncEntry, _ := GetCalDAVSyncRepository().GetOneByBooking(e.ID)
+	router.applyCalDavSyncStatus(res, &e.Booking, syncEntry)
 	SendJSON(w, res)
 }
 
@@ -188,14 +239,66 @@ func (router *BookingRouter) getAll(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	syncEntries, err := GetCalDAVSyncRepository().GetAllByUser(GetRequestUserID(r))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
 	res := []*GetBookingResponse{}
 	for _, e := range list {
 		m := router.copyToRestModel(e)
+		router.applyCalDavSyncStatus(m, &e.Booking, syncEntries[e.ID])
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *BookingRouter) getCalDavLog(w http.ResponseWriter, r *http.Request) {
+	list, err := GetCalDAVLogRepository().GetAllByUser(GetRequestUserID(r), 100)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetCalDAVLogEntryResponse{}
+	for _, e := range list {
+		m := &GetCalDAVLogEntryResponse{
+			ID:        e.ID,
+			BookingID: e.BookingID,
+			Action:    int(e.Action),
+			Details:   e.Details,
+			Created:   e.Created,
+		}
 		res = append(res, m)
 	}
 	SendJSON(w, res)
 }
 
+// resyncCalDav queues all upcoming bookings of the requesting user for
+// synchronisation with the user's calendar.
+func (router *BookingRouter) resyncCalDav(w http.ResponseWriter, r *http.Request) {
+	userID := GetRequestUserID(r)
+	if _, err := getCalendarConfig(userID); err != nil {
+		SendBadRequest(w)
+		return
+	}
+	list, err := GetBookingRepository().GetAllByUser(userID, time.Now().UTC())
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	for _, e := range list {
+		if err := router.enqueueCalDavSync(&e.Booking, CalDAVSyncOperationUpdate); err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+	}
+	SendUpdated(w)
+}
+
 func (router *BookingRouter) update(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
@@ -231,6 +334,9 @@ func (router *BookingRouter) update(w http.ResponseWriter, r *http.Request) {
 	}
 	eNew.ID = e.ID
 	eNew.CalDavID = e.CalDavID
+	eNew.CalDavCalendar = e.CalDavCalendar
+	eNew.SeriesID = e.SeriesID
+	eNew.CheckInTime = e.CheckInTime
 	eNew.UserID = e.UserID
 	if m.UserEmail != "" && m.UserEmail != requestUser.Email {
 		if !CanSpaceAdminOrg(requestUser, location.OrganizationID) {
@@ -243,12 +349,17 @@ func (router *BookingRouter) update(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	bookingUser, err := router.getBookingUser(requestUser, eNew.UserID)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
 	bookingReq := &BookingRequest{
 		Enter: eNew.Enter,
 		Leave: eNew.Leave,
 	}
 
-	if valid, code := router.checkBookingCreateUpdate(bookingReq, location, requestUser, eNew.ID); !valid {
+	if valid, code := router.checkBookingCreateUpdate(bookingReq, location, space.ID, requestUser, bookingUser, eNew.ID); !valid {
 		SendBadRequestCode(w, code)
 		return
 	}
@@ -267,7 +378,14 @@ func (router *BookingRouter) update(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
-	go router.onBookingUpdated(eNew)
+	router.onBookingUpdated(eNew)
+	router.writeAuditLog(r, location.OrganizationID, AuditActionUpdate, &e.Booking, eNew)
+	if eNew.UserID != e.UserID {
+		sendBookingMails(BookingMailCancelled, []*BookingDetails{e}, requestUser)
+		sendBookingMailsByID(BookingMailCreated, []*Booking{eNew}, requestUser)
+	} else {
+		sendBookingMailsByID(BookingMailChanged, []*Booking{eNew}, requestUser)
+	}
 	SendUpdated(w)
 }
 
@@ -299,19 +417,511 @@ func (router *BookingRouter) delete(w http.ResponseWriter, r *http.Request) {
 	requestUser := GetRequestUser(r)
 	// Check for the date, If the BookingRequest is to close with SettingsMaxHoursBeforeDelete, the Delete can not be performed.
 	if router.isValidBookingHoursBeforeDelete(e, requestUser, location.OrganizationID) {
-		go router.onBookingDeleted(&e.Booking)
 		if err := GetBookingRepository().Delete(e); err != nil {
 			SendInternalServerError(w)
 			return
 		}
+		router.onBookingCancelled(r, e, requestUser)
 		SendUpdated(w)
 		return
 	}
 	SendForbiddenCode(w, ResponseCodeBookingMaxHoursBeforeDelete)
 }
 
-func (router *BookingRouter) checkBookingCreateUpdate(m *BookingRequest, location *Location, requestUser *User, bookingID string) (bool, int) {
-	if valid, code := router.isValidBookingRequest(m, requestUser, location.OrganizationID, bookingID); !valid {
+// getSeriesForRequest loads the series referenced in the request path and
+// ensures the request user is either its owner or a space admin of the
+// series' organization.
+func (router *BookingRouter) getSeriesForRequest(w http.ResponseWriter, r *http.Request) (*BookingSeries, *Location, bool) {
+	vars := mux.Vars(r)
+	series, err := GetBookingSeriesRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil, nil, false
+	}
+	space, err := GetSpaceRepository().GetOne(series.SpaceID)
+	if err != nil {
+		SendNotFound(w)
+		return nil, nil, false
+	}
+	location, err := GetLocationRepository().GetOne(space.LocationID)
+	if err != nil {
+		SendNotFound(w)
+		return nil, nil, false
+	}
+	requestUser := GetRequestUser(r)
+	if !CanAccessOrg(requestUser, location.OrganizationID) {
+		SendForbidden(w)
+		return nil, nil, false
+	}
+	if series.UserID != requestUser.ID && !CanSpaceAdminOrg(requestUser, location.OrganizationID) {
+		SendForbidden(w)
+		return nil, nil, false
+	}
+	return series, location, true
+}
+
+// getSeriesBookingsInScope returns the bookings of the series affected by
+// the given scope: "all" (default) or "future" (not yet started).
+func (router *BookingRouter) getSeriesBookingsInScope(seriesID string, scope string) ([]*BookingDetails, error) {
+	if scope != "" && scope != "all" && scope != "future" {
+		return nil, errors.New("invalid scope")
+	}
+	list, err := GetBookingRepository().GetAllBySeries(seriesID)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "future" {
+		return list, nil
+	}
+	res := []*BookingDetails{}
+	for _, e := range list {
+		enter, _ := attachTimezoneInformation(e.Enter, &e.Space.Location)
+		if enter.After(time.Now()) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func (router *BookingRouter) getSeries(w http.ResponseWriter, r *http.Request) {
+	series, location, ok := router.getSeriesForRequest(w, r)
+	if !ok {
+		return
+	}
+	list, err := GetBookingRepository().GetAllBySeries(series.ID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := &GetBookingSeriesResponse{
+		ID:         series.ID,
+		UserID:     series.UserID,
+		SpaceID:    series.SpaceID,
+		Recurrence: series.Recurrence,
+		Bookings:   []*GetBookingResponse{},
+	}
+	res.Enter, _ = attachTimezoneInformation(series.Enter, location)
+	res.Leave, _ = attachTimezoneInformation(series.Leave, location)
+	for _, e := range list {
+		res.Bookings = append(res.Bookings, router.copyToRestModel(e))
+	}
+	SendJSON(w, res)
+}
+
+func (router *BookingRouter) updateSeries(w http.ResponseWriter, r *http.Request) {
+	series, _, ok := router.getSeriesForRequest(w, r)
+	if !ok {
+		return
+	}
+	var m UpdateBookingSeriesRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	list, err := router.getSeriesBookingsInScope(series.ID, m.Scope)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	if len(list) == 0 {
+		SendUpdated(w)
+		return
+	}
+	space, err := GetSpaceRepository().GetOne(m.SpaceID)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	location, err := GetLocationRepository().GetOne(space.LocationID)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	requestUser := GetRequestUser(r)
+	if series.UserID != requestUser.ID && !CanSpaceAdminOrg(requestUser, location.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	bookingUser, err := router.getBookingUser(requestUser, series.UserID)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	enter, err := attachTimezoneInformation(m.Enter, location)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	leave, err := attachTimezoneInformation(m.Leave, location)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	// All affected occurrences are moved by the same number of days as the
+	// first one and take over the new times of day.
+	days := getDaysBetween(list[0].Enter, enter)
+	affected := map[string]bool{}
+	for _, e := range list {
+		affected[e.ID] = true
+	}
+	updated := []*Booking{}
+	for _, e := range list {
+		bookingReq := getShiftedOccurrence(e.Enter, days, enter, leave)
+		if len(updated) > 0 && bookingReq.Enter.Before(updated[len(updated)-1].Leave) {
+			SendBadRequest(w)
+			return
+		}
+		if valid, code := router.checkBookingCreateUpdate(bookingReq, location, space.ID, requestUser, bookingUser, e.ID); !valid {
+			SendBadRequestCode(w, code)
+			return
+		}
+		conflicts, err := GetBookingRepository().GetConflicts(m.SpaceID, bookingReq.Enter, bookingReq.Leave, e.ID)
+		if err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+		for _, conflict := range conflicts {
+			if !affected[conflict.ID] {
+				SendAleadyExists(w)
+				return
+			}
+		}
+		updated = append(updated, &Booking{
+			ID:             e.ID,
+			UserID:         e.UserID,
+			SpaceID:        m.SpaceID,
+			Enter:          bookingReq.Enter,
+			Leave:          bookingReq.Leave,
+			CalDavID:       e.CalDavID,
+			CalDavCalendar: e.CalDavCalendar,
+			SeriesID:       e.SeriesID,
+			CheckInTime:    e.CheckInTime,
+		})
+	}
+	if err := GetBookingRepository().UpdateAll(updated); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if m.Scope != "future" {
+		series.SpaceID = m.SpaceID
+		series.Enter = updated[0].Enter
+		series.Leave = updated[0].Leave
+		if err := GetBookingSeriesRepository().Update(series); err != nil {
+			log.Println(err)
+		}
+	}
+	for i, e := range updated {
+		router.onBookingUpdated(e)
+		router.writeAuditLog(r, location.OrganizationID, AuditActionUpdate, &list[i].Booking, e)
+	}
+	sendBookingMailsByID(BookingMailChanged, updated, requestUser)
+	SendUpdated(w)
+}
+
+func (router *BookingRouter) deleteSeries(w http.ResponseWriter, r *http.Request) {
+	series, location, ok := router.getSeriesForRequest(w, r)
+	if !ok {
+		return
+	}
+	list, err := router.getSeriesBookingsInScope(series.ID, r.URL.Query().Get("scope"))
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	// Occurrences too close to their start (SettingMaxHoursBeforeDelete) are
+	// kept and reported to the caller.
+	requestUser := GetRequestUser(r)
+	deletable := []*BookingDetails{}
+	res := &DeleteBookingSeriesResponse{IDs: []string{}, Skipped: []*BookingConflict{}}
+	for _, e := range list {
+		if router.isValidBookingHoursBeforeDelete(e, requestUser, location.OrganizationID) {
+			deletable = append(deletable, e)
+			res.IDs = append(res.IDs, e.ID)
+		} else {
+			res.Skipped = append(res.Skipped, &BookingConflict{Enter: e.Enter, Leave: e.Leave, Code: ResponseCodeBookingMaxHoursBeforeDelete})
+		}
+	}
+	if len(deletable) == 0 && len(list) > 0 {
+		SendForbiddenCode(w, ResponseCodeBookingMaxHoursBeforeDelete)
+		return
+	}
+	if err := GetBookingRepository().DeleteAll(deletable); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	for _, e := range deletable {
+		router.onBookingDeleted(&e.Booking)
+		router.writeAuditLog(r, location.OrganizationID, AuditActionDelete, &e.Booking, nil)
+	}
+	sendBookingMails(BookingMailCancelled, deletable, requestUser)
+	if err := GetBookingSeriesRepository().DeleteIfEmpty(series.ID); err != nil {
+		log.Println(err)
+	}
+	if len(res.Skipped) > 0 {
+		SendJSON(w, res)
+		return
+	}
+	SendUpdated(w)
+}
+
+func (router *BookingRouter) checkIn(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := GetBookingRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	requestUser := GetRequestUser(r)
+	if e.UserID != requestUser.ID && !CanSpaceAdminOrg(requestUser, e.Space.Location.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	router.doCheckIn(w, r, e)
+}
+
+// checkInSpace checks in the request user's current booking on the space
+// referenced by the token printed on the space's QR code.
+func (router *BookingRouter) checkInSpace(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !isValidSpaceCheckInToken(vars["spaceId"], vars["token"]) {
+		SendForbidden(w)
+		return
+	}
+	list, err := GetBookingRepository().GetAllByUser(GetRequestUserID(r), time.Now().UTC().Add(time.Hour*-14))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	now := time.Now()
+	for _, e := range list {
+		if e.SpaceID != vars["spaceId"] {
+			continue
+		}
+		start, end := router.getCheckInWindow(e)
+		if !now.Before(start) && !now.After(end) {
+			router.doCheckIn(w, r, e)
+			return
+		}
+	}
+	SendNotFound(w)
+}
+
+func (router *BookingRouter) doCheckIn(w http.ResponseWriter, r *http.Request, e *BookingDetails) {
+	if e.CheckInTime != nil {
+		SendUpdated(w)
+		return
+	}
+	now := time.Now()
+	start, end := router.getCheckInWindow(e)
+	if now.Before(start) || now.After(end) {
+		SendBadRequestCode(w, ResponseCodeBookingCheckInNotPossible)
+		return
+	}
+	tz, err := time.LoadLocation(GetLocationRepository().GetTimezone(&e.Space.Location))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	// Stored as wall clock time in the location's time zone, like enter and leave
+	before := e.Booking
+	checkInTime := now.In(tz)
+	e.CheckInTime = &checkInTime
+	if err := GetBookingRepository().Update(&e.Booking); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	router.writeAuditLog(r, e.Space.Location.OrganizationID, AuditActionUpdate, &before, &e.Booking)
+	SendUpdated(w)
+}
+
+// checkOut ends a running booking early by shortening its leave time to now,
+// rounded up to SettingCheckOutGranularityMinutes. As this isn't a
+// cancellation, SettingMaxHoursBeforeDelete doesn't apply.
+func (router *BookingRouter) checkOut(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := GetBookingRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	requestUser := GetRequestUser(r)
+	if e.UserID != requestUser.ID && !CanSpaceAdminOrg(requestUser, e.Space.Location.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	tz, err := time.LoadLocation(GetLocationRepository().GetTimezone(&e.Space.Location))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	enter, _ := attachTimezoneInformation(e.Enter, &e.Space.Location)
+	leave, _ := attachTimezoneInformation(e.Leave, &e.Space.Location)
+	now := time.Now().In(tz)
+	if now.Before(enter) || !now.Before(leave) {
+		SendBadRequestCode(w, ResponseCodeBookingCheckOutNotPossible)
+		return
+	}
+	granularity, _ := GetSettingsRepository().GetInt(e.Space.Location.OrganizationID, SettingCheckOutGranularityMinutes.Name)
+	leaveNew := roundUpToGranularity(now, granularity)
+	if !leaveNew.Before(leave) {
+		SendUpdated(w)
+		return
+	}
+	freed := &BookingDetails{
+		Booking: Booking{
+			SpaceID: e.SpaceID,
+			Enter:   leaveNew,
+			Leave:   e.Leave,
+		},
+		Space: e.Space,
+	}
+	before := e.Booking
+	e.Leave = leaveNew
+	if err := GetBookingRepository().Update(&e.Booking); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	router.onBookingUpdated(&e.Booking)
+	router.writeAuditLog(r, e.Space.Location.OrganizationID, AuditActionUpdate, &before, &e.Booking)
+	router.onBookingSlotFreed(freed, &e.Space.Location)
+	SendUpdated(w)
+}
+
+// roundUpToGranularity rounds t up to the next multiple of the given number of
+// minutes, counted from midnight.
+func roundUpToGranularity(t time.Time, minutes int) time.Time {
+	if minutes <= 0 {
+		return t
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	granularity := time.Minute * time.Duration(minutes)
+	elapsed := t.Sub(midnight)
+	return midnight.Add((elapsed + granularity - 1) / granularity * granularity)
+}
+
+// getCheckInWindow returns the time range in which the booking can be checked
+// in: from SettingMaxMinutesCheckIn minutes before enter until leave. If
+// check-in is required, the window closes SettingMaxMinutesCheckIn minutes
+// after enter, as the booking is released afterwards.
+func (router *BookingRouter) getCheckInWindow(e *BookingDetails) (time.Time, time.Time) {
+	orgID := e.Space.Location.OrganizationID
+	enabled, _ := GetSettingsRepository().GetBool(orgID, SettingEnableCheckIn.Name)
+	minutes, _ := GetSettingsRepository().GetInt(orgID, SettingMaxMinutesCheckIn.Name)
+	enter, _ := attachTimezoneInformation(e.Enter, &e.Space.Location)
+	leave, _ := attachTimezoneInformation(e.Leave, &e.Space.Location)
+	start := enter.Add(time.Minute * time.Duration(-minutes))
+	end := leave
+	if enabled && enter.Add(time.Minute*time.Duration(minutes)).Before(end) {
+		end = enter.Add(time.Minute * time.Duration(minutes))
+	}
+	return start, end
+}
+
+// releaseNoShowBookings deletes all bookings in organizations requiring a
+// check-in which haven't been checked in within SettingMaxMinutesCheckIn
+// minutes after enter. Returns the number of released bookings.
+func (router *BookingRouter) releaseNoShowBookings() (int, error) {
+	// Enter and leave are stored as local wall clock times, so look at all
+	// bookings which might have started in any time zone
+	now := time.Now().UTC()
+	list, err := GetBookingRepository().GetAllNotCheckedIn(now.Add(time.Hour*14), now.Add(time.Hour*-14))
+	if err != nil {
+		return 0, err
+	}
+	required := map[string]bool{}
+	num := 0
+	for _, e := range list {
+		orgID := e.Space.Location.OrganizationID
+		if _, ok := required[orgID]; !ok {
+			required[orgID], _ = GetSettingsRepository().GetBool(orgID, SettingEnableCheckIn.Name)
+		}
+		if !required[orgID] {
+			continue
+		}
+		_, end := router.getCheckInWindow(e)
+		leave, _ := attachTimezoneInformation(e.Leave, &e.Space.Location)
+		if !time.Now().After(end) || !end.Before(leave) {
+			continue
+		}
+		if err := GetBookingRepository().Delete(e); err != nil {
+			log.Println(err)
+			continue
+		}
+		router.onBookingCancelled(nil, e, nil)
+		num++
+	}
+	return num, nil
+}
+
+// sendBookingReminders sends reminder mails for bookings starting within the
+// lead time configured by their users. Each reminder is claimed in the
+// database before sending, so that it's only sent once, even with multiple
+// instances running.
+func (router *BookingRouter) sendBookingReminders() (int, error) {
+	// Enter is stored as local wall clock time, so look at all bookings which
+	// might start within the maximum lead time in any time zone
+	now := time.Now().UTC()
+	list, err := GetBookingRepository().GetAllWithoutReminder(now.Add(time.Hour*-14), now.Add(time.Minute*BookingReminderMaxMinutes+time.Hour*14))
+	if err != nil {
+		return 0, err
+	}
+	orgDefaults := map[string]int{}
+	num := 0
+	for _, e := range list {
+		orgID := e.Space.Location.OrganizationID
+		if _, ok := orgDefaults[orgID]; !ok {
+			orgDefaults[orgID], _ = GetSettingsRepository().GetInt(orgID, SettingReminderMinutes.Name)
+		}
+		minutes, err := GetUserPreferencesRepository().GetInt(e.UserID, PreferenceReminderMinutes.Name)
+		if err != nil || minutes == PreferenceReminderMinutesDefault {
+			minutes = orgDefaults[orgID]
+		}
+		if minutes <= 0 {
+			continue
+		}
+		enter, _ := attachTimezoneInformation(e.Enter, &e.Space.Location)
+		if time.Now().Before(enter.Add(time.Minute*time.Duration(-minutes))) || !time.Now().Before(enter) {
+			continue
+		}
+		claimed, err := GetBookingReminderRepository().Claim(e.ID, e.Enter, now, BookingReminderLease)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		// If sending fails, the lease expires and the reminder is retried
+		if err := sendBookingReminderMail(e); err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := GetBookingReminderRepository().MarkSent(e.ID, e.Enter, now); err != nil {
+			log.Println(err)
+			continue
+		}
+		num++
+	}
+	return num, nil
+}
+
+// checkBookingCreateUpdate validates a booking made by requestUser for
+// bookingUser. Both are the same user unless an admin books on behalf of
+// someone else.
+func (router *BookingRouter) checkBookingCreateUpdate(m *BookingRequest, location *Location, spaceID string, requestUser *User, bookingUser *User, bookingID string) (bool, int) {
+	if !router.isValidGroupRestriction(location, spaceID, bookingUser) {
+		return false, ResponseCodeBookingSpaceRestricted
+	}
+	if valid, code := router.isValidBookingRequest(m, requestUser, location, spaceID, bookingID); !valid {
 		return false, code
 	}
 	if !router.isValidConcurrent(m, location, bookingID) {
@@ -350,7 +960,7 @@ func (router *BookingRouter) preBookingCreateCheck(w http.ResponseWriter, r *htt
 		Enter: enterNew,
 		Leave: leaveNew,
 	}
-	if valid, code := router.checkBookingCreateUpdate(bookingReq, location, requestUser, ""); !valid {
+	if valid, code := router.checkBookingCreateUpdate(bookingReq, location, "", requestUser, requestUser, ""); !valid {
 		SendBadRequestCode(w, code)
 		return
 	}
@@ -363,113 +973,164 @@ func (router *BookingRouter) create(w http.ResponseWriter, r *http.Request) {
 		SendBadRequest(w)
 		return
 	}
-	space, err := GetSpaceRepository().GetOne(m.SpaceID)
+	space, err := GetSpaceRepository().GetOne(m.SpaceID)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	location, err := GetLocationRepository().GetOne(space.LocationID)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	requestUser := GetRequestUser(r)
+	if !CanAccessOrg(requestUser, location.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	e, err := router.copyFromRestModel(&m, location)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	e.UserID = GetRequestUserID(r)
+	if m.UserEmail != "" && m.UserEmail != requestUser.Email {
+		if !CanSpaceAdminOrg(requestUser, location.OrganizationID) {
+			SendForbidden(w)
+			return
+		}
+		e.UserID, err = router.bookForUser(requestUser, m.UserEmail, w)
+		if err != nil {
+			SendInternalServerError(w)
+			return
+		}
+	}
+
+	bookingUser, err := router.getBookingUser(requestUser, e.UserID)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	if m.Recurrence != "" || m.DateUntil != nil {
+		router.createRecurring(w, r, &m, e, location, requestUser, bookingUser)
+		return
+	}
+	bookingReq := &BookingRequest{
+		Enter: e.Enter,
+		Leave: e.Leave,
+	}
+	if valid, code := router.checkBookingCreateUpdate(bookingReq, location, space.ID, requestUser, bookingUser, ""); !valid {
+		SendBadRequestCode(w, code)
+		return
+	}
+	conflicts, err := GetBookingRepository().CreateWithoutConflicts(e)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if len(conflicts) > 0 {
+		SendAleadyExists(w)
+		return
+	}
+	router.onBookingCreated(e)
+	router.writeAuditLog(r, location.OrganizationID, router.getCreateAuditAction(e, requestUser), nil, e)
+	sendBookingMailsByID(BookingMailCreated, []*Booking{e}, requestUser)
+	SendCreated(w, e.ID)
+}
+
+func (router *BookingRouter) createRecurring(w http.ResponseWriter, r *http.Request, m *CreateBookingRequest, e *Booking, location *Location, requestUser *User, bookingUser *User) {
+	rule, err := router.getRecurrenceRule(m, e, location)
+	if err != nil {
+		log.Println(err)
+		SendBadRequest(w)
+		return
+	}
+	occurrences, err := getRecurrenceOccurrences(rule, e.Enter, e.Leave)
 	if err != nil {
+		log.Println(err)
 		SendBadRequest(w)
 		return
 	}
-	location, err := GetLocationRepository().GetOne(space.LocationID)
-	if err != nil {
-		SendBadRequest(w)
+	res := &CreateRecurringBookingResponse{
+		IDs:       []string{},
+		Conflicts: []*BookingConflict{},
+	}
+	list := []*Booking{}
+	for _, bookingReq := range occurrences {
+		if valid, code := router.checkBookingCreateUpdate(bookingReq, location, e.SpaceID, requestUser, bookingUser, ""); !valid {
+			res.Conflicts = append(res.Conflicts, &BookingConflict{Enter: bookingReq.Enter, Leave: bookingReq.Leave, Code: code})
+			continue
+		}
+		list = append(list, &Booking{
+			UserID:  e.UserID,
+			SpaceID: e.SpaceID,
+			Enter:   bookingReq.Enter,
+			Leave:   bookingReq.Leave,
+		})
+	}
+	if len(list) == 0 || (len(res.Conflicts) > 0 && !m.SkipConflicts) {
+		SendJSONWithStatus(w, http.StatusConflict, res)
 		return
 	}
-	requestUser := GetRequestUser(r)
-	if !CanAccessOrg(requestUser, location.OrganizationID) {
-		SendForbidden(w)
+	if !router.isValidMaxUpcomingBookingsForNum(location.OrganizationID, requestUser, len(list)) {
+		SendBadRequestCode(w, ResponseCodeBookingTooManyUpcomingBookings)
 		return
 	}
-	e, err := router.copyFromRestModel(&m, location)
+	series := &BookingSeries{
+		UserID:     e.UserID,
+		SpaceID:    e.SpaceID,
+		Recurrence: rule.OrigOptions.RRuleString(),
+		Enter:      e.Enter,
+		Leave:      e.Leave,
+	}
+	conflicts, err := GetBookingSeriesRepository().Create(series, list, m.SkipConflicts)
 	if err != nil {
+		log.Println(err)
 		SendInternalServerError(w)
 		return
 	}
-	e.UserID = GetRequestUserID(r)
-	if m.UserEmail != "" && m.UserEmail != requestUser.Email {
-		if !CanSpaceAdminOrg(requestUser, location.OrganizationID) {
-			SendForbidden(w)
-			return
-		}
-		e.UserID, err = router.bookForUser(requestUser, m.UserEmail, w)
-		if err != nil {
-			SendInternalServerError(w)
-			return
+	for _, conflict := range conflicts {
+		res.Conflicts = append(res.Conflicts, &BookingConflict{Enter: conflict.Enter, Leave: conflict.Leave, Code: ResponseCodeBookingSlotConflict})
+	}
+	sort.Slice(res.Conflicts, func(i, j int) bool {
+		return res.Conflicts[i].Enter.Before(res.Conflicts[j].Enter)
+	})
+	if series.ID == "" {
+		SendJSONWithStatus(w, http.StatusConflict, res)
+		return
+	}
+	res.SeriesID = series.ID
+	for _, booking := range list {
+		if booking.ID == "" {
+			continue
 		}
+		res.IDs = append(res.IDs, booking.ID)
+		router.onBookingCreated(booking)
+		router.writeAuditLog(r, location.OrganizationID, router.getCreateAuditAction(booking, requestUser), nil, booking)
 	}
+	sendBookingMailsByID(BookingMailCreated, list, requestUser)
+	w.Header().Set("X-Object-ID", res.IDs[0])
+	SendJSONWithStatus(w, http.StatusCreated, res)
+}
 
-	log.Println(m.DateUntil)
-	
-	if m.DateUntil != nil {
-		var reservations []BookingRequest
-		current := e.Enter
-	
-		for current.Before(*m.DateUntil) || current.Equal(*m.DateUntil) {
-			reservations = append(reservations, BookingRequest {
-				Enter: current,
-				Leave: time.Date(current.Year(), current.Month(), current.Day(), e.Leave.Hour(), e.Leave.Minute(), e.Leave.Second(), e.Leave.Nanosecond(), e.Leave.Location()),
-			})
-			current = current.AddDate(0, 0, 7)
-		}
-	
-		for _, bookingReq := range reservations {
-			booking := Booking{
-				UserID: e.UserID,
-				Enter: bookingReq.Enter,
-				Leave: bookingReq.Leave,
-				SpaceID: e.SpaceID,
-			}
-		
-			if valid, code := router.checkBookingCreateUpdate(&bookingReq, location, requestUser, ""); !valid {
-				log.Println(err)
-				SendBadRequestCode(w, code)
-				return
-			}
-			conflicts, err := GetBookingRepository().GetConflicts(e.SpaceID, booking.Enter, booking.Leave, "")
-			if err != nil {
-				log.Println(err)
-				SendInternalServerError(w)
-				return
-			}
-			if len(conflicts) > 0 {
-				log.Println(conflicts)
-				SendAleadyExists(w)
-				return
-			}
-			if err := GetBookingRepository().Create(&booking); err != nil {
-				log.Println(err)
-				SendInternalServerError(w)
-				return
-			}
-		}
-	} else {
-		bookingReq := &BookingRequest{
-			Enter: e.Enter,
-			Leave: e.Leave,
-		}
+func (router *BookingRouter) getRecurrenceRule(m *CreateBookingRequest, e *Booking, location *Location) (*rrule.RRule, error) {
+	if m.Recurrence != "" {
+		return parseRecurrenceRule(m.Recurrence, e.Enter)
+	}
+	until, err := attachTimezoneInformation(*m.DateUntil, location)
+	if err != nil {
+		return nil, err
+	}
+	return getWeeklyRecurrenceRule(e.Enter, until)
+}
 
-		if valid, code := router.checkBookingCreateUpdate(bookingReq, location, requestUser, ""); !valid {
-			log.Println(err)
-			SendBadRequestCode(w, code)
-			return
-		}
-		conflicts, err := GetBookingRepository().GetConflicts(e.SpaceID, e.Enter, e.Leave, "")
-		if err != nil {
-			log.Println(err)
-			SendInternalServerError(w)
-			return
-		}
-		if len(conflicts) > 0 {
-			log.Println(conflicts)
-			SendAleadyExists(w)
-			return
-		}
-		if err := GetBookingRepository().Create(e); err != nil {
-			log.Println(err)
-			SendInternalServerError(w)
-			return
-		}
+// getBookingUser returns the user a booking is made for.
+func (router *BookingRouter) getBookingUser(requestUser *User, userID string) (*User, error) {
+	if userID == requestUser.ID {
+		return requestUser, nil
 	}
-	go router.onBookingCreated(e)
-	SendCreated(w, e.ID)
+	return GetUserRepository().GetOne(userID)
 }
 
 func (router *BookingRouter) bookForUser(requestUser *User, userEmail string, w http.ResponseWriter) (string, error) {
@@ -507,6 +1168,7 @@ func (router *BookingRouter) bookForUser(requestUser *User, userEmail string, w
 			SendInternalServerError(w)
 			return "", errors.New("InternalServerError")
 		}
+		fireUserWebhookEvent(user, WebhookEventUserCreated)
 		bookForUser, err = GetUserRepository().GetByEmail(userEmail)
 		if err != nil {
 			SendInternalServerError(w)
@@ -523,10 +1185,6 @@ func (router *BookingRouter) bookForUser(requestUser *User, userEmail string, w
 
 func (router *BookingRouter) getPresenceReport(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	var m GetBookingFilterRequest
 	if UnmarshalValidateBody(r, &m) != nil {
 		SendBadRequest(w)
@@ -539,10 +1197,14 @@ func (router *BookingRouter) getPresenceReport(w http.ResponseWriter, r *http.Re
 			SendNotFound(w)
 			return
 		}
-		if !GetUserRepository().isSuperAdmin(user) && location.OrganizationID != user.OrganizationID {
+		if !CanSpaceAdminLocation(user, location) {
 			SendForbidden(w)
 			return
 		}
+	} else if !HasPermission(user, GetRequestAPIToken(r), PermissionSpacesAdmin) {
+		// Users administrating single locations must specify the location
+		SendForbidden(w)
+		return
 	}
 	items, err := GetBookingRepository().GetPresenceReport(user.OrganizationID, location, m.Start, m.End, 1000, 0)
 	if err != nil {
@@ -560,6 +1222,10 @@ func (router *BookingRouter) getPresenceReport(w http.ResponseWriter, r *http.Re
 		Dates:     make([]string, numDates),
 		Presences: make([][]int, numUsers),
 	}
+	if numUsers == 0 {
+		SendJSON(w, res)
+		return
+	}
 	i := 0
 	for date := range items[0].Presence {
 		res.Dates[i] = date
@@ -636,13 +1302,17 @@ func (router *BookingRouter) isValidBookingAdvance(m *BookingRequest, orgID stri
 }
 
 func (router *BookingRouter) isValidMaxUpcomingBookings(orgID string, user *User) bool {
+	return router.isValidMaxUpcomingBookingsForNum(orgID, user, 1)
+}
+
+func (router *BookingRouter) isValidMaxUpcomingBookingsForNum(orgID string, user *User, numNew int) bool {
 	noAdminRestrictions, _ := GetSettingsRepository().GetBool(orgID, SettingNoAdminRestrictions.Name)
 	if noAdminRestrictions && CanSpaceAdminOrg(user, orgID) {
 		return true
 	}
 	maxUpcoming, _ := GetSettingsRepository().GetInt(orgID, SettingMaxBookingsPerUser.Name)
 	curUpcoming, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
-	return len(curUpcoming) < maxUpcoming
+	return len(curUpcoming)+numNew <= maxUpcoming
 }
 
 func (router *BookingRouter) isValidMaxConcurrentBookingsForUser(orgID string, user *User, m *BookingRequest, bookingID string) bool {
@@ -659,8 +1329,9 @@ func (router *BookingRouter) isValidMaxConcurrentBookingsForUser(orgID string, u
 	return len(curAtTime) < maxConcurrent
 }
 
-func (router *BookingRouter) isValidBookingRequest(m *BookingRequest, user *User, orgID string, bookingID string) (bool, int) {
+func (router *BookingRouter) isValidBookingRequest(m *BookingRequest, user *User, location *Location, spaceID string, bookingID string) (bool, int) {
 	isUpdate := bookingID != ""
+	orgID := location.OrganizationID
 	if !router.isValidBookingDuration(m, orgID, user) {
 		return false, ResponseCodeBookingInvalidBookingDuration
 	}
@@ -681,6 +1352,20 @@ func (router *BookingRouter) isValidBookingRequest(m *BookingRequest, user *User
 	return true, 0
 }
 
+// isValidGroupRestriction checks whether the user may book the space. If no
+// space is passed, only the location's restriction is checked.
+func (router *BookingRouter) isValidGroupRestriction(location *Location, spaceID string, user *User) bool {
+	noAdminRestrictions, _ := GetSettingsRepository().GetBool(location.OrganizationID, SettingNoAdminRestrictions.Name)
+	if noAdminRestrictions && CanSpaceAdminOrg(user, location.OrganizationID) {
+		return true
+	}
+	if spaceID == "" {
+		return !GetGroupRepository().IsLocationRestricted(location.ID, user.ID)
+	}
+	space := &Space{ID: spaceID, LocationID: location.ID}
+	return !GetGroupRepository().IsSpaceRestricted(space, user.ID)
+}
+
 func (router *BookingRouter) isValidConcurrent(m *BookingRequest, location *Location, bookingID string) bool {
 	if location.MaxConcurrentBookings == 0 {
 		return true
@@ -736,103 +1421,488 @@ func (router *BookingRouter) isValidMinHoursBooking(e *BookingRequest, organizat
 	return difference_in_hours >= int64(min_hours)
 }
 
-func (router *BookingRouter) getCalDavConfig(userID string) (*CaldavConfig, error) {
-	prefs, err := GetUserPreferencesRepository().GetAll(userID)
+func (router *BookingRouter) initCalendarEvent(e *Booking) (*CalendarEvent, error) {
+	space, err := GetSpaceRepository().GetOne(e.SpaceID)
 	if err != nil {
 		return nil, err
 	}
-	res := &CaldavConfig{}
-	for _, pref := range prefs {
-		if pref.Name == PreferenceCalDAVURL.Name {
-			if _, err := url.ParseRequestURI(pref.Value); err == nil {
-				res.URL = pref.Value
-			}
-		} else if pref.Name == PreferenceCalDAVUser.Name && len(pref.Value) > 0 {
-			res.Username = pref.Value
-		} else if pref.Name == PreferenceCalDAVPass.Name && len(pref.Value) > 0 {
-			decryptedPassword := decryptString(pref.Value)
-			if decryptedPassword != "" {
-				res.Password = decryptedPassword
-			}
-		} else if pref.Name == PreferenceCalDAVPath.Name && len(pref.Value) > 0 {
-			res.Path = pref.Value
-		}
+	location, err := GetLocationRepository().GetOne(space.LocationID)
+	if err != nil {
+		return nil, err
 	}
-	if res.URL == "" || res.Username == "" || res.Password == "" || res.Path == "" {
-		return nil, errors.New("caldav not configured completely")
+	enter, err := attachTimezoneInformation(e.Enter, location)
+	if err != nil {
+		return nil, err
 	}
-	return res, nil
+	leave, err := attachTimezoneInformation(e.Leave, location)
+	if err != nil {
+		return nil, err
+	}
+	event := &CalendarEvent{
+		Title:    "Seat Reservation: " + space.Name + ", " + location.Name,
+		Location: space.Name + ", " + location.Name,
+		Start:    enter,
+		End:      leave,
+	}
+	return event, nil
+}
+
+// writeAuditLog writes an audit log entry for a booking. before or after is nil
+// if the booking has been created or deleted.
+func (router *BookingRouter) writeAuditLog(r *http.Request, organizationID, action string, before *Booking, after *Booking) {
+	var entityID string
+	var beforeData, afterData interface{}
+	if before != nil {
+		entityID = before.ID
+		beforeData = getAuditLogBookingData(before)
+	}
+	if after != nil {
+		entityID = after.ID
+		afterData = getAuditLogBookingData(after)
+	}
+	writeAuditLog(r, organizationID, action, AuditEntityBooking, entityID, beforeData, afterData)
+}
+
+// getCreateAuditAction distinguishes bookings made on behalf of another user.
+func (router *BookingRouter) getCreateAuditAction(e *Booking, requestUser *User) string {
+	if e.UserID != requestUser.ID {
+		return AuditActionBookOnBehalf
+	}
+	return AuditActionCreate
+}
+
+func (router *BookingRouter) onBookingCreated(e *Booking) {
+	if err := router.enqueueCalDavSync(e, CalDAVSyncOperationUpdate); err != nil {
+		log.Println(err)
+	}
+	fireBookingWebhookEvent(e, WebhookEventBookingCreated)
+	notifyLocationBooking(e)
+}
+
+func (router *BookingRouter) onBookingUpdated(e *Booking) {
+	if err := router.enqueueCalDavSync(e, CalDAVSyncOperationUpdate); err != nil {
+		log.Println(err)
+	}
+	fireBookingWebhookEvent(e, WebhookEventBookingUpdated)
 }
 
-func (router *BookingRouter) initCaldavEvent(e *Booking) (*CalDAVClient, *CalDAVEvent, string, error) {
-	config, err := router.getCalDavConfig(e.UserID)
+// onBookingCancelled follows the deletion of a single booking, no matter if it
+// has been deleted by a user or a background job (r and actor are nil then).
+func (router *BookingRouter) onBookingCancelled(r *http.Request, e *BookingDetails, actor *User) {
+	if e.SeriesID != "" {
+		GetBookingSeriesRepository().DeleteIfEmpty(string(e.SeriesID))
+	}
+	router.onBookingDeleted(&e.Booking)
+	router.writeAuditLog(r, e.Space.Location.OrganizationID, AuditActionDelete, &e.Booking, nil)
+	sendBookingMails(BookingMailCancelled, []*BookingDetails{e}, actor)
+	// The location joined into the booking lacks the booking limits which
+	// apply when offering the slot to the waitlist
+	location, err := GetLocationRepository().GetOne(e.Space.LocationID)
 	if err != nil {
-		return nil, nil, "", err
+		log.Println(err)
+		return
 	}
-	caldavClient := &CalDAVClient{}
-	if err := caldavClient.Connect(config.URL, config.Username, config.Password); err != nil {
+	router.onBookingSlotFreed(e, location)
+}
+
+func (router *BookingRouter) onBookingDeleted(e *Booking) {
+	if err := router.enqueueCalDavSync(e, CalDAVSyncOperationDelete); err != nil {
 		log.Println(err)
-		return nil, nil, "", err
 	}
-	space, err := GetSpaceRepository().GetOne(e.SpaceID)
+	fireBookingWebhookEvent(e, WebhookEventBookingDeleted)
+}
+
+// enqueueCalDavSync adds a calendar operation for the booking to the outbox if
+// the booking's user has configured a calendar provider.
+func (router *BookingRouter) enqueueCalDavSync(e *Booking, operation CalDAVSyncOperation) error {
+	config, err := getCalendarConfig(e.UserID)
 	if err != nil {
-		return nil, nil, "", err
+		return nil
 	}
-	location, err := GetLocationRepository().GetOne(space.LocationID)
+	calDavID := router.getCalDavID(e, config)
+	if operation == CalDAVSyncOperationDelete && e.CalDavID != "" && calDavID == "" {
+		// The event belongs to a calendar the user doesn't use anymore
+		return nil
+	}
+	if calDavID == "" {
+		// New events are created with the booking ID as their CalDAV ID.
+		// Providers which assign IDs themselves create a new event instead.
+		calDavID = e.ID
+	}
+	if operation == CalDAVSyncOperationDelete && e.CalDavID == "" {
+		if pending, _ := GetCalDAVSyncRepository().GetOneByBooking(e.ID); pending == nil {
+			return nil
+		}
+	}
+	now := time.Now().UTC()
+	return GetCalDAVSyncRepository().Enqueue(&CalDAVSyncEntry{
+		UserID:      e.UserID,
+		BookingID:   e.ID,
+		Operation:   operation,
+		CalDavID:    calDavID,
+		NextAttempt: now,
+		Created:     now,
+	})
+}
+
+// processCalDavSyncQueue performs due operations from the CalDAV outbox.
+// Failed operations are retried with exponential backoff.
+func (router *BookingRouter) processCalDavSyncQueue() (int, error) {
+	list, err := GetCalDAVSyncRepository().Claim(time.Now().UTC(), CalDAVSyncLease, CalDAVSyncMaxAttempts, CalDAVSyncBatchSize)
 	if err != nil {
-		return nil, nil, "", err
+		return 0, err
 	}
-	caldavEvent := &CalDAVEvent{
-		Title:    "Seat Reservation: " + space.Name + ", " + location.Name,
-		Location: space.Name + ", " + location.Name,
-		Start:    e.Enter,
-		End:      e.Leave,
+	num := 0
+	for _, entry := range list {
+		if err := router.processCalDavSyncEntry(entry); err != nil {
+			log.Println(err)
+			entry.Attempts++
+			entry.NextAttempt = time.Now().UTC().Add(getCalDavSyncBackoff(entry.Attempts))
+			entry.LastError = err.Error()
+			if err := GetCalDAVSyncRepository().Update(entry); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		if err := GetCalDAVSyncRepository().Delete(entry); err != nil {
+			log.Println(err)
+		}
+		num++
 	}
-	return caldavClient, caldavEvent, config.Path, nil
+	return num, nil
 }
 
-func (router *BookingRouter) onBookingCreated(e *Booking) {
-	caldavClient, caldavEvent, path, err := router.initCaldavEvent(e)
+func (router *BookingRouter) processCalDavSyncEntry(entry *CalDAVSyncEntry) error {
+	config, err := getCalendarConfig(entry.UserID)
+	if err != nil {
+		// Calendar integration has been disabled by the user in the meantime
+		return nil
+	}
+	if entry.Operation == CalDAVSyncOperationDelete {
+		provider, err := getCalendarProvider(config)
+		if err != nil {
+			return err
+		}
+		return provider.DeleteEvent(config.CalendarID, &CalendarEvent{ID: entry.CalDavID})
+	}
+	e, err := GetBookingRepository().GetOne(entry.BookingID)
+	if err != nil {
+		// Booking has been deleted in the meantime
+		return nil
+	}
+	event, err := router.initCalendarEvent(&e.Booking)
+	if err != nil {
+		return err
+	}
+	provider, err := getCalendarProvider(config)
+	if err != nil {
+		return err
+	}
+	event.ID = router.getCalDavID(&e.Booking, config)
+	if event.ID == "" {
+		event.ID = e.ID
+	}
+	if err := provider.CreateEvent(config.CalendarID, event); err != nil {
+		return err
+	}
+	if e.CalDavID != event.ID || e.CalDavCalendar != config.Key() {
+		return GetBookingRepository().SetCalDavID(e.ID, event.ID, config.Key())
+	}
+	return nil
+}
+
+// getCalDavID returns the ID of the booking's event in the calendar of the
+// config or an empty string if no event has been created in that calendar.
+// Bookings synchronised before the calendar was stored are assumed to belong
+// to the current calendar.
+func (router *BookingRouter) getCalDavID(e *Booking, config *CalendarConfig) string {
+	if e.CalDavCalendar != "" && e.CalDavCalendar != config.Key() {
+		return ""
+	}
+	return e.CalDavID
+}
+
+// getCalDavSyncBackoff returns the delay before the next attempt after the
+// given number of failed attempts.
+func getCalDavSyncBackoff(attempts int) time.Duration {
+	backoff := CalDAVSyncInitialBackoff
+	for i := 1; i < attempts && backoff < CalDAVSyncMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > CalDAVSyncMaxBackoff {
+		backoff = CalDAVSyncMaxBackoff
+	}
+	return backoff
+}
+
+// reconcileCalDav compares upcoming bookings with their events in the users'
+// calendars and applies the organization's SettingCalDAVReconcilePolicy
+// to events which have been deleted or moved.
+func (router *BookingRouter) reconcileCalDav() (int, error) {
+	// Enter and leave are stored as local wall clock times, so look at all
+	// bookings which might not have ended in any time zone
+	list, err := GetBookingRepository().GetAllWithCalDavID(time.Now().UTC().Add(time.Hour * -14))
 	if err != nil {
+		return 0, err
+	}
+	policies := map[string]int{}
+	userIDs := []string{}
+	bookings := map[string][]*BookingDetails{}
+	for _, e := range list {
+		orgID := e.Space.Location.OrganizationID
+		if _, ok := policies[orgID]; !ok {
+			policies[orgID], _ = GetSettingsRepository().GetInt(orgID, SettingCalDAVReconcilePolicy.Name)
+		}
+		if policies[orgID] == CalDAVReconcilePolicyDisabled {
+			continue
+		}
+		leave, _ := attachTimezoneInformation(e.Leave, &e.Space.Location)
+		if leave.Before(time.Now()) {
+			continue
+		}
+		if _, ok := bookings[e.UserID]; !ok {
+			userIDs = append(userIDs, e.UserID)
+		}
+		bookings[e.UserID] = append(bookings[e.UserID], e)
+	}
+	num := 0
+	for _, userID := range userIDs {
+		numUser, err := router.reconcileCalDavUser(userID, bookings[userID], policies)
+		if err != nil {
+			log.Println(err)
+		}
+		num += numUser
+	}
+	return num, nil
+}
+
+func (router *BookingRouter) reconcileCalDavUser(userID string, list []*BookingDetails, policies map[string]int) (int, error) {
+	config, err := getCalendarConfig(userID)
+	if err != nil {
+		return 0, nil
+	}
+	pending, err := GetCalDAVSyncRepository().GetAllByUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	provider, err := getCalendarProvider(config)
+	if err != nil {
+		return 0, err
+	}
+	num := 0
+	for _, e := range list {
+		if pending[e.ID] != nil {
+			// Local changes haven't been synchronised yet
+			continue
+		}
+		if router.getCalDavID(&e.Booking, config) == "" {
+			// Event has been created in a calendar the user doesn't use anymore
+			continue
+		}
+		policy := policies[e.Space.Location.OrganizationID]
+		event, err := provider.GetEvent(config.CalendarID, e.CalDavID)
+		if err == ErrCalendarEventNotFound {
+			router.onCalDavEventDeleted(e, policy)
+			num++
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		enter, _ := attachTimezoneInformation(e.Enter, &e.Space.Location)
+		leave, _ := attachTimezoneInformation(e.Leave, &e.Space.Location)
+		if event.Start.Equal(enter) && event.End.Equal(leave) {
+			continue
+		}
+		router.onCalDavEventMoved(e, event, policy)
+		num++
+	}
+	return num, nil
+}
+
+func (router *BookingRouter) onCalDavEventDeleted(e *BookingDetails, policy int) {
+	if policy == CalDAVReconcilePolicyRecreate {
+		if err := router.enqueueCalDavSync(&e.Booking, CalDAVSyncOperationUpdate); err != nil {
+			log.Println(err)
+			return
+		}
+		router.logCalDavReconcile(e, CalDAVLogActionEventRecreated, "")
 		return
 	}
-	if err := caldavClient.CreateEvent(path, caldavEvent); err != nil {
+	if err := GetBookingRepository().Delete(e); err != nil {
 		log.Println(err)
 		return
 	}
-	e.CalDavID = caldavEvent.ID
-	GetBookingRepository().Update(e)
+	router.logCalDavReconcile(e, CalDAVLogActionBookingCancelled, "")
+	router.onBookingCancelled(nil, e, nil)
 }
 
-func (router *BookingRouter) onBookingUpdated(e *Booking) {
-	caldavClient, caldavEvent, path, err := router.initCaldavEvent(e)
+func (router *BookingRouter) onCalDavEventMoved(e *BookingDetails, event *CalendarEvent, policy int) {
+	tz, err := time.LoadLocation(GetLocationRepository().GetTimezone(&e.Space.Location))
 	if err != nil {
+		log.Println(err)
 		return
 	}
-	if e.CalDavID != "" {
-		caldavEvent.ID = e.CalDavID
+	enter := event.Start.In(tz)
+	leave := event.End.In(tz)
+	details := enter.Format("2006-01-02 15:04") + " - " + leave.Format("2006-01-02 15:04")
+	if policy == CalDAVReconcilePolicyUpdate {
+		if valid, code := router.isValidCalDavEventMove(e, enter, leave); !valid {
+			details += " (" + strconv.Itoa(code) + ")"
+		} else {
+			before := e.Booking
+			e.Enter = enter
+			e.Leave = leave
+			if err := GetBookingRepository().Update(&e.Booking); err != nil {
+				log.Println(err)
+				return
+			}
+			router.logCalDavReconcile(e, CalDAVLogActionBookingUpdated, details)
+			router.onBookingUpdated(&e.Booking)
+			router.writeAuditLog(nil, e.Space.Location.OrganizationID, AuditActionUpdate, &before, &e.Booking)
+			sendBookingMails(BookingMailChanged, []*BookingDetails{e}, nil)
+			return
+		}
 	}
-	if err := caldavClient.CreateEvent(path, caldavEvent); err != nil {
+	if err := router.enqueueCalDavSync(&e.Booking, CalDAVSyncOperationUpdate); err != nil {
 		log.Println(err)
 		return
 	}
-	e.CalDavID = caldavEvent.ID
-	GetBookingRepository().Update(e)
+	router.logCalDavReconcile(e, CalDAVLogActionEventReset, details)
 }
 
-func (router *BookingRouter) onBookingDeleted(e *Booking) {
-	caldavClient, caldavEvent, path, err := router.initCaldavEvent(e)
+func (router *BookingRouter) isValidCalDavEventMove(e *BookingDetails, enter, leave time.Time) (bool, int) {
+	user, err := GetUserRepository().GetOne(e.UserID)
+	if err != nil {
+		return false, 0
+	}
+	bookingReq := &BookingRequest{
+		Enter: enter,
+		Leave: leave,
+	}
+	if valid, code := router.checkBookingCreateUpdate(bookingReq, &e.Space.Location, e.SpaceID, user, user, e.ID); !valid {
+		return false, code
+	}
+	conflicts, err := GetBookingRepository().GetConflicts(e.SpaceID, enter, leave, e.ID)
+	if err != nil || len(conflicts) > 0 {
+		return false, ResponseCodeBookingSlotConflict
+	}
+	return true, 0
+}
+
+func (router *BookingRouter) logCalDavReconcile(e *BookingDetails, action CalDAVLogAction, details string) {
+	entry := &CalDAVLogEntry{
+		UserID:    e.UserID,
+		BookingID: e.ID,
+		Action:    action,
+		Details:   details,
+		Created:   time.Now().UTC(),
+	}
+	if err := GetCalDAVLogRepository().Create(entry); err != nil {
+		log.Println(err)
+	}
+}
+
+func (router *BookingRouter) applyCalDavSyncStatus(m *GetBookingResponse, e *Booking, entry *CalDAVSyncEntry) {
+	if entry != nil && entry.Attempts >= CalDAVSyncMaxAttempts {
+		m.CalDavSync = CalDAVSyncStatusFailed
+		m.CalDavError = entry.LastError
+	} else if entry != nil {
+		m.CalDavSync = CalDAVSyncStatusPending
+		m.CalDavError = entry.LastError
+	} else if e.CalDavID != "" {
+		m.CalDavSync = CalDAVSyncStatusSynced
+	} else {
+		m.CalDavSync = CalDAVSyncStatusNone
+	}
+}
+
+// onBookingSlotFreed offers the slot of a deleted booking to the user waiting
+// longest for it. Depending on SettingWaitlistAutoBook, the slot is either
+// booked for that user right away or the user is notified by email.
+func (router *BookingRouter) onBookingSlotFreed(e *BookingDetails, location *Location) {
+	if e.Leave.Before(time.Now().UTC()) {
+		return
+	}
+	entries, err := GetWaitlistRepository().GetMatching(&Space{ID: e.SpaceID, LocationID: location.ID}, e.Enter, e.Leave)
 	if err != nil {
+		log.Println(err)
 		return
 	}
-	if e.CalDavID == "" {
+	if len(entries) == 0 {
 		return
 	}
-	caldavEvent.ID = e.CalDavID
-	if err := caldavClient.DeleteEvent(path, caldavEvent); err != nil {
+	org, err := GetOrganizationRepository().GetOne(location.OrganizationID)
+	if err != nil {
 		log.Println(err)
 		return
 	}
+	autoBook, _ := GetSettingsRepository().GetBool(org.ID, SettingWaitlistAutoBook.Name)
+	for _, entry := range entries {
+		user, err := GetUserRepository().GetOne(entry.UserID)
+		if err != nil {
+			continue
+		}
+		templateFile := EmailTemplateWaitlistAvailable
+		if autoBook {
+			if !router.bookFromWaitlist(entry, e.SpaceID, location, user) {
+				continue
+			}
+			templateFile = EmailTemplateWaitlistBooked
+		}
+		if err := GetWaitlistRepository().Delete(&entry.WaitlistEntry); err != nil {
+			log.Println(err)
+		}
+		vars := map[string]string{
+			"recipientName":  user.Email,
+			"recipientEmail": user.Email,
+			"spaceName":      e.Space.Name,
+			"locationName":   location.Name,
+			"enter":          entry.Enter.Format("2006-01-02 15:04"),
+			"leave":          entry.Leave.Format("2006-01-02 15:04"),
+		}
+		if err := sendEmail(user.Email, GetConfig().SMTPSenderAddress, templateFile, org.Language, vars); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+}
+
+func (router *BookingRouter) bookFromWaitlist(entry *WaitlistEntryDetails, spaceID string, location *Location, user *User) bool {
+	enter, err := attachTimezoneInformation(entry.Enter, location)
+	if err != nil {
+		return false
+	}
+	leave, err := attachTimezoneInformation(entry.Leave, location)
+	if err != nil {
+		return false
+	}
+	bookingReq := &BookingRequest{
+		Enter: enter,
+		Leave: leave,
+	}
+	if valid, _ := router.checkBookingCreateUpdate(bookingReq, location, spaceID, user, user, ""); !valid {
+		return false
+	}
+	booking := &Booking{
+		UserID:  user.ID,
+		SpaceID: spaceID,
+		Enter:   enter,
+		Leave:   leave,
+	}
+	conflicts, err := GetBookingRepository().CreateWithoutConflicts(booking)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if len(conflicts) > 0 {
+		return false
+	}
+	router.onBookingCreated(booking)
+	router.writeAuditLog(nil, location.OrganizationID, AuditActionCreate, nil, booking)
+	sendBookingMailsByID(BookingMailCreated, []*Booking{booking}, user)
+	return true
 }
 
 func (router *BookingRouter) copyFromRestModel(m *CreateBookingRequest, location *Location) (*Booking, error) {
@@ -858,6 +1928,12 @@ func (router *BookingRouter) copyToRestModel(e *BookingDetails) *GetBookingRespo
 	m.ID = e.ID
 	m.UserID = e.UserID
 	m.UserEmail = e.UserEmail
+	m.SeriesID = string(e.SeriesID)
+	if e.CheckInTime != nil {
+		checkInTime, _ := attachTimezoneInformation(*e.CheckInTime, &e.Space.Location)
+		m.CheckedIn = true
+		m.CheckInTime = &checkInTime
+	}
 	m.SpaceID = e.SpaceID
 	m.Enter, _ = attachTimezoneInformation(e.Enter, &e.Space.Location)
 	m.Leave, _ = attachTimezoneInformation(e.Leave, &e.Space.Location)
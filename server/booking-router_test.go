@@ -2,9 +2,16 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/mail"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -2364,3 +2371,1170 @@ func TestBookingsNonExistingUsers(t *testing.T) {
 	checkTestResponseCode(t, http.StatusForbidden, res.Code)
 
 }
+
+func TestBookingsRecurrenceDaily(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=3\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+	checkTestString(t, res.Header().Get("X-Object-Id"), list[0].ID)
+	checkTestString(t, "2030-09-02T08:00:00", list[0].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-03T08:00:00", list[1].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-04T17:00:00", list[2].Leave.Format(JsDateTimeFormat))
+}
+
+func TestBookingsRecurrenceWeekdays(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-06T08:00:00Z\", \"leave\": \"2030-09-06T17:00:00Z\", \"recurrence\": \"RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=3\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+	checkTestString(t, "2030-09-06T08:00:00", list[0].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-09T08:00:00", list[1].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-10T08:00:00", list[2].Enter.Format(JsDateTimeFormat))
+}
+
+func TestBookingsRecurrenceEveryNWeeks(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=WEEKLY;INTERVAL=2;UNTIL=20301001T000000Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+	checkTestString(t, "2030-09-16T08:00:00", list[1].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-30T08:00:00", list[2].Enter.Format(JsDateTimeFormat))
+}
+
+func TestBookingsRecurrenceLegacyDateUntil(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"dateUntil\": \"2030-09-16T08:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+}
+
+func TestBookingsRecurrenceInvalidRule(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	rules := []string{"FREQ=DAILY", "FREQ=HOURLY;COUNT=3", "FREQ=DAILY;COUNT=3;BYHOUR=9", "FREQ=DAILY;COUNT=1000", "FOO"}
+	for _, rule := range rules {
+		payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"" + rule + "\"}"
+		req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	}
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+}
+
+func TestBookingsRecurrenceConflictCreatesNothing(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-04T08:00:00Z\", \"leave\": \"2030-09-04T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user2.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	payload = "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=5\"}"
+	req = newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+	var resBody *CreateRecurringBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, "", resBody.SeriesID)
+	checkTestInt(t, 0, len(resBody.IDs))
+	checkTestInt(t, 1, len(resBody.Conflicts))
+	checkTestString(t, "2030-09-04T08:00:00", resBody.Conflicts[0].Enter.Format(JsDateTimeFormat))
+	checkTestInt(t, ResponseCodeBookingSlotConflict, resBody.Conflicts[0].Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+}
+
+func TestBookingsRecurrenceSkipConflicts(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-04T08:00:00Z\", \"leave\": \"2030-09-04T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user2.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	payload = "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=5\", \"skipConflicts\": true}"
+	req = newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var resBody *CreateRecurringBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 4, len(resBody.IDs))
+	checkTestString(t, resBody.IDs[0], res.Header().Get("X-Object-Id"))
+	checkTestInt(t, 1, len(resBody.Conflicts))
+	checkTestString(t, "2030-09-04T08:00:00", resBody.Conflicts[0].Enter.Format(JsDateTimeFormat))
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 4, len(list))
+	checkTestString(t, "2030-09-05T08:00:00", list[2].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, resBody.SeriesID, string(list[2].SeriesID))
+}
+
+func TestBookingsRecurrenceSkipConflictsNothingLeft(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user2.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	payload = "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=1\", \"skipConflicts\": true}"
+	req = newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+}
+
+func TestBookingsRecurrenceMaxUpcomingBookings(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingMaxBookingsPerUser.Name, "2")
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=3\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingTooManyUpcomingBookings), res.Header().Get("X-Error-Code"))
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+}
+
+func createSeriesTestBookings(t *testing.T, user *User, s *Space) string {
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=3\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	req = newHTTPRequest("GET", "/booking/"+res.Header().Get("X-Object-Id"), user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	if resBody.SeriesID == "" {
+		t.Fatal("Expected series ID to be set")
+	}
+	return resBody.SeriesID
+}
+
+func TestBookingsSeriesGet(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+
+	req := newHTTPRequest("GET", "/booking/series/"+seriesID, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingSeriesResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, seriesID, resBody.ID)
+	checkTestString(t, s.ID, resBody.SpaceID)
+	checkTestString(t, "FREQ=DAILY;COUNT=3", resBody.Recurrence)
+	checkTestInt(t, 3, len(resBody.Bookings))
+	checkTestString(t, "2030-09-03T08:00:00", resBody.Bookings[1].Enter.Format(JsDateTimeFormat))
+}
+
+func TestBookingsSeriesForbidden(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+	user2 := createTestUserInOrg(org)
+
+	req := newHTTPRequest("GET", "/booking/series/"+seriesID, user2.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/booking/series/"+seriesID, user2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+}
+
+func TestBookingsSeriesUpdate(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-03T09:00:00Z\", \"leave\": \"2030-09-03T12:00:00Z\", \"scope\": \"all\"}"
+	req := newHTTPRequest("PUT", "/booking/series/"+seriesID, user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+	checkTestString(t, "2030-09-03T09:00:00", list[0].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-03T12:00:00", list[0].Leave.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-05T09:00:00", list[2].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, seriesID, string(list[2].SeriesID))
+}
+
+func TestBookingsSeriesUpdateConflict(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+	user2 := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-05T08:00:00Z\", \"leave\": \"2030-09-05T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user2.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	// Moving the series by one day would collide with the last occurrence
+	payload = "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-03T08:00:00Z\", \"leave\": \"2030-09-03T17:00:00Z\"}"
+	req = newHTTPRequest("PUT", "/booking/series/"+seriesID, user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 3, len(list))
+	checkTestString(t, "2030-09-02T08:00:00", list[0].Enter.Format(JsDateTimeFormat))
+}
+
+func TestBookingsSeriesDelete(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+
+	req := newHTTPRequest("DELETE", "/booking/series/"+seriesID+"?scope=future", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+
+	req = newHTTPRequest("GET", "/booking/series/"+seriesID, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestBookingsSeriesDeleteMaxHoursBeforeDelete(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+	GetSettingsRepository().Set(org.ID, SettingEnableMaxHourBeforeDelete.Name, "1")
+	GetSettingsRepository().Set(org.ID, SettingMaxHoursBeforeDelete.Name, "24")
+	list, _ := GetBookingRepository().GetAllBySeries(seriesID)
+	list[0].Enter = time.Now().UTC().Add(time.Hour * 2)
+	list[0].Leave = time.Now().UTC().Add(time.Hour * 4)
+	GetBookingRepository().Update(&list[0].Booking)
+
+	req := newHTTPRequest("DELETE", "/booking/series/"+seriesID+"?scope=future", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *DeleteBookingSeriesResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 2, len(resBody.IDs))
+	checkTestInt(t, 1, len(resBody.Skipped))
+	checkTestInt(t, ResponseCodeBookingMaxHoursBeforeDelete, resBody.Skipped[0].Code)
+
+	remaining, _ := GetBookingRepository().GetAllBySeries(seriesID)
+	checkTestInt(t, 1, len(remaining))
+	checkTestString(t, list[0].ID, remaining[0].ID)
+}
+
+func TestBookingsSeriesDeleteOrphaned(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+	GetDatabase().DB().Exec("DELETE FROM bookings WHERE series_id = $1", seriesID)
+
+	if err := GetBookingSeriesRepository().DeleteOrphaned(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetBookingSeriesRepository().GetOne(seriesID); err == nil {
+		t.Fatal("Expected series to be deleted")
+	}
+}
+
+func TestBookingsSeriesDeleteSingleOccurrence(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	seriesID := createSeriesTestBookings(t, user, s)
+
+	list, _ := GetBookingRepository().GetAllByUser(user.ID, time.Now().UTC())
+	req := newHTTPRequest("DELETE", "/booking/"+list[1].ID, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/booking/series/"+seriesID, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingSeriesResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 2, len(resBody.Bookings))
+}
+
+func TestBookingsCheckIn(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingEnableCheckIn.Name, "1")
+	e := createTestBooking(user, s, time.Now().Add(-5*time.Minute), time.Now().Add(2*time.Hour))
+
+	req := newHTTPRequest("GET", "/booking/"+e.ID, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestBool(t, false, resBody.CheckedIn)
+
+	user2 := createTestUserInOrg(org)
+	req = newHTTPRequest("POST", "/booking/"+e.ID+"/checkin", user2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("POST", "/booking/"+e.ID+"/checkin", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/booking/"+e.ID, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestBool(t, true, resBody.CheckedIn)
+	if resBody.CheckInTime == nil || resBody.CheckInTime.Sub(time.Now()).Abs() > time.Minute {
+		t.Fatal("Expected check-in time to be now")
+	}
+}
+
+func TestBookingsCheckInNotPossible(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingEnableCheckIn.Name, "1")
+	GetSettingsRepository().Set(org.ID, SettingMaxMinutesCheckIn.Name, "15")
+	early := createTestBooking(user, s, time.Now().Add(1*time.Hour), time.Now().Add(2*time.Hour))
+	late := createTestBooking(user, s, time.Now().Add(-30*time.Minute), time.Now().Add(-20*time.Minute).Add(2*time.Hour))
+
+	req := newHTTPRequest("POST", "/booking/"+early.ID+"/checkin", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingCheckInNotPossible), res.Header().Get("X-Error-Code"))
+
+	req = newHTTPRequest("POST", "/booking/"+late.ID+"/checkin", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingCheckInNotPossible), res.Header().Get("X-Error-Code"))
+}
+
+func TestBookingsReleaseNoShow(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingEnableCheckIn.Name, "1")
+	GetSettingsRepository().Set(org.ID, SettingMaxMinutesCheckIn.Name, "15")
+	s2 := &Space{Name: "Test 2", LocationID: s.LocationID}
+	GetSpaceRepository().Create(s2)
+	s3 := &Space{Name: "Test 3", LocationID: s.LocationID}
+	GetSpaceRepository().Create(s3)
+
+	noShow := createTestBooking(user, s, time.Now().Add(-30*time.Minute), time.Now().Add(2*time.Hour))
+	checkedIn := createTestBooking(user, s2, time.Now().Add(-30*time.Minute), time.Now().Add(2*time.Hour))
+	upcoming := createTestBooking(user, s3, time.Now().Add(-5*time.Minute), time.Now().Add(2*time.Hour))
+	now := time.Now()
+	checkedIn.CheckInTime = &now
+	GetBookingRepository().Update(checkedIn)
+
+	router := &BookingRouter{}
+	num, err := router.releaseNoShowBookings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+
+	if _, err := GetBookingRepository().GetOne(noShow.ID); err == nil {
+		t.Fatal("Expected no-show booking to be released")
+	}
+	entries, _ := GetAuditLogRepository().GetAll(org.ID, &AuditLogFilter{EntityType: AuditEntityBooking, EntityID: noShow.ID, Action: AuditActionDelete, Limit: 10})
+	checkTestInt(t, 1, len(entries))
+	if _, err := GetBookingRepository().GetOne(checkedIn.ID); err != nil {
+		t.Fatal("Expected checked in booking to be kept")
+	}
+	if _, err := GetBookingRepository().GetOne(upcoming.ID); err != nil {
+		t.Fatal("Expected booking within check-in period to be kept")
+	}
+}
+
+func TestBookingsReleaseNoShowDisabled(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	e := createTestBooking(user, s, time.Now().Add(-30*time.Minute), time.Now().Add(2*time.Hour))
+
+	router := &BookingRouter{}
+	num, _ := router.releaseNoShowBookings()
+	checkTestInt(t, 0, num)
+	if _, err := GetBookingRepository().GetOne(e.ID); err != nil {
+		t.Fatal("Expected booking to be kept")
+	}
+}
+
+func TestBookingsCheckInBySpaceToken(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	s2 := &Space{Name: "Test 2", LocationID: s.LocationID}
+	GetSpaceRepository().Create(s2)
+	e := createTestBooking(user, s, time.Now().Add(-5*time.Minute), time.Now().Add(2*time.Hour))
+	user2 := createTestUserInOrg(org)
+	token, _ := getSpaceCheckInToken(s.ID)
+	token2, _ := getSpaceCheckInToken(s2.ID)
+	checkTestBool(t, true, token != token2)
+
+	// Invalid token
+	req := newHTTPRequest("POST", "/booking/checkin/"+s.ID+"/"+token2, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// No booking on this space
+	req = newHTTPRequest("POST", "/booking/checkin/"+s2.ID+"/"+token2, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+	req = newHTTPRequest("POST", "/booking/checkin/"+s.ID+"/"+token, user2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	req = newHTTPRequest("POST", "/booking/checkin/"+s.ID+"/"+token, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	booking, _ := GetBookingRepository().GetOne(e.ID)
+	if booking.CheckInTime == nil {
+		t.Fatal("Expected booking to be checked in")
+	}
+}
+
+func TestBookingsCheckOut(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingMaxHoursBeforeDelete.Name, "24")
+	GetSettingsRepository().Set(org.ID, SettingCheckOutGranularityMinutes.Name, "15")
+	e := createTestBooking(user, s, time.Now().Add(-2*time.Hour), time.Now().Add(4*time.Hour))
+
+	user2 := createTestUserInOrg(org)
+	req := newHTTPRequest("POST", "/booking/"+e.ID+"/checkout", user2.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("POST", "/booking/"+e.ID+"/checkout", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	booking, _ := GetBookingRepository().GetOne(e.ID)
+	leave := time.Date(booking.Leave.Year(), booking.Leave.Month(), booking.Leave.Day(), booking.Leave.Hour(), booking.Leave.Minute(), booking.Leave.Second(), 0, tz)
+	if leave.Before(time.Now().Add(-time.Minute)) || leave.After(time.Now().Add(15*time.Minute)) {
+		t.Fatalf("Expected leave time to be rounded up from now, got %s", leave)
+	}
+	checkTestInt(t, 0, leave.Minute()%15)
+	checkTestInt(t, 0, leave.Second())
+}
+
+func TestBookingsCheckOutNotPossible(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	upcoming := createTestBooking(user, s, time.Now().Add(1*time.Hour), time.Now().Add(2*time.Hour))
+	past := createTestBooking(user, s, time.Now().Add(-3*time.Hour), time.Now().Add(-2*time.Hour))
+
+	req := newHTTPRequest("POST", "/booking/"+upcoming.ID+"/checkout", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingCheckOutNotPossible), res.Header().Get("X-Error-Code"))
+
+	req = newHTTPRequest("POST", "/booking/"+past.ID+"/checkout", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingCheckOutNotPossible), res.Header().Get("X-Error-Code"))
+}
+
+func TestRoundUpToGranularity(t *testing.T) {
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	checkTestString(t, "10:15:00", roundUpToGranularity(time.Date(2030, 9, 1, 10, 7, 30, 0, tz), 15).Format("15:04:05"))
+	checkTestString(t, "10:15:00", roundUpToGranularity(time.Date(2030, 9, 1, 10, 15, 0, 0, tz), 15).Format("15:04:05"))
+	checkTestString(t, "11:00:00", roundUpToGranularity(time.Date(2030, 9, 1, 10, 0, 1, 0, tz), 60).Format("15:04:05"))
+	checkTestString(t, "00:00:00", roundUpToGranularity(time.Date(2030, 9, 1, 23, 50, 0, 0, tz), 30).Format("15:04:05"))
+}
+
+func setCalDavTestPreferences(user *User, url string) {
+	GetUserPreferencesRepository().Set(user.ID, PreferenceCalDAVURL.Name, url)
+	GetUserPreferencesRepository().Set(user.ID, PreferenceCalDAVUser.Name, "test")
+	GetUserPreferencesRepository().Set(user.ID, PreferenceCalDAVPass.Name, encryptString("test"))
+	GetUserPreferencesRepository().Set(user.ID, PreferenceCalDAVPath.Name, caldavTestCalendar)
+}
+
+func createCalDavSyncTestBooking(t *testing.T, user *User, s *Space) string {
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	return res.Header().Get("X-Object-Id")
+}
+
+func TestBookingsCalDavSyncNotConfigured(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	id := createCalDavSyncTestBooking(t, user, s)
+
+	entry, _ := GetCalDAVSyncRepository().GetOneByBooking(id)
+	if entry != nil {
+		t.Fatal("Expected no CalDAV sync entry")
+	}
+	req := newHTTPRequest("GET", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, CalDAVSyncStatusNone, resBody.CalDavSync)
+}
+
+func TestBookingsCalDavSyncRetry(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	setCalDavTestPreferences(user, "http://127.0.0.1:1/")
+	id := createCalDavSyncTestBooking(t, user, s)
+
+	req := newHTTPRequest("GET", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, CalDAVSyncStatusPending, resBody.CalDavSync)
+	checkTestString(t, "", resBody.CalDavError)
+
+	router := &BookingRouter{}
+	num, err := router.processCalDavSyncQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 0, num)
+	entry, err := GetCalDAVSyncRepository().GetOneByBooking(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, int(CalDAVSyncOperationUpdate), int(entry.Operation))
+	checkTestString(t, id, entry.CalDavID)
+	checkTestInt(t, 1, entry.Attempts)
+	if entry.NextAttempt.Before(time.Now().UTC().Add(CalDAVSyncInitialBackoff - time.Second)) {
+		t.Fatal("Expected next attempt to be deferred")
+	}
+
+	// Not due yet
+	num, _ = router.processCalDavSyncQueue()
+	checkTestInt(t, 0, num)
+	entry, _ = GetCalDAVSyncRepository().GetOneByBooking(id)
+	checkTestInt(t, 1, entry.Attempts)
+
+	req = newHTTPRequest("GET", "/booking/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBodyList []*GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBodyList)
+	checkTestInt(t, 1, len(resBodyList))
+	checkTestString(t, CalDAVSyncStatusPending, resBodyList[0].CalDavSync)
+	if resBodyList[0].CalDavError == "" {
+		t.Fatal("Expected CalDAV error to be set")
+	}
+
+	entry.Attempts = CalDAVSyncMaxAttempts
+	GetCalDAVSyncRepository().Update(entry)
+	req = newHTTPRequest("GET", "/booking/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, CalDAVSyncStatusFailed, resBody.CalDavSync)
+}
+
+func TestBookingsCalDavSyncDeleteReplacesPending(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	setCalDavTestPreferences(user, "http://127.0.0.1:1/")
+	id := createCalDavSyncTestBooking(t, user, s)
+
+	req := newHTTPRequest("DELETE", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	entries, _ := GetCalDAVSyncRepository().GetAllByUser(user.ID)
+	checkTestInt(t, 1, len(entries))
+	checkTestInt(t, int(CalDAVSyncOperationDelete), int(entries[id].Operation))
+	checkTestString(t, id, entries[id].CalDavID)
+}
+
+func TestBookingsCalDavResync(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	e1 := createTestBooking(user, s, time.Now().Add(24*time.Hour), time.Now().Add(26*time.Hour))
+	e2 := createTestBooking(user, s, time.Now().Add(48*time.Hour), time.Now().Add(50*time.Hour))
+
+	req := newHTTPRequest("POST", "/booking/caldav/resync", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	setCalDavTestPreferences(user, "http://127.0.0.1:1/")
+	req = newHTTPRequest("POST", "/booking/caldav/resync", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	entries, _ := GetCalDAVSyncRepository().GetAllByUser(user.ID)
+	checkTestInt(t, 2, len(entries))
+	if entries[e1.ID] == nil || entries[e2.ID] == nil {
+		t.Fatal("Expected both bookings to be queued")
+	}
+}
+
+func TestCalDavSyncBackoff(t *testing.T) {
+	checkTestInt(t, 30, int(getCalDavSyncBackoff(1).Seconds()))
+	checkTestInt(t, 60, int(getCalDavSyncBackoff(2).Seconds()))
+	checkTestInt(t, 120, int(getCalDavSyncBackoff(3).Seconds()))
+	checkTestInt(t, int(CalDAVSyncMaxBackoff.Seconds()), int(getCalDavSyncBackoff(20).Seconds()))
+}
+
+func createCalDavReconcileTestSetup(t *testing.T, policy int) (*httptest.Server, *caldavTestBackend, *User, string) {
+	server, backend := newCalDAVTestServer()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingCalDAVReconcilePolicy.Name, strconv.Itoa(policy))
+	setCalDavTestPreferences(user, server.URL+"/")
+	id := createCalDavSyncTestBooking(t, user, s)
+	router := &BookingRouter{}
+	num, err := router.processCalDavSyncQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	return server, backend, user, id
+}
+
+func TestBookingsCalDavSyncWithServer(t *testing.T) {
+	clearTestDB()
+	server, backend, user, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyDisabled)
+	defer server.Close()
+
+	if backend.getEvent(id) == nil {
+		t.Fatal("Expected event to be created")
+	}
+	booking, _ := GetBookingRepository().GetOne(id)
+	checkTestString(t, id, booking.CalDavID)
+	req := newHTTPRequest("GET", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, CalDAVSyncStatusSynced, resBody.CalDavSync)
+
+	req = newHTTPRequest("DELETE", "/booking/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	router := &BookingRouter{}
+	num, _ := router.processCalDavSyncQueue()
+	checkTestInt(t, 1, num)
+	if backend.getEvent(id) != nil {
+		t.Fatal("Expected event to be deleted")
+	}
+}
+
+func TestBookingsCalDavReconcileDisabled(t *testing.T) {
+	clearTestDB()
+	server, backend, _, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyDisabled)
+	defer server.Close()
+
+	backend.deleteEvent(id)
+	router := &BookingRouter{}
+	num, err := router.reconcileCalDav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 0, num)
+	if booking, _ := GetBookingRepository().GetOne(id); booking == nil {
+		t.Fatal("Expected booking to exist")
+	}
+}
+
+func TestBookingsCalDavReconcileRecreate(t *testing.T) {
+	clearTestDB()
+	server, backend, user, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyRecreate)
+	defer server.Close()
+
+	backend.deleteEvent(id)
+	router := &BookingRouter{}
+	num, err := router.reconcileCalDav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	num, _ = router.processCalDavSyncQueue()
+	checkTestInt(t, 1, num)
+	if backend.getEvent(id) == nil {
+		t.Fatal("Expected event to be recreated")
+	}
+
+	req := newHTTPRequest("GET", "/booking/caldav/log", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetCalDAVLogEntryResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, id, resBody[0].BookingID)
+	checkTestInt(t, int(CalDAVLogActionEventRecreated), resBody[0].Action)
+
+	// Nothing left to do
+	num, _ = router.reconcileCalDav()
+	checkTestInt(t, 0, num)
+}
+
+func TestBookingsCalDavReconcileCancel(t *testing.T) {
+	clearTestDB()
+	server, backend, user, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyCancel)
+	defer server.Close()
+
+	backend.deleteEvent(id)
+	router := &BookingRouter{}
+	num, err := router.reconcileCalDav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	if booking, _ := GetBookingRepository().GetOne(id); booking != nil {
+		t.Fatal("Expected booking to be cancelled")
+	}
+	list, _ := GetCalDAVLogRepository().GetAllByUser(user.ID, 10)
+	checkTestInt(t, 1, len(list))
+	checkTestInt(t, int(CalDAVLogActionBookingCancelled), int(list[0].Action))
+
+	// Cancellation is handled like any other deletion
+	entries, _ := GetAuditLogRepository().GetAll(user.OrganizationID, &AuditLogFilter{EntityType: AuditEntityBooking, EntityID: id, Limit: 10})
+	checkTestInt(t, 2, len(entries))
+	checkTestString(t, AuditActionDelete, entries[0].Action)
+	checkTestString(t, "", entries[0].ActorUserID)
+	if entry, _ := GetCalDAVSyncRepository().GetOneByBooking(id); entry == nil {
+		t.Fatal("Expected event deletion to be queued")
+	}
+}
+
+func TestBookingsCalDavReconcileUpdate(t *testing.T) {
+	clearTestDB()
+	server, backend, user, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyUpdate)
+	defer server.Close()
+
+	backend.setEventTimes(id, time.Date(2030, 9, 2, 9, 0, 0, 0, time.UTC), time.Date(2030, 9, 2, 15, 0, 0, 0, time.UTC))
+	router := &BookingRouter{}
+	num, err := router.reconcileCalDav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+
+	req := newHTTPRequest("GET", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, "2030-09-02T09:00:00Z", resBody.Enter.UTC().Format(time.RFC3339))
+	checkTestString(t, "2030-09-02T15:00:00Z", resBody.Leave.UTC().Format(time.RFC3339))
+	list, _ := GetCalDAVLogRepository().GetAllByUser(user.ID, 10)
+	checkTestInt(t, 1, len(list))
+	checkTestInt(t, int(CalDAVLogActionBookingUpdated), int(list[0].Action))
+	entries, _ := GetAuditLogRepository().GetAll(user.OrganizationID, &AuditLogFilter{EntityType: AuditEntityBooking, EntityID: id, Action: AuditActionUpdate, Limit: 10})
+	checkTestInt(t, 1, len(entries))
+	checkTestBool(t, true, strings.Contains(entries[0].After, "09:00"))
+
+	num, _ = router.reconcileCalDav()
+	checkTestInt(t, 0, num)
+}
+
+func TestBookingsCalDavReconcileMovedReset(t *testing.T) {
+	clearTestDB()
+	server, backend, user, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyRecreate)
+	defer server.Close()
+
+	backend.setEventTimes(id, time.Date(2030, 9, 2, 9, 0, 0, 0, time.UTC), time.Date(2030, 9, 2, 15, 0, 0, 0, time.UTC))
+	router := &BookingRouter{}
+	num, _ := router.reconcileCalDav()
+	checkTestInt(t, 1, num)
+	num, _ = router.processCalDavSyncQueue()
+	checkTestInt(t, 1, num)
+
+	req := newHTTPRequest("GET", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetBookingResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	start, _ := backend.getEvent(id).DateTimeStart(time.UTC)
+	if !start.Equal(resBody.Enter) {
+		t.Fatalf("Expected event start %s, got %s", resBody.Enter, start)
+	}
+	list, _ := GetCalDAVLogRepository().GetAllByUser(user.ID, 10)
+	checkTestInt(t, 1, len(list))
+	checkTestInt(t, int(CalDAVLogActionEventReset), int(list[0].Action))
+}
+
+func TestBookingsCalDavReconcileCalendarChanged(t *testing.T) {
+	clearTestDB()
+	server, backend, user, id := createCalDavReconcileTestSetup(t, CalDAVReconcilePolicyCancel)
+	defer server.Close()
+	server2, backend2 := newCalDAVTestServer()
+	defer server2.Close()
+
+	// Events of the previous calendar are not considered deleted
+	payload := `{"value": "` + server2.URL + `/"}`
+	req := newHTTPRequest("PUT", "/preference/"+PreferenceCalDAVURL.Name, user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	booking, _ := GetBookingRepository().GetOne(id)
+	checkTestString(t, "", booking.CalDavID)
+	router := &BookingRouter{}
+	num, _ := router.reconcileCalDav()
+	checkTestInt(t, 0, num)
+
+	// Upcoming bookings are created in the new calendar
+	num, _ = router.processCalDavSyncQueue()
+	checkTestInt(t, 1, num)
+	if backend2.getEvent(id) == nil {
+		t.Fatal("Expected event to be created in the new calendar")
+	}
+	if backend.getEvent(id) == nil {
+		t.Fatal("Expected event in the previous calendar to be left untouched")
+	}
+	config, _ := getCalendarConfig(user.ID)
+	booking, _ = GetBookingRepository().GetOne(id)
+	checkTestString(t, id, booking.CalDavID)
+	checkTestString(t, config.Key(), booking.CalDavCalendar)
+
+	// Bookings with events in another calendar are skipped
+	GetBookingRepository().SetCalDavID(id, id, "other")
+	backend2.deleteEvent(id)
+	num, _ = router.reconcileCalDav()
+	checkTestInt(t, 0, num)
+	if booking, _ := GetBookingRepository().GetOne(id); booking == nil {
+		t.Fatal("Expected booking to exist")
+	}
+}
+
+func TestBookingsMSGraphSync(t *testing.T) {
+	clearTestDB()
+	server, backend := newMSGraphTestServer()
+	defer server.Close()
+	defer setMSGraphTestConfig(server)()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	connectMSGraphTestUser(user, backend)
+	id := createCalDavSyncTestBooking(t, user, s)
+
+	router := &BookingRouter{}
+	num, err := router.processCalDavSyncQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	booking, _ := GetBookingRepository().GetOne(id)
+	checkTestString(t, "AAMk-1", booking.CalDavID)
+	if backend.getEvent(booking.CalDavID) == nil {
+		t.Fatal("Expected event to be created")
+	}
+	checkTestString(t, msGraphTestCalendar, backend.getCalendar(booking.CalDavID))
+
+	// Deleted events are recreated with a new ID
+	backend.mu.Lock()
+	delete(backend.events, booking.CalDavID)
+	backend.mu.Unlock()
+	GetSettingsRepository().Set(org.ID, SettingCalDAVReconcilePolicy.Name, strconv.Itoa(CalDAVReconcilePolicyRecreate))
+	num, err = router.reconcileCalDav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	router.processCalDavSyncQueue()
+	booking, _ = GetBookingRepository().GetOne(id)
+	checkTestString(t, "AAMk-2", booking.CalDavID)
+
+	req := newHTTPRequest("DELETE", "/booking/"+id, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	num, _ = router.processCalDavSyncQueue()
+	checkTestInt(t, 1, num)
+	checkTestInt(t, 0, backend.getNumEvents())
+}
+
+// getBookingMailTestInvites returns the decoded ICS attachments of the last
+// mail sent.
+func getBookingMailTestInvites(t *testing.T) []string {
+	msg, err := mail.ReadMessage(strings.NewReader(SendMailMockContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	res := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		data, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		res = append(res, string(data))
+	}
+	return res
+}
+
+func TestBookingsMailCreated(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	SendMailMockContent = ""
+	id := createCalDavSyncTestBooking(t, user, s)
+
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "To: "+user.Email))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Subject: Ihre Buchung wurde bestätigt"))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Test 1, Test: 2030-09-02 08:00 - 2030-09-02 17:00"))
+	invites := getBookingMailTestInvites(t)
+	checkTestInt(t, 1, len(invites))
+	checkTestBool(t, true, strings.Contains(invites[0], "METHOD:REQUEST"))
+	checkTestBool(t, true, strings.Contains(invites[0], "UID:"+id))
+	checkTestBool(t, true, strings.Contains(invites[0], "mailto:"+user.Email))
+}
+
+func TestBookingsMailOnBehalf(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	SendMailMockContent = ""
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"userEmail\": \"" + user.Email + "\"}"
+	req := newHTTPRequest("POST", "/booking/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "To: "+user.Email))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, admin.Email+" hat einen Platz für Sie gebucht"))
+	checkTestInt(t, 1, len(getBookingMailTestInvites(t)))
+
+	// Opt out of mails on behalf
+	GetUserPreferencesRepository().Set(user.ID, PreferenceMailBookingOnBehalf.Name, "0")
+	SendMailMockContent = ""
+	payload = "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-03T08:00:00Z\", \"leave\": \"2030-09-03T17:00:00Z\", \"userEmail\": \"" + user.Email + "\"}"
+	req = newHTTPRequest("POST", "/booking/", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	checkTestString(t, "", SendMailMockContent)
+}
+
+func TestBookingsMailChangedAndCancelled(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	id := createCalDavSyncTestBooking(t, user, s)
+
+	SendMailMockContent = ""
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T09:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\"}"
+	req := newHTTPRequest("PUT", "/booking/"+id, user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Subject: Ihre Buchung wurde geändert"))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Test 1, Test: 2030-09-02 09:00 - 2030-09-02 17:00"))
+
+	SendMailMockContent = ""
+	req = newHTTPRequest("DELETE", "/booking/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Subject: Ihre Buchung wurde storniert"))
+	invites := getBookingMailTestInvites(t)
+	checkTestInt(t, 1, len(invites))
+	checkTestBool(t, true, strings.Contains(invites[0], "METHOD:CANCEL"))
+	checkTestBool(t, true, strings.Contains(invites[0], "STATUS:CANCELLED"))
+	checkTestBool(t, true, strings.Contains(invites[0], "UID:"+id))
+}
+
+func TestBookingsMailOptOut(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetUserPreferencesRepository().Set(user.ID, PreferenceMailBookingCreated.Name, "0")
+	SendMailMockContent = ""
+	createCalDavSyncTestBooking(t, user, s)
+	checkTestString(t, "", SendMailMockContent)
+}
+
+func TestBookingsMailRecurring(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	SendMailMockContent = ""
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T08:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\", \"recurrence\": \"FREQ=DAILY;COUNT=3\"}"
+	req := newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Test 1, Test: 2030-09-02 08:00 - 2030-09-02 17:00"))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Test 1, Test: 2030-09-04 08:00 - 2030-09-04 17:00"))
+	checkTestInt(t, 3, len(getBookingMailTestInvites(t)))
+}
+
+func TestBookingsReminder(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingReminderMinutes.Name, "60")
+	soon := createTestBooking(user, s, time.Now().Add(30*time.Minute), time.Now().Add(2*time.Hour))
+	later := createTestBooking(user, s, time.Now().Add(3*time.Hour), time.Now().Add(4*time.Hour))
+	createTestBooking(user, s, time.Now().Add(-30*time.Minute), time.Now().Add(time.Hour))
+
+	router := &BookingRouter{}
+	SendMailMockContent = ""
+	num, err := router.sendBookingReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "To: "+user.Email))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Subject: Erinnerung: Ihre bevorstehende Buchung"))
+	// Sent reminders can't be claimed again, not even after the lease expired
+	booking, _ := GetBookingRepository().GetOne(soon.ID)
+	claimed, _ := GetBookingReminderRepository().Claim(soon.ID, booking.Enter, time.Now().UTC().Add(2*BookingReminderLease), BookingReminderLease)
+	checkTestBool(t, false, claimed)
+
+	// Reminders are sent only once
+	SendMailMockContent = ""
+	num, _ = router.sendBookingReminders()
+	checkTestInt(t, 0, num)
+	checkTestString(t, "", SendMailMockContent)
+
+	// The user's preference overrides the organization's default
+	GetUserPreferencesRepository().Set(user.ID, PreferenceReminderMinutes.Name, "240")
+	num, _ = router.sendBookingReminders()
+	checkTestInt(t, 1, num)
+	booking, _ = GetBookingRepository().GetOne(later.ID)
+	claimed, _ = GetBookingReminderRepository().Claim(later.ID, booking.Enter, time.Now().UTC().Add(2*BookingReminderLease), BookingReminderLease)
+	checkTestBool(t, false, claimed)
+}
+
+func TestBookingsReminderOptOut(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+	createTestBooking(user, s, time.Now().Add(30*time.Minute), time.Now().Add(2*time.Hour))
+	createTestBooking(user2, s, time.Now().Add(30*time.Minute), time.Now().Add(2*time.Hour))
+
+	// Disabled by default
+	router := &BookingRouter{}
+	num, _ := router.sendBookingReminders()
+	checkTestInt(t, 0, num)
+
+	GetSettingsRepository().Set(org.ID, SettingReminderMinutes.Name, "60")
+	GetUserPreferencesRepository().Set(user.ID, PreferenceReminderMinutes.Name, "0")
+	GetUserPreferencesRepository().Set(user2.ID, PreferenceReminderMinutes.Name, strconv.Itoa(PreferenceReminderMinutesDefault))
+	SendMailMockContent = ""
+	num, _ = router.sendBookingReminders()
+	checkTestInt(t, 1, num)
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "To: "+user2.Email))
+}
+
+func TestBookingsReminderClaimed(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingReminderMinutes.Name, "60")
+	e := createTestBooking(user, s, time.Now().Add(30*time.Minute), time.Now().Add(2*time.Hour))
+	booking, _ := GetBookingRepository().GetOne(e.ID)
+
+	// Another instance is sending the reminder
+	now := time.Now().UTC()
+	claimed, err := GetBookingReminderRepository().Claim(e.ID, booking.Enter, now, BookingReminderLease)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestBool(t, true, claimed)
+	router := &BookingRouter{}
+	num, _ := router.sendBookingReminders()
+	checkTestInt(t, 0, num)
+
+	// The other instance failed, so the reminder is sent after the lease expired
+	GetDatabase().DB().Exec("UPDATE booking_reminders SET lease_until = $1", now.Add(-time.Minute))
+	num, _ = router.sendBookingReminders()
+	checkTestInt(t, 1, num)
+}
+
+func TestBookingsReminderMoved(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	GetSettingsRepository().Set(org.ID, SettingReminderMinutes.Name, "60")
+	e := createTestBooking(user, s, time.Now().Add(30*time.Minute), time.Now().Add(2*time.Hour))
+	router := &BookingRouter{}
+	num, _ := router.sendBookingReminders()
+	checkTestInt(t, 1, num)
+
+	// Moving the booking results in a new reminder
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	e.Enter = time.Now().Add(45 * time.Minute).In(tz)
+	GetBookingRepository().Update(e)
+	num, _ = router.sendBookingReminders()
+	checkTestInt(t, 1, num)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type BookingSeriesRepository struct {
+}
+
+type BookingSeries struct {
+	ID         string
+	UserID     string
+	SpaceID    string
+	Recurrence string
+	Enter      time.Time
+	Leave      time.Time
+}
+
+var bookingSeriesRepository *BookingSeriesRepository
+var bookingSeriesRepositoryOnce sync.Once
+
+func GetBookingSeriesRepository() *BookingSeriesRepository {
+	bookingSeriesRepositoryOnce.Do(func() {
+		bookingSeriesRepository = &BookingSeriesRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS booking_series (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"user_id uuid NOT NULL, " +
+			"space_id uuid NOT NULL, " +
+			"recurrence VARCHAR NOT NULL, " +
+			"enter_time TIMESTAMP NOT NULL, " +
+			"leave_time TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return bookingSeriesRepository
+}
+
+func (r *BookingSeriesRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+// Create inserts the series and its bookings in a single transaction. The
+// space is locked while the transaction runs, so no conflicting booking can be
+// created concurrently. Bookings overlapping existing ones are returned as
+// conflicts: if skipConflicts is false, nothing is created in this case,
+// otherwise only the conflicting bookings are left out. If no booking remains,
+// the series is not created either.
+func (r *BookingSeriesRepository) Create(e *BookingSeries, bookings []*Booking, skipConflicts bool) ([]*Booking, error) {
+	tx, err := GetDatabase().DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := GetBookingRepository().lockSpace(tx, e.SpaceID); err != nil {
+		return nil, err
+	}
+	conflicts := []*Booking{}
+	bookable := []*Booking{}
+	for _, booking := range bookings {
+		list, err := GetBookingRepository().getConflicts(tx, booking.SpaceID, booking.Enter, booking.Leave, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(list) > 0 {
+			conflicts = append(conflicts, booking)
+		} else {
+			bookable = append(bookable, booking)
+		}
+	}
+	if len(bookable) == 0 || (len(conflicts) > 0 && !skipConflicts) {
+		return conflicts, nil
+	}
+	var id string
+	err = tx.QueryRow("INSERT INTO booking_series "+
+		"(user_id, space_id, recurrence, enter_time, leave_time) "+
+		"VALUES ($1, $2, $3, $4, $5) "+
+		"RETURNING id",
+		e.UserID, e.SpaceID, e.Recurrence, e.Enter, e.Leave).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	for _, booking := range bookable {
+		booking.SeriesID = NullString(id)
+		if err := GetBookingRepository().create(tx, booking); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	e.ID = id
+	return conflicts, nil
+}
+
+func (r *BookingSeriesRepository) GetOne(id string) (*BookingSeries, error) {
+	e := &BookingSeries{}
+	err := GetDatabase().DB().QueryRow("SELECT id, user_id, space_id, recurrence, enter_time, leave_time "+
+		"FROM booking_series "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.UserID, &e.SpaceID, &e.Recurrence, &e.Enter, &e.Leave)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *BookingSeriesRepository) Update(e *BookingSeries) error {
+	_, err := GetDatabase().DB().Exec("UPDATE booking_series SET "+
+		"user_id = $1, "+
+		"space_id = $2, "+
+		"recurrence = $3, "+
+		"enter_time = $4, "+
+		"leave_time = $5 "+
+		"WHERE id = $6",
+		e.UserID, e.SpaceID, e.Recurrence, e.Enter, e.Leave, e.ID)
+	return err
+}
+
+func (r *BookingSeriesRepository) Delete(e *BookingSeries) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM booking_series WHERE id = $1", e.ID)
+	return err
+}
+
+// DeleteIfEmpty removes the series if no booking references it anymore.
+func (r *BookingSeriesRepository) DeleteIfEmpty(id string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM booking_series "+
+		"WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM bookings WHERE series_id = $1)", id)
+	return err
+}
+
+// DeleteOrphaned removes series whose bookings have all been deleted without
+// going through DeleteIfEmpty, i.e. together with their space or user.
+func (r *BookingSeriesRepository) DeleteOrphaned() error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM booking_series " +
+		"WHERE NOT EXISTS (SELECT 1 FROM bookings WHERE bookings.series_id = booking_series.id)")
+	return err
+}
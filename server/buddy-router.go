@@ -68,6 +68,7 @@ func (router *BuddyRouter) delete(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, GetRequestUser(r).OrganizationID, AuditActionDelete, AuditEntityBuddy, e.ID, map[string]string{"buddyId": e.BuddyID}, nil)
 	SendUpdated(w)
 }
 
@@ -92,6 +93,7 @@ func (router *BuddyRouter) create(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, buddyUser.OrganizationID, AuditActionCreate, AuditEntityBuddy, e.ID, nil, map[string]string{"buddyId": e.BuddyID})
 	SendCreated(w, e.ID)
 }
 
@@ -3,9 +3,11 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/emersion/go-ical"
@@ -16,28 +18,25 @@ import (
 
 type CalDAVClient struct {
 	url        string
+	username   string
+	password   string
 	httpClient webdav.HTTPClient
 	client     *caldav.Client
 	principal  string
 	homeSet    string
 }
 
-type CalDAVCalendar struct {
-	Path string
-	Name string
-}
-
-type CalDAVEvent struct {
-	ID       string
-	Title    string
-	Start    time.Time
-	End      time.Time
-	Location string
+func newCalDAVClient(url, username, password string) *CalDAVClient {
+	return &CalDAVClient{
+		url:      url,
+		username: username,
+		password: password,
+	}
 }
 
-func (c *CalDAVClient) Connect(url, username, password string) error {
-	httpClient := webdav.HTTPClientWithBasicAuth(http.DefaultClient, username, password)
-	caldavClient, err := caldav.NewClient(httpClient, url)
+func (c *CalDAVClient) Connect() error {
+	httpClient := webdav.HTTPClientWithBasicAuth(http.DefaultClient, c.username, c.password)
+	caldavClient, err := caldav.NewClient(httpClient, c.url)
 	if err != nil {
 		return err
 	}
@@ -49,7 +48,6 @@ func (c *CalDAVClient) Connect(url, username, password string) error {
 	if err != nil {
 		return err
 	}
-	c.url = url
 	c.client = caldavClient
 	c.httpClient = httpClient
 	c.principal = principal
@@ -57,22 +55,22 @@ func (c *CalDAVClient) Connect(url, username, password string) error {
 	return nil
 }
 
-func (c *CalDAVClient) ListCalendars() ([]*CalDAVCalendar, error) {
+func (c *CalDAVClient) ListCalendars() ([]*Calendar, error) {
 	calendars, err := c.client.FindCalendars(context.Background(), c.homeSet)
 	if err != nil {
 		return nil, err
 	}
-	res := make([]*CalDAVCalendar, 0)
+	res := make([]*Calendar, 0)
 	for _, calendar := range calendars {
-		res = append(res, &CalDAVCalendar{
-			Path: calendar.Path,
+		res = append(res, &Calendar{
+			ID:   calendar.Path,
 			Name: calendar.Name,
 		})
 	}
 	return res, nil
 }
 
-func (c *CalDAVClient) CreateEvent(calendarPath string, e *CalDAVEvent) error {
+func (c *CalDAVClient) CreateEvent(calendarPath string, e *CalendarEvent) error {
 	if e.ID == "" {
 		e.ID = uuid.New().String()
 	}
@@ -82,7 +80,7 @@ func (c *CalDAVClient) CreateEvent(calendarPath string, e *CalDAVEvent) error {
 	return err
 }
 
-func (c *CalDAVClient) DeleteEvent(calendarPath string, e *CalDAVEvent) error {
+func (c *CalDAVClient) DeleteEvent(calendarPath string, e *CalendarEvent) error {
 	cal := c.getCaldavEvent(e)
 
 	var buf bytes.Buffer
@@ -90,18 +88,12 @@ func (c *CalDAVClient) DeleteEvent(calendarPath string, e *CalDAVEvent) error {
 		return err
 	}
 
-	u, err := url.Parse(c.url)
+	eventURL, err := c.getEventURL(calendarPath, e.ID)
 	if err != nil {
 		return err
 	}
-	pathNew := url.URL{
-		Scheme: u.Scheme,
-		User:   u.User,
-		Host:   u.Host,
-		Path:   path.Join(calendarPath, e.ID+".ics"),
-	}
 
-	req, err := http.NewRequest(http.MethodDelete, pathNew.String(), &buf)
+	req, err := http.NewRequest(http.MethodDelete, eventURL, &buf)
 	if err != nil {
 		return err
 	}
@@ -113,23 +105,94 @@ func (c *CalDAVClient) DeleteEvent(calendarPath string, e *CalDAVEvent) error {
 	}
 	resp.Body.Close()
 
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return errors.New("CalDAV server returned status code " + strconv.Itoa(resp.StatusCode))
+	}
 	return nil
 }
 
-func (c *CalDAVClient) getCaldavEvent(e *CalDAVEvent) *ical.Calendar {
+func (c *CalDAVClient) GetEvent(calendarPath string, id string) (*CalendarEvent, error) {
+	eventURL, err := c.getEventURL(calendarPath, id)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, eventURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ical.MIMEType)
+
+	resp, err := c.httpClient.Do(req.WithContext(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, ErrCalendarEventNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New("CalDAV server returned status code " + strconv.Itoa(resp.StatusCode))
+	}
+	cal, err := ical.NewDecoder(resp.Body).Decode()
+	if err != nil {
+		return nil, err
+	}
+	events := cal.Events()
+	if len(events) == 0 {
+		return nil, ErrCalendarEventNotFound
+	}
+	event := events[0]
+	res := &CalendarEvent{
+		ID: id,
+	}
+	res.Title, _ = event.Props.Text(ical.PropSummary)
+	res.Location, _ = event.Props.Text(ical.PropLocation)
+	if res.Start, err = event.DateTimeStart(time.UTC); err != nil {
+		return nil, err
+	}
+	if res.End, err = event.DateTimeEnd(time.UTC); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *CalDAVClient) getEventURL(calendarPath string, id string) (string, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return "", err
+	}
+	eventURL := url.URL{
+		Scheme: u.Scheme,
+		User:   u.User,
+		Host:   u.Host,
+		Path:   path.Join(calendarPath, id+".ics"),
+	}
+	return eventURL.String(), nil
+}
+
+func (c *CalDAVClient) getCaldavEvent(e *CalendarEvent) *ical.Calendar {
+	cal := newICalCalendar()
+	cal.Children = append(cal.Children, newICalEvent(e).Component)
+	return cal
+}
+
+func newICalCalendar() *ical.Calendar {
 	cal := ical.NewCalendar()
 	cal.Props.SetText(ical.PropProductID, "-//seatsurfing.app//seatsurfing//EN")
 	cal.Props.SetText(ical.PropVersion, "2.0")
+	return cal
+}
 
+func newICalEvent(e *CalendarEvent) *ical.Event {
 	event := ical.NewEvent()
 	event.Props.SetText(ical.PropSummary, e.Title)
-	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
 	event.Props.SetDateTime(ical.PropDateTimeStart, e.Start)
 	event.Props.SetDateTime(ical.PropDateTimeEnd, e.End)
 	event.Props.SetText(ical.PropLocation, e.Location)
 	event.Props.Del(ical.PropDuration)
 	event.Props.SetText(ical.PropUID, e.ID)
-	cal.Children = append(cal.Children, event.Component)
-
-	return cal
+	return event
 }
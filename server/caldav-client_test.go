@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+const (
+	caldavTestPrincipal = "/test/"
+	caldavTestHomeSet   = "/test/calendars/"
+	caldavTestCalendar  = "/test/calendars/default/"
+)
+
+// caldavTestBackend is an in-memory CalDAV backend with a single calendar.
+type caldavTestBackend struct {
+	mu      sync.Mutex
+	objects map[string]*ical.Calendar
+}
+
+func newCalDAVTestServer() (*httptest.Server, *caldavTestBackend) {
+	backend := &caldavTestBackend{objects: map[string]*ical.Calendar{}}
+	server := httptest.NewServer(&caldav.Handler{Backend: backend})
+	return server, backend
+}
+
+func (b *caldavTestBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return caldavTestPrincipal, nil
+}
+
+func (b *caldavTestBackend) CalendarHomeSetPath(ctx context.Context) (string, error) {
+	return caldavTestHomeSet, nil
+}
+
+func (b *caldavTestBackend) CreateCalendar(ctx context.Context, calendar *caldav.Calendar) error {
+	return webdav.NewHTTPError(http.StatusForbidden, nil)
+}
+
+func (b *caldavTestBackend) ListCalendars(ctx context.Context) ([]caldav.Calendar, error) {
+	return []caldav.Calendar{{Path: caldavTestCalendar, Name: "Default", SupportedComponentSet: []string{ical.CompEvent}}}, nil
+}
+
+func (b *caldavTestBackend) GetCalendar(ctx context.Context, path string) (*caldav.Calendar, error) {
+	if path != caldavTestCalendar {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, nil)
+	}
+	return &caldav.Calendar{Path: caldavTestCalendar, Name: "Default", SupportedComponentSet: []string{ical.CompEvent}}, nil
+}
+
+func (b *caldavTestBackend) GetCalendarObject(ctx context.Context, path string, req *caldav.CalendarCompRequest) (*caldav.CalendarObject, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cal, ok := b.objects[path]
+	if !ok {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, nil)
+	}
+	return &caldav.CalendarObject{Path: path, ModTime: time.Now(), Data: cal}, nil
+}
+
+func (b *caldavTestBackend) ListCalendarObjects(ctx context.Context, path string, req *caldav.CalendarCompRequest) ([]caldav.CalendarObject, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := []caldav.CalendarObject{}
+	for objectPath, cal := range b.objects {
+		if strings.HasPrefix(objectPath, path) {
+			res = append(res, caldav.CalendarObject{Path: objectPath, ModTime: time.Now(), Data: cal})
+		}
+	}
+	return res, nil
+}
+
+func (b *caldavTestBackend) QueryCalendarObjects(ctx context.Context, path string, query *caldav.CalendarQuery) ([]caldav.CalendarObject, error) {
+	return b.ListCalendarObjects(ctx, path, &query.CompRequest)
+}
+
+func (b *caldavTestBackend) PutCalendarObject(ctx context.Context, path string, calendar *ical.Calendar, opts *caldav.PutCalendarObjectOptions) (*caldav.CalendarObject, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[path] = calendar
+	return &caldav.CalendarObject{Path: path, ModTime: time.Now(), Data: calendar}, nil
+}
+
+func (b *caldavTestBackend) DeleteCalendarObject(ctx context.Context, path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.objects[path]; !ok {
+		return webdav.NewHTTPError(http.StatusNotFound, nil)
+	}
+	delete(b.objects, path)
+	return nil
+}
+
+func (b *caldavTestBackend) getEvent(id string) *ical.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cal, ok := b.objects[caldavTestCalendar+id+".ics"]
+	if !ok || len(cal.Events()) == 0 {
+		return nil
+	}
+	return &cal.Events()[0]
+}
+
+func (b *caldavTestBackend) setEventTimes(id string, start, end time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	event := b.objects[caldavTestCalendar+id+".ics"].Events()[0]
+	event.Props.SetDateTime(ical.PropDateTimeStart, start)
+	event.Props.SetDateTime(ical.PropDateTimeEnd, end)
+}
+
+func (b *caldavTestBackend) deleteEvent(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, caldavTestCalendar+id+".ics")
+}
+
+func TestCalDAVClientEvents(t *testing.T) {
+	server, backend := newCalDAVTestServer()
+	defer server.Close()
+
+	client := newCalDAVClient(server.URL+"/", "test", "test")
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	calendars, err := client.ListCalendars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, len(calendars))
+	checkTestString(t, caldavTestCalendar, calendars[0].ID)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	event := &CalendarEvent{
+		Title:    "Seat Reservation: Desk 1, Office",
+		Location: "Desk 1, Office",
+		Start:    time.Date(2030, 9, 2, 8, 0, 0, 0, tz),
+		End:      time.Date(2030, 9, 2, 17, 0, 0, 0, tz),
+	}
+	if err := client.CreateEvent(caldavTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+	if backend.getEvent(event.ID) == nil {
+		t.Fatal("Expected event to be created")
+	}
+
+	res, err := client.GetEvent(caldavTestCalendar, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, event.Title, res.Title)
+	checkTestString(t, event.Location, res.Location)
+	if !res.Start.Equal(event.Start) || !res.End.Equal(event.End) {
+		t.Fatalf("Expected %s - %s, got %s - %s", event.Start, event.End, res.Start, res.End)
+	}
+
+	if err := client.DeleteEvent(caldavTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetEvent(caldavTestCalendar, event.ID); err != ErrCalendarEventNotFound {
+		t.Fatalf("Expected ErrCalendarEventNotFound, got %v", err)
+	}
+	// Deleting a non-existing event is not an error
+	if err := client.DeleteEvent(caldavTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type CalDAVLogRepository struct {
+}
+
+type CalDAVLogAction int
+
+const (
+	CalDAVLogActionEventRecreated   CalDAVLogAction = 1
+	CalDAVLogActionEventReset       CalDAVLogAction = 2
+	CalDAVLogActionBookingCancelled CalDAVLogAction = 3
+	CalDAVLogActionBookingUpdated   CalDAVLogAction = 4
+)
+
+// CalDAVLogEntry records a change made while reconciling a user's bookings
+// with the user's CalDAV calendar.
+type CalDAVLogEntry struct {
+	ID        string
+	UserID    string
+	BookingID string
+	Action    CalDAVLogAction
+	Details   string
+	Created   time.Time
+}
+
+var calDAVLogRepository *CalDAVLogRepository
+var calDAVLogRepositoryOnce sync.Once
+
+func GetCalDAVLogRepository() *CalDAVLogRepository {
+	calDAVLogRepositoryOnce.Do(func() {
+		calDAVLogRepository = &CalDAVLogRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS caldav_logs (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"user_id uuid NOT NULL, " +
+			"booking_id uuid NOT NULL, " +
+			"action INTEGER NOT NULL, " +
+			"details VARCHAR NOT NULL DEFAULT '', " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_caldav_logs_user_id ON caldav_logs(user_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return calDAVLogRepository
+}
+
+func (r *CalDAVLogRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *CalDAVLogRepository) Create(e *CalDAVLogEntry) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO caldav_logs "+
+		"(user_id, booking_id, action, details, created) "+
+		"VALUES ($1, $2, $3, $4, $5) "+
+		"RETURNING id",
+		e.UserID, e.BookingID, e.Action, e.Details, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// GetAllByUser returns the latest maxResults entries of a user, newest first.
+func (r *CalDAVLogRepository) GetAllByUser(userID string, maxResults int) ([]*CalDAVLogEntry, error) {
+	var result []*CalDAVLogEntry
+	rows, err := GetDatabase().DB().Query("SELECT id, user_id, booking_id, action, details, created "+
+		"FROM caldav_logs "+
+		"WHERE user_id = $1 "+
+		"ORDER BY created DESC "+
+		"LIMIT $2", userID, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &CalDAVLogEntry{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.BookingID, &e.Action, &e.Details, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *CalDAVLogRepository) DeleteExpired() error {
+	created := time.Now().UTC().Add(-time.Hour * 24 * 90)
+	_, err := GetDatabase().DB().Exec("DELETE FROM caldav_logs WHERE created < $1", created)
+	return err
+}
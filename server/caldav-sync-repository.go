@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type CalDAVSyncRepository struct {
+}
+
+type CalDAVSyncOperation int
+
+const (
+	CalDAVSyncMaxAttempts    = 10
+	CalDAVSyncBatchSize      = 50
+	CalDAVSyncLease          = time.Minute * 5
+	CalDAVSyncInitialBackoff = time.Second * 30
+	CalDAVSyncMaxBackoff     = time.Hour * 6
+)
+
+const (
+	CalDAVSyncStatusNone    = "none"
+	CalDAVSyncStatusPending = "pending"
+	CalDAVSyncStatusFailed  = "failed"
+	CalDAVSyncStatusSynced  = "synced"
+)
+
+const (
+	// Creates the event if the booking has no CalDAV ID yet, updates it otherwise
+	CalDAVSyncOperationUpdate CalDAVSyncOperation = 1
+	CalDAVSyncOperationDelete CalDAVSyncOperation = 2
+)
+
+// CalDAVSyncEntry is a pending CalDAV operation in the outbox. There's at
+// most one entry per booking, newer operations replace older ones.
+type CalDAVSyncEntry struct {
+	ID          string
+	UserID      string
+	BookingID   string
+	Operation   CalDAVSyncOperation
+	CalDavID    string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+}
+
+var calDAVSyncRepository *CalDAVSyncRepository
+var calDAVSyncRepositoryOnce sync.Once
+
+func GetCalDAVSyncRepository() *CalDAVSyncRepository {
+	calDAVSyncRepositoryOnce.Do(func() {
+		calDAVSyncRepository = &CalDAVSyncRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS caldav_sync_queue (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"user_id uuid NOT NULL, " +
+			"booking_id uuid NOT NULL, " +
+			"operation INTEGER NOT NULL, " +
+			"caldav_id VARCHAR NOT NULL DEFAULT '', " +
+			"attempts INTEGER NOT NULL DEFAULT 0, " +
+			"next_attempt TIMESTAMP NOT NULL, " +
+			"last_error VARCHAR NOT NULL DEFAULT '', " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_caldav_sync_queue_booking_id ON caldav_sync_queue(booking_id)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_caldav_sync_queue_next_attempt ON caldav_sync_queue(next_attempt)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return calDAVSyncRepository
+}
+
+func (r *CalDAVSyncRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+// Enqueue adds the entry to the outbox, replacing any pending entry for the
+// same booking.
+func (r *CalDAVSyncRepository) Enqueue(e *CalDAVSyncEntry) error {
+	tx, err := GetDatabase().DB().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM caldav_sync_queue WHERE booking_id = $1", e.BookingID); err != nil {
+		return err
+	}
+	var id string
+	err = tx.QueryRow("INSERT INTO caldav_sync_queue "+
+		"(user_id, booking_id, operation, caldav_id, attempts, next_attempt, last_error, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) "+
+		"RETURNING id",
+		e.UserID, e.BookingID, e.Operation, e.CalDavID, e.Attempts, e.NextAttempt, e.LastError, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *CalDAVSyncRepository) GetOneByBooking(bookingID string) (*CalDAVSyncEntry, error) {
+	e := &CalDAVSyncEntry{}
+	err := GetDatabase().DB().QueryRow("SELECT id, user_id, booking_id, operation, caldav_id, attempts, next_attempt, last_error, created "+
+		"FROM caldav_sync_queue "+
+		"WHERE booking_id = $1",
+		bookingID).Scan(&e.ID, &e.UserID, &e.BookingID, &e.Operation, &e.CalDavID, &e.Attempts, &e.NextAttempt, &e.LastError, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GetAllByUser returns the pending entries of a user, mapped by booking ID.
+func (r *CalDAVSyncRepository) GetAllByUser(userID string) (map[string]*CalDAVSyncEntry, error) {
+	result := make(map[string]*CalDAVSyncEntry)
+	rows, err := GetDatabase().DB().Query("SELECT id, user_id, booking_id, operation, caldav_id, attempts, next_attempt, last_error, created "+
+		"FROM caldav_sync_queue "+
+		"WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &CalDAVSyncEntry{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.BookingID, &e.Operation, &e.CalDavID, &e.Attempts, &e.NextAttempt, &e.LastError, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		result[e.BookingID] = e
+	}
+	return result, nil
+}
+
+// Claim returns up to limit entries due for processing and defers their next
+// attempt by lease, so that other workers don't pick them up concurrently.
+func (r *CalDAVSyncRepository) Claim(now time.Time, lease time.Duration, maxAttempts, limit int) ([]*CalDAVSyncEntry, error) {
+	var result []*CalDAVSyncEntry
+	rows, err := GetDatabase().DB().Query("UPDATE caldav_sync_queue SET "+
+		"next_attempt = $2 "+
+		"WHERE id IN ("+
+		"SELECT id FROM caldav_sync_queue "+
+		"WHERE next_attempt <= $1 AND attempts < $3 "+
+		"ORDER BY created "+
+		"LIMIT $4 "+
+		"FOR UPDATE SKIP LOCKED"+
+		") "+
+		"RETURNING id, user_id, booking_id, operation, caldav_id, attempts, next_attempt, last_error, created",
+		now, now.Add(lease), maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &CalDAVSyncEntry{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.BookingID, &e.Operation, &e.CalDavID, &e.Attempts, &e.NextAttempt, &e.LastError, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *CalDAVSyncRepository) Update(e *CalDAVSyncEntry) error {
+	_, err := GetDatabase().DB().Exec("UPDATE caldav_sync_queue SET "+
+		"attempts = $1, "+
+		"next_attempt = $2, "+
+		"last_error = $3 "+
+		"WHERE id = $4",
+		e.Attempts, e.NextAttempt, e.LastError, e.ID)
+	return err
+}
+
+func (r *CalDAVSyncRepository) Delete(e *CalDAVSyncEntry) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *CalDAVSyncRepository) DeleteAllByUser(userID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE user_id = $1", userID)
+	return err
+}
+
+// DeleteExpired removes entries which have failed permanently a while ago.
+func (r *CalDAVSyncRepository) DeleteExpired() error {
+	created := time.Now().UTC().Add(-time.Hour * 24 * 30)
+	_, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE attempts >= $1 AND created < $2", CalDAVSyncMaxAttempts, created)
+	return err
+}
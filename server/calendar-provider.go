@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrCalendarEventNotFound = errors.New("calendar event not found")
+
+type CalendarProviderType int
+
+const (
+	CalendarProviderCalDAV  CalendarProviderType = 1
+	CalendarProviderMSGraph CalendarProviderType = 2
+)
+
+type Calendar struct {
+	ID   string
+	Name string
+}
+
+type CalendarEvent struct {
+	ID       string
+	Title    string
+	Start    time.Time
+	End      time.Time
+	Location string
+}
+
+// CalendarProvider is an external calendar bookings are synchronised with.
+// Calendars are identified by their path for CalDAV and by their ID for
+// Microsoft Graph.
+type CalendarProvider interface {
+	Connect() error
+	ListCalendars() ([]*Calendar, error)
+	// CreateEvent creates the event, or updates it if an event with e.ID
+	// exists. It sets e.ID to the ID assigned by the provider.
+	CreateEvent(calendarID string, e *CalendarEvent) error
+	// GetEvent returns ErrCalendarEventNotFound if the event doesn't exist (anymore).
+	GetEvent(calendarID string, id string) (*CalendarEvent, error)
+	DeleteEvent(calendarID string, e *CalendarEvent) error
+}
+
+// CalendarConfig holds a user's calendar integration settings.
+type CalendarConfig struct {
+	UserID     string
+	Provider   CalendarProviderType
+	URL        string
+	Username   string
+	Password   string
+	CalendarID string
+}
+
+// getCalendarConfig returns the calendar integration settings of a user or an
+// error if the user hasn't configured the selected provider completely.
+func getCalendarConfig(userID string) (*CalendarConfig, error) {
+	prefs, err := GetUserPreferencesRepository().GetAll(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := &CalendarConfig{
+		UserID:   userID,
+		Provider: CalendarProviderCalDAV,
+	}
+	var caldavPath, msGraphCalendar string
+	for _, pref := range prefs {
+		if pref.Name == PreferenceCalendarProvider.Name && len(pref.Value) > 0 {
+			provider, _ := strconv.Atoi(pref.Value)
+			res.Provider = CalendarProviderType(provider)
+		} else if pref.Name == PreferenceCalDAVURL.Name {
+			if _, err := url.ParseRequestURI(pref.Value); err == nil {
+				res.URL = pref.Value
+			}
+		} else if pref.Name == PreferenceCalDAVUser.Name && len(pref.Value) > 0 {
+			res.Username = pref.Value
+		} else if pref.Name == PreferenceCalDAVPass.Name && len(pref.Value) > 0 {
+			decryptedPassword := decryptString(pref.Value)
+			if decryptedPassword != "" {
+				res.Password = decryptedPassword
+			}
+		} else if pref.Name == PreferenceCalDAVPath.Name && len(pref.Value) > 0 {
+			caldavPath = pref.Value
+		} else if pref.Name == PreferenceMSGraphCalendar.Name && len(pref.Value) > 0 {
+			msGraphCalendar = pref.Value
+		}
+	}
+	switch res.Provider {
+	case CalendarProviderCalDAV:
+		res.CalendarID = caldavPath
+		if res.URL == "" || res.Username == "" || res.Password == "" || res.CalendarID == "" {
+			return nil, errors.New("caldav not configured completely")
+		}
+	case CalendarProviderMSGraph:
+		// An empty calendar ID refers to the user's default calendar
+		res.CalendarID = msGraphCalendar
+		if !isMSGraphEnabled() {
+			return nil, errors.New("microsoft graph integration not enabled")
+		}
+		if _, err := GetCalendarTokenRepository().Get(userID, CalendarProviderMSGraph); err != nil {
+			return nil, errors.New("microsoft graph not connected")
+		}
+	default:
+		return nil, errors.New("unknown calendar provider " + strconv.Itoa(int(res.Provider)))
+	}
+	return res, nil
+}
+
+// Key identifies the calendar events are stored in. Event IDs are only valid
+// within the calendar they have been created in.
+func (c *CalendarConfig) Key() string {
+	return strconv.Itoa(int(c.Provider)) + "|" + c.URL + "|" + c.Username + "|" + c.CalendarID
+}
+
+// getCalendarKey returns the key of the user's calendar or an empty string if
+// the user hasn't configured calendar integration.
+func getCalendarKey(userID string) string {
+	config, err := getCalendarConfig(userID)
+	if err != nil {
+		return ""
+	}
+	return config.Key()
+}
+
+// newCalendarProvider returns an unconnected provider for the config.
+func newCalendarProvider(config *CalendarConfig) CalendarProvider {
+	if config.Provider == CalendarProviderMSGraph {
+		return newMSGraphClient(config.UserID)
+	}
+	return newCalDAVClient(config.URL, config.Username, config.Password)
+}
+
+// getCalendarProvider returns a connected provider for the config.
+func getCalendarProvider(config *CalendarConfig) (CalendarProvider, error) {
+	provider := newCalendarProvider(config)
+	if err := provider.Connect(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type CalendarTokenRepository struct {
+}
+
+// CalendarToken is a user's OAuth token for a calendar provider. Access and
+// refresh tokens are stored encrypted.
+type CalendarToken struct {
+	UserID       string
+	Provider     CalendarProviderType
+	AccessToken  string
+	RefreshToken string
+	TokenType    string
+	Expiry       time.Time
+}
+
+var calendarTokenRepository *CalendarTokenRepository
+var calendarTokenRepositoryOnce sync.Once
+
+func GetCalendarTokenRepository() *CalendarTokenRepository {
+	calendarTokenRepositoryOnce.Do(func() {
+		calendarTokenRepository = &CalendarTokenRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS calendar_tokens (" +
+			"user_id uuid NOT NULL, " +
+			"provider INTEGER NOT NULL, " +
+			"access_token VARCHAR NOT NULL, " +
+			"refresh_token VARCHAR NOT NULL DEFAULT '', " +
+			"token_type VARCHAR NOT NULL DEFAULT '', " +
+			"expiry TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (user_id, provider))")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return calendarTokenRepository
+}
+
+func (r *CalendarTokenRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *CalendarTokenRepository) Set(e *CalendarToken) error {
+	_, err := GetDatabase().DB().Exec("INSERT INTO calendar_tokens "+
+		"(user_id, provider, access_token, refresh_token, token_type, expiry) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) "+
+		"ON CONFLICT (user_id, provider) DO UPDATE SET "+
+		"access_token = $3, refresh_token = $4, token_type = $5, expiry = $6",
+		e.UserID, e.Provider, encryptString(e.AccessToken), encryptString(e.RefreshToken), e.TokenType, e.Expiry.UTC())
+	return err
+}
+
+func (r *CalendarTokenRepository) Get(userID string, provider CalendarProviderType) (*CalendarToken, error) {
+	e := &CalendarToken{}
+	err := GetDatabase().DB().QueryRow("SELECT user_id, provider, access_token, refresh_token, token_type, expiry "+
+		"FROM calendar_tokens "+
+		"WHERE user_id = $1 AND provider = $2",
+		userID, provider).Scan(&e.UserID, &e.Provider, &e.AccessToken, &e.RefreshToken, &e.TokenType, &e.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	e.AccessToken = decryptString(e.AccessToken)
+	e.RefreshToken = decryptString(e.RefreshToken)
+	e.Expiry = e.Expiry.UTC()
+	return e, nil
+}
+
+func (r *CalendarTokenRepository) Delete(userID string, provider CalendarProviderType) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM calendar_tokens WHERE user_id = $1 AND provider = $2", userID, provider)
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type ChatMessageRepository struct {
+}
+
+const (
+	ChatMessageMaxAttempts = 5
+	ChatMessageBatchSize   = 50
+	ChatMessageLease       = time.Minute * 5
+)
+
+// ChatMessage is a message waiting to be posted to a location's notification
+// target. Messages are deleted once they have been posted.
+type ChatMessage struct {
+	ID          string
+	TargetID    string
+	Text        string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Created     time.Time
+}
+
+var chatMessageRepository *ChatMessageRepository
+var chatMessageRepositoryOnce sync.Once
+
+func GetChatMessageRepository() *ChatMessageRepository {
+	chatMessageRepositoryOnce.Do(func() {
+		chatMessageRepository = &ChatMessageRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS chat_messages (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"target_id uuid NOT NULL, " +
+			"text TEXT NOT NULL, " +
+			"attempts INTEGER NOT NULL DEFAULT 0, " +
+			"next_attempt TIMESTAMP NOT NULL, " +
+			"last_error VARCHAR NOT NULL DEFAULT '', " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_chat_messages_next_attempt ON chat_messages(next_attempt)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return chatMessageRepository
+}
+
+func (r *ChatMessageRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *ChatMessageRepository) Create(e *ChatMessage) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO chat_messages "+
+		"(target_id, text, attempts, next_attempt, last_error, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) "+
+		"RETURNING id",
+		e.TargetID, e.Text, e.Attempts, e.NextAttempt, e.LastError, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+// Claim returns up to limit messages due for posting and defers their next
+// attempt by lease, so that other workers don't pick them up concurrently.
+func (r *ChatMessageRepository) Claim(now time.Time, lease time.Duration, maxAttempts, limit int) ([]*ChatMessage, error) {
+	var result []*ChatMessage
+	rows, err := GetDatabase().DB().Query("UPDATE chat_messages SET "+
+		"next_attempt = $2 "+
+		"WHERE id IN ("+
+		"SELECT id FROM chat_messages "+
+		"WHERE next_attempt <= $1 AND attempts < $3 "+
+		"ORDER BY created "+
+		"LIMIT $4 "+
+		"FOR UPDATE SKIP LOCKED"+
+		") "+
+		"RETURNING id, target_id, text, attempts, next_attempt, last_error, created",
+		now, now.Add(lease), maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &ChatMessage{}
+		err = rows.Scan(&e.ID, &e.TargetID, &e.Text, &e.Attempts, &e.NextAttempt, &e.LastError, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *ChatMessageRepository) Update(e *ChatMessage) error {
+	_, err := GetDatabase().DB().Exec("UPDATE chat_messages SET "+
+		"attempts = $1, "+
+		"next_attempt = $2, "+
+		"last_error = $3 "+
+		"WHERE id = $4",
+		e.Attempts, e.NextAttempt, e.LastError, e.ID)
+	return err
+}
+
+func (r *ChatMessageRepository) Delete(e *ChatMessage) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM chat_messages WHERE id = $1", e.ID)
+	return err
+}
+
+// DeleteExpired removes messages which have failed permanently a while ago.
+func (r *ChatMessageRepository) DeleteExpired() error {
+	created := time.Now().UTC().Add(-time.Hour * 24 * 7)
+	_, err := GetDatabase().DB().Exec("DELETE FROM chat_messages WHERE attempts >= $1 AND created < $2", ChatMessageMaxAttempts, created)
+	return err
+}
@@ -43,6 +43,13 @@ type Config struct {
 	LoginProtectionSlidingWindowSeconds int
 	LoginProtectionBanMinutes           int
 	CryptKey                            string
+	MSGraphClientID                     string
+	MSGraphClientSecret                 string
+	MSGraphTenant                       string
+	MSGraphLoginURL                     string
+	MSGraphAPIURL                       string
+	TrustedProxies                      []string
+	WebhookAllowPrivateNetworks         bool
 }
 
 var _configInstance *Config
@@ -99,6 +106,13 @@ func (c *Config) ReadConfig() {
 	if c.CryptKey == "" || len(c.CryptKey) != 32 {
 		log.Println("Warning: No valid CRYPT_KEY set. Set it to a 32 bytes long string in order to use features such as CalDAV integration.")
 	}
+	c.MSGraphClientID = c.getEnv("MS_GRAPH_CLIENT_ID", "")
+	c.MSGraphClientSecret = c.getEnv("MS_GRAPH_CLIENT_SECRET", "")
+	c.MSGraphTenant = c.getEnv("MS_GRAPH_TENANT", "common")
+	c.MSGraphLoginURL = strings.TrimSuffix(c.getEnv("MS_GRAPH_LOGIN_URL", "https://login.microsoftonline.com"), "/")
+	c.MSGraphAPIURL = strings.TrimSuffix(c.getEnv("MS_GRAPH_API_URL", "https://graph.microsoft.com/v1.0"), "/")
+	c.TrustedProxies = c.getEnvList("TRUSTED_PROXIES")
+	c.WebhookAllowPrivateNetworks = (c.getEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "0") == "1")
 }
 
 func (c *Config) isValidLanguageCode(isoLanguageCode string) bool {
@@ -125,6 +139,16 @@ func (c *Config) getEnv(key, defaultValue string) string {
 	return res
 }
 
+func (c *Config) getEnvList(key string) []string {
+	res := []string{}
+	for _, s := range strings.Split(c.getEnv(key, ""), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
 func (c *Config) getEnvInt(key string, defaultValue int) int {
 	val, err := strconv.Atoi(c.getEnv(key, strconv.Itoa(defaultValue)))
 	if err != nil {
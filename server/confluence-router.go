@@ -83,6 +83,7 @@ func (router *ConfluenceRouter) serverLogin(w http.ResponseWriter, r *http.Reque
 			Role:           UserRoleUser,
 		}
 		GetUserRepository().Create(user)
+		fireUserWebhookEvent(user, WebhookEventUserCreated)
 	}
 	payload := &AuthStateLoginPayload{
 		LoginType: "",
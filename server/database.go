@@ -53,6 +53,14 @@ func (db *Database) Close() {
 	db.Connection.Close()
 }
 
+// DBExecutor is satisfied by both *sql.DB and *sql.Tx, so repository
+// functions can be run inside or outside of a transaction.
+type DBExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 type NullString string
 type NullTime *time.Time
 
@@ -8,7 +8,7 @@ import (
 )
 
 func RunDBSchemaUpdates() {
-	targetVersion := 18
+	targetVersion := 26
 	log.Printf("Initializing database with schema version %d...\n", targetVersion)
 	curVersion, err := GetSettingsRepository().GetGlobalInt(SettingDatabaseVersion.Name)
 	if err != nil {
@@ -16,9 +16,27 @@ func RunDBSchemaUpdates() {
 	}
 	repositories := []Repository{
 		GetAuthProviderRepository(),
+		GetAuthProviderRuleRepository(),
 		GetAuthStateRepository(),
 		GetAuthAttemptRepository(),
 		GetBookingRepository(),
+		GetBookingSeriesRepository(),
+		GetBookingReminderRepository(),
+		GetWaitlistRepository(),
+		GetICalFeedRepository(),
+		GetCalDAVSyncRepository(),
+		GetCalDAVLogRepository(),
+		GetCalendarTokenRepository(),
+		GetAPITokenRepository(),
+		GetTOTPRepository(),
+		GetRoleAssignmentRepository(),
+		GetGroupRepository(),
+		GetSCIMTokenRepository(),
+		GetWebhookRepository(),
+		GetWebhookDeliveryRepository(),
+		GetLocationNotificationRepository(),
+		GetChatMessageRepository(),
+		GetAuditLogRepository(),
 		GetLocationRepository(),
 		GetOrganizationRepository(),
 		GetSpaceRepository(),
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+type GroupRepository struct {
+}
+
+// Group is a set of users within an organization. Spaces and locations can be
+// restricted to groups, in which case only members of at least one of the
+// groups can book them.
+type Group struct {
+	ID             string
+	OrganizationID string
+	Name           string
+}
+
+type GroupMember struct {
+	UserID    string
+	Email     string
+	FromClaim bool
+}
+
+type GroupRestrictionEntityType int
+
+const (
+	GroupRestrictionEntityTypeLocation GroupRestrictionEntityType = 1
+	GroupRestrictionEntityTypeSpace    GroupRestrictionEntityType = 2
+)
+
+var groupRepository *GroupRepository
+var groupRepositoryOnce sync.Once
+
+func GetGroupRepository() *GroupRepository {
+	groupRepositoryOnce.Do(func() {
+		groupRepository = &GroupRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS user_groups (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"organization_id uuid NOT NULL, " +
+			"name VARCHAR NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_user_groups_organization_id_name ON user_groups(organization_id, name)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS user_groups_members (" +
+			"group_id uuid NOT NULL, " +
+			"user_id uuid NOT NULL, " +
+			"from_claim BOOLEAN NOT NULL DEFAULT FALSE, " +
+			"PRIMARY KEY (group_id, user_id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_user_groups_members_user_id ON user_groups_members(user_id)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS user_groups_restrictions (" +
+			"group_id uuid NOT NULL, " +
+			"entity_id uuid NOT NULL, " +
+			"entity_type INTEGER NOT NULL, " +
+			"PRIMARY KEY (group_id, entity_id, entity_type))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_user_groups_restrictions_entity_id_entity_type ON user_groups_restrictions(entity_id, entity_type)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return groupRepository
+}
+
+func (r *GroupRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *GroupRepository) Create(e *Group) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO user_groups "+
+		"(organization_id, name) "+
+		"VALUES ($1, $2) "+
+		"RETURNING id",
+		e.OrganizationID, e.Name).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *GroupRepository) GetOne(id string) (*Group, error) {
+	e := &Group{}
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, name "+
+		"FROM user_groups "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.OrganizationID, &e.Name)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *GroupRepository) GetAll(organizationID string) ([]*Group, error) {
+	var result []*Group
+	rows, err := GetDatabase().DB().Query("SELECT id, organization_id, name "+
+		"FROM user_groups "+
+		"WHERE organization_id = $1 "+
+		"ORDER BY name", organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &Group{}
+		err = rows.Scan(&e.ID, &e.OrganizationID, &e.Name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *GroupRepository) Update(e *Group) error {
+	_, err := GetDatabase().DB().Exec("UPDATE user_groups SET "+
+		"name = $1 "+
+		"WHERE id = $2",
+		e.Name, e.ID)
+	return err
+}
+
+func (r *GroupRepository) Delete(e *Group) error {
+	if err := GetAuthProviderRuleRepository().DeleteAllByGroup(e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members WHERE group_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_restrictions WHERE group_id = $1", e.ID); err != nil {
+		return err
+	}
+	_, err := GetDatabase().DB().Exec("DELETE FROM user_groups WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *GroupRepository) DeleteAll(organizationID string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members WHERE "+
+		"user_groups_members.group_id IN (SELECT user_groups.id FROM user_groups WHERE user_groups.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_restrictions WHERE "+
+		"user_groups_restrictions.group_id IN (SELECT user_groups.id FROM user_groups WHERE user_groups.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	_, err := GetDatabase().DB().Exec("DELETE FROM user_groups WHERE organization_id = $1", organizationID)
+	return err
+}
+
+// AddMember adds the user to the group. Manually added members are kept when
+// the user's group claim is synchronized.
+func (r *GroupRepository) AddMember(e *Group, userID string) error {
+	_, err := GetDatabase().DB().Exec("INSERT INTO user_groups_members "+
+		"(group_id, user_id, from_claim) "+
+		"VALUES ($1, $2, FALSE) "+
+		"ON CONFLICT (group_id, user_id) DO UPDATE SET from_claim = FALSE",
+		e.ID, userID)
+	return err
+}
+
+func (r *GroupRepository) RemoveMember(e *Group, userID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members "+
+		"WHERE group_id = $1 AND user_id = $2",
+		e.ID, userID)
+	return err
+}
+
+func (r *GroupRepository) GetMembers(e *Group) ([]*GroupMember, error) {
+	var result []*GroupMember
+	rows, err := GetDatabase().DB().Query("SELECT users.id, users.email, user_groups_members.from_claim "+
+		"FROM user_groups_members "+
+		"INNER JOIN users ON users.id = user_groups_members.user_id "+
+		"WHERE user_groups_members.group_id = $1 "+
+		"ORDER BY users.email", e.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m := &GroupMember{}
+		err = rows.Scan(&m.UserID, &m.Email, &m.FromClaim)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+func (r *GroupRepository) IsMember(e *Group, userID string) bool {
+	var res int
+	err := GetDatabase().DB().QueryRow("SELECT COUNT(*) FROM user_groups_members "+
+		"WHERE group_id = $1 AND user_id = $2",
+		e.ID, userID).Scan(&res)
+	return err == nil && res > 0
+}
+
+// SyncClaimMemberships replaces the user's memberships which have been
+// created from an identity provider's group claim. Groups are matched by name,
+// unknown names are ignored.
+func (r *GroupRepository) SyncClaimMemberships(user *User, groupNames []string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members "+
+		"WHERE user_id = $1 AND from_claim = TRUE",
+		user.ID); err != nil {
+		return err
+	}
+	if len(groupNames) == 0 {
+		return nil
+	}
+	_, err := GetDatabase().DB().Exec("INSERT INTO user_groups_members "+
+		"(group_id, user_id, from_claim) "+
+		"SELECT user_groups.id, $1::uuid, TRUE FROM user_groups "+
+		"WHERE user_groups.organization_id = $2 AND user_groups.name = ANY($3) "+
+		"ON CONFLICT (group_id, user_id) DO NOTHING",
+		user.ID, user.OrganizationID, pq.Array(groupNames))
+	return err
+}
+
+// SetRestrictions replaces the groups the entity is restricted to. An empty
+// list removes the restriction.
+func (r *GroupRepository) SetRestrictions(entityID string, entityType GroupRestrictionEntityType, groupIDs []string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_restrictions "+
+		"WHERE entity_id = $1 AND entity_type = $2",
+		entityID, entityType); err != nil {
+		return err
+	}
+	for _, groupID := range groupIDs {
+		if _, err := GetDatabase().DB().Exec("INSERT INTO user_groups_restrictions "+
+			"(group_id, entity_id, entity_type) "+
+			"VALUES ($1, $2, $3) "+
+			"ON CONFLICT (group_id, entity_id, entity_type) DO NOTHING",
+			groupID, entityID, entityType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *GroupRepository) GetRestrictions(entityID string, entityType GroupRestrictionEntityType) ([]string, error) {
+	result := []string{}
+	rows, err := GetDatabase().DB().Query("SELECT group_id "+
+		"FROM user_groups_restrictions "+
+		"WHERE entity_id = $1 AND entity_type = $2",
+		entityID, entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var groupID string
+		err = rows.Scan(&groupID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, groupID)
+	}
+	return result, nil
+}
+
+// getRestrictedCondition returns an SQL condition which is true if the entity
+// is restricted to groups the user is not a member of.
+func (r *GroupRepository) getRestrictedCondition(entityID string, entityType GroupRestrictionEntityType, userID string) string {
+	return "(EXISTS(SELECT 1 FROM user_groups_restrictions WHERE user_groups_restrictions.entity_id = " + entityID + " AND user_groups_restrictions.entity_type = " + strconv.Itoa(int(entityType)) + ") " +
+		"AND NOT EXISTS(SELECT 1 FROM user_groups_restrictions " +
+		"INNER JOIN user_groups_members ON user_groups_members.group_id = user_groups_restrictions.group_id " +
+		"WHERE user_groups_restrictions.entity_id = " + entityID + " AND user_groups_restrictions.entity_type = " + strconv.Itoa(int(entityType)) + " AND user_groups_members.user_id = " + userID + "))"
+}
+
+// GetRestrictedSpaceIDs returns the IDs of the location's spaces the user is
+// not allowed to book. If the location itself is restricted to groups the user
+// is not a member of, all of its spaces are returned.
+func (r *GroupRepository) GetRestrictedSpaceIDs(locationID string, userID string) (map[string]bool, error) {
+	res := make(map[string]bool)
+	rows, err := GetDatabase().DB().Query("SELECT spaces.id "+
+		"FROM spaces "+
+		"WHERE spaces.location_id = $1 AND ("+
+		r.getRestrictedCondition("spaces.location_id", GroupRestrictionEntityTypeLocation, "$2")+" OR "+
+		r.getRestrictedCondition("spaces.id", GroupRestrictionEntityTypeSpace, "$2")+
+		")", locationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var spaceID string
+		err = rows.Scan(&spaceID)
+		if err != nil {
+			return nil, err
+		}
+		res[spaceID] = true
+	}
+	return res, nil
+}
+
+// IsLocationRestricted returns true if the location is restricted to groups
+// the user is not a member of.
+func (r *GroupRepository) IsLocationRestricted(locationID string, userID string) bool {
+	var res bool
+	err := GetDatabase().DB().QueryRow("SELECT "+
+		r.getRestrictedCondition("$1::uuid", GroupRestrictionEntityTypeLocation, "$2::uuid"),
+		locationID, userID).Scan(&res)
+	return err != nil || res
+}
+
+// IsSpaceRestricted returns true if the space or its location is restricted
+// to groups the user is not a member of.
+func (r *GroupRepository) IsSpaceRestricted(space *Space, userID string) bool {
+	var res bool
+	err := GetDatabase().DB().QueryRow("SELECT "+
+		r.getRestrictedCondition("$1::uuid", GroupRestrictionEntityTypeLocation, "$3::uuid")+" OR "+
+		r.getRestrictedCondition("$2::uuid", GroupRestrictionEntityTypeSpace, "$3::uuid"),
+		space.LocationID, space.ID, userID).Scan(&res)
+	return err != nil || res
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type GroupRouter struct {
+}
+
+type CreateGroupRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type GetGroupResponse struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organizationId"`
+	CreateGroupRequest
+}
+
+type GetGroupMemberResponse struct {
+	UserID    string `json:"userId"`
+	Email     string `json:"email"`
+	FromClaim bool   `json:"fromClaim"`
+}
+
+type SetGroupRestrictionRequest struct {
+	GroupIDs []string `json:"groupIds"`
+}
+
+type GetGroupRestrictionResponse struct {
+	SetGroupRestrictionRequest
+}
+
+func (router *GroupRouter) setupRoutes(s *mux.Router) {
+	RequirePermission(s.HandleFunc("/{id}/member/", router.getMembers).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/member/{userId}", router.addMember).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/member/{userId}", router.removeMember).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionLocationsAdmin)
+}
+
+func (router *GroupRouter) getOne(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroup(w, r)
+	if e == nil {
+		return
+	}
+	SendJSON(w, router.copyToRestModel(e))
+}
+
+func (router *GroupRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	list, err := GetGroupRepository().GetAll(user.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetGroupResponse{}
+	for _, e := range list {
+		m := router.copyToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *GroupRouter) update(w http.ResponseWriter, r *http.Request) {
+	var m CreateGroupRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	e := router.getGroup(w, r)
+	if e == nil {
+		return
+	}
+	eNew := router.copyFromRestModel(&m)
+	eNew.ID = e.ID
+	eNew.OrganizationID = e.OrganizationID
+	if err := GetGroupRepository().Update(eNew); err != nil {
+		log.Println(err)
+		SendAleadyExists(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityGroup, e.ID, router.copyToRestModel(e), router.copyToRestModel(eNew))
+	SendUpdated(w)
+}
+
+func (router *GroupRouter) delete(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroup(w, r)
+	if e == nil {
+		return
+	}
+	if err := GetGroupRepository().Delete(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityGroup, e.ID, router.copyToRestModel(e), nil)
+	SendUpdated(w)
+}
+
+func (router *GroupRouter) create(w http.ResponseWriter, r *http.Request) {
+	var m CreateGroupRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	e := router.copyFromRestModel(&m)
+	e.OrganizationID = user.OrganizationID
+	if err := GetGroupRepository().Create(e); err != nil {
+		log.Println(err)
+		SendAleadyExists(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityGroup, e.ID, nil, router.copyToRestModel(e))
+	SendCreated(w, e.ID)
+}
+
+func (router *GroupRouter) getMembers(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroup(w, r)
+	if e == nil {
+		return
+	}
+	list, err := GetGroupRepository().GetMembers(e)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetGroupMemberResponse{}
+	for _, member := range list {
+		m := &GetGroupMemberResponse{
+			UserID:    member.UserID,
+			Email:     member.Email,
+			FromClaim: member.FromClaim,
+		}
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *GroupRouter) addMember(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroup(w, r)
+	if e == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	user, err := GetUserRepository().GetOne(vars["userId"])
+	if err != nil || user.OrganizationID != e.OrganizationID {
+		SendNotFound(w)
+		return
+	}
+	if err := GetGroupRepository().AddMember(e, user.ID); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityGroupMember, e.ID, nil, &GetGroupMemberResponse{UserID: user.ID, Email: user.Email})
+	SendUpdated(w)
+}
+
+func (router *GroupRouter) removeMember(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroup(w, r)
+	if e == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	user, err := GetUserRepository().GetOne(vars["userId"])
+	if err != nil || !GetGroupRepository().IsMember(e, user.ID) {
+		SendNotFound(w)
+		return
+	}
+	if err := GetGroupRepository().RemoveMember(e, user.ID); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityGroupMember, e.ID, &GetGroupMemberResponse{UserID: user.ID, Email: user.Email}, nil)
+	SendUpdated(w)
+}
+
+// getGroup returns the group specified in the request if the requesting user
+// can access its organization. Otherwise, it sends an error response and
+// returns nil.
+func (router *GroupRouter) getGroup(w http.ResponseWriter, r *http.Request) *Group {
+	vars := mux.Vars(r)
+	e, err := GetGroupRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	if !CanAccessOrg(GetRequestUser(r), e.OrganizationID) {
+		SendForbidden(w)
+		return nil
+	}
+	return e
+}
+
+func (router *GroupRouter) copyFromRestModel(m *CreateGroupRequest) *Group {
+	e := &Group{}
+	e.Name = m.Name
+	return e
+}
+
+func (router *GroupRouter) copyToRestModel(e *Group) *GetGroupResponse {
+	m := &GetGroupResponse{}
+	m.ID = e.ID
+	m.OrganizationID = e.OrganizationID
+	m.Name = e.Name
+	return m
+}
+
+// setGroupRestrictions handles requests restricting a location or space to
+// groups. All groups must belong to the organization.
+func setGroupRestrictions(w http.ResponseWriter, r *http.Request, organizationID string, entityID string, entityType GroupRestrictionEntityType) {
+	var m SetGroupRestrictionRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	for _, groupID := range m.GroupIDs {
+		group, err := GetGroupRepository().GetOne(groupID)
+		if err != nil || group.OrganizationID != organizationID {
+			SendBadRequest(w)
+			return
+		}
+	}
+	before, err := GetGroupRepository().GetRestrictions(entityID, entityType)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if err := GetGroupRepository().SetRestrictions(entityID, entityType, m.GroupIDs); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, organizationID, AuditActionUpdate, AuditEntityGroupRestriction, entityID, &SetGroupRestrictionRequest{GroupIDs: before}, &m)
+	SendUpdated(w)
+}
+
+func sendGroupRestrictions(w http.ResponseWriter, entityID string, entityType GroupRestrictionEntityType) {
+	list, err := GetGroupRepository().GetRestrictions(entityID, entityType)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := &GetGroupRestrictionResponse{}
+	res.GroupIDs = list
+	SendJSON(w, res)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func createGroupTestGroup(t *testing.T, admin *User, name string) *Group {
+	payload := `{"name": "` + name + `"}`
+	req := newHTTPRequest("POST", "/group/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	group, _ := GetGroupRepository().GetOne(res.Header().Get("X-Object-Id"))
+	return group
+}
+
+func TestGroupsForbidden(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	group := createGroupTestGroup(t, admin, "Group 1")
+
+	payload := `{"name": "Group 2"}`
+	req := newHTTPRequest("POST", "/group/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("POST", "/group/"+group.ID+"/member/"+user.ID, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Groups of other orgs can't be accessed
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	req = newHTTPRequest("GET", "/group/"+group.ID, admin2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Users of other orgs can't be added
+	user2 := createTestUserInOrg(org2)
+	req = newHTTPRequest("POST", "/group/"+group.ID+"/member/"+user2.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestGroupsCRUD(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	group := createGroupTestGroup(t, admin, "Group 1")
+
+	// Names must be unique
+	payload := `{"name": "Group 1"}`
+	req := newHTTPRequest("POST", "/group/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	payload = `{"name": "Group 1.1"}`
+	req = newHTTPRequest("PUT", "/group/"+group.ID, admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/group/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetGroupResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, group.ID, resBody[0].ID)
+	checkTestString(t, "Group 1.1", resBody[0].Name)
+
+	req = newHTTPRequest("DELETE", "/group/"+group.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/group/"+group.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestGroupsMembers(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	group := createGroupTestGroup(t, admin, "Group 1")
+
+	req := newHTTPRequest("POST", "/group/"+group.ID+"/member/"+user.ID, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/group/"+group.ID+"/member/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetGroupMemberResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, user.ID, resBody[0].UserID)
+	checkTestBool(t, false, resBody[0].FromClaim)
+
+	req = newHTTPRequest("DELETE", "/group/"+group.ID+"/member/"+user.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("DELETE", "/group/"+group.ID+"/member/"+user.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestGroupsClaimMemberships(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	group1 := createGroupTestGroup(t, admin, "Group 1")
+	group2 := createGroupTestGroup(t, admin, "Group 2")
+	group3 := createGroupTestGroup(t, admin, "Group 3")
+	GetGroupRepository().AddMember(group3, user.ID)
+
+	GetGroupRepository().SyncClaimMemberships(user, []string{"Group 1", "Group 2", "Unknown"})
+	checkTestBool(t, true, GetGroupRepository().IsMember(group1, user.ID))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group2, user.ID))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group3, user.ID))
+
+	// Memberships are removed if the claim changes, manual ones are kept
+	GetGroupRepository().SyncClaimMemberships(user, []string{"Group 2"})
+	checkTestBool(t, false, GetGroupRepository().IsMember(group1, user.ID))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group2, user.ID))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group3, user.ID))
+
+	GetGroupRepository().SyncClaimMemberships(user, []string{})
+	checkTestBool(t, false, GetGroupRepository().IsMember(group2, user.ID))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group3, user.ID))
+}
+
+func TestGroupsSpaceRestriction(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	GetSettingsRepository().Set(org.ID, SettingMaxDaysInAdvance.Name, "5000")
+	admin := createTestUserOrgAdmin(org)
+	member := createTestUserInOrg(org)
+	user := createTestUserInOrg(org)
+	group := createGroupTestGroup(t, admin, "Group 1")
+	GetGroupRepository().AddMember(group, member.ID)
+	location := &Location{Name: "Test", OrganizationID: org.ID}
+	GetLocationRepository().Create(location)
+	space1 := &Space{Name: "Test 1", LocationID: location.ID}
+	GetSpaceRepository().Create(space1)
+	space2 := &Space{Name: "Test 2", LocationID: location.ID}
+	GetSpaceRepository().Create(space2)
+
+	payload := `{"groupIds": ["` + group.ID + `"]}`
+	req := newHTTPRequest("PUT", "/location/"+location.ID+"/space/"+space1.ID+"/group/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	req = newHTTPRequest("PUT", "/location/"+location.ID+"/space/"+space1.ID+"/group/", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/location/"+location.ID+"/space/"+space1.ID+"/group/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var restrictions *GetGroupRestrictionResponse
+	json.Unmarshal(res.Body.Bytes(), &restrictions)
+	checkTestInt(t, 1, len(restrictions.GroupIDs))
+	checkTestString(t, group.ID, restrictions.GroupIDs[0])
+
+	// Availability
+	payload = `{"enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00"}`
+	req = newHTTPRequest("POST", "/location/"+location.ID+"/space/availability", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetSpaceAvailabilityResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 2, len(resBody))
+	checkTestBool(t, false, resBody[0].Available)
+	checkTestBool(t, true, resBody[0].Restricted)
+	checkTestBool(t, true, resBody[1].Available)
+	checkTestBool(t, false, resBody[1].Restricted)
+
+	req = newHTTPRequest("POST", "/location/"+location.ID+"/space/availability", member.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestBool(t, true, resBody[0].Available)
+	checkTestBool(t, false, resBody[0].Restricted)
+
+	// Bookings
+	payload = `{"spaceId": "` + space1.ID + `", "enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00"}`
+	req = newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingSpaceRestricted), res.Header().Get("X-Error-Code"))
+	req = newHTTPRequest("POST", "/booking/", member.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	payload = `{"spaceId": "` + space2.ID + `", "enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00"}`
+	req = newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+}
+
+func TestGroupsLocationRestriction(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	GetSettingsRepository().Set(org.ID, SettingMaxDaysInAdvance.Name, "5000")
+	admin := createTestUserOrgAdmin(org)
+	member := createTestUserInOrg(org)
+	user := createTestUserInOrg(org)
+	group := createGroupTestGroup(t, admin, "Group 1")
+	GetGroupRepository().AddMember(group, member.ID)
+	location := &Location{Name: "Test", OrganizationID: org.ID}
+	GetLocationRepository().Create(location)
+	space := &Space{Name: "Test 1", LocationID: location.ID}
+	GetSpaceRepository().Create(space)
+
+	// Groups of other orgs can't be used
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	group2 := createGroupTestGroup(t, admin2, "Group 2")
+	payload := `{"groupIds": ["` + group2.ID + `"]}`
+	req := newHTTPRequest("PUT", "/location/"+location.ID+"/group/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	payload = `{"groupIds": ["` + group.ID + `"]}`
+	req = newHTTPRequest("PUT", "/location/"+location.ID+"/group/", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	payload = `{"spaceId": "` + space.ID + `", "enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00"}`
+	req = newHTTPRequest("POST", "/booking/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingSpaceRestricted), res.Header().Get("X-Error-Code"))
+	req = newHTTPRequest("POST", "/booking/", member.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	// Removing the restriction
+	payload = `{"groupIds": []}`
+	req = newHTTPRequest("PUT", "/location/"+location.ID+"/group/", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, false, GetGroupRepository().IsLocationRestricted(location.ID, user.ID))
+}
+
+func TestGroupsRestrictionBookForUser(t *testing.T) {
+	clearTestDB()
+	org, location, space := createTestOrgWithSpace()
+	admin := createTestUserOrgAdmin(org)
+	member := createTestUserInOrg(org)
+	user := createTestUserInOrg(org)
+	group := createGroupTestGroup(t, admin, "Group 1")
+	GetGroupRepository().AddMember(group, member.ID)
+	GetGroupRepository().AddMember(group, admin.ID)
+	payload := `{"groupIds": ["` + group.ID + `"]}`
+	req := newHTTPRequest("PUT", "/location/"+location.ID+"/space/"+space.ID+"/group/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// The restriction applies to the user booked for, not the admin
+	payload = `{"spaceId": "` + space.ID + `", "enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00", "userEmail": "` + user.Email + `"}`
+	req = newHTTPRequest("POST", "/booking/", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingSpaceRestricted), res.Header().Get("X-Error-Code"))
+
+	payload = `{"spaceId": "` + space.ID + `", "enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00", "userEmail": "` + member.Email + `"}`
+	req = newHTTPRequest("POST", "/booking/", admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	// Restricted spaces can't be waitlisted either
+	payload = `{"spaceId": "` + space.ID + `", "enter": "2030-09-01T08:30:00+02:00", "leave": "2030-09-01T17:00:00+02:00"}`
+	req = newHTTPRequest("POST", "/waitlist/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, strconv.Itoa(ResponseCodeBookingSpaceRestricted), res.Header().Get("X-Error-Code"))
+}
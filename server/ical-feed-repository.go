@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+type ICalFeedRepository struct {
+}
+
+// ICalFeed is a secret subscription URL token for either the bookings of a
+// single user or the bookings of a whole location.
+type ICalFeed struct {
+	ID             string
+	OrganizationID string
+	UserID         NullString
+	LocationID     NullString
+	Token          string
+	Created        time.Time
+}
+
+var iCalFeedRepository *ICalFeedRepository
+var iCalFeedRepositoryOnce sync.Once
+
+func GetICalFeedRepository() *ICalFeedRepository {
+	iCalFeedRepositoryOnce.Do(func() {
+		iCalFeedRepository = &ICalFeedRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS ical_feeds (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"organization_id uuid NOT NULL, " +
+			"user_id uuid NULL, " +
+			"location_id uuid NULL, " +
+			"token VARCHAR NOT NULL, " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_ical_feeds_token ON ical_feeds(token)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_ical_feeds_user_id ON ical_feeds(user_id)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_ical_feeds_location_id ON ical_feeds(location_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return iCalFeedRepository
+}
+
+func (r *ICalFeedRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *ICalFeedRepository) Create(e *ICalFeed) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO ical_feeds "+
+		"(organization_id, user_id, location_id, token, created) "+
+		"VALUES ($1, $2, $3, $4, $5) "+
+		"RETURNING id",
+		e.OrganizationID, CheckNullString(e.UserID), CheckNullString(e.LocationID), e.Token, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *ICalFeedRepository) GetOneByToken(token string) (*ICalFeed, error) {
+	e := &ICalFeed{}
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, COALESCE(user_id::text, ''), COALESCE(location_id::text, ''), token, created "+
+		"FROM ical_feeds "+
+		"WHERE token = $1",
+		token).Scan(&e.ID, &e.OrganizationID, &e.UserID, &e.LocationID, &e.Token, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *ICalFeedRepository) GetOneByUser(userID string) (*ICalFeed, error) {
+	e := &ICalFeed{}
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, COALESCE(user_id::text, ''), COALESCE(location_id::text, ''), token, created "+
+		"FROM ical_feeds "+
+		"WHERE user_id = $1",
+		userID).Scan(&e.ID, &e.OrganizationID, &e.UserID, &e.LocationID, &e.Token, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *ICalFeedRepository) GetOneByLocation(locationID string) (*ICalFeed, error) {
+	e := &ICalFeed{}
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, COALESCE(user_id::text, ''), COALESCE(location_id::text, ''), token, created "+
+		"FROM ical_feeds "+
+		"WHERE location_id = $1",
+		locationID).Scan(&e.ID, &e.OrganizationID, &e.UserID, &e.LocationID, &e.Token, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *ICalFeedRepository) Update(e *ICalFeed) error {
+	_, err := GetDatabase().DB().Exec("UPDATE ical_feeds SET "+
+		"token = $1, "+
+		"created = $2 "+
+		"WHERE id = $3",
+		e.Token, e.Created, e.ID)
+	return err
+}
+
+func (r *ICalFeedRepository) Delete(e *ICalFeed) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *ICalFeedRepository) DeleteOfUser(u *User) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE user_id = $1", u.ID)
+	return err
+}
+
+// getICalFeedToken returns a new random, URL safe feed token.
+func getICalFeedToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
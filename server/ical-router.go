@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/gorilla/mux"
+)
+
+type ICalRouter struct {
+}
+
+type GetICalFeedResponse struct {
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+}
+
+func (router *ICalRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/feed/{token}", router.getFeed).Methods("GET")
+	s.HandleFunc("/user", router.getUserFeed).Methods("GET")
+	s.HandleFunc("/user/rotate", router.rotateUserFeed).Methods("POST")
+	RequirePermission(s.HandleFunc("/location/{id}", router.getLocationFeed).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/location/{id}/rotate", router.rotateLocationFeed).Methods("POST"), PermissionOrgAdmin)
+}
+
+func (router *ICalRouter) getFeed(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	feed, err := GetICalFeedRepository().GetOneByToken(vars["token"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	var list []*BookingDetails
+	if feed.UserID != "" {
+		list, err = GetBookingRepository().GetAllByUser(string(feed.UserID), time.Now().UTC())
+	} else {
+		list, err = GetBookingRepository().GetAllByLocation(string(feed.LocationID), time.Now().UTC())
+	}
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	cal := newICalCalendar()
+	for _, e := range list {
+		event := router.getICalEvent(e, feed.LocationID != "")
+		if event == nil {
+			continue
+		}
+		cal.Children = append(cal.Children, newICalEvent(event).Component)
+	}
+	if len(cal.Children) == 0 {
+		// A calendar must contain at least one component
+		cal.Children = append(cal.Children, router.getUTCTimezone())
+	}
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.Header().Set("Content-Type", ical.MIMEType+"; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+func (router *ICalRouter) getUserFeed(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	feed, err := GetICalFeedRepository().GetOneByUser(user.ID)
+	if err != nil {
+		feed = &ICalFeed{
+			OrganizationID: user.OrganizationID,
+			UserID:         NullString(user.ID),
+		}
+		if err := router.createFeed(feed); err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+	}
+	SendJSON(w, router.copyToRestModel(feed))
+}
+
+func (router *ICalRouter) rotateUserFeed(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	feed, err := GetICalFeedRepository().GetOneByUser(user.ID)
+	if err != nil {
+		feed = &ICalFeed{
+			OrganizationID: user.OrganizationID,
+			UserID:         NullString(user.ID),
+		}
+	}
+	if err := router.createFeed(feed); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, user.OrganizationID, AuditActionRotate, AuditEntityICalFeed, feed.ID, nil, nil)
+	SendJSON(w, router.copyToRestModel(feed))
+}
+
+func (router *ICalRouter) getLocationFeed(w http.ResponseWriter, r *http.Request) {
+	location := router.getAdminLocation(w, r)
+	if location == nil {
+		return
+	}
+	feed, err := GetICalFeedRepository().GetOneByLocation(location.ID)
+	if err != nil {
+		feed = &ICalFeed{
+			OrganizationID: location.OrganizationID,
+			LocationID:     NullString(location.ID),
+		}
+		if err := router.createFeed(feed); err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+	}
+	SendJSON(w, router.copyToRestModel(feed))
+}
+
+func (router *ICalRouter) rotateLocationFeed(w http.ResponseWriter, r *http.Request) {
+	location := router.getAdminLocation(w, r)
+	if location == nil {
+		return
+	}
+	feed, err := GetICalFeedRepository().GetOneByLocation(location.ID)
+	if err != nil {
+		feed = &ICalFeed{
+			OrganizationID: location.OrganizationID,
+			LocationID:     NullString(location.ID),
+		}
+	}
+	if err := router.createFeed(feed); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionRotate, AuditEntityICalFeed, feed.ID, nil, nil)
+	SendJSON(w, router.copyToRestModel(feed))
+}
+
+func (router *ICalRouter) getAdminLocation(w http.ResponseWriter, r *http.Request) *Location {
+	vars := mux.Vars(r)
+	location, err := GetLocationRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	if !CanAccessOrg(GetRequestUser(r), location.OrganizationID) {
+		SendForbidden(w)
+		return nil
+	}
+	return location
+}
+
+// createFeed assigns a new token to the feed, creating it if it doesn't exist
+// yet. Any previously issued URL stops working.
+func (router *ICalRouter) createFeed(feed *ICalFeed) error {
+	token, err := getICalFeedToken()
+	if err != nil {
+		return err
+	}
+	feed.Token = token
+	feed.Created = time.Now().UTC()
+	if feed.ID == "" {
+		return GetICalFeedRepository().Create(feed)
+	}
+	return GetICalFeedRepository().Update(feed)
+}
+
+func (router *ICalRouter) getICalEvent(e *BookingDetails, includeUser bool) *CalendarEvent {
+	enter, err := attachTimezoneInformation(e.Enter, &e.Space.Location)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	leave, err := attachTimezoneInformation(e.Leave, &e.Space.Location)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	title := "Seat Reservation: " + e.Space.Name + ", " + e.Space.Location.Name
+	if includeUser {
+		title = e.Space.Name + ": " + e.UserEmail
+	}
+	return &CalendarEvent{
+		ID:       e.ID,
+		Title:    title,
+		Location: e.Space.Name + ", " + e.Space.Location.Name,
+		Start:    enter.UTC(),
+		End:      leave.UTC(),
+	}
+}
+
+func (router *ICalRouter) getUTCTimezone() *ical.Component {
+	standard := ical.NewComponent(ical.CompTimezoneStandard)
+	standard.Props.SetDateTime(ical.PropDateTimeStart, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+	for _, name := range []string{ical.PropTimezoneOffsetFrom, ical.PropTimezoneOffsetTo} {
+		prop := ical.NewProp(name)
+		prop.Value = "+0000"
+		standard.Props.Set(prop)
+	}
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, "UTC")
+	tz.Children = append(tz.Children, standard)
+	return tz
+}
+
+func (router *ICalRouter) getFeedURL(feed *ICalFeed) string {
+	return GetConfig().PublicURL + "ical/feed/" + feed.Token
+}
+
+func (router *ICalRouter) copyToRestModel(feed *ICalFeed) *GetICalFeedResponse {
+	return &GetICalFeedResponse{
+		URL:     router.getFeedURL(feed),
+		Created: feed.Created,
+	}
+}
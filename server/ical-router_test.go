@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+func getICalFeedTestPath(t *testing.T, feedURL string) string {
+	path := strings.TrimPrefix(feedURL, strings.TrimSuffix(GetConfig().PublicURL, "/"))
+	if path == feedURL {
+		t.Fatalf("Unexpected feed URL: %s", feedURL)
+	}
+	return path
+}
+
+func TestICalUserFeed(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+	e1 := createTestBooking(user, s, time.Now().Add(24*time.Hour), time.Now().Add(26*time.Hour))
+	createTestBooking(user, s, time.Now().Add(-26*time.Hour), time.Now().Add(-24*time.Hour))
+	createTestBooking(user2, s, time.Now().Add(48*time.Hour), time.Now().Add(50*time.Hour))
+
+	req := newHTTPRequest("GET", "/ical/user", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetICalFeedResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	path := getICalFeedTestPath(t, resBody.URL)
+
+	// Same URL on repeated calls
+	req = newHTTPRequest("GET", "/ical/user", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody2 *GetICalFeedResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody2)
+	checkTestString(t, resBody.URL, resBody2.URL)
+
+	req = newHTTPRequest("GET", path, "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), ical.MIMEType) {
+		t.Fatalf("Unexpected content type: %s", res.Header().Get("Content-Type"))
+	}
+	cal, err := ical.NewDecoder(res.Body).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := cal.Events()
+	checkTestInt(t, 1, len(events))
+	uid, _ := events[0].Props.Text(ical.PropUID)
+	checkTestString(t, e1.ID, uid)
+	start, _ := events[0].DateTimeStart(time.UTC)
+	if start.Sub(e1.Enter).Abs() > time.Second {
+		t.Fatalf("Expected start %s, got %s", e1.Enter, start)
+	}
+}
+
+func TestICalUserFeedRotate(t *testing.T) {
+	clearTestDB()
+	org, _, _ := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("GET", "/ical/user", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetICalFeedResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	oldPath := getICalFeedTestPath(t, resBody.URL)
+
+	req = newHTTPRequest("POST", "/ical/user/rotate", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody2 *GetICalFeedResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody2)
+	newPath := getICalFeedTestPath(t, resBody2.URL)
+	if oldPath == newPath {
+		t.Fatal("Expected feed URL to change")
+	}
+
+	req = newHTTPRequest("GET", oldPath, "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	req = newHTTPRequest("GET", newPath, "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
+
+func TestICalLocationFeed(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	user2 := createTestUserInOrg(org)
+	createTestBooking(user, s, time.Now().Add(24*time.Hour), time.Now().Add(26*time.Hour))
+	createTestBooking(user2, s, time.Now().Add(48*time.Hour), time.Now().Add(50*time.Hour))
+
+	req := newHTTPRequest("GET", "/ical/location/"+s.LocationID, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/ical/location/"+s.LocationID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetICalFeedResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+
+	req = newHTTPRequest("GET", getICalFeedTestPath(t, resBody.URL), "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	cal, err := ical.NewDecoder(res.Body).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 2, len(cal.Events()))
+}
+
+func TestICalFeedInvalidToken(t *testing.T) {
+	clearTestDB()
+	req := newHTTPRequest("GET", "/ical/feed/invalid", "", nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func createLocationAdminTestLocation(t *testing.T, admin *User, name string) *Location {
+	payload := `{"name": "` + name + `"}`
+	req := newHTTPRequest("POST", "/location/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	location, _ := GetLocationRepository().GetOne(res.Header().Get("X-Object-Id"))
+	return location
+}
+
+func TestLocationAdminsForbidden(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	spaceAdmin := createTestUserInOrgWithName(org, "spaceadmin@test.com", UserRoleSpaceAdmin)
+	user := createTestUserInOrg(org)
+	location := createLocationAdminTestLocation(t, admin, "Location 1")
+
+	req := newHTTPRequest("POST", "/location/"+location.ID+"/admin/"+user.ID, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("POST", "/location/"+location.ID+"/admin/"+user.ID, spaceAdmin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Users of other orgs can't be assigned
+	org2 := createTestOrg("test2.com")
+	user2 := createTestUserInOrg(org2)
+	req = newHTTPRequest("POST", "/location/"+location.ID+"/admin/"+user2.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestLocationAdminsCRUD(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	location := createLocationAdminTestLocation(t, admin, "Location 1")
+
+	req := newHTTPRequest("POST", "/location/"+location.ID+"/admin/"+user.ID, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/location/"+location.ID+"/admin/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetLocationAdminResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, user.ID, resBody[0].UserID)
+	checkTestString(t, user.Email, resBody[0].Email)
+
+	req = newHTTPRequest("GET", "/user/me", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var me *GetUserResponse
+	json.Unmarshal(res.Body.Bytes(), &me)
+	checkTestInt(t, 1, len(me.SpaceAdminLocationIDs))
+	checkTestString(t, location.ID, me.SpaceAdminLocationIDs[0])
+
+	req = newHTTPRequest("DELETE", "/location/"+location.ID+"/admin/"+user.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/location/"+location.ID+"/admin/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 0, len(resBody))
+
+	req = newHTTPRequest("DELETE", "/location/"+location.ID+"/admin/"+user.ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestLocationAdminsScope(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	location1 := createLocationAdminTestLocation(t, admin, "Location 1")
+	location2 := createLocationAdminTestLocation(t, admin, "Location 2")
+	GetRoleAssignmentRepository().Create(&RoleAssignment{UserID: user.ID, LocationID: location1.ID, Role: UserRoleSpaceAdmin})
+
+	// Spaces
+	payload := `{"name": "H234", "x": 50, "y": 100, "width": 200, "height": 300, "rotation": 90}`
+	req := newHTTPRequest("POST", "/location/"+location1.ID+"/space/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	req = newHTTPRequest("POST", "/location/"+location2.ID+"/space/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Spaces of other locations can't be modified using an assigned location
+	space2 := &Space{LocationID: location2.ID, Name: "H235"}
+	GetSpaceRepository().Create(space2)
+	req = newHTTPRequest("PUT", "/location/"+location1.ID+"/space/"+space2.ID, user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	payload = `{"deleteIds": ["` + space2.ID + `"]}`
+	req = newHTTPRequest("POST", "/location/"+location1.ID+"/space/bulk", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var bulkRes *BulkUpdateResponse
+	json.Unmarshal(res.Body.Bytes(), &bulkRes)
+	checkTestBool(t, false, bulkRes.Deletes[0].Success)
+
+	// Locations
+	payload = `{"name": "Location 1.1"}`
+	req = newHTTPRequest("PUT", "/location/"+location1.ID, user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	req = newHTTPRequest("PUT", "/location/"+location2.ID, user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	req = newHTTPRequest("DELETE", "/location/"+location1.ID, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	req = newHTTPRequest("POST", "/location/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Presence report
+	start := time.Now().Add(24 * time.Hour)
+	end := start.Add(24 * 7 * time.Hour)
+	period := `"start": "` + start.Format(JsDateTimeFormatWithTimezone) + `", "end": "` + end.Format(JsDateTimeFormatWithTimezone) + `"`
+	payload = `{` + period + `, "locationId": "` + location1.ID + `"}`
+	req = newHTTPRequest("POST", "/booking/report/presence/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	payload = `{` + period + `, "locationId": "` + location2.ID + `"}`
+	req = newHTTPRequest("POST", "/booking/report/presence/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	payload = `{` + period + `}`
+	req = newHTTPRequest("POST", "/booking/report/presence/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Org-wide space admin routes remain inaccessible
+	req = newHTTPRequest("GET", "/stats/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type LocationNotificationRepository struct {
+}
+
+// LocationNotificationTarget is a Slack or Microsoft Teams incoming webhook
+// notified about bookings at a location. If DailyDigest is set, a list of
+// users in the office is posted every day at DigestHour (location time).
+type LocationNotificationTarget struct {
+	ID             string
+	LocationID     string
+	URL            string
+	NotifyBookings bool
+	DailyDigest    bool
+	DigestHour     int
+	LastDigest     *time.Time
+}
+
+var locationNotificationRepository *LocationNotificationRepository
+var locationNotificationRepositoryOnce sync.Once
+
+func GetLocationNotificationRepository() *LocationNotificationRepository {
+	locationNotificationRepositoryOnce.Do(func() {
+		locationNotificationRepository = &LocationNotificationRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS location_notification_targets (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"location_id uuid NOT NULL, " +
+			"url VARCHAR NOT NULL, " +
+			"notify_bookings boolean NOT NULL DEFAULT TRUE, " +
+			"daily_digest boolean NOT NULL DEFAULT FALSE, " +
+			"digest_hour INTEGER NOT NULL DEFAULT 8, " +
+			"last_digest DATE NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_location_notification_targets_location_id ON location_notification_targets(location_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return locationNotificationRepository
+}
+
+func (r *LocationNotificationRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *LocationNotificationRepository) Create(e *LocationNotificationTarget) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO location_notification_targets "+
+		"(location_id, url, notify_bookings, daily_digest, digest_hour) "+
+		"VALUES ($1, $2, $3, $4, $5) "+
+		"RETURNING id",
+		e.LocationID, e.URL, e.NotifyBookings, e.DailyDigest, e.DigestHour).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *LocationNotificationRepository) GetOne(id string) (*LocationNotificationTarget, error) {
+	e := &LocationNotificationTarget{}
+	err := GetDatabase().DB().QueryRow("SELECT id, location_id, url, notify_bookings, daily_digest, digest_hour, last_digest "+
+		"FROM location_notification_targets "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.LocationID, &e.URL, &e.NotifyBookings, &e.DailyDigest, &e.DigestHour, &e.LastDigest)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *LocationNotificationRepository) GetAll(locationID string) ([]*LocationNotificationTarget, error) {
+	return r.getAll("WHERE location_id = $1", locationID)
+}
+
+// GetAllWithDigest returns all targets which have the daily digest enabled.
+func (r *LocationNotificationRepository) GetAllWithDigest() ([]*LocationNotificationTarget, error) {
+	return r.getAll("WHERE daily_digest = TRUE")
+}
+
+func (r *LocationNotificationRepository) getAll(where string, args ...interface{}) ([]*LocationNotificationTarget, error) {
+	var result []*LocationNotificationTarget
+	rows, err := GetDatabase().DB().Query("SELECT id, location_id, url, notify_bookings, daily_digest, digest_hour, last_digest "+
+		"FROM location_notification_targets "+
+		where+" "+
+		"ORDER BY location_id, url", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &LocationNotificationTarget{}
+		err = rows.Scan(&e.ID, &e.LocationID, &e.URL, &e.NotifyBookings, &e.DailyDigest, &e.DigestHour, &e.LastDigest)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *LocationNotificationRepository) Update(e *LocationNotificationTarget) error {
+	_, err := GetDatabase().DB().Exec("UPDATE location_notification_targets SET "+
+		"url = $1, "+
+		"notify_bookings = $2, "+
+		"daily_digest = $3, "+
+		"digest_hour = $4 "+
+		"WHERE id = $5",
+		e.URL, e.NotifyBookings, e.DailyDigest, e.DigestHour, e.ID)
+	return err
+}
+
+// ClaimDigest marks the digest for the specified day as sent. It returns false
+// if it has already been claimed, so that each digest is sent only once, even
+// with multiple instances running.
+func (r *LocationNotificationRepository) ClaimDigest(e *LocationNotificationTarget, day time.Time) (bool, error) {
+	var id string
+	err := GetDatabase().DB().QueryRow("UPDATE location_notification_targets SET "+
+		"last_digest = $2 "+
+		"WHERE id = $1 AND (last_digest IS NULL OR last_digest < $2) "+
+		"RETURNING id",
+		e.ID, day.Format("2006-01-02")).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *LocationNotificationRepository) Delete(e *LocationNotificationTarget) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM chat_messages WHERE target_id = $1", e.ID); err != nil {
+		return err
+	}
+	_, err := GetDatabase().DB().Exec("DELETE FROM location_notification_targets WHERE id = $1", e.ID)
+	return err
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChatMessagePayload is the message format of Slack incoming webhooks, which
+// is also accepted by Microsoft Teams incoming webhooks.
+type ChatMessagePayload struct {
+	Text string `json:"text"`
+}
+
+// notifyLocationBooking queues a message about the new booking for each of the
+// location's notification targets.
+func notifyLocationBooking(e *Booking) {
+	booking, err := GetBookingRepository().GetOne(e.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	location := &booking.Space.Location
+	list, err := GetLocationNotificationRepository().GetAll(location.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var targets []*LocationNotificationTarget
+	for _, target := range list {
+		if target.NotifyBookings {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	showNames, _ := GetSettingsRepository().GetBool(location.OrganizationID, SettingShowNames.Name)
+	now, err := getLocationNow(location)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	text := getBookingNotificationText(booking, showNames, getWallClockDate(now))
+	for _, target := range targets {
+		enqueueChatMessage(target, text)
+	}
+}
+
+func getBookingNotificationText(e *BookingDetails, showNames bool, today time.Time) string {
+	name := "Someone"
+	if showNames {
+		name = e.UserEmail
+	}
+	timeRange := e.Enter.Format("15:04") + " - " + e.Leave.Format("15:04")
+	if e.Enter.Format("2006-01-02") != e.Leave.Format("2006-01-02") {
+		timeRange = e.Enter.Format("15:04") + " - " + e.Leave.Format("2006-01-02 15:04")
+	}
+	return name + " booked " + e.Space.Name + " in " + e.Space.Location.Name + " for " +
+		getRelativeDayText(e.Enter, today) + ", " + timeRange
+}
+
+// sendLocationDigests queues the daily "who's in the office" digest for all
+// targets which are due. Each digest is claimed in the database before, so
+// that it's sent only once a day.
+func sendLocationDigests() (int, error) {
+	list, err := GetLocationNotificationRepository().GetAllWithDigest()
+	if err != nil {
+		return 0, err
+	}
+	locations := map[string]*Location{}
+	num := 0
+	for _, target := range list {
+		location, ok := locations[target.LocationID]
+		if !ok {
+			if location, err = GetLocationRepository().GetOne(target.LocationID); err != nil {
+				log.Println(err)
+				continue
+			}
+			locations[target.LocationID] = location
+		}
+		now, err := getLocationNow(location)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		today := getWallClockDate(now)
+		if now.Hour() < target.DigestHour || (target.LastDigest != nil && !target.LastDigest.Before(today)) {
+			continue
+		}
+		claimed, err := GetLocationNotificationRepository().ClaimDigest(target, today)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		text, err := getLocationDigestText(location, today)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		enqueueChatMessage(target, text)
+		num++
+	}
+	return num, nil
+}
+
+// getLocationDigestText returns the list of users who have booked a space at
+// the location on the specified day, or an empty string if there are none.
+// Names are only shown if SettingShowNames is enabled.
+func getLocationDigestText(location *Location, day time.Time) (string, error) {
+	userIDs, err := GetSpaceRepository().GetBookingUserIDMap(location.OrganizationID, day, day.Add(time.Hour*24-time.Second))
+	if err != nil {
+		return "", err
+	}
+	var emails []string
+	seen := map[string]bool{}
+	for _, userID := range userIDs[location.ID] {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		user, err := GetUserRepository().GetOne(userID)
+		if err != nil {
+			return "", err
+		}
+		emails = append(emails, user.Email)
+	}
+	if len(emails) == 0 {
+		return "", nil
+	}
+	when := "today (" + day.Format("2006-01-02") + ")"
+	showNames, _ := GetSettingsRepository().GetBool(location.OrganizationID, SettingShowNames.Name)
+	if !showNames {
+		if len(emails) == 1 {
+			return "1 person is in the office at " + location.Name + " " + when + ".", nil
+		}
+		return strconv.Itoa(len(emails)) + " people are in the office at " + location.Name + " " + when + ".", nil
+	}
+	sort.Strings(emails)
+	return "Who's in the office at " + location.Name + " " + when + ":\n• " + strings.Join(emails, "\n• "), nil
+}
+
+func getLocationNow(location *Location) (time.Time, error) {
+	tz, err := time.LoadLocation(GetLocationRepository().GetTimezone(location))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().In(tz), nil
+}
+
+// getWallClockDate returns the date of t as a wall clock time, comparable to
+// enter and leave times loaded from the database.
+func getWallClockDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func getRelativeDayText(t time.Time, today time.Time) string {
+	day := t.Format("2006-01-02")
+	if day == today.Format("2006-01-02") {
+		return "today"
+	}
+	if day == today.AddDate(0, 0, 1).Format("2006-01-02") {
+		return "tomorrow"
+	}
+	return day
+}
+
+func enqueueChatMessage(target *LocationNotificationTarget, text string) {
+	now := time.Now().UTC()
+	e := &ChatMessage{
+		TargetID:    target.ID,
+		Text:        text,
+		NextAttempt: now,
+		Created:     now,
+	}
+	if err := GetChatMessageRepository().Create(e); err != nil {
+		log.Println(err)
+	}
+}
+
+// processChatMessageQueue posts due messages and returns the number of
+// successfully posted ones. Failed messages are retried with the same backoff
+// as webhook deliveries.
+func processChatMessageQueue() (int, error) {
+	list, err := GetChatMessageRepository().Claim(time.Now().UTC(), ChatMessageLease, ChatMessageMaxAttempts, ChatMessageBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	num := 0
+	for _, e := range list {
+		target, err := GetLocationNotificationRepository().GetOne(e.TargetID)
+		if err == nil {
+			err = postChatMessage(target.URL, e.Text)
+		}
+		if err != nil {
+			log.Println(err)
+			e.Attempts++
+			e.NextAttempt = time.Now().UTC().Add(getWebhookBackoff(e.Attempts))
+			e.LastError = err.Error()
+			if err := GetChatMessageRepository().Update(e); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		if err := GetChatMessageRepository().Delete(e); err != nil {
+			log.Println(err)
+		}
+		num++
+	}
+	return num, nil
+}
+
+func postChatMessage(url string, text string) error {
+	payload, err := json.Marshal(&ChatMessagePayload{Text: text})
+	if err != nil {
+		return err
+	}
+	resp, err := getWebhookHTTPClient().Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("chat webhook returned status code " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createLocationNotificationTestTarget(t *testing.T, admin *User, locationID string, url string, dailyDigest bool) string {
+	payload := `{"url": "` + url + `", "notifyBookings": true, "dailyDigest": ` + strconv.FormatBool(dailyDigest) + `, "digestHour": 0}`
+	req := newHTTPRequest("POST", "/location/"+locationID+"/notification/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	return res.Header().Get("X-Object-Id")
+}
+
+func TestLocationNotificationsForbidden(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	id := createLocationNotificationTestTarget(t, admin, s.LocationID, "https://hooks.slack.com/test", false)
+
+	payload := `{"url": "https://hooks.slack.com/test", "notifyBookings": true}`
+	req := newHTTPRequest("POST", "/location/"+s.LocationID+"/notification/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/location/"+s.LocationID+"/notification/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/location/"+s.LocationID+"/notification/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	req = newHTTPRequest("GET", "/location/"+s.LocationID+"/notification/", admin2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestLocationNotificationsCRUD(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	admin := createTestUserOrgAdmin(org)
+	id := createLocationNotificationTestTarget(t, admin, s.LocationID, "https://hooks.slack.com/test", false)
+
+	req := newHTTPRequest("GET", "/location/"+s.LocationID+"/notification/", admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetLocationNotificationResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, id, resBody[0].ID)
+	checkTestString(t, s.LocationID, resBody[0].LocationID)
+	checkTestString(t, "https://hooks.slack.com/test", resBody[0].URL)
+	checkTestBool(t, true, resBody[0].NotifyBookings)
+	checkTestBool(t, false, resBody[0].DailyDigest)
+
+	payload := `{"url": "https://example.webhook.office.com/test", "notifyBookings": false, "dailyDigest": true, "digestHour": 9}`
+	req = newHTTPRequest("PUT", "/location/"+s.LocationID+"/notification/"+id, admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	e, _ := GetLocationNotificationRepository().GetOne(id)
+	checkTestString(t, "https://example.webhook.office.com/test", e.URL)
+	checkTestBool(t, false, e.NotifyBookings)
+	checkTestBool(t, true, e.DailyDigest)
+	checkTestInt(t, 9, e.DigestHour)
+
+	req = newHTTPRequest("DELETE", "/location/"+s.LocationID+"/notification/"+id, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	list, _ := GetLocationNotificationRepository().GetAll(s.LocationID)
+	checkTestInt(t, 0, len(list))
+}
+
+func TestLocationNotificationsInvalid(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	admin := createTestUserOrgAdmin(org)
+
+	payloads := []string{
+		`{"url": "not a url"}`,
+		`{"url": "ftp://test.com/hook"}`,
+		`{"url": "https://hooks.slack.com/test", "digestHour": 24}`,
+		`{"url": "https://hooks.slack.com/test", "digestHour": -1}`,
+	}
+	for _, payload := range payloads {
+		req := newHTTPRequest("POST", "/location/"+s.LocationID+"/notification/", admin.ID, bytes.NewBufferString(payload))
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	}
+
+	l2 := &Location{Name: "Other", OrganizationID: org.ID}
+	GetLocationRepository().Create(l2)
+	id := createLocationNotificationTestTarget(t, admin, s.LocationID, "https://hooks.slack.com/test", false)
+	req := newHTTPRequest("DELETE", "/location/"+l2.ID+"/notification/"+id, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestLocationNotificationsBookingMessage(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	createLocationNotificationTestTarget(t, admin, s.LocationID, server.URL, false)
+
+	createCalDavSyncTestBooking(t, user, s)
+	num, err := processChatMessageQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	checkTestInt(t, 1, receiver.getNumRequests())
+	var payload ChatMessagePayload
+	json.Unmarshal([]byte(receiver.bodies[0]), &payload)
+	checkTestString(t, "Someone booked Test 1 in Test for 2030-09-02, 08:00 - 17:00", payload.Text)
+
+	GetSettingsRepository().Set(org.ID, SettingShowNames.Name, "1")
+	createCalDavSyncTestBooking(t, admin, s)
+	processChatMessageQueue()
+	checkTestInt(t, 2, receiver.getNumRequests())
+	json.Unmarshal([]byte(receiver.bodies[1]), &payload)
+	checkTestString(t, admin.Email+" booked Test 1 in Test for 2030-09-02, 08:00 - 17:00", payload.Text)
+}
+
+func TestLocationNotificationsRetry(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	receiver.setStatusCode(http.StatusInternalServerError)
+	createLocationNotificationTestTarget(t, admin, s.LocationID, server.URL, false)
+
+	createCalDavSyncTestBooking(t, user, s)
+	num, _ := processChatMessageQueue()
+	checkTestInt(t, 0, num)
+	checkTestInt(t, 1, receiver.getNumRequests())
+
+	// Not due yet
+	num, _ = processChatMessageQueue()
+	checkTestInt(t, 0, num)
+	checkTestInt(t, 1, receiver.getNumRequests())
+
+	GetDatabase().DB().Exec("UPDATE chat_messages SET next_attempt = $1", time.Now().UTC().Add(-time.Minute))
+	receiver.setStatusCode(http.StatusOK)
+	num, _ = processChatMessageQueue()
+	checkTestInt(t, 1, num)
+	checkTestInt(t, 2, receiver.getNumRequests())
+}
+
+func TestLocationNotificationsBookingText(t *testing.T) {
+	today := time.Date(2030, 9, 2, 0, 0, 0, 0, time.UTC)
+	e := &BookingDetails{
+		Booking: Booking{
+			Enter: time.Date(2030, 9, 2, 8, 0, 0, 0, time.UTC),
+			Leave: time.Date(2030, 9, 2, 17, 0, 0, 0, time.UTC),
+		},
+		Space:     SpaceDetails{Space: Space{Name: "Desk 1"}, Location: Location{Name: "HQ"}},
+		UserEmail: "foo@test.com",
+	}
+	checkTestString(t, "foo@test.com booked Desk 1 in HQ for today, 08:00 - 17:00", getBookingNotificationText(e, true, today))
+	checkTestString(t, "Someone booked Desk 1 in HQ for today, 08:00 - 17:00", getBookingNotificationText(e, false, today))
+	checkTestString(t, "Someone booked Desk 1 in HQ for tomorrow, 08:00 - 17:00", getBookingNotificationText(e, false, today.AddDate(0, 0, -1)))
+	e.Leave = time.Date(2030, 9, 3, 12, 0, 0, 0, time.UTC)
+	checkTestString(t, "Someone booked Desk 1 in HQ for 2030-09-02, 08:00 - 2030-09-03 12:00", getBookingNotificationText(e, false, today.AddDate(0, 0, -5)))
+}
+
+func TestLocationNotificationsDigest(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	l, _ := GetLocationRepository().GetOne(s.LocationID)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	id := createLocationNotificationTestTarget(t, admin, s.LocationID, server.URL, true)
+	target, _ := GetLocationNotificationRepository().GetOne(id)
+	target.NotifyBookings = false
+	GetLocationNotificationRepository().Update(target)
+
+	// No bookings today, so the digest is claimed but not posted
+	num, err := sendLocationDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 0, num)
+
+	now, _ := getLocationNow(l)
+	s2 := &Space{Name: "Test 2", LocationID: s.LocationID}
+	GetSpaceRepository().Create(s2)
+	enter := time.Date(now.Year(), now.Month(), now.Day(), 10, 0, 0, 0, now.Location())
+	createTestBooking(user, s, enter, enter.Add(time.Hour))
+	createTestBooking(admin, s2, enter, enter.Add(time.Hour))
+
+	GetDatabase().DB().Exec("UPDATE location_notification_targets SET last_digest = NULL")
+	num, _ = sendLocationDigests()
+	checkTestInt(t, 1, num)
+	num, _ = sendLocationDigests()
+	checkTestInt(t, 0, num)
+
+	processChatMessageQueue()
+	checkTestInt(t, 1, receiver.getNumRequests())
+	var payload ChatMessagePayload
+	json.Unmarshal([]byte(receiver.bodies[0]), &payload)
+	checkTestBool(t, true, strings.HasPrefix(payload.Text, "2 people are in the office at Test today ("))
+
+	GetSettingsRepository().Set(org.ID, SettingShowNames.Name, "1")
+	text, _ := getLocationDigestText(l, getWallClockDate(now))
+	checkTestBool(t, true, strings.Contains(text, "• "+user.Email))
+	checkTestBool(t, true, strings.Contains(text, "• "+admin.Email))
+}
@@ -162,6 +162,29 @@ func (r *LocationRepository) Delete(e *Location) error {
 	if _, err := GetDatabase().DB().Exec("DELETE FROM bookings WHERE bookings.space_id IN (SELECT spaces.id FROM spaces WHERE spaces.location_id = $1)", e.ID); err != nil {
 		return err
 	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM booking_series WHERE booking_series.space_id IN (SELECT spaces.id FROM spaces WHERE spaces.location_id = $1)", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE location_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE location_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM chat_messages WHERE chat_messages.target_id IN (SELECT location_notification_targets.id FROM location_notification_targets WHERE location_notification_targets.location_id = $1)", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM location_notification_targets WHERE location_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM role_assignments WHERE location_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_restrictions WHERE "+
+		"user_groups_restrictions.entity_id = $1 OR "+
+		"user_groups_restrictions.entity_id IN (SELECT spaces.id FROM spaces WHERE spaces.location_id = $1)", e.ID); err != nil {
+		return err
+	}
 	if _, err := GetDatabase().DB().Exec("DELETE FROM spaces WHERE location_id = $1", e.ID); err != nil {
 		return err
 	}
@@ -176,6 +199,34 @@ func (r *LocationRepository) DeleteAll(organizationID string) error {
 		")", organizationID); err != nil {
 		return err
 	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM booking_series WHERE "+
+		"booking_series.space_id IN (SELECT spaces.id FROM spaces WHERE "+
+		"spaces.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)"+
+		")", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE waitlist_entries.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE ical_feeds.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM chat_messages WHERE "+
+		"chat_messages.target_id IN (SELECT location_notification_targets.id FROM location_notification_targets WHERE "+
+		"location_notification_targets.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)"+
+		")", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM location_notification_targets WHERE location_notification_targets.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM role_assignments WHERE role_assignments.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_restrictions WHERE "+
+		"user_groups_restrictions.group_id IN (SELECT user_groups.id FROM user_groups WHERE user_groups.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
 	if _, err := GetDatabase().DB().Exec("DELETE FROM spaces WHERE spaces.location_id IN (SELECT locations.id FROM locations WHERE locations.organization_id = $1)", organizationID); err != nil {
 		return err
 	}
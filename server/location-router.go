@@ -56,6 +56,24 @@ type GetSpaceAttributeValueResponse struct {
 	Value       string `json:"value"`
 }
 
+type CreateLocationNotificationRequest struct {
+	URL            string `json:"url" validate:"required"`
+	NotifyBookings bool   `json:"notifyBookings"`
+	DailyDigest    bool   `json:"dailyDigest"`
+	DigestHour     int    `json:"digestHour"`
+}
+
+type GetLocationNotificationResponse struct {
+	ID         string `json:"id"`
+	LocationID string `json:"locationId"`
+	CreateLocationNotificationRequest
+}
+
+type GetLocationAdminResponse struct {
+	UserID string `json:"userId"`
+	Email  string `json:"email"`
+}
+
 type SearchLocationRequest struct {
 	Enter      time.Time         `json:"enter" validate:"required"`
 	Leave      time.Time         `json:"leave" validate:"required"`
@@ -75,18 +93,27 @@ const (
 )
 
 func (router *LocationRouter) setupRoutes(s *mux.Router) {
-	s.HandleFunc("/search", router.search).Methods("POST")
-	s.HandleFunc("/loadsampledata", router.loadSampleData).Methods("POST")
-	s.HandleFunc("/{id}/attribute", router.getAttributes).Methods("GET")
-	s.HandleFunc("/{id}/attribute/{attributeId}", router.setAttribute).Methods("POST")
-	s.HandleFunc("/{id}/attribute/{attributeId}", router.deleteAttribute).Methods("DELETE")
-	s.HandleFunc("/{id}/map", router.getMap).Methods("GET")
-	s.HandleFunc("/{id}/map", router.setMap).Methods("POST")
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/search", router.search).Methods("POST"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/loadsampledata", router.loadSampleData).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/attribute", router.getAttributes).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}/attribute/{attributeId}", router.setAttribute).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/attribute/{attributeId}", router.deleteAttribute).Methods("DELETE"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/notification/", router.getNotificationTargets).Methods("GET"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/notification/", router.createNotificationTarget).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/notification/{targetId}", router.updateNotificationTarget).Methods("PUT"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/notification/{targetId}", router.deleteNotificationTarget).Methods("DELETE"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/admin/", router.getAdmins).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/admin/{userId}", router.addAdmin).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/admin/{userId}", router.removeAdmin).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/group/", router.getGroupRestrictions).Methods("GET"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/group/", router.setGroupRestrictions).Methods("PUT"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/map", router.getMap).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}/map", router.setMap).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionSpacesAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionSpacesAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionBookingsRead)
 }
 
 func (router *LocationRouter) getAttributes(w http.ResponseWriter, r *http.Request) {
@@ -123,7 +150,7 @@ func (router *LocationRouter) setAttribute(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanSpaceAdminLocation(user, e) {
 		SendForbidden(w)
 		return
 	}
@@ -147,6 +174,7 @@ func (router *LocationRouter) setAttribute(w http.ResponseWriter, r *http.Reques
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityLocationAttribute, e.ID, nil, map[string]string{"attributeId": attribute.ID, "value": m.Value})
 	SendUpdated(w)
 }
 
@@ -159,11 +187,12 @@ func (router *LocationRouter) deleteAttribute(w http.ResponseWriter, r *http.Req
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanSpaceAdminLocation(user, e) {
 		SendForbidden(w)
 		return
 	}
 	GetSpaceAttributeValueRepository().Delete(vars["attributeId"], e.ID, SpaceAttributeValueEntityTypeLocation)
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityLocationAttribute, e.ID, map[string]string{"attributeId": vars["attributeId"]}, nil)
 	SendUpdated(w)
 }
 
@@ -317,8 +346,8 @@ func (router *LocationRouter) searchAttachNumSpaces(attributeValues []*SpaceAttr
 	return attributeValues, nil
 }
 
-func (router *LocationRouter) searchAttachNumFreeSpaces(attributeValues []*SpaceAttributeValue, organizationID string, enter, leave time.Time) ([]*SpaceAttributeValue, error) {
-	freeSpaces, err := GetSpaceRepository().GetFreeCountMap(organizationID, enter, leave)
+func (router *LocationRouter) searchAttachNumFreeSpaces(attributeValues []*SpaceAttributeValue, user *User, enter, leave time.Time) ([]*SpaceAttributeValue, error) {
+	freeSpaces, err := GetSpaceRepository().GetFreeCountMap(user.OrganizationID, user.ID, enter, leave)
 	if err != nil {
 		return nil, err
 	}
@@ -401,7 +430,7 @@ func (router *LocationRouter) search(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if router.searchInputContains(&m.Attributes, SearchAttributeNumFreeSpaces) {
-		attributeValues, err = router.searchAttachNumFreeSpaces(attributeValues, user.OrganizationID, m.Enter, m.Leave)
+		attributeValues, err = router.searchAttachNumFreeSpaces(attributeValues, user, m.Enter, m.Leave)
 		if err != nil {
 			log.Println(err)
 			SendInternalServerError(w)
@@ -439,7 +468,7 @@ func (router *LocationRouter) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanSpaceAdminLocation(user, e) {
 		SendForbidden(w)
 		return
 	}
@@ -457,6 +486,8 @@ func (router *LocationRouter) update(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	eNew.MapMimeType, eNew.MapWidth, eNew.MapHeight = e.MapMimeType, e.MapWidth, e.MapHeight
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityLocation, e.ID, router.copyToRestModel(e), router.copyToRestModel(eNew))
 	SendUpdated(w)
 }
 
@@ -468,7 +499,7 @@ func (router *LocationRouter) delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -477,6 +508,7 @@ func (router *LocationRouter) delete(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityLocation, e.ID, router.copyToRestModel(e), nil)
 	SendUpdated(w)
 }
 
@@ -489,10 +521,6 @@ func (router *LocationRouter) create(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
 	e := router.copyFromRestModel(&m)
 	e.OrganizationID = user.OrganizationID
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	if m.Timezone != "" {
 		if !isValidTimeZone(m.Timezone) {
 			SendBadRequest(w)
@@ -504,9 +532,264 @@ func (router *LocationRouter) create(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityLocation, e.ID, nil, router.copyToRestModel(e))
 	SendCreated(w, e.ID)
 }
 
+func (router *LocationRouter) getNotificationTargets(w http.ResponseWriter, r *http.Request) {
+	location := router.getNotificationLocation(w, r)
+	if location == nil {
+		return
+	}
+	list, err := GetLocationNotificationRepository().GetAll(location.ID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetLocationNotificationResponse{}
+	for _, e := range list {
+		m := router.copyNotificationToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *LocationRouter) createNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	var m CreateLocationNotificationRequest
+	if UnmarshalValidateBody(r, &m) != nil || !router.isValidNotificationRequest(&m) {
+		SendBadRequest(w)
+		return
+	}
+	location := router.getNotificationLocation(w, r)
+	if location == nil {
+		return
+	}
+	e := router.copyNotificationFromRestModel(&m)
+	e.LocationID = location.ID
+	if err := GetLocationNotificationRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionCreate, AuditEntityLocationNotification, e.ID, nil, router.copyNotificationToRestModel(e))
+	SendCreated(w, e.ID)
+}
+
+func (router *LocationRouter) updateNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	var m CreateLocationNotificationRequest
+	if UnmarshalValidateBody(r, &m) != nil || !router.isValidNotificationRequest(&m) {
+		SendBadRequest(w)
+		return
+	}
+	location := router.getNotificationLocation(w, r)
+	if location == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetLocationNotificationRepository().GetOne(vars["targetId"])
+	if err != nil || e.LocationID != location.ID {
+		SendNotFound(w)
+		return
+	}
+	eNew := router.copyNotificationFromRestModel(&m)
+	eNew.ID = e.ID
+	eNew.LocationID = e.LocationID
+	if err := GetLocationNotificationRepository().Update(eNew); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionUpdate, AuditEntityLocationNotification, e.ID, router.copyNotificationToRestModel(e), router.copyNotificationToRestModel(eNew))
+	SendUpdated(w)
+}
+
+func (router *LocationRouter) deleteNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	location := router.getNotificationLocation(w, r)
+	if location == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	e, err := GetLocationNotificationRepository().GetOne(vars["targetId"])
+	if err != nil || e.LocationID != location.ID {
+		SendNotFound(w)
+		return
+	}
+	if err := GetLocationNotificationRepository().Delete(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionDelete, AuditEntityLocationNotification, e.ID, router.copyNotificationToRestModel(e), nil)
+	SendUpdated(w)
+}
+
+func (router *LocationRouter) getAdmins(w http.ResponseWriter, r *http.Request) {
+	location := router.getAdminLocation(w, r)
+	if location == nil {
+		return
+	}
+	list, err := GetRoleAssignmentRepository().GetAllByLocation(location.ID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetLocationAdminResponse{}
+	for _, e := range list {
+		if e.Role != UserRoleSpaceAdmin {
+			continue
+		}
+		user, err := GetUserRepository().GetOne(e.UserID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		m := &GetLocationAdminResponse{
+			UserID: user.ID,
+			Email:  user.Email,
+		}
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+// addAdmin assigns the space admin role for the location to a user of the
+// location's organization.
+func (router *LocationRouter) addAdmin(w http.ResponseWriter, r *http.Request) {
+	location := router.getAdminLocation(w, r)
+	if location == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	user, err := GetUserRepository().GetOne(vars["userId"])
+	if err != nil || user.OrganizationID != location.OrganizationID {
+		SendNotFound(w)
+		return
+	}
+	e := &RoleAssignment{
+		UserID:     user.ID,
+		LocationID: location.ID,
+		Role:       UserRoleSpaceAdmin,
+	}
+	if err := GetRoleAssignmentRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionCreate, AuditEntityLocationAdmin, location.ID, nil, &GetLocationAdminResponse{UserID: user.ID, Email: user.Email})
+	SendUpdated(w)
+}
+
+func (router *LocationRouter) removeAdmin(w http.ResponseWriter, r *http.Request) {
+	location := router.getAdminLocation(w, r)
+	if location == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	user, err := GetUserRepository().GetOne(vars["userId"])
+	if err != nil || !GetRoleAssignmentRepository().HasLocationRole(user.ID, location.ID, UserRoleSpaceAdmin) {
+		SendNotFound(w)
+		return
+	}
+	if err := GetRoleAssignmentRepository().Delete(user.ID, location.ID, UserRoleSpaceAdmin); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionDelete, AuditEntityLocationAdmin, location.ID, &GetLocationAdminResponse{UserID: user.ID, Email: user.Email}, nil)
+	SendUpdated(w)
+}
+
+func (router *LocationRouter) getGroupRestrictions(w http.ResponseWriter, r *http.Request) {
+	location := router.getGroupRestrictionLocation(w, r)
+	if location == nil {
+		return
+	}
+	sendGroupRestrictions(w, location.ID, GroupRestrictionEntityTypeLocation)
+}
+
+func (router *LocationRouter) setGroupRestrictions(w http.ResponseWriter, r *http.Request) {
+	location := router.getGroupRestrictionLocation(w, r)
+	if location == nil {
+		return
+	}
+	setGroupRestrictions(w, r, location.OrganizationID, location.ID, GroupRestrictionEntityTypeLocation)
+}
+
+func (router *LocationRouter) getGroupRestrictionLocation(w http.ResponseWriter, r *http.Request) *Location {
+	vars := mux.Vars(r)
+	location, err := GetLocationRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	if !CanSpaceAdminLocation(GetRequestUser(r), location) {
+		SendForbidden(w)
+		return nil
+	}
+	return location
+}
+
+// getAdminLocation returns the location specified in the request if the
+// requesting user can access its organization. Otherwise, it sends an error
+// response and returns nil.
+func (router *LocationRouter) getAdminLocation(w http.ResponseWriter, r *http.Request) *Location {
+	vars := mux.Vars(r)
+	location, err := GetLocationRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	if !CanAccessOrg(GetRequestUser(r), location.OrganizationID) {
+		SendForbidden(w)
+		return nil
+	}
+	return location
+}
+
+// getNotificationLocation returns the location specified in the request if the
+// requesting user is allowed to manage its notification targets. Otherwise, it
+// sends an error response and returns nil.
+func (router *LocationRouter) getNotificationLocation(w http.ResponseWriter, r *http.Request) *Location {
+	vars := mux.Vars(r)
+	location, err := GetLocationRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	user := GetRequestUser(r)
+	if !CanSpaceAdminLocation(user, location) {
+		SendForbidden(w)
+		return nil
+	}
+	return location
+}
+
+func (router *LocationRouter) isValidNotificationRequest(m *CreateLocationNotificationRequest) bool {
+	return isValidWebhookURL(m.URL) && m.DigestHour >= 0 && m.DigestHour <= 23
+}
+
+func (router *LocationRouter) copyNotificationFromRestModel(m *CreateLocationNotificationRequest) *LocationNotificationTarget {
+	e := &LocationNotificationTarget{}
+	e.URL = m.URL
+	e.NotifyBookings = m.NotifyBookings
+	e.DailyDigest = m.DailyDigest
+	e.DigestHour = m.DigestHour
+	return e
+}
+
+func (router *LocationRouter) copyNotificationToRestModel(e *LocationNotificationTarget) *GetLocationNotificationResponse {
+	m := &GetLocationNotificationResponse{}
+	m.ID = e.ID
+	m.LocationID = e.LocationID
+	m.URL = e.URL
+	m.NotifyBookings = e.NotifyBookings
+	m.DailyDigest = e.DailyDigest
+	m.DigestHour = e.DigestHour
+	return m
+}
+
 func (router *LocationRouter) getMap(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	e, err := GetLocationRepository().GetOne(vars["id"])
@@ -544,7 +827,7 @@ func (router *LocationRouter) setMap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanSpaceAdminLocation(user, e) {
 		SendForbidden(w)
 		return
 	}
@@ -571,21 +854,21 @@ func (router *LocationRouter) setMap(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	before := router.copyToRestModel(e)
+	e.MapMimeType, e.MapWidth, e.MapHeight = locationMap.MimeType, locationMap.Width, locationMap.Height
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityLocation, e.ID, before, router.copyToRestModel(e))
 	SendUpdated(w)
 }
 
 func (router *LocationRouter) loadSampleData(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	org, err := GetOrganizationRepository().GetOne(user.OrganizationID)
 	if err != nil {
 		SendInternalServerError(w)
 		return
 	}
 	GetOrganizationRepository().createSampleData(org)
+	writeAuditLog(r, org.ID, AuditActionLoadSampleData, AuditEntityOrganization, org.ID, nil, nil)
 }
 
 func (router *LocationRouter) copyFromRestModel(m *CreateLocationRequest) *Location {
@@ -29,6 +29,8 @@ func TestMain(m *testing.M) {
 	os.Setenv("ORG_SIGNUP_ENABLED", "1")
 	os.Setenv("ORG_SIGNUP_DELETE", "1")
 	os.Setenv("LOGIN_PROTECTION_MAX_FAILS", "3")
+	os.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "1")
+	os.Setenv("CRYPT_KEY", "rC8REJftxMcdhzTvu9Tk6RqgygBRctZC")
 	GetConfig().ReadConfig()
 	db := GetDatabase()
 	dropTestDB()
@@ -118,6 +120,38 @@ func createTestOrg(orgDomain string) *Organization {
 	return org
 }
 
+func createTestOrgWithSpace() (*Organization, *Location, *Space) {
+	org := createTestOrg("test.com")
+	GetSettingsRepository().Set(org.ID, SettingMaxDaysInAdvance.Name, "5000")
+	GetSettingsRepository().Set(org.ID, SettingMaxBookingsPerUser.Name, "100")
+	l := &Location{
+		Name:           "Test",
+		OrganizationID: org.ID,
+	}
+	if err := GetLocationRepository().Create(l); err != nil {
+		panic(err)
+	}
+	s := &Space{Name: "Test 1", LocationID: l.ID}
+	if err := GetSpaceRepository().Create(s); err != nil {
+		panic(err)
+	}
+	return org, l, s
+}
+
+func createTestBooking(user *User, s *Space, enter time.Time, leave time.Time) *Booking {
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	e := &Booking{
+		UserID:  user.ID,
+		SpaceID: s.ID,
+		Enter:   enter.In(tz),
+		Leave:   leave.In(tz),
+	}
+	if err := GetBookingRepository().Create(e); err != nil {
+		panic(err)
+	}
+	return e
+}
+
 func createTestUserInOrgWithName(org *Organization, email string, role UserRole) *User {
 	user := &User{
 		Email:          email,
@@ -180,14 +214,14 @@ func createLoginTestUserParams() *LoginResponse {
 }
 
 func dropTestDB() {
-	tables := []string{"auth_providers", "auth_states", "bookings", "spaces", "locations", "organizations_domains", "organizations", "users", "signups", "settings", "subscription_events", "space_attributes"}
+	tables := []string{"auth_providers", "auth_provider_rules", "auth_states", "bookings", "booking_series", "booking_reminders", "waitlist_entries", "ical_feeds", "caldav_sync_queue", "caldav_logs", "calendar_tokens", "api_tokens", "user_totp", "user_totp_recovery_codes", "role_assignments", "user_groups", "user_groups_members", "user_groups_restrictions", "scim_tokens", "webhooks", "webhook_deliveries", "location_notification_targets", "chat_messages", "audit_log", "spaces", "locations", "organizations_domains", "organizations", "users", "signups", "settings", "subscription_events", "space_attributes"}
 	for _, s := range tables {
 		GetDatabase().DB().Exec("DROP TABLE IF EXISTS " + s)
 	}
 }
 
 func clearTestDB() {
-	tables := []string{"auth_providers", "auth_states", "auth_attempts", "bookings", "spaces", "locations", "organizations_domains", "organizations", "users", "users_preferences", "signups", "settings", "subscription_events", "space_attributes"}
+	tables := []string{"auth_providers", "auth_provider_rules", "auth_states", "auth_attempts", "bookings", "booking_series", "booking_reminders", "waitlist_entries", "ical_feeds", "caldav_sync_queue", "caldav_logs", "calendar_tokens", "api_tokens", "user_totp", "user_totp_recovery_codes", "role_assignments", "user_groups", "user_groups_members", "user_groups_restrictions", "scim_tokens", "webhooks", "webhook_deliveries", "location_notification_targets", "chat_messages", "audit_log", "spaces", "locations", "organizations_domains", "organizations", "users", "users_preferences", "signups", "settings", "subscription_events", "space_attributes"}
 	for _, s := range tables {
 		GetDatabase().DB().Exec("TRUNCATE " + s)
 	}
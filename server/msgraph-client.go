@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const MSGraphDateTimeFormat string = "2006-01-02T15:04:05.9999999"
+
+// MSGraphClient synchronises events with a Microsoft 365 / Outlook calendar
+// using the user's OAuth token.
+type MSGraphClient struct {
+	userID     string
+	httpClient *http.Client
+}
+
+type msGraphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type msGraphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type msGraphEvent struct {
+	ID       string          `json:"id,omitempty"`
+	Subject  string          `json:"subject"`
+	Start    msGraphDateTime `json:"start"`
+	End      msGraphDateTime `json:"end"`
+	Location msGraphLocation `json:"location"`
+}
+
+type msGraphCalendar struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type msGraphCalendarList struct {
+	Value    []*msGraphCalendar `json:"value"`
+	NextLink string             `json:"@odata.nextLink"`
+}
+
+type msGraphErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type MSGraphError struct {
+	StatusCode int
+	Code       string
+}
+
+func (e *MSGraphError) Error() string {
+	return "Microsoft Graph returned status code " + strconv.Itoa(e.StatusCode) + " (" + e.Code + ")"
+}
+
+// msGraphTokenSource persists refreshed tokens, so that subsequent requests
+// and other instances don't have to refresh them again.
+type msGraphTokenSource struct {
+	userID      string
+	source      oauth2.TokenSource
+	accessToken string
+}
+
+func (s *msGraphTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken != s.accessToken {
+		if err := saveMSGraphToken(s.userID, token); err != nil {
+			return nil, err
+		}
+		s.accessToken = token.AccessToken
+	}
+	return token, nil
+}
+
+func isMSGraphEnabled() bool {
+	return GetConfig().MSGraphClientID != "" && canCrypt()
+}
+
+func getMSGraphOAuthConfig() *oauth2.Config {
+	c := GetConfig()
+	return &oauth2.Config{
+		RedirectURL:  c.PublicURL + "preference/msgraph/callback",
+		ClientID:     c.MSGraphClientID,
+		ClientSecret: c.MSGraphClientSecret,
+		Scopes:       []string{"offline_access", "User.Read", "Calendars.ReadWrite"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   c.MSGraphLoginURL + "/" + c.MSGraphTenant + "/oauth2/v2.0/authorize",
+			TokenURL:  c.MSGraphLoginURL + "/" + c.MSGraphTenant + "/oauth2/v2.0/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+func saveMSGraphToken(userID string, token *oauth2.Token) error {
+	return GetCalendarTokenRepository().Set(&CalendarToken{
+		UserID:       userID,
+		Provider:     CalendarProviderMSGraph,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	})
+}
+
+func newMSGraphClient(userID string) *MSGraphClient {
+	return &MSGraphClient{
+		userID: userID,
+	}
+}
+
+func (c *MSGraphClient) Connect() error {
+	e, err := GetCalendarTokenRepository().Get(c.userID, CalendarProviderMSGraph)
+	if err != nil {
+		return err
+	}
+	token := &oauth2.Token{
+		AccessToken:  e.AccessToken,
+		RefreshToken: e.RefreshToken,
+		TokenType:    e.TokenType,
+		Expiry:       e.Expiry,
+	}
+	source := &msGraphTokenSource{
+		userID:      c.userID,
+		source:      getMSGraphOAuthConfig().TokenSource(context.Background(), token),
+		accessToken: token.AccessToken,
+	}
+	c.httpClient = oauth2.NewClient(context.Background(), source)
+	return c.request(http.MethodGet, "/me", nil, nil)
+}
+
+func (c *MSGraphClient) ListCalendars() ([]*Calendar, error) {
+	res := make([]*Calendar, 0)
+	next := "/me/calendars"
+	for next != "" {
+		list := &msGraphCalendarList{}
+		if err := c.request(http.MethodGet, next, nil, list); err != nil {
+			return nil, err
+		}
+		for _, calendar := range list.Value {
+			res = append(res, &Calendar{
+				ID:   calendar.ID,
+				Name: calendar.Name,
+			})
+		}
+		next = list.NextLink
+	}
+	return res, nil
+}
+
+func (c *MSGraphClient) CreateEvent(calendarID string, e *CalendarEvent) error {
+	event := c.getGraphEvent(e)
+	if e.ID != "" {
+		err := c.request(http.MethodPatch, "/me/events/"+url.PathEscape(e.ID), event, nil)
+		if !isMSGraphNotFound(err) {
+			return err
+		}
+	}
+	eventsPath := "/me/events"
+	if calendarID != "" {
+		eventsPath = "/me/calendars/" + url.PathEscape(calendarID) + "/events"
+	}
+	res := &msGraphEvent{}
+	if err := c.request(http.MethodPost, eventsPath, event, res); err != nil {
+		return err
+	}
+	if res.ID == "" {
+		return errors.New("Microsoft Graph returned no event ID")
+	}
+	e.ID = res.ID
+	return nil
+}
+
+func (c *MSGraphClient) GetEvent(calendarID string, id string) (*CalendarEvent, error) {
+	event := &msGraphEvent{}
+	err := c.request(http.MethodGet, "/me/events/"+url.PathEscape(id), nil, event)
+	if isMSGraphNotFound(err) {
+		return nil, ErrCalendarEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &CalendarEvent{
+		ID:       id,
+		Title:    event.Subject,
+		Location: event.Location.DisplayName,
+	}
+	if res.Start, err = c.parseDateTime(event.Start); err != nil {
+		return nil, err
+	}
+	if res.End, err = c.parseDateTime(event.End); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *MSGraphClient) DeleteEvent(calendarID string, e *CalendarEvent) error {
+	err := c.request(http.MethodDelete, "/me/events/"+url.PathEscape(e.ID), nil, nil)
+	if isMSGraphNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// request sends a request to the Graph API and decodes the response into
+// result if it's not nil. Path may also be an absolute URL, as returned in
+// @odata.nextLink.
+func (c *MSGraphClient) request(method, path string, body interface{}, result interface{}) error {
+	requestURL := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		requestURL = GetConfig().MSGraphAPIURL + path
+	}
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Prefer", "outlook.timezone=\"UTC\"")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		graphErr := &MSGraphError{StatusCode: resp.StatusCode}
+		var errorResponse msGraphErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&errorResponse) == nil {
+			graphErr.Code = errorResponse.Error.Code
+		}
+		return graphErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (c *MSGraphClient) getGraphEvent(e *CalendarEvent) *msGraphEvent {
+	return &msGraphEvent{
+		Subject: e.Title,
+		Start: msGraphDateTime{
+			DateTime: e.Start.UTC().Format(JsDateTimeFormat),
+			TimeZone: "UTC",
+		},
+		End: msGraphDateTime{
+			DateTime: e.End.UTC().Format(JsDateTimeFormat),
+			TimeZone: "UTC",
+		},
+		Location: msGraphLocation{
+			DisplayName: e.Location,
+		},
+	}
+}
+
+func (c *MSGraphClient) parseDateTime(dt msGraphDateTime) (time.Time, error) {
+	tz, err := time.LoadLocation(dt.TimeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(MSGraphDateTimeFormat, dt.DateTime, tz)
+}
+
+// isMSGraphNotFound returns true if err indicates that the requested object
+// doesn't exist. Graph rejects IDs which have not been issued by Graph (such
+// as booking IDs used before an event has been created) as malformed.
+func isMSGraphNotFound(err error) bool {
+	var graphErr *MSGraphError
+	if !errors.As(err, &graphErr) {
+		return false
+	}
+	return graphErr.StatusCode == http.StatusNotFound ||
+		graphErr.StatusCode == http.StatusGone ||
+		(graphErr.StatusCode == http.StatusBadRequest && graphErr.Code == "ErrorInvalidIdMalformed")
+}
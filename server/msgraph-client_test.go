@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+)
+
+const (
+	msGraphTestTenant   = "test"
+	msGraphTestCode     = "test-code"
+	msGraphTestCalendar = "calendar-1"
+)
+
+// msGraphTestBackend is an in-memory stand-in for the Microsoft identity
+// platform token endpoint and the Graph calendar API.
+type msGraphTestBackend struct {
+	mu           sync.Mutex
+	events       map[string]*msGraphEvent
+	calendars    map[string]string
+	accessToken  string
+	refreshToken string
+	numTokens    int
+	numEvents    int
+}
+
+func newMSGraphTestServer() (*httptest.Server, *msGraphTestBackend) {
+	backend := &msGraphTestBackend{
+		events:    map[string]*msGraphEvent{},
+		calendars: map[string]string{},
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/"+msGraphTestTenant+"/oauth2/v2.0/token", backend.token).Methods("POST")
+	api := r.PathPrefix("/v1.0").Subrouter()
+	api.Use(backend.verifyAuth)
+	api.HandleFunc("/me", backend.me).Methods("GET")
+	api.HandleFunc("/me/calendars", backend.listCalendars).Methods("GET")
+	api.HandleFunc("/me/calendars/{calendar}/events", backend.createEvent).Methods("POST")
+	api.HandleFunc("/me/events", backend.createEvent).Methods("POST")
+	api.HandleFunc("/me/events/{id}", backend.getEventHandler).Methods("GET")
+	api.HandleFunc("/me/events/{id}", backend.updateEvent).Methods("PATCH")
+	api.HandleFunc("/me/events/{id}", backend.deleteEventHandler).Methods("DELETE")
+	server := httptest.NewServer(r)
+	return server, backend
+}
+
+// setMSGraphTestConfig points the Graph integration to the stand-in and
+// returns a function restoring the previous config.
+func setMSGraphTestConfig(server *httptest.Server) func() {
+	c := GetConfig()
+	clientID, tenant, loginURL, apiURL := c.MSGraphClientID, c.MSGraphTenant, c.MSGraphLoginURL, c.MSGraphAPIURL
+	c.MSGraphClientID = "test"
+	c.MSGraphTenant = msGraphTestTenant
+	c.MSGraphLoginURL = server.URL
+	c.MSGraphAPIURL = server.URL + "/v1.0"
+	return func() {
+		c.MSGraphClientID, c.MSGraphTenant, c.MSGraphLoginURL, c.MSGraphAPIURL = clientID, tenant, loginURL, apiURL
+	}
+}
+
+// connectMSGraphTestUser stores a valid token for the user and selects
+// Microsoft Graph as the user's calendar provider.
+func connectMSGraphTestUser(user *User, backend *msGraphTestBackend) {
+	saveMSGraphToken(user.ID, backend.issueToken())
+	GetUserPreferencesRepository().Set(user.ID, PreferenceCalendarProvider.Name, strconv.Itoa(int(CalendarProviderMSGraph)))
+	GetUserPreferencesRepository().Set(user.ID, PreferenceMSGraphCalendar.Name, msGraphTestCalendar)
+}
+
+func (b *msGraphTestBackend) issueToken() *oauth2.Token {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.numTokens++
+	b.accessToken = "access-" + strconv.Itoa(b.numTokens)
+	b.refreshToken = "refresh-" + strconv.Itoa(b.numTokens)
+	return &oauth2.Token{
+		AccessToken:  b.accessToken,
+		RefreshToken: b.refreshToken,
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+}
+
+func (b *msGraphTestBackend) token(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	valid := (r.FormValue("grant_type") == "authorization_code" && r.FormValue("code") == msGraphTestCode) ||
+		(r.FormValue("grant_type") == "refresh_token" && r.FormValue("refresh_token") == b.refreshToken)
+	b.mu.Unlock()
+	if !valid {
+		SendJSONWithStatus(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	token := b.issueToken()
+	SendJSON(w, map[string]interface{}{
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		"token_type":    token.TokenType,
+		"expires_in":    3600,
+	})
+}
+
+func (b *msGraphTestBackend) verifyAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		valid := r.Header.Get("Authorization") == "Bearer "+b.accessToken
+		b.mu.Unlock()
+		if !valid {
+			b.sendError(w, http.StatusUnauthorized, "InvalidAuthenticationToken")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (b *msGraphTestBackend) sendError(w http.ResponseWriter, status int, code string) {
+	res := &msGraphErrorResponse{}
+	res.Error.Code = code
+	SendJSONWithStatus(w, status, res)
+}
+
+func (b *msGraphTestBackend) me(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, map[string]string{"id": "test"})
+}
+
+func (b *msGraphTestBackend) listCalendars(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, &msGraphCalendarList{Value: []*msGraphCalendar{{ID: msGraphTestCalendar, Name: "Calendar"}}})
+}
+
+func (b *msGraphTestBackend) createEvent(w http.ResponseWriter, r *http.Request) {
+	calendar := mux.Vars(r)["calendar"]
+	if calendar != "" && calendar != msGraphTestCalendar {
+		b.sendError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+	var event *msGraphEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		b.sendError(w, http.StatusBadRequest, "RequestBodyRead")
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.numEvents++
+	event.ID = "AAMk-" + strconv.Itoa(b.numEvents)
+	b.events[event.ID] = event
+	b.calendars[event.ID] = calendar
+	SendJSONWithStatus(w, http.StatusCreated, event)
+}
+
+func (b *msGraphTestBackend) lookupEvent(w http.ResponseWriter, r *http.Request) *msGraphEvent {
+	id := mux.Vars(r)["id"]
+	if !strings.HasPrefix(id, "AAMk-") {
+		b.sendError(w, http.StatusBadRequest, "ErrorInvalidIdMalformed")
+		return nil
+	}
+	event, ok := b.events[id]
+	if !ok {
+		b.sendError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return nil
+	}
+	return event
+}
+
+func (b *msGraphTestBackend) getEventHandler(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	event := b.lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+	res := *event
+	res.Start.DateTime += ".0000000"
+	res.End.DateTime += ".0000000"
+	SendJSON(w, res)
+}
+
+func (b *msGraphTestBackend) updateEvent(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	event := b.lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+	var update *msGraphEvent
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		b.sendError(w, http.StatusBadRequest, "RequestBodyRead")
+		return
+	}
+	update.ID = event.ID
+	b.events[event.ID] = update
+	SendJSON(w, update)
+}
+
+func (b *msGraphTestBackend) deleteEventHandler(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	event := b.lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+	delete(b.events, event.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *msGraphTestBackend) getEvent(id string) *msGraphEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.events[id]
+}
+
+func (b *msGraphTestBackend) getCalendar(id string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calendars[id]
+}
+
+func (b *msGraphTestBackend) getNumEvents() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.events)
+}
+
+func TestMSGraphClientEvents(t *testing.T) {
+	clearTestDB()
+	server, backend := newMSGraphTestServer()
+	defer server.Close()
+	defer setMSGraphTestConfig(server)()
+	user := createTestUserInOrg(createTestOrg("test.com"))
+	connectMSGraphTestUser(user, backend)
+
+	client := newMSGraphClient(user.ID)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	calendars, err := client.ListCalendars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, len(calendars))
+	checkTestString(t, msGraphTestCalendar, calendars[0].ID)
+
+	tz, _ := time.LoadLocation("Europe/Berlin")
+	event := &CalendarEvent{
+		ID:       "c3c2e2d3-7d07-4aa0-9fa5-4b4ad4d5f3b1",
+		Title:    "Seat Reservation: Desk 1, Office",
+		Location: "Desk 1, Office",
+		Start:    time.Date(2030, 9, 2, 8, 0, 0, 0, tz),
+		End:      time.Date(2030, 9, 2, 17, 0, 0, 0, tz),
+	}
+	// IDs not issued by Graph result in a new event
+	if err := client.CreateEvent(msGraphTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "AAMk-1", event.ID)
+	checkTestString(t, msGraphTestCalendar, backend.getCalendar(event.ID))
+
+	res, err := client.GetEvent(msGraphTestCalendar, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, event.Title, res.Title)
+	checkTestString(t, event.Location, res.Location)
+	if !res.Start.Equal(event.Start) || !res.End.Equal(event.End) {
+		t.Fatalf("Expected %s - %s, got %s - %s", event.Start, event.End, res.Start, res.End)
+	}
+
+	// Existing events are updated in place
+	event.End = time.Date(2030, 9, 2, 12, 0, 0, 0, tz)
+	if err := client.CreateEvent(msGraphTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "AAMk-1", event.ID)
+	checkTestInt(t, 1, backend.getNumEvents())
+	checkTestString(t, "2030-09-02T10:00:00", backend.getEvent(event.ID).End.DateTime)
+
+	if err := client.DeleteEvent(msGraphTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetEvent(msGraphTestCalendar, event.ID); err != ErrCalendarEventNotFound {
+		t.Fatalf("Expected ErrCalendarEventNotFound, got %v", err)
+	}
+	// Deleting a non-existing event is not an error
+	if err := client.DeleteEvent(msGraphTestCalendar, event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMSGraphClientTokenRefresh(t *testing.T) {
+	clearTestDB()
+	server, backend := newMSGraphTestServer()
+	defer server.Close()
+	defer setMSGraphTestConfig(server)()
+	user := createTestUserInOrg(createTestOrg("test.com"))
+	token := backend.issueToken()
+	token.Expiry = time.Now().Add(-time.Minute)
+	saveMSGraphToken(user.ID, token)
+
+	client := newMSGraphClient(user.ID)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := GetCalendarTokenRepository().Get(user.ID, CalendarProviderMSGraph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "access-2", stored.AccessToken)
+	checkTestString(t, "refresh-2", stored.RefreshToken)
+	if !stored.Expiry.After(time.Now()) {
+		t.Fatalf("Expected expiry in the future, got %s", stored.Expiry)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	oidcCacheDuration      = 1 * time.Hour
+	oidcKeySetRefreshDelay = 1 * time.Minute
+	oidcMaxResponseSize    = 1024 * 1024
+)
+
+// oidcSigningMethods are the ID token signature algorithms accepted. Symmetric
+// algorithms are not supported, as the keys are taken from the JWKS.
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// OIDCDiscovery contains the provider metadata read from the issuer's
+// .well-known/openid-configuration document.
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+type oidcJSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type oidcJSONWebKeySet struct {
+	Keys []*oidcJSONWebKey `json:"keys"`
+}
+
+type oidcCachedDiscovery struct {
+	discovery *OIDCDiscovery
+	expiry    time.Time
+}
+
+type oidcCachedKeySet struct {
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+// OIDCClient fetches and caches the discovery documents and key sets of
+// OpenID Connect providers.
+type OIDCClient struct {
+	mutex       sync.Mutex
+	discoveries map[string]*oidcCachedDiscovery
+	keySets     map[string]*oidcCachedKeySet
+	httpClient  *http.Client
+}
+
+var oidcClient *OIDCClient
+var oidcClientOnce sync.Once
+
+func GetOIDCClient() *OIDCClient {
+	oidcClientOnce.Do(func() {
+		oidcClient = newOIDCClient()
+	})
+	return oidcClient
+}
+
+func newOIDCClient() *OIDCClient {
+	return &OIDCClient{
+		discoveries: make(map[string]*oidcCachedDiscovery),
+		keySets:     make(map[string]*oidcCachedKeySet),
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetDiscovery returns the provider metadata of the issuer.
+func (c *OIDCClient) GetDiscovery(issuer string) (*OIDCDiscovery, error) {
+	issuer = strings.TrimSuffix(strings.TrimSpace(issuer), "/")
+	if issuer == "" {
+		return nil, errors.New("issuer url is empty")
+	}
+	c.mutex.Lock()
+	cached := c.discoveries[issuer]
+	c.mutex.Unlock()
+	if cached != nil && time.Now().Before(cached.expiry) {
+		return cached.discovery, nil
+	}
+	discovery := &OIDCDiscovery{}
+	if err := c.fetchJSON(issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, errors.New("issuer in discovery document does not match: " + discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+	c.mutex.Lock()
+	c.discoveries[issuer] = &oidcCachedDiscovery{
+		discovery: discovery,
+		expiry:    time.Now().Add(oidcCacheDuration),
+	}
+	c.mutex.Unlock()
+	return discovery, nil
+}
+
+// VerifyIDToken checks the ID token's signature against the provider's key
+// set, its issuer, audience, expiry and nonce. It returns the token's claims.
+func (c *OIDCClient) VerifyIDToken(discovery *OIDCDiscovery, clientID, rawToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(oidcSigningMethods))
+	_, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.getKey(discovery.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, errors.New("invalid id token issuer")
+	}
+	if !claims.VerifyAudience(clientID, true) {
+		return nil, errors.New("invalid id token audience")
+	}
+	// The authorized party must be us if present or if there are multiple audiences
+	azp, _ := claims["azp"].(string)
+	if audiences, ok := claims["aud"].([]interface{}); (ok && len(audiences) > 1) || azp != "" {
+		if azp != clientID {
+			return nil, errors.New("invalid id token authorized party")
+		}
+	}
+	if tokenNonce, _ := claims["nonce"].(string); nonce == "" || tokenNonce != nonce {
+		return nil, errors.New("invalid id token nonce")
+	}
+	return claims, nil
+}
+
+// getKey returns the key with the given ID from the key set. The key set is
+// fetched again if the key is unknown, e.g. after a key rotation, but not more
+// often than once per oidcKeySetRefreshDelay.
+func (c *OIDCClient) getKey(jwksURI, kid string) (interface{}, error) {
+	c.mutex.Lock()
+	cached := c.keySets[jwksURI]
+	c.mutex.Unlock()
+	if cached == nil || time.Now().After(cached.fetched.Add(oidcCacheDuration)) ||
+		(cached.lookup(kid) == nil && time.Now().After(cached.fetched.Add(oidcKeySetRefreshDelay))) {
+		keySet := &oidcJSONWebKeySet{}
+		if err := c.fetchJSON(jwksURI, keySet); err != nil {
+			return nil, err
+		}
+		cached = &oidcCachedKeySet{
+			keys:    make(map[string]interface{}),
+			fetched: time.Now(),
+		}
+		for _, jwk := range keySet.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+			if key, err := jwk.publicKey(); err == nil {
+				cached.keys[jwk.Kid] = key
+			}
+		}
+		c.mutex.Lock()
+		c.keySets[jwksURI] = cached
+		c.mutex.Unlock()
+	}
+	if key := cached.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("signing key not found: " + kid)
+}
+
+func (c *OIDCClient) fetchJSON(url string, v interface{}) error {
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Received status code " + strconv.Itoa(resp.StatusCode) + " from " + url)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// lookup returns the key with the given ID. Tokens without a key ID can only
+// be verified if the key set contains a single key.
+func (ks *oidcCachedKeySet) lookup(kid string) interface{} {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key
+		}
+	}
+	return ks.keys[kid]
+}
+
+func (jwk *oidcJSONWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve: " + jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, errors.New("unsupported key type: " + jwk.Kty)
+}
+
+// GetOIDCNonce returns a new random nonce to be bound to the ID token.
+func GetOIDCNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
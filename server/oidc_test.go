@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type testOIDCProvider struct {
+	server        *httptest.Server
+	rsaKey        *rsa.PrivateKey
+	ecKey         *ecdsa.PrivateKey
+	issuer        string
+	jwksRequests  int
+	discoveryHits int
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testOIDCProvider{rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		p.discoveryHits++
+		json.NewEncoder(w).Encode(&OIDCDiscovery{
+			Issuer:                p.issuer,
+			AuthorizationEndpoint: p.issuer + "/authorize",
+			TokenEndpoint:         p.issuer + "/token",
+			JWKSURI:               p.issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.jwksRequests++
+		json.NewEncoder(w).Encode(&oidcJSONWebKeySet{
+			Keys: []*oidcJSONWebKey{
+				{
+					Kty: "RSA",
+					Kid: "rsa1",
+					Use: "sig",
+					N:   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+					E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					Kty: "EC",
+					Kid: "ec1",
+					Crv: "P-256",
+					X:   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+					Y:   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+				},
+			},
+		})
+	})
+	p.server = httptest.NewServer(mux)
+	p.issuer = p.server.URL
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testOIDCProvider) getClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   p.issuer,
+		"sub":   "123",
+		"aud":   "client1",
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "nonce1",
+		"email": "foo@test.com",
+	}
+}
+
+func (p *testOIDCProvider) sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	c := newOIDCClient()
+
+	discovery, err := c.GetDiscovery(p.issuer + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, p.issuer+"/authorize", discovery.AuthorizationEndpoint)
+	checkTestString(t, p.issuer+"/token", discovery.TokenEndpoint)
+
+	// Cached
+	_, err = c.GetDiscovery(p.issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, p.discoveryHits)
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	realIssuer := p.issuer
+	p.issuer = "https://evil.test"
+	c := newOIDCClient()
+
+	_, err := c.GetDiscovery(realIssuer)
+	checkTestBool(t, true, err != nil)
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	c := newOIDCClient()
+	discovery, err := c.GetDiscovery(p.issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, p.getClaims())
+	claims, err := c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "foo@test.com", claims["email"].(string))
+
+	token = p.sign(t, jwt.SigningMethodES256, "ec1", p.ecKey, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key set is cached
+	checkTestInt(t, 1, p.jwksRequests)
+}
+
+func TestOIDCVerifyIDTokenInvalid(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	c := newOIDCClient()
+	discovery, err := c.GetDiscovery(p.issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wrong nonce
+	token := p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce2")
+	checkTestBool(t, true, err != nil)
+
+	// Wrong audience
+	_, err = c.VerifyIDToken(discovery, "client2", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+
+	// Multiple audiences without authorized party
+	claims := p.getClaims()
+	claims["aud"] = []string{"client1", "client2"}
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, claims)
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+	claims["azp"] = "client1"
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, claims)
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err == nil)
+
+	// Wrong issuer
+	claims = p.getClaims()
+	claims["iss"] = "https://evil.test"
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, claims)
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+
+	// Expired
+	claims = p.getClaims()
+	claims["exp"] = time.Now().Add(-1 * time.Minute).Unix()
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, claims)
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+
+	// No expiry
+	claims = p.getClaims()
+	delete(claims, "exp")
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, claims)
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+
+	// Signed with an unknown key
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa1", otherKey, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+
+	// HMAC using the public key as secret
+	publicKey, _ := x509.MarshalPKIXPublicKey(&p.rsaKey.PublicKey)
+	token = p.sign(t, jwt.SigningMethodHS256, "rsa1", publicKey, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+
+	// Unsigned
+	token = p.sign(t, jwt.SigningMethodNone, "rsa1", jwt.UnsafeAllowNoneSignatureType, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+}
+
+func TestOIDCVerifyIDTokenUnknownKeyRefresh(t *testing.T) {
+	p := newTestOIDCProvider(t)
+	c := newOIDCClient()
+	discovery, err := c.GetDiscovery(p.issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := p.sign(t, jwt.SigningMethodRS256, "rsa1", p.rsaKey, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err == nil)
+
+	// Unknown key IDs do not cause the key set to be fetched again immediately
+	token = p.sign(t, jwt.SigningMethodRS256, "rsa2", p.rsaKey, p.getClaims())
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+	checkTestInt(t, 1, p.jwksRequests)
+
+	c.keySets[discovery.JWKSURI].fetched = time.Now().Add(-2 * oidcKeySetRefreshDelay)
+	_, err = c.VerifyIDToken(discovery, "client1", token, "nonce1")
+	checkTestBool(t, true, err != nil)
+	checkTestInt(t, 2, p.jwksRequests)
+}
@@ -219,12 +219,27 @@ func (r *OrganizationRepository) Update(e *Organization) error {
 }
 
 func (r *OrganizationRepository) Delete(e *Organization) error {
+	if err := GetAuthProviderRuleRepository().DeleteAll(e.ID); err != nil {
+		return err
+	}
 	if err := GetAuthProviderRepository().DeleteAll(e.ID); err != nil {
 		return err
 	}
+	if err := GetWebhookRepository().DeleteAll(e.ID); err != nil {
+		return err
+	}
+	if err := GetAuditLogRepository().DeleteAll(e.ID); err != nil {
+		return err
+	}
 	if err := GetLocationRepository().DeleteAll(e.ID); err != nil {
 		return err
 	}
+	if err := GetGroupRepository().DeleteAll(e.ID); err != nil {
+		return err
+	}
+	if err := GetSCIMTokenRepository().DeleteAll(e.ID); err != nil {
+		return err
+	}
 	if err := GetSettingsRepository().DeleteAll(e.ID); err != nil {
 		return err
 	}
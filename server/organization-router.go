@@ -38,15 +38,15 @@ type GetManageSubscriptionURLResponse struct {
 
 func (router *OrganizationRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/domain/{domain}", router.getOrgForDomain).Methods("GET")
-	s.HandleFunc("/{id}/domain/", router.getDomains).Methods("GET")
-	s.HandleFunc("/{id}/domain/{domain}/verify", router.verifyDomain).Methods("POST")
-	s.HandleFunc("/{id}/domain/{domain}", router.removeDomain).Methods("DELETE")
-	s.HandleFunc("/{id}/domain/{domain}", router.addDomain).Methods("POST")
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/{id}/domain/", router.getDomains).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/domain/{domain}/verify", router.verifyDomain).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/domain/{domain}", router.removeDomain).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/domain/{domain}", router.addDomain).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionSuperAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionSuperAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionSuperAdmin)
 }
 
 func (router *OrganizationRouter) getOrgForDomain(w http.ResponseWriter, r *http.Request) {
@@ -72,7 +72,7 @@ func (router *OrganizationRouter) getOne(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	user := GetRequestUser(r)
-	if !(GetUserRepository().isSuperAdmin(user) || CanAdminOrg(user, e.ID)) {
+	if !CanAccessOrg(user, e.ID) {
 		SendForbidden(w)
 		return
 	}
@@ -81,11 +81,6 @@ func (router *OrganizationRouter) getOne(w http.ResponseWriter, r *http.Request)
 }
 
 func (router *OrganizationRouter) getAll(w http.ResponseWriter, r *http.Request) {
-	user := GetRequestUser(r)
-	if !GetUserRepository().isSuperAdmin(user) {
-		SendForbidden(w)
-		return
-	}
 	list, err := GetOrganizationRepository().GetAll()
 	if err != nil {
 		log.Println(err)
@@ -109,7 +104,7 @@ func (router *OrganizationRouter) getDomains(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	user := GetRequestUser(r)
-	if !(GetUserRepository().isSuperAdmin(user) || CanAdminOrg(user, e.ID)) {
+	if !CanAccessOrg(user, e.ID) {
 		SendForbidden(w)
 		return
 	}
@@ -139,7 +134,7 @@ func (router *OrganizationRouter) addDomain(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	user := GetRequestUser(r)
-	if !(GetUserRepository().isSuperAdmin(user) || CanAdminOrg(user, e.ID)) {
+	if !CanAccessOrg(user, e.ID) {
 		SendForbidden(w)
 		return
 	}
@@ -162,6 +157,7 @@ func (router *OrganizationRouter) addDomain(w http.ResponseWriter, r *http.Reque
 		SendAleadyExists(w)
 		return
 	}
+	writeAuditLog(r, e.ID, AuditActionCreate, AuditEntityDomain, vars["domain"], nil, nil)
 	SendCreated(w, vars["domain"])
 }
 
@@ -174,7 +170,7 @@ func (router *OrganizationRouter) verifyDomain(w http.ResponseWriter, r *http.Re
 		return
 	}
 	user := GetRequestUser(r)
-	if !(GetUserRepository().isSuperAdmin(user) || CanAdminOrg(user, e.ID)) {
+	if !CanAccessOrg(user, e.ID) {
 		SendForbidden(w)
 		return
 	}
@@ -203,6 +199,7 @@ func (router *OrganizationRouter) verifyDomain(w http.ResponseWriter, r *http.Re
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.ID, AuditActionVerify, AuditEntityDomain, domain.DomainName, nil, nil)
 	SendUpdated(w)
 }
 
@@ -215,7 +212,7 @@ func (router *OrganizationRouter) removeDomain(w http.ResponseWriter, r *http.Re
 		return
 	}
 	user := GetRequestUser(r)
-	if !(GetUserRepository().isSuperAdmin(user) || CanAdminOrg(user, e.ID)) {
+	if !CanAccessOrg(user, e.ID) {
 		SendForbidden(w)
 		return
 	}
@@ -235,15 +232,11 @@ func (router *OrganizationRouter) removeDomain(w http.ResponseWriter, r *http.Re
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.ID, AuditActionDelete, AuditEntityDomain, vars["domain"], nil, nil)
 	SendUpdated(w)
 }
 
 func (router *OrganizationRouter) update(w http.ResponseWriter, r *http.Request) {
-	user := GetRequestUser(r)
-	if !GetUserRepository().isSuperAdmin(user) {
-		SendForbidden(w)
-		return
-	}
 	var m CreateOrganizationRequest
 	if UnmarshalValidateBody(r, &m) != nil {
 		SendBadRequest(w)
@@ -252,23 +245,25 @@ func (router *OrganizationRouter) update(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	e := router.copyFromRestModel(&m)
 	e.ID = vars["id"]
+	var before interface{}
+	if eOld, err := GetOrganizationRepository().GetOne(e.ID); err == nil {
+		before = router.copyToRestModel(eOld)
+	}
 	if err := GetOrganizationRepository().Update(e); err != nil {
 		log.Println(err)
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.ID, AuditActionUpdate, AuditEntityOrganization, e.ID, before, router.copyToRestModel(e))
 	SendUpdated(w)
 }
 
 func (router *OrganizationRouter) delete(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !(GetUserRepository().isSuperAdmin(user) || CanAdminOrg(user, user.OrganizationID)) {
-		SendForbidden(w)
-		return
-	}
-	if !GetUserRepository().isSuperAdmin(user) && CanAdminOrg(user, user.OrganizationID) {
+	if !GetUserRepository().isSuperAdmin(user) {
 		if !GetConfig().OrgSignupDelete {
 			SendForbidden(w)
+			return
 		}
 	}
 	vars := mux.Vars(r)
@@ -277,19 +272,19 @@ func (router *OrganizationRouter) delete(w http.ResponseWriter, r *http.Request)
 		SendNotFound(w)
 		return
 	}
+	if !CanAccessOrg(user, e.ID) {
+		SendForbidden(w)
+		return
+	}
 	if err := GetOrganizationRepository().Delete(e); err != nil {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.ID, AuditActionDelete, AuditEntityOrganization, e.ID, router.copyToRestModel(e), nil)
 	SendUpdated(w)
 }
 
 func (router *OrganizationRouter) create(w http.ResponseWriter, r *http.Request) {
-	user := GetRequestUser(r)
-	if !GetUserRepository().isSuperAdmin(user) {
-		SendForbidden(w)
-		return
-	}
 	var m CreateOrganizationRequest
 	if err := UnmarshalValidateBody(r, &m); err != nil {
 		SendBadRequest(w)
@@ -302,6 +297,7 @@ func (router *OrganizationRouter) create(w http.ResponseWriter, r *http.Request)
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.ID, AuditActionCreate, AuditEntityOrganization, e.ID, nil, router.copyToRestModel(e))
 	SendCreated(w, e.ID)
 }
 
@@ -482,3 +482,17 @@ func TestOrganizationsDelete(t *testing.T) {
 	checkTestInt(t, 0, len(users))
 
 }
+
+func TestOrganizationsDeleteOtherOrg(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	org2 := createTestOrg("test2.com")
+	user := createTestUserOrgAdmin(org)
+
+	req := newHTTPRequest("DELETE", "/organization/"+org2.ID, user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	_, err := GetOrganizationRepository().GetOne(org2.ID)
+	checkTestBool(t, true, err == nil)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Permission is required to access a route. Users are granted permissions by
+// their role, API tokens additionally restrict them to their scopes.
+type Permission string
+
+const (
+	// PermissionAccount is required by routes which don't declare a
+	// permission. It's granted to all users, but never to API tokens.
+	PermissionAccount       Permission = "account"
+	PermissionBookingsRead  Permission = "bookings:read"
+	PermissionBookingsWrite Permission = "bookings:write"
+	PermissionSpacesAdmin   Permission = "spaces:admin"
+	// PermissionLocationsAdmin is granted to space admins and to users who
+	// have been assigned the space admin role for at least one location.
+	// Handlers must check access to the location using CanSpaceAdminLocation.
+	PermissionLocationsAdmin Permission = "locations:admin"
+	PermissionOrgAdmin       Permission = "org:admin"
+	PermissionSuperAdmin     Permission = "super:admin"
+)
+
+var apiTokenScopePermissions = map[APITokenScope][]Permission{
+	APITokenScopeBookingsRead:  {PermissionBookingsRead},
+	APITokenScopeBookingsWrite: {PermissionBookingsWrite},
+	APITokenScopeAdminSpaces:   {PermissionSpacesAdmin, PermissionLocationsAdmin},
+}
+
+var routePermissions = make(map[*mux.Route]Permission)
+
+// RequirePermission declares the permission required to access the route. It's
+// meant to be called from a router's setupRoutes.
+func RequirePermission(route *mux.Route, permission Permission) *mux.Route {
+	routePermissions[route] = permission
+	return route
+}
+
+// GetRoutePermission returns the permission declared for the route matched by
+// the request.
+func GetRoutePermission(r *http.Request) Permission {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return PermissionAccount
+	}
+	if permission, ok := routePermissions[route]; ok {
+		return permission
+	}
+	return PermissionAccount
+}
+
+// GetRolePermissions returns the permissions granted by a role. Higher roles
+// include the permissions of the lower ones.
+func GetRolePermissions(role UserRole) []Permission {
+	res := []Permission{PermissionAccount, PermissionBookingsRead, PermissionBookingsWrite}
+	if int(role) >= int(UserRoleSpaceAdmin) {
+		res = append(res, PermissionSpacesAdmin, PermissionLocationsAdmin)
+	}
+	if int(role) >= int(UserRoleOrgAdmin) {
+		res = append(res, PermissionOrgAdmin)
+	}
+	if int(role) >= int(UserRoleSuperAdmin) {
+		res = append(res, PermissionSuperAdmin)
+	}
+	return res
+}
+
+// HasPermission returns true if the user has been granted the permission. If
+// the request has been authenticated using an API token, one of the token's
+// scopes must grant it as well.
+func HasPermission(user *User, token *APIToken, permission Permission) bool {
+	if !containsPermission(GetRolePermissions(user.Role), permission) && !hasLocationPermission(user, permission) {
+		return false
+	}
+	if token == nil {
+		return true
+	}
+	return hasScopePermission(token, permission)
+}
+
+// hasScopePermission returns true if one of the token's scopes grants the
+// permission.
+func hasScopePermission(token *APIToken, permission Permission) bool {
+	for _, scope := range token.Scopes {
+		if containsPermission(apiTokenScopePermissions[scope], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLocationPermission returns true if the permission is granted by a role
+// assigned to the user for at least one location.
+func hasLocationPermission(user *User, permission Permission) bool {
+	if permission != PermissionLocationsAdmin {
+		return false
+	}
+	return GetRoleAssignmentRepository().HasAnyLocationRole(user.ID, UserRoleSpaceAdmin)
+}
+
+func containsPermission(list []Permission, permission Permission) bool {
+	for _, p := range list {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPermissionMiddleware enforces the permissions declared for the routes.
+// It relies on VerifyAuthMiddleware having authenticated the request.
+func VerifyPermissionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" || GetRequestUserID(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user := GetRequestUser(r)
+		if user == nil {
+			SendUnauthorized(w)
+			return
+		}
+		if !HasPermission(user, GetRequestAPIToken(r), GetRoutePermission(r)) {
+			SendForbidden(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPermissionsRoles(t *testing.T) {
+	user := &User{Role: UserRoleUser}
+	checkTestBool(t, true, HasPermission(user, nil, PermissionAccount))
+	checkTestBool(t, true, HasPermission(user, nil, PermissionBookingsWrite))
+	checkTestBool(t, false, HasPermission(user, nil, PermissionSpacesAdmin))
+
+	spaceAdmin := &User{Role: UserRoleSpaceAdmin}
+	checkTestBool(t, true, HasPermission(spaceAdmin, nil, PermissionSpacesAdmin))
+	checkTestBool(t, false, HasPermission(spaceAdmin, nil, PermissionOrgAdmin))
+
+	orgAdmin := &User{Role: UserRoleOrgAdmin}
+	checkTestBool(t, true, HasPermission(orgAdmin, nil, PermissionSpacesAdmin))
+	checkTestBool(t, true, HasPermission(orgAdmin, nil, PermissionOrgAdmin))
+	checkTestBool(t, false, HasPermission(orgAdmin, nil, PermissionSuperAdmin))
+
+	superAdmin := &User{Role: UserRoleSuperAdmin}
+	checkTestBool(t, true, HasPermission(superAdmin, nil, PermissionSuperAdmin))
+}
+
+func TestPermissionsAPITokenScopes(t *testing.T) {
+	orgAdmin := &User{Role: UserRoleOrgAdmin}
+	token := &APIToken{Scopes: []APITokenScope{APITokenScopeAdminSpaces}}
+	checkTestBool(t, true, HasPermission(orgAdmin, token, PermissionSpacesAdmin))
+	checkTestBool(t, false, HasPermission(orgAdmin, token, PermissionOrgAdmin))
+	checkTestBool(t, false, HasPermission(orgAdmin, token, PermissionBookingsRead))
+	checkTestBool(t, false, HasPermission(orgAdmin, token, PermissionAccount))
+
+	// Scopes don't grant more than the user's role
+	user := &User{Role: UserRoleUser}
+	checkTestBool(t, false, HasPermission(user, token, PermissionSpacesAdmin))
+}
+
+func TestPermissionsRoutes(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	spaceAdmin := createTestUserInOrgWithName(org, uuid.New().String()+"@test.com", UserRoleSpaceAdmin)
+	orgAdmin := createTestUserOrgAdmin(org)
+
+	payload := `{"name": "Test"}`
+	req := newHTTPRequest("POST", "/location/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("POST", "/location/", spaceAdmin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/", spaceAdmin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/", orgAdmin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	req = newHTTPRequest("GET", "/organization/", orgAdmin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Routes without a declared permission are accessible to all users
+	req = newHTTPRequest("GET", "/preference/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
+
+func TestPermissionsAPITokenRoutes(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	token := createAPITokenTestToken(t, admin, `"admin:spaces"`)
+
+	req := newHTTPRequestWithAccessToken("GET", "/webhook/", token.Token, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/preference/", token.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	payload := `{"name": "Test"}`
+	req = newHTTPRequestWithAccessToken("POST", "/location/", token.Token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	// Tokens lose access if the user's role is downgraded
+	admin.Role = UserRoleUser
+	GetUserRepository().Update(admin)
+	req = newHTTPRequestWithAccessToken("POST", "/location/", token.Token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// Upper bound of occurrences a single recurring booking request may expand to
+const RecurrenceMaxOccurrences = 366
+
+var (
+	ErrRecurrenceUnsupportedFreq    = errors.New("recurrence frequency must be DAILY, WEEKLY, MONTHLY or YEARLY")
+	ErrRecurrenceUnbounded          = errors.New("recurrence rule must be limited by COUNT or UNTIL")
+	ErrRecurrenceTimeOfDay          = errors.New("recurrence rule must not specify BYHOUR, BYMINUTE or BYSECOND")
+	ErrRecurrenceTooManyOccurrences = errors.New("recurrence rule produces too many occurrences")
+	ErrRecurrenceOverlapping        = errors.New("recurrence rule produces overlapping occurrences")
+	ErrRecurrenceEmpty              = errors.New("recurrence rule produces no occurrences")
+)
+
+// parseRecurrenceRule parses an RFC 5545 RRULE string (with or without the
+// "RRULE:" prefix) and anchors it at dtstart. Local UNTIL values are
+// interpreted in the time zone of dtstart.
+func parseRecurrenceRule(s string, dtstart time.Time) (*rrule.RRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	option, err := rrule.StrToROptionInLocation(s, dtstart.Location())
+	if err != nil {
+		return nil, err
+	}
+	if option.Freq != rrule.DAILY && option.Freq != rrule.WEEKLY && option.Freq != rrule.MONTHLY && option.Freq != rrule.YEARLY {
+		return nil, ErrRecurrenceUnsupportedFreq
+	}
+	if option.Count == 0 && option.Until.IsZero() {
+		return nil, ErrRecurrenceUnbounded
+	}
+	if len(option.Byhour) > 0 || len(option.Byminute) > 0 || len(option.Bysecond) > 0 {
+		return nil, ErrRecurrenceTimeOfDay
+	}
+	option.Dtstart = dtstart
+	return rrule.NewRRule(*option)
+}
+
+// getWeeklyRecurrenceRule returns the rule used by the legacy dateUntil
+// parameter: repeat every 7 days until (and including) the given date.
+func getWeeklyRecurrenceRule(dtstart, until time.Time) (*rrule.RRule, error) {
+	return rrule.NewRRule(rrule.ROption{
+		Freq:    rrule.WEEKLY,
+		Dtstart: dtstart,
+		Until:   until,
+	})
+}
+
+// getRecurrenceOccurrences expands the rule into booking requests. Every
+// occurrence keeps the wall clock times of enter and leave, so bookings
+// don't shift across daylight saving time changes.
+func getRecurrenceOccurrences(rule *rrule.RRule, enter, leave time.Time) ([]*BookingRequest, error) {
+	dayOffset := getDaysBetween(enter, leave)
+	res := []*BookingRequest{}
+	next := rule.Iterator()
+	for {
+		occurrence, ok := next()
+		if !ok {
+			break
+		}
+		if len(res) >= RecurrenceMaxOccurrences {
+			return nil, ErrRecurrenceTooManyOccurrences
+		}
+		item := getOccurrenceOnDay(occurrence, dayOffset, enter, leave)
+		if len(res) > 0 && item.Enter.Before(res[len(res)-1].Leave) {
+			return nil, ErrRecurrenceOverlapping
+		}
+		res = append(res, item)
+	}
+	if len(res) == 0 {
+		return nil, ErrRecurrenceEmpty
+	}
+	return res, nil
+}
+
+// getShiftedOccurrence moves an existing occurrence by the given number of
+// days and applies the wall clock times (and time zone) of enter and leave.
+func getShiftedOccurrence(occurrence time.Time, days int, enter, leave time.Time) *BookingRequest {
+	return getOccurrenceOnDay(occurrence.AddDate(0, 0, days), getDaysBetween(enter, leave), enter, leave)
+}
+
+func getOccurrenceOnDay(day time.Time, dayOffset int, enter, leave time.Time) *BookingRequest {
+	return &BookingRequest{
+		Enter: time.Date(day.Year(), day.Month(), day.Day(), enter.Hour(), enter.Minute(), enter.Second(), enter.Nanosecond(), enter.Location()),
+		Leave: time.Date(day.Year(), day.Month(), day.Day()+dayOffset, leave.Hour(), leave.Minute(), leave.Second(), leave.Nanosecond(), leave.Location()),
+	}
+}
+
+// getDaysBetween returns the number of calendar days between the wall clock
+// dates of a and b.
+func getDaysBetween(a, b time.Time) int {
+	dayA := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	dayB := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(math.Round(dayB.Sub(dayA).Hours() / 24))
+}
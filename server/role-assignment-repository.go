@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+)
+
+type RoleAssignmentRepository struct {
+}
+
+// RoleAssignment grants a user a role for a single location, in addition to the
+// organization-wide role stored with the user. Currently, only
+// UserRoleSpaceAdmin can be assigned per location.
+type RoleAssignment struct {
+	ID         string
+	UserID     string
+	LocationID string
+	Role       UserRole
+}
+
+var roleAssignmentRepository *RoleAssignmentRepository
+var roleAssignmentRepositoryOnce sync.Once
+
+func GetRoleAssignmentRepository() *RoleAssignmentRepository {
+	roleAssignmentRepositoryOnce.Do(func() {
+		roleAssignmentRepository = &RoleAssignmentRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS role_assignments (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"user_id uuid NOT NULL, " +
+			"location_id uuid NOT NULL, " +
+			"role INTEGER NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_role_assignments_user_id_location_id_role ON role_assignments(user_id, location_id, role)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_role_assignments_location_id ON role_assignments(location_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return roleAssignmentRepository
+}
+
+func (r *RoleAssignmentRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+// Create adds the assignment. If the user already has the role for the
+// location, the existing assignment is kept.
+func (r *RoleAssignmentRepository) Create(e *RoleAssignment) error {
+	_, err := GetDatabase().DB().Exec("INSERT INTO role_assignments "+
+		"(user_id, location_id, role) "+
+		"VALUES ($1, $2, $3) "+
+		"ON CONFLICT (user_id, location_id, role) DO NOTHING",
+		e.UserID, e.LocationID, e.Role)
+	if err != nil {
+		return err
+	}
+	return GetDatabase().DB().QueryRow("SELECT id FROM role_assignments "+
+		"WHERE user_id = $1 AND location_id = $2 AND role = $3",
+		e.UserID, e.LocationID, e.Role).Scan(&e.ID)
+}
+
+func (r *RoleAssignmentRepository) GetAllByLocation(locationID string) ([]*RoleAssignment, error) {
+	return r.getAll("WHERE location_id = $1", locationID)
+}
+
+func (r *RoleAssignmentRepository) GetAllByUser(userID string) ([]*RoleAssignment, error) {
+	return r.getAll("WHERE user_id = $1", userID)
+}
+
+func (r *RoleAssignmentRepository) getAll(where string, arg string) ([]*RoleAssignment, error) {
+	var result []*RoleAssignment
+	rows, err := GetDatabase().DB().Query("SELECT id, user_id, location_id, role "+
+		"FROM role_assignments "+
+		where, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &RoleAssignment{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.LocationID, &e.Role)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// HasLocationRole returns true if the user has been assigned the role for the
+// location.
+func (r *RoleAssignmentRepository) HasLocationRole(userID, locationID string, role UserRole) bool {
+	var res int
+	err := GetDatabase().DB().QueryRow("SELECT COUNT(id) FROM role_assignments "+
+		"WHERE user_id = $1 AND location_id = $2 AND role = $3",
+		userID, locationID, role).Scan(&res)
+	return err == nil && res > 0
+}
+
+// HasAnyLocationRole returns true if the user has been assigned the role for
+// at least one location.
+func (r *RoleAssignmentRepository) HasAnyLocationRole(userID string, role UserRole) bool {
+	var res int
+	err := GetDatabase().DB().QueryRow("SELECT COUNT(id) FROM role_assignments "+
+		"WHERE user_id = $1 AND role = $2",
+		userID, role).Scan(&res)
+	return err == nil && res > 0
+}
+
+func (r *RoleAssignmentRepository) Delete(userID, locationID string, role UserRole) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM role_assignments "+
+		"WHERE user_id = $1 AND location_id = $2 AND role = $3",
+		userID, locationID, role)
+	return err
+}
@@ -27,8 +27,11 @@ func (c contextKey) String() string {
 }
 
 var (
-	contextKeyUserID     = contextKey("UserID")
-	contextKeyAuthHeader = contextKey("AuthHeader")
+	contextKeyUserID             = contextKey("UserID")
+	contextKeyUser               = contextKey("User")
+	contextKeyAuthHeader         = contextKey("AuthHeader")
+	contextKeyAPIToken           = contextKey("APIToken")
+	contextKeySCIMOrganizationID = contextKey("SCIMOrganizationID")
 )
 
 var (
@@ -40,6 +43,9 @@ var (
 	ResponseCodeBookingMaxConcurrentForUser      = 1006
 	ResponseCodeBookingInvalidMinBookingDuration = 1007
 	ResponseCodeBookingMaxHoursBeforeDelete      = 1008
+	ResponseCodeBookingCheckInNotPossible        = 1009
+	ResponseCodeBookingCheckOutNotPossible       = 1010
+	ResponseCodeBookingSpaceRestricted           = 1011
 )
 
 type Route interface {
@@ -51,6 +57,13 @@ func SendTemporaryRedirect(w http.ResponseWriter, url string) {
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
+// SendSeeOther redirects a POST request, causing the client to continue with
+// a GET request.
+func SendSeeOther(w http.ResponseWriter, url string) {
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
 func SendNotFound(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNotFound)
 }
@@ -109,6 +122,18 @@ func SendJSON(w http.ResponseWriter, v interface{}) {
 	w.Write(json)
 }
 
+func SendJSONWithStatus(w http.ResponseWriter, status int, v interface{}) {
+	json, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(json)
+}
+
 func SendTextNotFound(w http.ResponseWriter, contentType string, b []byte) {
 	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusNotFound)
@@ -206,13 +231,33 @@ func VerifyAuthMiddleware(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "+APITokenPrefix) {
+			token, user, err := verifyAPIToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+			if err != nil {
+				log.Println(err)
+				SendUnauthorized(w)
+				return
+			}
+			ctx := context.WithValue(r.Context(), contextKeyUserID, token.UserID)
+			ctx = context.WithValue(ctx, contextKeyUser, user)
+			ctx = context.WithValue(ctx, contextKeyAPIToken, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		claims, authHeader, err := ExtractClaimsFromRequest(r)
 		if err != nil {
 			log.Println(err)
 			SendUnauthorized(w)
 			return
 		}
+		user, err := GetUserRepository().GetOne(claims.UserID)
+		if err != nil {
+			log.Println(err)
+			SendUnauthorized(w)
+			return
+		}
 		ctx := context.WithValue(r.Context(), contextKeyUserID, claims.UserID)
+		ctx = context.WithValue(ctx, contextKeyUser, user)
 		ctx = context.WithValue(ctx, contextKeyAuthHeader, authHeader)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -246,7 +291,22 @@ func GetAuthHeaderFromContext(r *http.Request) string {
 	return authHeader.(string)
 }
 
+// GetRequestAPIToken returns the API token the request has been authenticated
+// with, or nil if it has been authenticated using a JWT.
+func GetRequestAPIToken(r *http.Request) *APIToken {
+	token := r.Context().Value(contextKeyAPIToken)
+	if token == nil {
+		return nil
+	}
+	return token.(*APIToken)
+}
+
+// GetRequestUser returns the user the request has been authenticated as. The
+// user is loaded once by VerifyAuthMiddleware.
 func GetRequestUser(r *http.Request) *User {
+	if user, ok := r.Context().Value(contextKeyUser).(*User); ok {
+		return user
+	}
 	ID := GetRequestUserID(r)
 	user, err := GetUserRepository().GetOne(ID)
 	if err != nil {
@@ -276,6 +336,19 @@ func CanSpaceAdminOrg(user *User, organizationID string) bool {
 	return false
 }
 
+// CanSpaceAdminLocation returns true if the user can manage the location,
+// either as a space admin of its organization or by a role assigned to the
+// user for the location.
+func CanSpaceAdminLocation(user *User, location *Location) bool {
+	if CanSpaceAdminOrg(user, location.OrganizationID) {
+		return true
+	}
+	if user.OrganizationID != location.OrganizationID {
+		return false
+	}
+	return GetRoleAssignmentRepository().HasLocationRole(user.ID, location.ID, UserRoleSpaceAdmin)
+}
+
 func CanAdminOrg(user *User, organizationID string) bool {
 	if (user.OrganizationID == organizationID) && (GetUserRepository().isOrgAdmin(user)) {
 		return true
@@ -313,4 +386,7 @@ var unauthorizedRoutes = [...]string{
 	"/fastspring/webhook",
 	"/confluence",
 	"/booking/debugtimeissues/",
+	"/ical/feed/",
+	"/preference/msgraph/callback",
+	"/scim/",
 }
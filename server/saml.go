@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	samlNamespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlNamespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlNamespaceMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlBindingRedirect    = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlBindingPost        = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlNameIDUnspecified  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	samlMaxClockSkew       = 3 * time.Minute
+	samlMaxMessageSize     = 1024 * 1024
+)
+
+// SAMLServiceProvider holds the settings of an auth provider of type SAML,
+// seen from our side as the service provider.
+type SAMLServiceProvider struct {
+	EntityID    string
+	ACSURL      string
+	IdPEntityID string
+	IdPSSOURL   string
+	IdPCerts    []*x509.Certificate
+	EmailAttr   string
+	RequestID   string
+}
+
+// SAMLAssertionInfo contains the data read from a validated assertion.
+type SAMLAssertionInfo struct {
+	NameID     string
+	Email      string
+	Attributes map[string][]string
+}
+
+// SAMLIdPMetadata contains the settings imported from an identity provider's
+// metadata document.
+type SAMLIdPMetadata struct {
+	EntityID    string
+	SSOURL      string
+	Certificate string
+}
+
+type samlAuthnRequest struct {
+	XMLName                     xml.Name `xml:"samlp:AuthnRequest"`
+	XMLNSSAMLP                  string   `xml:"xmlns:samlp,attr"`
+	XMLNSSAML                   string   `xml:"xmlns:saml,attr"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      string   `xml:"saml:Issuer"`
+	NameIDPolicy                struct {
+		Format      string `xml:"Format,attr"`
+		AllowCreate bool   `xml:"AllowCreate,attr"`
+	} `xml:"samlp:NameIDPolicy"`
+}
+
+type samlEntityDescriptor struct {
+	EntityID          string `xml:"entityID,attr"`
+	IDPSSODescriptors []struct {
+		KeyDescriptors []struct {
+			Use  string `xml:"use,attr"`
+			Cert string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+		SingleSignOnServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+}
+
+type samlEntitiesDescriptor struct {
+	EntityDescriptors []samlEntityDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+}
+
+type samlSPMetadata struct {
+	XMLName         xml.Name `xml:"md:EntityDescriptor"`
+	XMLNSMD         string   `xml:"xmlns:md,attr"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat               string `xml:"md:NameIDFormat"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		} `xml:"md:AssertionConsumerService"`
+	} `xml:"md:SPSSODescriptor"`
+}
+
+// GetAuthnRequestURL returns the URL the user is redirected to in order to
+// authenticate at the identity provider, using the HTTP-Redirect binding.
+func (sp *SAMLServiceProvider) GetAuthnRequestURL(relayState string) (string, error) {
+	req := &samlAuthnRequest{
+		XMLNSSAMLP:                  samlNamespaceProtocol,
+		XMLNSSAML:                   samlNamespaceAssertion,
+		ID:                          sp.RequestID,
+		Version:                     "2.0",
+		IssueInstant:                time.Now().UTC().Format(time.RFC3339),
+		Destination:                 sp.IdPSSOURL,
+		AssertionConsumerServiceURL: sp.ACSURL,
+		ProtocolBinding:             samlBindingPost,
+		Issuer:                      sp.EntityID,
+	}
+	req.NameIDPolicy.Format = samlNameIDUnspecified
+	req.NameIDPolicy.AllowCreate = true
+	data, err := xml.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	writer.Write(data)
+	writer.Close()
+	u, err := url.Parse(sp.IdPSSOURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	query.Set("RelayState", relayState)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// GetMetadata returns the service provider's metadata document to be imported
+// by the identity provider.
+func (sp *SAMLServiceProvider) GetMetadata() ([]byte, error) {
+	m := &samlSPMetadata{
+		XMLNSMD:  samlNamespaceMetadata,
+		EntityID: sp.EntityID,
+	}
+	m.SPSSODescriptor.WantAssertionsSigned = true
+	m.SPSSODescriptor.ProtocolSupportEnumeration = samlNamespaceProtocol
+	m.SPSSODescriptor.NameIDFormat = samlNameIDUnspecified
+	m.SPSSODescriptor.AssertionConsumerService.Binding = samlBindingPost
+	m.SPSSODescriptor.AssertionConsumerService.Location = sp.ACSURL
+	data, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ParseResponse validates the base64 encoded SAMLResponse received at the
+// assertion consumer service. Either the response or the assertion must be
+// signed by one of the identity provider's certificates. Only data covered by
+// the signature is read.
+func (sp *SAMLServiceProvider) ParseResponse(encoded string, now time.Time) (*SAMLAssertionInfo, error) {
+	data, err := base64.StdEncoding.DecodeString(removeXMLWhitespace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	response, err := parseSAMLDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !samlIs(response, samlNamespaceProtocol, "Response") {
+		return nil, errors.New("not a saml response")
+	}
+	responseSigned := samlChildElement(response, dsig.Namespace, "Signature") != nil
+	if responseSigned {
+		if response, err = sp.verifySignature(response, now); err != nil {
+			return nil, err
+		}
+	}
+	if response.SelectAttrValue("Version", "") != "2.0" {
+		return nil, errors.New("unsupported saml version")
+	}
+	if destination := response.SelectAttrValue("Destination", ""); destination != "" && destination != sp.ACSURL {
+		return nil, errors.New("invalid destination")
+	}
+	if response.SelectAttrValue("InResponseTo", "") != sp.RequestID {
+		return nil, errors.New("response does not match request")
+	}
+	if err := sp.validateIssuer(response); err != nil {
+		return nil, err
+	}
+	status := samlChildElement(response, samlNamespaceProtocol, "Status")
+	if status == nil {
+		return nil, errors.New("missing status")
+	}
+	statusCode := samlChildElement(status, samlNamespaceProtocol, "StatusCode")
+	if statusCode == nil || statusCode.SelectAttrValue("Value", "") != samlStatusSuccess {
+		return nil, errors.New("authentication failed at identity provider")
+	}
+	if len(samlChildElements(response, samlNamespaceAssertion, "EncryptedAssertion")) > 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := samlChildElements(response, samlNamespaceAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("expected exactly one assertion")
+	}
+	assertion := assertions[0]
+	if samlChildElement(assertion, dsig.Namespace, "Signature") != nil || !responseSigned {
+		if assertion, err = sp.verifySignature(assertion, now); err != nil {
+			return nil, err
+		}
+	}
+	return sp.parseAssertion(assertion, now)
+}
+
+// verifySignature checks the enveloped signature of the element and returns
+// the signed content, which is what must be read afterwards. The element is
+// detached from its parent first, keeping the inherited namespaces.
+func (sp *SAMLServiceProvider) verifySignature(e *etree.Element, now time.Time) (*etree.Element, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(e)
+	if err != nil {
+		return nil, err
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, e)
+	if err != nil {
+		return nil, err
+	}
+	err = errors.New("no identity provider certificate")
+	for _, cert := range sp.IdPCerts {
+		ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{cert},
+		})
+		ctx.Clock = dsig.NewFakeClockAt(now)
+		var res *etree.Element
+		if res, err = ctx.Validate(detached); err == nil {
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (sp *SAMLServiceProvider) validateIssuer(e *etree.Element) error {
+	issuer := samlChildElement(e, samlNamespaceAssertion, "Issuer")
+	if issuer == nil {
+		// The issuer is optional in responses, but not in assertions
+		if samlIs(e, samlNamespaceAssertion, "Assertion") {
+			return errors.New("missing issuer")
+		}
+		return nil
+	}
+	if sp.IdPEntityID != "" && samlText(issuer) != sp.IdPEntityID {
+		return errors.New("invalid issuer")
+	}
+	return nil
+}
+
+func (sp *SAMLServiceProvider) parseAssertion(assertion *etree.Element, now time.Time) (*SAMLAssertionInfo, error) {
+	if err := sp.validateIssuer(assertion); err != nil {
+		return nil, err
+	}
+	subject := samlChildElement(assertion, samlNamespaceAssertion, "Subject")
+	if subject == nil {
+		return nil, errors.New("missing subject")
+	}
+	if err := sp.validateSubjectConfirmation(subject, now); err != nil {
+		return nil, err
+	}
+	if err := sp.validateConditions(samlChildElement(assertion, samlNamespaceAssertion, "Conditions"), now); err != nil {
+		return nil, err
+	}
+	res := &SAMLAssertionInfo{
+		Attributes: make(map[string][]string),
+	}
+	if nameID := samlChildElement(subject, samlNamespaceAssertion, "NameID"); nameID != nil {
+		res.NameID = samlText(nameID)
+	}
+	for _, statement := range samlChildElements(assertion, samlNamespaceAssertion, "AttributeStatement") {
+		for _, attribute := range samlChildElements(statement, samlNamespaceAssertion, "Attribute") {
+			values := []string{}
+			for _, value := range samlChildElements(attribute, samlNamespaceAssertion, "AttributeValue") {
+				values = append(values, samlText(value))
+			}
+			name := attribute.SelectAttrValue("Name", "")
+			res.Attributes[name] = append(res.Attributes[name], values...)
+			if friendlyName := attribute.SelectAttrValue("FriendlyName", ""); friendlyName != "" && friendlyName != name {
+				res.Attributes[friendlyName] = append(res.Attributes[friendlyName], values...)
+			}
+		}
+	}
+	if sp.EmailAttr == "" {
+		res.Email = res.NameID
+	} else if values := res.Attributes[sp.EmailAttr]; len(values) > 0 {
+		res.Email = values[0]
+	}
+	if strings.TrimSpace(res.Email) == "" {
+		return nil, errors.New("could not read email address")
+	}
+	return res, nil
+}
+
+func (sp *SAMLServiceProvider) validateSubjectConfirmation(subject *etree.Element, now time.Time) error {
+	for _, confirmation := range samlChildElements(subject, samlNamespaceAssertion, "SubjectConfirmation") {
+		if confirmation.SelectAttrValue("Method", "") != samlConfirmationBearer {
+			continue
+		}
+		data := samlChildElement(confirmation, samlNamespaceAssertion, "SubjectConfirmationData")
+		if data == nil {
+			continue
+		}
+		if data.SelectAttrValue("Recipient", "") != sp.ACSURL {
+			continue
+		}
+		if inResponseTo := data.SelectAttrValue("InResponseTo", ""); inResponseTo != "" && inResponseTo != sp.RequestID {
+			continue
+		}
+		notOnOrAfter, err := time.Parse(time.RFC3339, data.SelectAttrValue("NotOnOrAfter", ""))
+		if err != nil || !now.Before(notOnOrAfter.Add(samlMaxClockSkew)) {
+			continue
+		}
+		return nil
+	}
+	return errors.New("no valid bearer subject confirmation")
+}
+
+func (sp *SAMLServiceProvider) validateConditions(conditions *etree.Element, now time.Time) error {
+	if conditions == nil {
+		return errors.New("missing conditions")
+	}
+	if s := conditions.SelectAttrValue("NotBefore", ""); s != "" {
+		notBefore, err := time.Parse(time.RFC3339, s)
+		if err != nil || now.Add(samlMaxClockSkew).Before(notBefore) {
+			return errors.New("assertion not yet valid")
+		}
+	}
+	if s := conditions.SelectAttrValue("NotOnOrAfter", ""); s != "" {
+		notOnOrAfter, err := time.Parse(time.RFC3339, s)
+		if err != nil || !now.Before(notOnOrAfter.Add(samlMaxClockSkew)) {
+			return errors.New("assertion expired")
+		}
+	}
+	restrictions := samlChildElements(conditions, samlNamespaceAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return errors.New("missing audience restriction")
+	}
+	// Each restriction must be satisfied by at least one of its audiences
+	for _, restriction := range restrictions {
+		valid := false
+		for _, audience := range samlChildElements(restriction, samlNamespaceAssertion, "Audience") {
+			if samlText(audience) == sp.EntityID {
+				valid = true
+			}
+		}
+		if !valid {
+			return errors.New("invalid audience")
+		}
+	}
+	return nil
+}
+
+// ParseSAMLIdPMetadata reads the entity ID, single sign-on URL and signing
+// certificates from an identity provider's metadata document.
+func ParseSAMLIdPMetadata(data []byte) (*SAMLIdPMetadata, error) {
+	var entities []samlEntityDescriptor
+	var entity samlEntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil && len(entity.IDPSSODescriptors) > 0 {
+		entities = append(entities, entity)
+	} else {
+		var list samlEntitiesDescriptor
+		if err := xml.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		entities = list.EntityDescriptors
+	}
+	for _, entity := range entities {
+		for _, idp := range entity.IDPSSODescriptors {
+			res := &SAMLIdPMetadata{
+				EntityID: entity.EntityID,
+			}
+			for _, sso := range idp.SingleSignOnServices {
+				if sso.Binding == samlBindingRedirect {
+					res.SSOURL = sso.Location
+				}
+			}
+			certs := []string{}
+			for _, key := range idp.KeyDescriptors {
+				if (key.Use == "" || key.Use == "signing") && strings.TrimSpace(key.Cert) != "" {
+					der, err := base64.StdEncoding.DecodeString(removeXMLWhitespace(key.Cert))
+					if err != nil {
+						return nil, err
+					}
+					certs = append(certs, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+				}
+			}
+			res.Certificate = strings.Join(certs, "")
+			if res.SSOURL == "" || res.Certificate == "" {
+				return nil, errors.New("identity provider does not support the redirect binding or has no signing certificate")
+			}
+			return res, nil
+		}
+	}
+	return nil, errors.New("no identity provider found in metadata")
+}
+
+// ParseSAMLCertificates parses one or more PEM encoded certificates. Base64
+// encoded DER certificates without PEM headers, as found in metadata
+// documents, are accepted as well.
+func ParseSAMLCertificates(s string) ([]*x509.Certificate, error) {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "-----") {
+		der, err := base64.StdEncoding.DecodeString(removeXMLWhitespace(s))
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+	res := []*x509.Certificate{}
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cert)
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return res, nil
+}
+
+// parseSAMLDocument parses a SAML message and returns its root element. DTDs
+// are rejected.
+func parseSAMLDocument(data []byte) (*etree.Element, error) {
+	if len(data) > samlMaxMessageSize {
+		return nil, errors.New("saml message too large")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+	for _, token := range doc.Child {
+		if _, ok := token.(*etree.Directive); ok {
+			return nil, errors.New("dtd not allowed")
+		}
+	}
+	if doc.Root() == nil {
+		return nil, errors.New("empty saml message")
+	}
+	return doc.Root(), nil
+}
+
+func samlIs(e *etree.Element, namespace, local string) bool {
+	return e.Tag == local && e.NamespaceURI() == namespace
+}
+
+func samlChildElements(e *etree.Element, namespace, local string) []*etree.Element {
+	res := []*etree.Element{}
+	for _, child := range e.ChildElements() {
+		if samlIs(child, namespace, local) {
+			res = append(res, child)
+		}
+	}
+	return res
+}
+
+// samlChildElement returns the first child element with the given name or nil.
+func samlChildElement(e *etree.Element, namespace, local string) *etree.Element {
+	children := samlChildElements(e, namespace, local)
+	if len(children) == 0 {
+		return nil
+	}
+	return children[0]
+}
+
+// samlText returns the element's character data without comments.
+func samlText(e *etree.Element) string {
+	var sb strings.Builder
+	for _, token := range e.Child {
+		if data, ok := token.(*etree.CharData); ok {
+			sb.WriteString(data.Data)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func removeXMLWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
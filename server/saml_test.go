@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const (
+	testSAMLACSURL   = "https://sp.test/auth/1/saml/acs"
+	testSAMLEntityID = "https://sp.test/auth/1/saml/metadata"
+	testSAMLIdP      = "https://idp.test/"
+)
+
+func getTestSAMLKeyPair(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.test"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func getTestSAMLServiceProvider(cert *x509.Certificate) *SAMLServiceProvider {
+	return &SAMLServiceProvider{
+		EntityID:    testSAMLEntityID,
+		ACSURL:      testSAMLACSURL,
+		IdPEntityID: testSAMLIdP,
+		IdPSSOURL:   testSAMLIdP + "sso",
+		IdPCerts:    []*x509.Certificate{cert},
+		EmailAttr:   "mail",
+		RequestID:   "_req1",
+	}
+}
+
+// signTestXML replaces the {SIG} placeholder with an enveloped signature over
+// the element with the given ID.
+func signTestXML(t *testing.T, key *rsa.PrivateKey, cert *x509.Certificate, doc, id string) string {
+	root, err := parseSAMLDocument([]byte(strings.Replace(doc, "{SIG}", "", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := root.FindElement("//[@ID='" + id + "']")
+	nsCtx, err := etreeutils.NSBuildParentContext(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detached, err := etreeutils.NSDetatch(nsCtx, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := dsig.NewSigningContext(key, [][]byte{cert.Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := ctx.SignEnveloped(detached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := signed.SelectElement("ds:Signature")
+	signatureDoc := etree.NewDocument()
+	signatureDoc.SetRoot(signature.Copy())
+	s, err := signatureDoc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(doc, "{SIG}", s, 1)
+}
+
+func getTestSAMLResponse(audience string, notOnOrAfter time.Time) string {
+	now := time.Now().UTC()
+	return fmt.Sprintf(`<samlp:Response xmlns:samlp="%s" xmlns:saml="%s" ID="_resp1" Version="2.0" IssueInstant="%s" Destination="%s" InResponseTo="_req1">
+  <saml:Issuer>%s</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="%s"/></samlp:Status>
+  <saml:Assertion ID="_assertion1" Version="2.0" IssueInstant="%s">
+    <saml:Issuer>%s</saml:Issuer>{SIG}
+    <saml:Subject>
+      <saml:NameID>jdoe</saml:NameID>
+      <saml:SubjectConfirmation Method="%s">
+        <saml:SubjectConfirmationData Recipient="%s" NotOnOrAfter="%s" InResponseTo="_req1"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="%s" NotOnOrAfter="%s">
+      <saml:AudienceRestriction><saml:Audience>%s</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="urn:oid:0.9.2342.19200300.100.1.3" FriendlyName="mail"><saml:AttributeValue>jdoe@test.com</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="groups"><saml:AttributeValue>Staff</saml:AttributeValue><saml:AttributeValue>Admins</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`,
+		samlNamespaceProtocol, samlNamespaceAssertion, now.Format(time.RFC3339), testSAMLACSURL,
+		testSAMLIdP,
+		samlStatusSuccess,
+		now.Format(time.RFC3339),
+		testSAMLIdP,
+		samlConfirmationBearer,
+		testSAMLACSURL, notOnOrAfter.Format(time.RFC3339),
+		now.Add(-1*time.Minute).Format(time.RFC3339), notOnOrAfter.Format(time.RFC3339),
+		audience)
+}
+
+func encodeTestSAMLResponse(doc string) string {
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+func TestSAMLParseResponse(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := signTestXML(t, key, cert, getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "_assertion1")
+
+	res, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "jdoe", res.NameID)
+	checkTestString(t, "jdoe@test.com", res.Email)
+	checkTestInt(t, 1, len(res.Attributes["urn:oid:0.9.2342.19200300.100.1.3"]))
+	checkTestInt(t, 2, len(res.Attributes["groups"]))
+	checkTestString(t, "Admins", res.Attributes["groups"][1])
+
+	// NameID is used if no email attribute is configured
+	sp.EmailAttr = ""
+	res, err = sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "jdoe", res.Email)
+}
+
+func TestSAMLParseResponseSignedResponse(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute))
+	doc = strings.Replace(doc, "{SIG}", "", 1)
+	doc = strings.Replace(doc, "<samlp:Status>", "{SIG}<samlp:Status>", 1)
+	doc = signTestXML(t, key, cert, doc, "_resp1")
+
+	res, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "jdoe@test.com", res.Email)
+}
+
+func TestSAMLParseResponseUnsigned(t *testing.T) {
+	_, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := strings.Replace(getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "{SIG}", "", 1)
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseWrongCertificate(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	_, otherCert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(otherCert)
+	doc := signTestXML(t, key, cert, getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "_assertion1")
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseTampered(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := signTestXML(t, key, cert, getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "_assertion1")
+	doc = strings.Replace(doc, "jdoe@test.com", "admin@test.com", 1)
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseWrapped(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := signTestXML(t, key, cert, getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "_assertion1")
+	// Add a second, unsigned assertion with the same ID
+	start := strings.Index(doc, "<saml:Assertion ")
+	end := strings.Index(doc, "</saml:Assertion>") + len("</saml:Assertion>")
+	evil := strings.Replace(doc[start:end], "jdoe@test.com", "admin@test.com", 1)
+	doc = doc[:start] + evil + doc[start:]
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseOneLogin(t *testing.T) {
+	// Response captured from OneLogin, taken from the test data of
+	// github.com/crewjam/saml (BSD-2-Clause). Only the response is signed and
+	// the assertion redeclares inherited namespaces.
+	encoded, err := os.ReadFile("testdata/saml-onelogin-response.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("testdata/saml-onelogin-idp-metadata.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := parseSAMLDocument(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseSAMLCertificates(metadata.FindElement("//ds:X509Certificate").Text())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := &SAMLServiceProvider{
+		EntityID:    "https://29ee6d2e.ngrok.io/saml/metadata",
+		ACSURL:      "https://29ee6d2e.ngrok.io/saml/acs",
+		IdPEntityID: metadata.SelectAttrValue("entityID", ""),
+		IdPCerts:    certs,
+		EmailAttr:   "User.email",
+		RequestID:   "id-d40c15c104b52691eccf0a2a5c8a15595be75423",
+	}
+	now := time.Date(2016, 1, 5, 17, 53, 12, 0, time.UTC)
+	res, err := sp.ParseResponse(string(encoded), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "ross@kndr.org", res.NameID)
+	checkTestString(t, "ross@kndr.org", res.Email)
+	checkTestString(t, "Kinder", res.Attributes["User.LastName"][0])
+
+	// Tampering with the signed content is detected
+	doc, _ := base64.StdEncoding.DecodeString(removeXMLWhitespace(string(encoded)))
+	tampered := strings.Replace(string(doc), ">Kinder<", ">Evil<", 1)
+	_, err = sp.ParseResponse(encodeTestSAMLResponse(tampered), now)
+	checkTestBool(t, true, err != nil)
+
+	// The signing certificate has expired meanwhile
+	_, err = sp.ParseResponse(string(encoded), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseRejectsDTD(t *testing.T) {
+	_, err := parseSAMLDocument([]byte(`<!DOCTYPE a [<!ENTITY e "x">]><a>&e;</a>`))
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseWrongAudience(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := signTestXML(t, key, cert, getTestSAMLResponse("https://other.test/", time.Now().Add(5*time.Minute)), "_assertion1")
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseExpired(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	doc := signTestXML(t, key, cert, getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "_assertion1")
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now().Add(10*time.Minute))
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLParseResponseWrongRequest(t *testing.T) {
+	key, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	sp.RequestID = "_req2"
+	doc := signTestXML(t, key, cert, getTestSAMLResponse(testSAMLEntityID, time.Now().Add(5*time.Minute)), "_assertion1")
+
+	_, err := sp.ParseResponse(encodeTestSAMLResponse(doc), time.Now())
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSAMLAuthnRequestURL(t *testing.T) {
+	_, cert := getTestSAMLKeyPair(t)
+	sp := getTestSAMLServiceProvider(cert)
+	s, err := sp.GetAuthnRequestURL("state1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "idp.test", u.Host)
+	checkTestString(t, "/sso", u.Path)
+	checkTestString(t, "state1", u.Query().Get("RelayState"))
+
+	compressed, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := parseSAMLDocument(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestBool(t, true, samlIs(req, samlNamespaceProtocol, "AuthnRequest"))
+	checkTestString(t, "_req1", req.SelectAttrValue("ID", ""))
+	checkTestString(t, testSAMLACSURL, req.SelectAttrValue("AssertionConsumerServiceURL", ""))
+	checkTestString(t, testSAMLEntityID, samlText(samlChildElement(req, samlNamespaceAssertion, "Issuer")))
+}
+
+func TestSAMLParseIdPMetadata(t *testing.T) {
+	_, cert := getTestSAMLKeyPair(t)
+	metadata := `<?xml version="1.0"?>
+<md:EntityDescriptor xmlns:md="` + samlNamespaceMetadata + `" xmlns:ds="` + dsig.Namespace + `" entityID="` + testSAMLIdP + `">
+  <md:IDPSSODescriptor protocolSupportEnumeration="` + samlNamespaceProtocol + `">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(cert.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:SingleSignOnService Binding="` + samlBindingPost + `" Location="https://idp.test/sso/post"/>
+    <md:SingleSignOnService Binding="` + samlBindingRedirect + `" Location="https://idp.test/sso/redirect"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+
+	res, err := ParseSAMLIdPMetadata([]byte(metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, testSAMLIdP, res.EntityID)
+	checkTestString(t, "https://idp.test/sso/redirect", res.SSOURL)
+	block, _ := pem.Decode([]byte(res.Certificate))
+	checkTestBool(t, true, block != nil && bytes.Equal(cert.Raw, block.Bytes))
+
+	certs, err := ParseSAMLCertificates(res.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, len(certs))
+	certs, err = ParseSAMLCertificates(base64.StdEncoding.EncodeToString(cert.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, len(certs))
+}
@@ -0,0 +1,833 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SCIMRouter implements the subset of SCIM 2.0 (RFC 7643, RFC 7644) required by
+// identity providers to provision users and groups. Requests are authenticated
+// using an organization's SCIM token instead of a user's credentials.
+type SCIMRouter struct {
+}
+
+const (
+	scimSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimContentType                 = "application/scim+json"
+	scimMaxResults                  = 200
+)
+
+var (
+	scimFilterRegexp     = regexp.MustCompile(`^\s*(\w+)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+	scimMemberPathRegexp = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+"([^"]*)"\s*\]$`)
+)
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMUser struct {
+	Schemas  []string    `json:"schemas"`
+	ID       string      `json:"id,omitempty"`
+	UserName string      `json:"userName"`
+	Active   *bool       `json:"active,omitempty"`
+	Emails   []SCIMEmail `json:"emails,omitempty"`
+	Meta     *SCIMMeta   `json:"meta,omitempty"`
+}
+
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+type SCIMSupported struct {
+	Supported bool `json:"supported"`
+}
+
+type SCIMFilterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type SCIMServiceProviderConfig struct {
+	Schemas        []string            `json:"schemas"`
+	Patch          SCIMSupported       `json:"patch"`
+	Bulk           SCIMSupported       `json:"bulk"`
+	Filter         SCIMFilterSupported `json:"filter"`
+	ChangePassword SCIMSupported       `json:"changePassword"`
+	Sort           SCIMSupported       `json:"sort"`
+	ETag           SCIMSupported       `json:"etag"`
+}
+
+func (router *SCIMRouter) setupRoutes(s *mux.Router) {
+	s.Use(router.verifyTokenMiddleware)
+	s.HandleFunc("/ServiceProviderConfig", router.getServiceProviderConfig).Methods("GET")
+	s.HandleFunc("/Users/{id}", router.getUser).Methods("GET")
+	s.HandleFunc("/Users/{id}", router.replaceUser).Methods("PUT")
+	s.HandleFunc("/Users/{id}", router.patchUser).Methods("PATCH")
+	s.HandleFunc("/Users/{id}", router.deleteUser).Methods("DELETE")
+	s.HandleFunc("/Users", router.createUser).Methods("POST")
+	s.HandleFunc("/Users", router.getUsers).Methods("GET")
+	s.HandleFunc("/Groups/{id}", router.getGroup).Methods("GET")
+	s.HandleFunc("/Groups/{id}", router.replaceGroup).Methods("PUT")
+	s.HandleFunc("/Groups/{id}", router.patchGroup).Methods("PATCH")
+	s.HandleFunc("/Groups/{id}", router.deleteGroup).Methods("DELETE")
+	s.HandleFunc("/Groups", router.createGroup).Methods("POST")
+	s.HandleFunc("/Groups", router.getGroups).Methods("GET")
+}
+
+// verifyTokenMiddleware authenticates the request using the SCIM token and
+// stores the token's organization in the request's context.
+func (router *SCIMRouter) verifyTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authHeader, "Bearer "+SCIMTokenPrefix) {
+			router.sendError(w, http.StatusUnauthorized, "", "missing or invalid bearer token")
+			return
+		}
+		token, err := GetSCIMTokenRepository().GetByToken(strings.TrimPrefix(authHeader, "Bearer "))
+		if err != nil {
+			router.sendError(w, http.StatusUnauthorized, "", "missing or invalid bearer token")
+			return
+		}
+		now := time.Now().UTC()
+		if token.LastUsed == nil || token.LastUsed.Add(APITokenLastUsedInterval).Before(now) {
+			if err := GetSCIMTokenRepository().UpdateLastUsed(token, now); err != nil {
+				log.Println(err)
+			}
+		}
+		ctx := context.WithValue(r.Context(), contextKeySCIMOrganizationID, token.OrganizationID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (router *SCIMRouter) getServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	res := &SCIMServiceProviderConfig{
+		Schemas: []string{scimSchemaServiceProviderConfig},
+		Patch:   SCIMSupported{Supported: true},
+		Filter:  SCIMFilterSupported{Supported: true, MaxResults: scimMaxResults},
+	}
+	router.send(w, http.StatusOK, res)
+}
+
+func (router *SCIMRouter) getUsers(w http.ResponseWriter, r *http.Request) {
+	organizationID := router.getOrganizationID(r)
+	startIndex, count := router.getPagination(r)
+	res := []interface{}{}
+	total := 0
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		value, ok := router.parseFilter(filter, "userName")
+		if !ok {
+			router.sendError(w, http.StatusBadRequest, "invalidFilter", "only filtering by userName is supported")
+			return
+		}
+		if e, err := GetUserRepository().GetByEmail(value); err == nil && e.OrganizationID == organizationID {
+			res = append(res, router.copyToSCIMUser(e))
+			total = 1
+		}
+	} else {
+		list, err := GetUserRepository().GetAll(organizationID, count, startIndex-1)
+		if err != nil {
+			log.Println(err)
+			router.sendError(w, http.StatusInternalServerError, "", "")
+			return
+		}
+		for _, e := range list {
+			res = append(res, router.copyToSCIMUser(e))
+		}
+		if total, err = GetUserRepository().GetCount(organizationID); err != nil {
+			log.Println(err)
+			router.sendError(w, http.StatusInternalServerError, "", "")
+			return
+		}
+	}
+	router.sendList(w, res, total, startIndex)
+}
+
+func (router *SCIMRouter) getUser(w http.ResponseWriter, r *http.Request) {
+	e := router.getUserFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	router.send(w, http.StatusOK, router.copyToSCIMUser(e))
+}
+
+// createUser creates a user in the token's organization, subject to the
+// organization's subscription limits and domains.
+func (router *SCIMRouter) createUser(w http.ResponseWriter, r *http.Request) {
+	var m SCIMUser
+	if err := UnmarshalBody(r, &m); err != nil {
+		router.sendError(w, http.StatusBadRequest, "invalidSyntax", "")
+		return
+	}
+	org, err := GetOrganizationRepository().GetOne(router.getOrganizationID(r))
+	if err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(m.UserName))
+	if !GetOrganizationRepository().isValidEmailForOrg(email, org) {
+		router.sendError(w, http.StatusBadRequest, "invalidValue", "userName must be an email address of one of the organization's domains")
+		return
+	}
+	if _, err := GetUserRepository().GetByEmail(email); err == nil {
+		router.sendError(w, http.StatusConflict, "uniqueness", "userName already exists")
+		return
+	}
+	if !GetUserRepository().canCreateUser(org) {
+		router.sendError(w, http.StatusPaymentRequired, "", "maximum number of users reached")
+		return
+	}
+	e := &User{
+		Email:          email,
+		OrganizationID: org.ID,
+		Role:           UserRoleUser,
+		Disabled:       m.Active != nil && !*m.Active,
+	}
+	if err := GetUserRepository().Create(e); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	fireUserWebhookEvent(e, WebhookEventUserCreated)
+	res := router.copyToSCIMUser(e)
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityUser, e.ID, nil, res)
+	w.Header().Set("Location", res.Meta.Location)
+	router.send(w, http.StatusCreated, res)
+}
+
+func (router *SCIMRouter) replaceUser(w http.ResponseWriter, r *http.Request) {
+	e := router.getUserFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	var m SCIMUser
+	if err := UnmarshalBody(r, &m); err != nil {
+		router.sendError(w, http.StatusBadRequest, "invalidSyntax", "")
+		return
+	}
+	eNew := *e
+	eNew.Disabled = m.Active != nil && !*m.Active
+	router.updateUser(w, r, e, &eNew, m.UserName)
+}
+
+// patchUser applies the operations on the userName and active attributes.
+// Operations on attributes which aren't stored are ignored.
+func (router *SCIMRouter) patchUser(w http.ResponseWriter, r *http.Request) {
+	e := router.getUserFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	var m SCIMPatchRequest
+	if err := UnmarshalBody(r, &m); err != nil {
+		router.sendError(w, http.StatusBadRequest, "invalidSyntax", "")
+		return
+	}
+	eNew := *e
+	userName := e.Email
+	for _, op := range m.Operations {
+		if operation := strings.ToLower(op.Op); operation != "add" && operation != "replace" {
+			continue
+		}
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				router.sendError(w, http.StatusBadRequest, "invalidValue", "")
+				return
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+		for path, value := range values {
+			switch strings.ToLower(path) {
+			case "active":
+				active, err := router.parseBool(value)
+				if err != nil {
+					router.sendError(w, http.StatusBadRequest, "invalidValue", "active must be a boolean")
+					return
+				}
+				eNew.Disabled = !active
+			case "username":
+				if err := json.Unmarshal(value, &userName); err != nil {
+					router.sendError(w, http.StatusBadRequest, "invalidValue", "userName must be a string")
+					return
+				}
+			}
+		}
+	}
+	router.updateUser(w, r, e, &eNew, userName)
+}
+
+func (router *SCIMRouter) updateUser(w http.ResponseWriter, r *http.Request, e *User, eNew *User, userName string) {
+	email := strings.ToLower(strings.TrimSpace(userName))
+	if email != e.Email {
+		org, err := GetOrganizationRepository().GetOne(e.OrganizationID)
+		if err != nil {
+			log.Println(err)
+			router.sendError(w, http.StatusInternalServerError, "", "")
+			return
+		}
+		if !GetOrganizationRepository().isValidEmailForOrg(email, org) {
+			router.sendError(w, http.StatusBadRequest, "invalidValue", "userName must be an email address of one of the organization's domains")
+			return
+		}
+		if _, err := GetUserRepository().GetByEmail(email); err == nil {
+			router.sendError(w, http.StatusConflict, "uniqueness", "userName already exists")
+			return
+		}
+		eNew.Email = email
+	}
+	if !eNew.Disabled {
+		eNew.BanExpiry = nil
+	}
+	if err := GetUserRepository().Update(eNew); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	if eNew.Disabled && !e.Disabled {
+		if err := router.revokeAccess(eNew); err != nil {
+			log.Println(err)
+			router.sendError(w, http.StatusInternalServerError, "", "")
+			return
+		}
+	}
+	res := router.copyToSCIMUser(eNew)
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityUser, e.ID, router.copyToSCIMUser(e), res)
+	router.send(w, http.StatusOK, res)
+}
+
+// revokeAccess invalidates all credentials of a deactivated user which remain
+// valid independently of a login: refresh tokens, API tokens and iCal feeds.
+func (router *SCIMRouter) revokeAccess(e *User) error {
+	if err := GetRefreshTokenRepository().DeleteOfUser(e); err != nil {
+		return err
+	}
+	if err := GetAPITokenRepository().DeleteOfUser(e); err != nil {
+		return err
+	}
+	return GetICalFeedRepository().DeleteOfUser(e)
+}
+
+func (router *SCIMRouter) deleteUser(w http.ResponseWriter, r *http.Request) {
+	e := router.getUserFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	if err := GetUserRepository().Delete(e); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityUser, e.ID, router.copyToSCIMUser(e), nil)
+	SendUpdated(w)
+}
+
+func (router *SCIMRouter) getGroups(w http.ResponseWriter, r *http.Request) {
+	startIndex, count := router.getPagination(r)
+	list, err := GetGroupRepository().GetAll(router.getOrganizationID(r))
+	if err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		value, ok := router.parseFilter(filter, "displayName")
+		if !ok {
+			router.sendError(w, http.StatusBadRequest, "invalidFilter", "only filtering by displayName is supported")
+			return
+		}
+		filtered := []*Group{}
+		for _, e := range list {
+			if e.Name == value {
+				filtered = append(filtered, e)
+			}
+		}
+		list = filtered
+	}
+	res := []interface{}{}
+	for i := startIndex - 1; i < len(list) && len(res) < count; i++ {
+		m, err := router.copyToSCIMGroup(list[i])
+		if err != nil {
+			log.Println(err)
+			router.sendError(w, http.StatusInternalServerError, "", "")
+			return
+		}
+		res = append(res, m)
+	}
+	router.sendList(w, res, len(list), startIndex)
+}
+
+func (router *SCIMRouter) getGroup(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroupFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	router.sendGroup(w, http.StatusOK, e)
+}
+
+func (router *SCIMRouter) createGroup(w http.ResponseWriter, r *http.Request) {
+	var m SCIMGroup
+	if err := UnmarshalBody(r, &m); err != nil || strings.TrimSpace(m.DisplayName) == "" {
+		router.sendError(w, http.StatusBadRequest, "invalidSyntax", "")
+		return
+	}
+	organizationID := router.getOrganizationID(r)
+	if router.getGroupByName(organizationID, m.DisplayName) != nil {
+		router.sendError(w, http.StatusConflict, "uniqueness", "displayName already exists")
+		return
+	}
+	userIDs, ok := router.getMemberUserIDs(organizationID, m.Members)
+	if !ok {
+		router.sendError(w, http.StatusBadRequest, "invalidValue", "unknown member")
+		return
+	}
+	e := &Group{
+		OrganizationID: organizationID,
+		Name:           strings.TrimSpace(m.DisplayName),
+	}
+	if err := GetGroupRepository().Create(e); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	if err := router.setMembers(e, userIDs); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityGroup, e.ID, nil, router.getGroupAuditLogData(e))
+	w.Header().Set("Location", router.getLocation("Groups", e.ID))
+	router.sendGroup(w, http.StatusCreated, e)
+}
+
+func (router *SCIMRouter) replaceGroup(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroupFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	var m SCIMGroup
+	if err := UnmarshalBody(r, &m); err != nil || strings.TrimSpace(m.DisplayName) == "" {
+		router.sendError(w, http.StatusBadRequest, "invalidSyntax", "")
+		return
+	}
+	userIDs, ok := router.getMemberUserIDs(e.OrganizationID, m.Members)
+	if !ok {
+		router.sendError(w, http.StatusBadRequest, "invalidValue", "unknown member")
+		return
+	}
+	if !router.renameGroup(w, r, e, m.DisplayName) {
+		return
+	}
+	if err := router.setMembers(e, userIDs); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	router.sendGroup(w, http.StatusOK, e)
+}
+
+// patchGroup applies the operations on the displayName and members attributes.
+// Members can be added, removed, and replaced.
+func (router *SCIMRouter) patchGroup(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroupFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	var m SCIMPatchRequest
+	if err := UnmarshalBody(r, &m); err != nil {
+		router.sendError(w, http.StatusBadRequest, "invalidSyntax", "")
+		return
+	}
+	for _, op := range m.Operations {
+		operation := strings.ToLower(op.Op)
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				router.sendError(w, http.StatusBadRequest, "invalidValue", "")
+				return
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+		for path, value := range values {
+			if matches := scimMemberPathRegexp.FindStringSubmatch(path); matches != nil && operation == "remove" {
+				if err := GetGroupRepository().RemoveMember(e, matches[1]); err != nil {
+					log.Println(err)
+					router.sendError(w, http.StatusInternalServerError, "", "")
+					return
+				}
+				continue
+			}
+			switch strings.ToLower(path) {
+			case "displayname":
+				var name string
+				if err := json.Unmarshal(value, &name); err != nil || strings.TrimSpace(name) == "" || operation == "remove" {
+					router.sendError(w, http.StatusBadRequest, "invalidValue", "displayName must be a string")
+					return
+				}
+				if !router.renameGroup(w, r, e, name) {
+					return
+				}
+			case "members":
+				if !router.patchMembers(w, e, operation, value) {
+					return
+				}
+			}
+		}
+	}
+	router.sendGroup(w, http.StatusOK, e)
+}
+
+func (router *SCIMRouter) patchMembers(w http.ResponseWriter, e *Group, operation string, value json.RawMessage) bool {
+	var members []SCIMMember
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &members); err != nil {
+			router.sendError(w, http.StatusBadRequest, "invalidValue", "members must be a list")
+			return false
+		}
+	}
+	var err error
+	switch operation {
+	case "add", "replace":
+		userIDs, ok := router.getMemberUserIDs(e.OrganizationID, members)
+		if !ok {
+			router.sendError(w, http.StatusBadRequest, "invalidValue", "unknown member")
+			return false
+		}
+		if operation == "replace" {
+			err = router.setMembers(e, userIDs)
+		} else {
+			for _, userID := range userIDs {
+				if err = GetGroupRepository().AddMember(e, userID); err != nil {
+					break
+				}
+			}
+		}
+	case "remove":
+		if len(value) == 0 {
+			err = router.setMembers(e, []string{})
+		}
+		for _, member := range members {
+			if err = GetGroupRepository().RemoveMember(e, member.Value); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return false
+	}
+	return true
+}
+
+func (router *SCIMRouter) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	e := router.getGroupFromRequest(w, r)
+	if e == nil {
+		return
+	}
+	if err := GetGroupRepository().Delete(e); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityGroup, e.ID, router.getGroupAuditLogData(e), nil)
+	SendUpdated(w)
+}
+
+func (router *SCIMRouter) renameGroup(w http.ResponseWriter, r *http.Request, e *Group, name string) bool {
+	name = strings.TrimSpace(name)
+	if name == e.Name {
+		return true
+	}
+	if router.getGroupByName(e.OrganizationID, name) != nil {
+		router.sendError(w, http.StatusConflict, "uniqueness", "displayName already exists")
+		return false
+	}
+	before := router.getGroupAuditLogData(e)
+	e.Name = name
+	if err := GetGroupRepository().Update(e); err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return false
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityGroup, e.ID, before, router.getGroupAuditLogData(e))
+	return true
+}
+
+// setMembers replaces the group's members with the users specified.
+func (router *SCIMRouter) setMembers(e *Group, userIDs []string) error {
+	members, err := GetGroupRepository().GetMembers(e)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool)
+	for _, userID := range userIDs {
+		keep[userID] = true
+	}
+	for _, member := range members {
+		if !keep[member.UserID] {
+			if err := GetGroupRepository().RemoveMember(e, member.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, userID := range userIDs {
+		if err := GetGroupRepository().AddMember(e, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMemberUserIDs returns the IDs of the members, which must be users of the
+// organization.
+func (router *SCIMRouter) getMemberUserIDs(organizationID string, members []SCIMMember) ([]string, bool) {
+	res := []string{}
+	for _, member := range members {
+		user, err := GetUserRepository().GetOne(member.Value)
+		if err != nil || user.OrganizationID != organizationID {
+			return nil, false
+		}
+		res = append(res, user.ID)
+	}
+	return res, true
+}
+
+func (router *SCIMRouter) getGroupByName(organizationID, name string) *Group {
+	list, err := GetGroupRepository().GetAll(organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	for _, e := range list {
+		if e.Name == strings.TrimSpace(name) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (router *SCIMRouter) getUserFromRequest(w http.ResponseWriter, r *http.Request) *User {
+	vars := mux.Vars(r)
+	e, err := GetUserRepository().GetOne(vars["id"])
+	if err != nil || e.OrganizationID != router.getOrganizationID(r) {
+		router.sendError(w, http.StatusNotFound, "", "user not found")
+		return nil
+	}
+	return e
+}
+
+func (router *SCIMRouter) getGroupFromRequest(w http.ResponseWriter, r *http.Request) *Group {
+	vars := mux.Vars(r)
+	e, err := GetGroupRepository().GetOne(vars["id"])
+	if err != nil || e.OrganizationID != router.getOrganizationID(r) {
+		router.sendError(w, http.StatusNotFound, "", "group not found")
+		return nil
+	}
+	return e
+}
+
+func (router *SCIMRouter) getOrganizationID(r *http.Request) string {
+	organizationID := r.Context().Value(contextKeySCIMOrganizationID)
+	if organizationID == nil {
+		return ""
+	}
+	return organizationID.(string)
+}
+
+// getPagination returns the 1-based start index and the number of results
+// requested.
+func (router *SCIMRouter) getPagination(r *http.Request) (int, int) {
+	startIndex, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 || count > scimMaxResults {
+		count = scimMaxResults
+	}
+	return startIndex, count
+}
+
+// parseFilter returns the value of a filter in the form 'attribute eq "value"'.
+// Other filters are not supported.
+func (router *SCIMRouter) parseFilter(filter, attribute string) (string, bool) {
+	matches := scimFilterRegexp.FindStringSubmatch(filter)
+	if matches == nil || !strings.EqualFold(matches[1], attribute) {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal([]byte(`"`+matches[2]+`"`), &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// parseBool accepts booleans as well as strings, as sent by some identity
+// providers.
+func (router *SCIMRouter) parseBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errors.New("invalid boolean: " + s)
+}
+
+// getGroupAuditLogData returns the group's representation for the audit log,
+// which is the same as for changes made using the group API.
+func (router *SCIMRouter) getGroupAuditLogData(e *Group) *GetGroupResponse {
+	return &GetGroupResponse{
+		ID:                 e.ID,
+		OrganizationID:     e.OrganizationID,
+		CreateGroupRequest: CreateGroupRequest{Name: e.Name},
+	}
+}
+
+func (router *SCIMRouter) getLocation(resourceType, id string) string {
+	return GetConfig().PublicURL + "scim/v2/" + resourceType + "/" + id
+}
+
+func (router *SCIMRouter) copyToSCIMUser(e *User) *SCIMUser {
+	active := !e.Disabled
+	return &SCIMUser{
+		Schemas:  []string{scimSchemaUser},
+		ID:       e.ID,
+		UserName: e.Email,
+		Active:   &active,
+		Emails:   []SCIMEmail{{Value: e.Email, Primary: true}},
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Location:     router.getLocation("Users", e.ID),
+		},
+	}
+}
+
+func (router *SCIMRouter) copyToSCIMGroup(e *Group) (*SCIMGroup, error) {
+	members, err := GetGroupRepository().GetMembers(e)
+	if err != nil {
+		return nil, err
+	}
+	m := &SCIMGroup{
+		Schemas:     []string{scimSchemaGroup},
+		ID:          e.ID,
+		DisplayName: e.Name,
+		Members:     []SCIMMember{},
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Location:     router.getLocation("Groups", e.ID),
+		},
+	}
+	for _, member := range members {
+		m.Members = append(m.Members, SCIMMember{Value: member.UserID, Display: member.Email})
+	}
+	return m, nil
+}
+
+func (router *SCIMRouter) sendGroup(w http.ResponseWriter, status int, e *Group) {
+	m, err := router.copyToSCIMGroup(e)
+	if err != nil {
+		log.Println(err)
+		router.sendError(w, http.StatusInternalServerError, "", "")
+		return
+	}
+	router.send(w, status, m)
+}
+
+func (router *SCIMRouter) sendList(w http.ResponseWriter, resources []interface{}, total, startIndex int) {
+	res := &SCIMListResponse{
+		Schemas:      []string{scimSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+	router.send(w, http.StatusOK, res)
+}
+
+func (router *SCIMRouter) sendError(w http.ResponseWriter, status int, scimType, detail string) {
+	res := &SCIMError{
+		Schemas:  []string{scimSchemaError},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	}
+	router.send(w, status, res)
+}
+
+func (router *SCIMRouter) send(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func createSCIMTestToken(t *testing.T, admin *User) string {
+	payload := `{"name": "IdP"}`
+	req := newHTTPRequest("POST", "/scim-token/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var resBody *CreateSCIMTokenResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	return resBody.Token
+}
+
+func TestSCIMTokensCRUD(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("POST", "/scim-token/", user.ID, bytes.NewBufferString(`{"name": "IdP"}`))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	token := createSCIMTestToken(t, admin)
+	checkTestString(t, SCIMTokenPrefix, token[:len(SCIMTokenPrefix)])
+
+	req = newHTTPRequest("GET", "/scim-token/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var list []*GetSCIMTokenResponse
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 1, len(list))
+	checkTestString(t, "IdP", list[0].Name)
+
+	// Tokens of other orgs can't be deleted
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	req = newHTTPRequest("DELETE", "/scim-token/"+list[0].ID, admin2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/scim-token/"+list[0].ID, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users", token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestSCIMUnauthorized(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+
+	req := newHTTPRequestWithAccessToken("GET", "/scim/v2/Users", "", nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users", SCIMTokenPrefix+"invalid", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	// A user's JWT is not accepted
+	req = newHTTPRequest("GET", "/scim/v2/Users", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestSCIMUsersCRUD(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	token := createSCIMTestToken(t, admin)
+
+	// Create
+	payload := `{"schemas": ["` + scimSchemaUser + `"], "userName": "Jane.Doe@test.com", "active": true}`
+	req := newHTTPRequestWithAccessToken("POST", "/scim/v2/Users", token, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	checkTestString(t, scimContentType, res.Header().Get("Content-Type"))
+	var created *SCIMUser
+	json.Unmarshal(res.Body.Bytes(), &created)
+	checkTestString(t, "jane.doe@test.com", created.UserName)
+	checkTestBool(t, true, *created.Active)
+	user, err := GetUserRepository().GetOne(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, org.ID, user.OrganizationID)
+	checkTestInt(t, int(UserRoleUser), int(user.Role))
+
+	// Duplicate
+	req = newHTTPRequestWithAccessToken("POST", "/scim/v2/Users", token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	// Domain not belonging to the org
+	payload = `{"schemas": ["` + scimSchemaUser + `"], "userName": "jane.doe@other.com"}`
+	req = newHTTPRequestWithAccessToken("POST", "/scim/v2/Users", token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Read
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users/"+created.ID, token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	// Filter
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "jane.doe@test.com"`), token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var list *SCIMListResponse
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 1, list.TotalResults)
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "john@test.com"`), token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 0, list.TotalResults)
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users?filter="+url.QueryEscape(`title pr`), token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// List
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users?startIndex=1&count=1", token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 2, list.TotalResults)
+	checkTestInt(t, 1, list.ItemsPerPage)
+
+	// Deactivate using a string value, as sent by some identity providers
+	payload = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "Replace", "path": "active", "value": "False"}, {"op": "replace", "path": "name.givenName", "value": "Jane"}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Users/"+created.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	user, _ = GetUserRepository().GetOne(created.ID)
+	checkTestBool(t, true, user.Disabled)
+
+	// Reactivate
+	payload = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "value": {"active": true}}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Users/"+created.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	user, _ = GetUserRepository().GetOne(created.ID)
+	checkTestBool(t, false, user.Disabled)
+
+	// Replace
+	payload = `{"schemas": ["` + scimSchemaUser + `"], "userName": "jane.smith@test.com", "active": false}`
+	req = newHTTPRequestWithAccessToken("PUT", "/scim/v2/Users/"+created.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	user, _ = GetUserRepository().GetOne(created.ID)
+	checkTestString(t, "jane.smith@test.com", user.Email)
+	checkTestBool(t, true, user.Disabled)
+
+	// Delete
+	req = newHTTPRequestWithAccessToken("DELETE", "/scim/v2/Users/"+created.ID, token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users/"+created.ID, token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestSCIMUsersDeactivateRevokesAccess(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	token := createSCIMTestToken(t, admin)
+	apiToken := createAPITokenTestToken(t, user, `"bookings:read"`)
+	refreshToken := &RefreshToken{UserID: user.ID, Created: time.Now(), Expiry: time.Now().Add(time.Hour)}
+	GetRefreshTokenRepository().Create(refreshToken)
+	req := newHTTPRequest("GET", "/ical/user", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	payload := `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "active", "value": false}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Users/"+user.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/booking/", apiToken.Token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	_, err := GetAPITokenRepository().GetOne(apiToken.ID)
+	checkTestBool(t, true, err != nil)
+	_, err = GetRefreshTokenRepository().GetOne(refreshToken.ID)
+	checkTestBool(t, true, err != nil)
+	_, err = GetICalFeedRepository().GetOneByUser(user.ID)
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSCIMUsersOtherOrg(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	token := createSCIMTestToken(t, admin2)
+
+	req := newHTTPRequestWithAccessToken("GET", "/scim/v2/Users/"+user.ID, token, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	payload := `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "active", "value": false}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Users/"+user.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName eq "`+user.Email+`"`), token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var list *SCIMListResponse
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 0, list.TotalResults)
+}
+
+func TestSCIMUsersSubscriptionLimit(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	token := createSCIMTestToken(t, admin)
+	GetSettingsRepository().Set(org.ID, SettingSubscriptionMaxUsers.Name, "1")
+
+	payload := `{"schemas": ["` + scimSchemaUser + `"], "userName": "jane.doe@test.com"}`
+	req := newHTTPRequestWithAccessToken("POST", "/scim/v2/Users", token, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusPaymentRequired, res.Code)
+	_, err := GetUserRepository().GetByEmail("jane.doe@test.com")
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSCIMGroups(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user1 := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+	org2 := createTestOrg("test2.com")
+	user3 := createTestUserInOrg(org2)
+	token := createSCIMTestToken(t, admin)
+
+	// Create
+	payload := `{"schemas": ["` + scimSchemaGroup + `"], "displayName": "Staff", "members": [{"value": "` + user1.ID + `"}]}`
+	req := newHTTPRequestWithAccessToken("POST", "/scim/v2/Groups", token, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var created *SCIMGroup
+	json.Unmarshal(res.Body.Bytes(), &created)
+	checkTestString(t, "Staff", created.DisplayName)
+	checkTestInt(t, 1, len(created.Members))
+	group, err := GetGroupRepository().GetOne(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestBool(t, true, GetGroupRepository().IsMember(group, user1.ID))
+
+	// Duplicate
+	req = newHTTPRequestWithAccessToken("POST", "/scim/v2/Groups", token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	// Users of other orgs can't be added
+	payload = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "add", "path": "members", "value": [{"value": "` + user3.ID + `"}]}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Groups/"+group.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestBool(t, false, GetGroupRepository().IsMember(group, user3.ID))
+
+	// Add member and rename
+	payload = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "add", "path": "members", "value": [{"value": "` + user2.ID + `"}]}, {"op": "replace", "value": {"displayName": "Employees"}}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Groups/"+group.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	group, _ = GetGroupRepository().GetOne(group.ID)
+	checkTestString(t, "Employees", group.Name)
+	checkTestBool(t, true, GetGroupRepository().IsMember(group, user2.ID))
+
+	// Remove member using a filter
+	payload = `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "remove", "path": "members[value eq \"` + user1.ID + `\"]"}]}`
+	req = newHTTPRequestWithAccessToken("PATCH", "/scim/v2/Groups/"+group.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestBool(t, false, GetGroupRepository().IsMember(group, user1.ID))
+	checkTestBool(t, true, GetGroupRepository().IsMember(group, user2.ID))
+
+	// Replace
+	payload = `{"schemas": ["` + scimSchemaGroup + `"], "displayName": "Employees", "members": [{"value": "` + user1.ID + `"}]}`
+	req = newHTTPRequestWithAccessToken("PUT", "/scim/v2/Groups/"+group.ID, token, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestBool(t, true, GetGroupRepository().IsMember(group, user1.ID))
+	checkTestBool(t, false, GetGroupRepository().IsMember(group, user2.ID))
+
+	// Filter
+	req = newHTTPRequestWithAccessToken("GET", "/scim/v2/Groups?filter="+url.QueryEscape(`displayName eq "Employees"`), token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var list *SCIMListResponse
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 1, list.TotalResults)
+
+	// Delete
+	req = newHTTPRequestWithAccessToken("DELETE", "/scim/v2/Groups/"+group.ID, token, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	_, err = GetGroupRepository().GetOne(group.ID)
+	checkTestBool(t, true, err != nil)
+}
+
+func TestSCIMGroupsPagination(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	token := createSCIMTestToken(t, admin)
+	for i := 0; i < 5; i++ {
+		GetGroupRepository().Create(&Group{OrganizationID: org.ID, Name: "Group " + strconv.Itoa(i)})
+	}
+
+	req := newHTTPRequestWithAccessToken("GET", "/scim/v2/Groups?startIndex=4&count=10", token, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var list *SCIMListResponse
+	json.Unmarshal(res.Body.Bytes(), &list)
+	checkTestInt(t, 5, list.TotalResults)
+	checkTestInt(t, 4, list.StartIndex)
+	checkTestInt(t, 2, list.ItemsPerPage)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+type SCIMTokenRepository struct {
+}
+
+// SCIMTokenPrefix distinguishes SCIM tokens from personal API tokens.
+const SCIMTokenPrefix = "scim_"
+
+// SCIMToken authenticates an identity provider's provisioning requests for an
+// organization. Only a hash of the token is stored, the plain token is shown
+// once on creation.
+type SCIMToken struct {
+	ID             string
+	OrganizationID string
+	Name           string
+	TokenHash      string
+	Created        time.Time
+	LastUsed       *time.Time
+}
+
+var scimTokenRepository *SCIMTokenRepository
+var scimTokenRepositoryOnce sync.Once
+
+func GetSCIMTokenRepository() *SCIMTokenRepository {
+	scimTokenRepositoryOnce.Do(func() {
+		scimTokenRepository = &SCIMTokenRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS scim_tokens (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"organization_id uuid NOT NULL, " +
+			"name VARCHAR NOT NULL, " +
+			"token_hash VARCHAR NOT NULL, " +
+			"created TIMESTAMP NOT NULL, " +
+			"last_used TIMESTAMP NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_tokens_token_hash ON scim_tokens(token_hash)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_scim_tokens_organization_id ON scim_tokens(organization_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return scimTokenRepository
+}
+
+func (r *SCIMTokenRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *SCIMTokenRepository) Create(e *SCIMToken) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO scim_tokens "+
+		"(organization_id, name, token_hash, created) "+
+		"VALUES ($1, $2, $3, $4) "+
+		"RETURNING id",
+		e.OrganizationID, e.Name, e.TokenHash, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *SCIMTokenRepository) GetOne(id string) (*SCIMToken, error) {
+	return r.getOne("WHERE id = $1", id)
+}
+
+// GetByToken returns the token matching the plain token specified.
+func (r *SCIMTokenRepository) GetByToken(token string) (*SCIMToken, error) {
+	return r.getOne("WHERE token_hash = $1", GetAPITokenHash(token))
+}
+
+func (r *SCIMTokenRepository) getOne(where string, arg string) (*SCIMToken, error) {
+	e := &SCIMToken{}
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, name, token_hash, created, last_used "+
+		"FROM scim_tokens "+
+		where,
+		arg).Scan(&e.ID, &e.OrganizationID, &e.Name, &e.TokenHash, &e.Created, &e.LastUsed)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *SCIMTokenRepository) GetAll(organizationID string) ([]*SCIMToken, error) {
+	var result []*SCIMToken
+	rows, err := GetDatabase().DB().Query("SELECT id, organization_id, name, token_hash, created, last_used "+
+		"FROM scim_tokens "+
+		"WHERE organization_id = $1 "+
+		"ORDER BY created", organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &SCIMToken{}
+		err = rows.Scan(&e.ID, &e.OrganizationID, &e.Name, &e.TokenHash, &e.Created, &e.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *SCIMTokenRepository) UpdateLastUsed(e *SCIMToken, lastUsed time.Time) error {
+	_, err := GetDatabase().DB().Exec("UPDATE scim_tokens SET last_used = $1 WHERE id = $2", lastUsed, e.ID)
+	return err
+}
+
+func (r *SCIMTokenRepository) Delete(e *SCIMToken) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM scim_tokens WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *SCIMTokenRepository) DeleteAll(organizationID string) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM scim_tokens WHERE organization_id = $1", organizationID)
+	return err
+}
+
+// GetSCIMToken returns a new random plain token.
+func GetSCIMToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return SCIMTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type SCIMTokenRouter struct {
+}
+
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type GetSCIMTokenResponse struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"lastUsed"`
+}
+
+type CreateSCIMTokenResponse struct {
+	Token string `json:"token"`
+	GetSCIMTokenResponse
+}
+
+func (router *SCIMTokenRouter) setupRoutes(s *mux.Router) {
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionOrgAdmin)
+}
+
+func (router *SCIMTokenRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	list, err := GetSCIMTokenRepository().GetAll(user.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetSCIMTokenResponse{}
+	for _, e := range list {
+		m := router.copyToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+// create issues a new token for the requesting user's organization. The plain
+// token is only returned in the response to this request.
+func (router *SCIMTokenRouter) create(w http.ResponseWriter, r *http.Request) {
+	var m CreateSCIMTokenRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	plainToken, err := GetSCIMToken()
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	e := &SCIMToken{
+		OrganizationID: user.OrganizationID,
+		Name:           m.Name,
+		TokenHash:      GetAPITokenHash(plainToken),
+		Created:        time.Now().UTC(),
+	}
+	if err := GetSCIMTokenRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntitySCIMToken, e.ID, nil, router.copyToRestModel(e))
+	res := &CreateSCIMTokenResponse{
+		Token:                plainToken,
+		GetSCIMTokenResponse: *router.copyToRestModel(e),
+	}
+	w.Header().Set("X-Object-ID", e.ID)
+	SendJSONWithStatus(w, http.StatusCreated, res)
+}
+
+func (router *SCIMTokenRouter) delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := GetSCIMTokenRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	user := GetRequestUser(r)
+	if !CanAccessOrg(user, e.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	if err := GetSCIMTokenRepository().Delete(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntitySCIMToken, e.ID, router.copyToRestModel(e), nil)
+	SendUpdated(w)
+}
+
+func (router *SCIMTokenRouter) copyToRestModel(e *SCIMToken) *GetSCIMTokenResponse {
+	m := &GetSCIMTokenResponse{}
+	m.ID = e.ID
+	m.Name = e.Name
+	m.Created = e.Created
+	m.LastUsed = e.LastUsed
+	return m
+}
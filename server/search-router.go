@@ -17,21 +17,17 @@ type GetSearchResultsResponse struct {
 }
 
 func (router *SearchRouter) setupRoutes(s *mux.Router) {
-	s.HandleFunc("/{keyword}", router.getResults).Methods("GET")
+	RequirePermission(s.HandleFunc("/{keyword}", router.getResults).Methods("GET"), PermissionSpacesAdmin)
 }
 
 func (router *SearchRouter) getResults(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	vars := mux.Vars(r)
 	keyword := vars["keyword"]
 	res := &GetSearchResultsResponse{
 		Users: []*GetUserResponse{},
 	}
-	if CanAdminOrg(user, user.OrganizationID) {
+	if HasPermission(user, GetRequestAPIToken(r), PermissionOrgAdmin) {
 		if err := router.addUserResults(user, keyword, res); err != nil {
 			log.Println(err)
 			SendInternalServerError(w)
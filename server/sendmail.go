@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/base64"
+	"mime"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -14,9 +19,26 @@ const EmailTemplateDefaultLanguage = "en"
 var EmailTemplateSignup, _ = filepath.Abs("./res/email-signup.txt")
 var EmailTemplateConfirm, _ = filepath.Abs("./res/email-confirm.txt")
 var EmailTemplateResetpassword, _ = filepath.Abs("./res/email-resetpw.txt")
+var EmailTemplateWaitlistAvailable, _ = filepath.Abs("./res/email-waitlist-available.txt")
+var EmailTemplateWaitlistBooked, _ = filepath.Abs("./res/email-waitlist-booked.txt")
+var EmailTemplateBookingCreated, _ = filepath.Abs("./res/email-booking-created.txt")
+var EmailTemplateBookingOnBehalf, _ = filepath.Abs("./res/email-booking-on-behalf.txt")
+var EmailTemplateBookingChanged, _ = filepath.Abs("./res/email-booking-changed.txt")
+var EmailTemplateBookingCancelled, _ = filepath.Abs("./res/email-booking-cancelled.txt")
+var EmailTemplateBookingReminder, _ = filepath.Abs("./res/email-booking-reminder.txt")
 var SendMailMockContent = ""
 
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
 func sendEmail(recipient, sender, templateFile, language string, vars map[string]string) error {
+	return sendEmailWithAttachments(recipient, sender, templateFile, language, vars, nil)
+}
+
+func sendEmailWithAttachments(recipient, sender, templateFile, language string, vars map[string]string, attachments []*EmailAttachment) error {
 	actualTemplateFile, err := getEmailTemplatePath(templateFile, language)
 	if err != nil {
 		return err
@@ -25,6 +47,11 @@ func sendEmail(recipient, sender, templateFile, language string, vars map[string
 	if err != nil {
 		return err
 	}
+	if len(attachments) > 0 {
+		if body, err = addEmailAttachments(body, attachments); err != nil {
+			return err
+		}
+	}
 	if GetConfig().MockSendmail {
 		SendMailMockContent = body
 		return nil
@@ -70,6 +97,54 @@ func compileEmailTemplate(templateFile string, vars map[string]string) (string,
 	return s, nil
 }
 
+// addEmailAttachments turns a compiled template into a multipart/mixed
+// message with the template's body as the first part.
+func addEmailAttachments(message string, attachments []*EmailAttachment) (string, error) {
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	header, body, _ := strings.Cut(message, "\n\n")
+	bodyContentType := "text/plain; charset=UTF-8"
+	var res bytes.Buffer
+	for _, line := range strings.Split(header, "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Type") {
+			bodyContentType = strings.TrimSpace(value)
+			continue
+		}
+		res.WriteString(line + "\r\n")
+	}
+	var parts bytes.Buffer
+	writer := multipart.NewWriter(&parts)
+	res.WriteString("MIME-Version: 1.0\r\n")
+	res.WriteString("Content-Type: multipart/mixed; boundary=\"" + writer.Boundary() + "\"\r\n\r\n")
+	part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {bodyContentType}})
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return "", err
+	}
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return "", err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	res.Write(parts.Bytes())
+	return res.String(), nil
+}
+
 func smtpDialAndSend(from string, to []string, msg []byte) error {
 	config := GetConfig()
 	addr := config.SMTPHost + ":" + strconv.Itoa(config.SMTPPort)
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -23,3 +29,36 @@ func TestGetEmailTemplatePathNotExists(t *testing.T) {
 	checkTestString(t, "", res)
 	checkTestBool(t, true, err != nil)
 }
+
+func TestAddEmailAttachments(t *testing.T) {
+	message := "From: a@b.com\nTo: c@d.com\nContent-Type: text/plain; charset=UTF-8\nSubject: Test\n\nHello\nWorld"
+	attachments := []*EmailAttachment{
+		{Filename: "invite.ics", ContentType: "text/calendar; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")},
+	}
+	res, err := addEmailAttachments(message, attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "Test", msg.Header.Get("Subject"))
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	checkTestString(t, "multipart/mixed", mediaType)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "text/plain; charset=UTF-8", part.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(part)
+	checkTestString(t, "Hello\r\nWorld", string(body))
+	part, err = reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "invite.ics", part.FileName())
+	data, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	checkTestString(t, "BEGIN:VCALENDAR", string(data))
+}
@@ -40,6 +40,11 @@ var (
 	SettingMaxDaysInAdvance               SettingName = SettingName{Name: "max_days_in_advance", Type: SettingTypeInt}
 	SettingEnableMaxHourBeforeDelete      SettingName = SettingName{Name: "enable_max_hours_before_delete", Type: SettingTypeBool}
 	SettingMaxHoursBeforeDelete           SettingName = SettingName{Name: "max_hours_before_delete", Type: SettingTypeInt}
+	SettingEnableCheckIn                  SettingName = SettingName{Name: "enable_check_in", Type: SettingTypeBool}
+	SettingMaxMinutesCheckIn              SettingName = SettingName{Name: "max_minutes_check_in", Type: SettingTypeInt}
+	SettingCheckOutGranularityMinutes     SettingName = SettingName{Name: "check_out_granularity_minutes", Type: SettingTypeInt}
+	SettingCalDAVReconcilePolicy          SettingName = SettingName{Name: "caldav_reconcile_policy", Type: SettingTypeInt}
+	SettingReminderMinutes                SettingName = SettingName{Name: "reminder_minutes", Type: SettingTypeInt}
 	SettingMinBookingDurationHours        SettingName = SettingName{Name: "min_booking_duration_hours", Type: SettingTypeInt}
 	SettingMaxBookingDurationHours        SettingName = SettingName{Name: "max_booking_duration_hours", Type: SettingTypeInt}
 	SettingMaxHoursPartiallyBooked        SettingName = SettingName{Name: "max_hours_partially_booked", Type: SettingTypeInt}
@@ -53,6 +58,17 @@ var (
 	SettingDisableBuddies                 SettingName = SettingName{Name: "disable_buddies", Type: SettingTypeBool}
 	SettingSubscriptionMaxUsers           SettingName = SettingName{Name: "subscription_max_users", Type: SettingTypeInt}
 	SettingDefaultTimezone                SettingName = SettingName{Name: "default_timezone", Type: SettingTypeString}
+	SettingWaitlistAutoBook               SettingName = SettingName{Name: "waitlist_auto_book", Type: SettingTypeBool}
+	SettingTOTPRequiredAdmins             SettingName = SettingName{Name: "totp_required_admins", Type: SettingTypeBool}
+)
+
+// What to do if a booking's CalDAV event has been deleted or moved in the
+// user's calendar
+var (
+	CalDAVReconcilePolicyDisabled int = 0
+	CalDAVReconcilePolicyRecreate int = 1 // Recreate deleted and reset moved events
+	CalDAVReconcilePolicyCancel   int = 2 // Cancel bookings of deleted events, reset moved events
+	CalDAVReconcilePolicyUpdate   int = 3 // Cancel bookings of deleted events, update bookings of moved events
 )
 
 var settingsRepository *SettingsRepository
@@ -202,12 +218,19 @@ func (r *SettingsRepository) InitDefaultSettingsForOrg(organizationID string) er
 		"($1, '"+SettingMaxConcurrentBookingsPerUser.Name+"', '0'), "+
 		"($1, '"+SettingEnableMaxHourBeforeDelete.Name+"', '0'), "+
 		"($1, '"+SettingMaxHoursBeforeDelete.Name+"', '0'), "+
+		"($1, '"+SettingEnableCheckIn.Name+"', '0'), "+
+		"($1, '"+SettingMaxMinutesCheckIn.Name+"', '15'), "+
+		"($1, '"+SettingCheckOutGranularityMinutes.Name+"', '15'), "+
+		"($1, '"+SettingCalDAVReconcilePolicy.Name+"', '0'), "+
+		"($1, '"+SettingReminderMinutes.Name+"', '0'), "+
 		"($1, '"+SettingMaxHoursPartiallyBookedEnabled.Name+"', '0'), "+
 		"($1, '"+SettingMaxHoursPartiallyBooked.Name+"', '8'), "+
 		"($1, '"+SettingMinBookingDurationHours.Name+"', '0'), "+
 		"($1, '"+SettingMaxDaysInAdvance.Name+"', '360'), "+
 		"($1, '"+SettingMaxBookingDurationHours.Name+"', '12'), "+
-		"($1, '"+SettingDefaultTimezone.Name+"', 'Europe/Berlin') "+
+		"($1, '"+SettingDefaultTimezone.Name+"', 'Europe/Berlin'), "+
+		"($1, '"+SettingWaitlistAutoBook.Name+"', '0'), "+
+		"($1, '"+SettingTOTPRequiredAdmins.Name+"', '0') "+
 		"ON CONFLICT (organization_id, name) DO NOTHING",
 		organizationID)
 	return err
@@ -30,9 +30,9 @@ var (
 func (router *SettingsRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/timezones", router.getTimezones).Methods("GET")
 	s.HandleFunc("/{name}", router.getSetting).Methods("GET")
-	s.HandleFunc("/{name}", router.setSetting).Methods("PUT")
+	RequirePermission(s.HandleFunc("/{name}", router.setSetting).Methods("PUT"), PermissionOrgAdmin)
 	s.HandleFunc("/", router.getAll).Methods("GET")
-	s.HandleFunc("/", router.setAll).Methods("PUT")
+	RequirePermission(s.HandleFunc("/", router.setAll).Methods("PUT"), PermissionOrgAdmin)
 }
 
 func (router *SettingsRouter) getTimezones(w http.ResponseWriter, r *http.Request) {
@@ -66,10 +66,6 @@ func (router *SettingsRouter) getSetting(w http.ResponseWriter, r *http.Request)
 
 func (router *SettingsRouter) setSetting(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	var value SetSettingsRequest
 	if UnmarshalValidateBody(r, &value) != nil {
 		SendBadRequest(w)
@@ -88,7 +84,7 @@ func (router *SettingsRouter) setSetting(w http.ResponseWriter, r *http.Request)
 		SendBadRequest(w)
 		return
 	}
-	err := router.doSetOne(user.OrganizationID, vars["name"], value.Value)
+	err := router.doSetOne(r, user.OrganizationID, vars["name"], value.Value)
 	if err != nil {
 		log.Println(err)
 		if errors.Is(err, ErrAlreadyExists) {
@@ -130,10 +126,6 @@ func (router *SettingsRouter) getAll(w http.ResponseWriter, r *http.Request) {
 
 func (router *SettingsRouter) setAll(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	var list []GetSettingsResponse
 	if err := UnmarshalBody(r, &list); err != nil {
 		log.Println(err)
@@ -153,7 +145,7 @@ func (router *SettingsRouter) setAll(w http.ResponseWriter, r *http.Request) {
 			SendBadRequest(w)
 			return
 		}
-		err := router.doSetOne(user.OrganizationID, e.Name, e.Value)
+		err := router.doSetOne(r, user.OrganizationID, e.Name, e.Value)
 		if err != nil {
 			log.Println(err)
 			if errors.Is(err, ErrAlreadyExists) {
@@ -167,9 +159,18 @@ func (router *SettingsRouter) setAll(w http.ResponseWriter, r *http.Request) {
 	SendUpdated(w)
 }
 
-func (router *SettingsRouter) doSetOne(organizationID, name, value string) error {
-	err := GetSettingsRepository().Set(organizationID, name, value)
-	return err
+// doSetOne updates a setting and writes an audit log entry if its value has
+// changed.
+func (router *SettingsRouter) doSetOne(r *http.Request, organizationID, name, value string) error {
+	oldValue, _ := GetSettingsRepository().Get(organizationID, name)
+	if err := GetSettingsRepository().Set(organizationID, name, value); err != nil {
+		return err
+	}
+	if oldValue != value {
+		writeAuditLog(r, organizationID, AuditActionUpdate, AuditEntitySetting, name,
+			&GetSettingsResponse{Name: name, Value: oldValue}, &GetSettingsResponse{Name: name, Value: value})
+	}
+	return nil
 }
 
 func (router *SettingsRouter) copyToRestModel(e *OrgSetting) *GetSettingsResponse {
@@ -188,6 +189,11 @@ func (router *SettingsRouter) isValidSettingNameReadPublic(name string) bool {
 		name == SettingMinBookingDurationHours.Name ||
 		name == SettingShowNames.Name ||
 		name == SettingEnableMaxHourBeforeDelete.Name ||
+		name == SettingEnableCheckIn.Name ||
+		name == SettingMaxMinutesCheckIn.Name ||
+		name == SettingCheckOutGranularityMinutes.Name ||
+		name == SettingCalDAVReconcilePolicy.Name ||
+		name == SettingReminderMinutes.Name ||
 		name == SettingAllowBookingsNonExistingUsers.Name ||
 		name == SettingDailyBasisBooking.Name ||
 		name == SettingNoAdminRestrictions.Name ||
@@ -196,6 +202,7 @@ func (router *SettingsRouter) isValidSettingNameReadPublic(name string) bool {
 		name == SettingMaxHoursPartiallyBookedEnabled.Name ||
 		name == SettingDefaultTimezone.Name ||
 		name == SettingDisableBuddies.Name ||
+		name == SettingWaitlistAutoBook.Name ||
 		name == SysSettingVersion {
 		return true
 	}
@@ -214,6 +221,7 @@ func (router *SettingsRouter) isValidSettingNameReadAdmin(name string) bool {
 		name == SettingSubscriptionMaxUsers.Name ||
 		name == SettingConfluenceServerSharedSecret.Name ||
 		name == SettingConfluenceAnonymous.Name ||
+		name == SettingTOTPRequiredAdmins.Name ||
 		name == SysSettingOrgSignupDelete {
 		return true
 	}
@@ -229,6 +237,11 @@ func (router *SettingsRouter) isValidSettingNameWrite(name string) bool {
 		name == SettingMaxConcurrentBookingsPerUser.Name ||
 		name == SettingMaxDaysInAdvance.Name ||
 		name == SettingMaxHoursBeforeDelete.Name ||
+		name == SettingEnableCheckIn.Name ||
+		name == SettingMaxMinutesCheckIn.Name ||
+		name == SettingCheckOutGranularityMinutes.Name ||
+		name == SettingCalDAVReconcilePolicy.Name ||
+		name == SettingReminderMinutes.Name ||
 		name == SettingMinBookingDurationHours.Name ||
 		name == SettingDailyBasisBooking.Name ||
 		name == SettingNoAdminRestrictions.Name ||
@@ -239,6 +252,8 @@ func (router *SettingsRouter) isValidSettingNameWrite(name string) bool {
 		name == SettingAllowBookingsNonExistingUsers.Name ||
 		name == SettingMaxBookingDurationHours.Name ||
 		name == SettingDisableBuddies.Name ||
+		name == SettingWaitlistAutoBook.Name ||
+		name == SettingTOTPRequiredAdmins.Name ||
 		name == SettingDefaultTimezone.Name {
 		return true
 	}
@@ -282,9 +297,30 @@ func (router *SettingsRouter) getSettingType(name string) SettingType {
 	if name == SettingDefaultTimezone.Name {
 		return SettingDefaultTimezone.Type
 	}
+	if name == SettingWaitlistAutoBook.Name {
+		return SettingWaitlistAutoBook.Type
+	}
+	if name == SettingTOTPRequiredAdmins.Name {
+		return SettingTOTPRequiredAdmins.Type
+	}
 	if name == SettingMaxHoursBeforeDelete.Name {
 		return SettingMaxHoursBeforeDelete.Type
 	}
+	if name == SettingEnableCheckIn.Name {
+		return SettingEnableCheckIn.Type
+	}
+	if name == SettingMaxMinutesCheckIn.Name {
+		return SettingMaxMinutesCheckIn.Type
+	}
+	if name == SettingCheckOutGranularityMinutes.Name {
+		return SettingCheckOutGranularityMinutes.Type
+	}
+	if name == SettingCalDAVReconcilePolicy.Name {
+		return SettingCalDAVReconcilePolicy.Type
+	}
+	if name == SettingReminderMinutes.Name {
+		return SettingReminderMinutes.Type
+	}
 	if name == SettingEnableMaxHourBeforeDelete.Name {
 		return SettingEnableMaxHourBeforeDelete.Type
 	}
@@ -329,6 +365,26 @@ func (router *SettingsRouter) isValidSettingValue(name string, value string) boo
 	if name == SettingDefaultTimezone.Name && !isValidTimeZone(value) {
 		return false
 	}
+	if name == SettingMaxMinutesCheckIn.Name {
+		if minutes, _ := strconv.Atoi(value); minutes < 1 {
+			return false
+		}
+	}
+	if name == SettingCheckOutGranularityMinutes.Name {
+		if minutes, _ := strconv.Atoi(value); minutes < 1 || minutes > 24*60 {
+			return false
+		}
+	}
+	if name == SettingCalDAVReconcilePolicy.Name {
+		if policy, _ := strconv.Atoi(value); policy < CalDAVReconcilePolicyDisabled || policy > CalDAVReconcilePolicyUpdate {
+			return false
+		}
+	}
+	if name == SettingReminderMinutes.Name {
+		if minutes, _ := strconv.Atoi(value); minutes < 0 || minutes > BookingReminderMaxMinutes {
+			return false
+		}
+	}
 	return true
 }
 
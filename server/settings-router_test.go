@@ -60,6 +60,12 @@ func TestSettingsReadPublic(t *testing.T) {
 		SettingAllowBookingsNonExistingUsers.Name,
 		SettingDefaultTimezone.Name,
 		SettingCustomLogoUrl.Name,
+		SettingWaitlistAutoBook.Name,
+		SettingEnableCheckIn.Name,
+		SettingMaxMinutesCheckIn.Name,
+		SettingCheckOutGranularityMinutes.Name,
+		SettingCalDAVReconcilePolicy.Name,
+		SettingReminderMinutes.Name,
 		SysSettingVersion,
 	}
 	forbiddenSettings := []string{
@@ -69,6 +75,7 @@ func TestSettingsReadPublic(t *testing.T) {
 		SettingConfluenceAnonymous.Name,
 		SettingActiveSubscription.Name,
 		SettingSubscriptionMaxUsers.Name,
+		SettingTOTPRequiredAdmins.Name,
 	}
 
 	for _, name := range allowedSettings {
@@ -128,6 +135,13 @@ func TestSettingsReadAdmin(t *testing.T) {
 		SettingSubscriptionMaxUsers.Name,
 		SettingDefaultTimezone.Name,
 		SettingCustomLogoUrl.Name,
+		SettingWaitlistAutoBook.Name,
+		SettingEnableCheckIn.Name,
+		SettingMaxMinutesCheckIn.Name,
+		SettingCheckOutGranularityMinutes.Name,
+		SettingCalDAVReconcilePolicy.Name,
+		SettingReminderMinutes.Name,
+		SettingTOTPRequiredAdmins.Name,
 		SysSettingOrgSignupDelete,
 		SysSettingVersion,
 	}
@@ -24,11 +24,11 @@ type GetSpaceAttributeResponse struct {
 }
 
 func (router *SpaceAttributeRouter) setupRoutes(s *mux.Router) {
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionSpacesAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionSpacesAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionSpacesAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionBookingsRead)
 }
 
 func (router *SpaceAttributeRouter) getOne(w http.ResponseWriter, r *http.Request) {
@@ -77,7 +77,7 @@ func (router *SpaceAttributeRouter) update(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -89,6 +89,7 @@ func (router *SpaceAttributeRouter) update(w http.ResponseWriter, r *http.Reques
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntitySpaceAttribute, e.ID, router.copyToRestModel(e), router.copyToRestModel(eNew))
 	SendUpdated(w)
 }
 
@@ -100,7 +101,7 @@ func (router *SpaceAttributeRouter) delete(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -109,6 +110,7 @@ func (router *SpaceAttributeRouter) delete(w http.ResponseWriter, r *http.Reques
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntitySpaceAttribute, e.ID, router.copyToRestModel(e), nil)
 	SendUpdated(w)
 }
 
@@ -121,15 +123,12 @@ func (router *SpaceAttributeRouter) create(w http.ResponseWriter, r *http.Reques
 	user := GetRequestUser(r)
 	e := router.copyFromRestModel(&m)
 	e.OrganizationID = user.OrganizationID
-	if !CanSpaceAdminOrg(user, e.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	if err := GetSpaceAttributeRepository().Create(e); err != nil {
 		log.Println(err)
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntitySpaceAttribute, e.ID, nil, router.copyToRestModel(e))
 	SendCreated(w, e.ID)
 }
 
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// getSpaceCheckInToken returns the token proving a check-in request originates
+// from the QR code printed on the space. It's random per space and stays stable
+// until an admin rotates it, i.e. because a label has been copied.
+func getSpaceCheckInToken(spaceID string) (string, error) {
+	return GetSpaceRepository().GetCheckInToken(spaceID)
+}
+
+func isValidSpaceCheckInToken(spaceID, token string) bool {
+	expected, err := getSpaceCheckInToken(spaceID)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(token))
+}
+
+// getSpaceCheckInURL returns the booking UI URL encoded in the space's QR code.
+// The UI forwards the token to POST /booking/checkin/{spaceId}/{token}.
+func getSpaceCheckInURL(spaceID string) (string, error) {
+	token, err := getSpaceCheckInToken(spaceID)
+	if err != nil {
+		return "", err
+	}
+	return GetConfig().FrontendURL + "ui/checkin/" + spaceID + "/" + token, nil
+}
+
+// getNewSpaceCheckInToken returns a new random, URL safe check-in token.
+func getNewSpaceCheckInToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
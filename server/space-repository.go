@@ -32,8 +32,9 @@ type SpaceAvailabilityBookingEntry struct {
 
 type SpaceAvailability struct {
 	Space
-	Available bool
-	Bookings  []*SpaceAvailabilityBookingEntry
+	Available  bool
+	Restricted bool
+	Bookings   []*SpaceAvailabilityBookingEntry
 }
 
 type SpaceDetails struct {
@@ -69,7 +70,12 @@ func GetSpaceRepository() *SpaceRepository {
 }
 
 func (r *SpaceRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
-	// No updates yet
+	if curVersion < 26 {
+		if _, err := GetDatabase().DB().Exec("ALTER TABLE spaces " +
+			"ADD COLUMN checkin_token VARCHAR NULL"); err != nil {
+			panic(err)
+		}
+	}
 }
 
 func (r *SpaceRepository) Create(e *Space) error {
@@ -98,8 +104,19 @@ func (r *SpaceRepository) GetOne(id string) (*Space, error) {
 	return e, nil
 }
 
-func (r *SpaceRepository) GetAllInTime(locationID string, enter, leave time.Time) ([]*SpaceAvailability, error) {
+// GetAllInTime returns the location's spaces along with their bookings in the
+// given period. If a user ID is passed, spaces restricted to groups the user is
+// not a member of are marked as restricted and unavailable.
+func (r *SpaceRepository) GetAllInTime(locationID string, userID string, enter, leave time.Time) ([]*SpaceAvailability, error) {
 	var result []*SpaceAvailability
+	restricted := make(map[string]bool)
+	if userID != "" {
+		var err error
+		restricted, err = GetGroupRepository().GetRestrictedSpaceIDs(locationID, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
 	subQueryWhere := "bookings.space_id = spaces.id AND (" +
 		"($1 >= bookings.enter_time AND $1 < bookings.leave_time) OR " +
 		"($2 >= bookings.enter_time AND $2 <= bookings.leave_time) OR " +
@@ -137,6 +154,10 @@ func (r *SpaceRepository) GetAllInTime(locationID string, enter, leave time.Time
 		if err != nil {
 			return nil, err
 		}
+		if restricted[e.ID] {
+			e.Restricted = true
+			e.Available = false
+		}
 		result = append(result, e)
 	}
 	return result, nil
@@ -198,10 +219,48 @@ func (r *SpaceRepository) Update(e *Space) error {
 	return err
 }
 
+// GetCheckInToken returns the space's check-in token. Spaces get their token on
+// first use.
+func (r *SpaceRepository) GetCheckInToken(spaceID string) (string, error) {
+	token, err := getNewSpaceCheckInToken()
+	if err != nil {
+		return "", err
+	}
+	err = GetDatabase().DB().QueryRow("UPDATE spaces SET "+
+		"checkin_token = COALESCE(checkin_token, $2) "+
+		"WHERE id = $1 "+
+		"RETURNING checkin_token",
+		spaceID, token).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateCheckInToken replaces the space's check-in token, invalidating printed
+// QR codes.
+func (r *SpaceRepository) RotateCheckInToken(spaceID string) error {
+	token, err := getNewSpaceCheckInToken()
+	if err != nil {
+		return err
+	}
+	_, err = GetDatabase().DB().Exec("UPDATE spaces SET "+
+		"checkin_token = $2 "+
+		"WHERE id = $1",
+		spaceID, token)
+	return err
+}
+
 func (r *SpaceRepository) Delete(e *Space) error {
 	// if _, err := GetDatabase().DB().Exec("DELETE FROM bookings WHERE bookings.space_id = $1", e.ID); err != nil {
 	// 	return err
 	// }
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_restrictions WHERE entity_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE space_id = $1", e.ID); err != nil {
+		return err
+	}
 	_, err := GetDatabase().DB().Exec("DELETE FROM spaces WHERE id = $1", e.ID)
 	return err
 }
@@ -240,7 +299,9 @@ func (r *SpaceRepository) GetTotalCountMap(organizationID string) (map[string]in
 	return res, nil
 }
 
-func (r *SpaceRepository) GetFreeCountMap(organizationID string, enter, leave time.Time) (map[string]int, error) {
+// GetFreeCountMap returns the number of spaces per location which are available
+// to the user in the given period.
+func (r *SpaceRepository) GetFreeCountMap(organizationID string, userID string, enter, leave time.Time) (map[string]int, error) {
 	res := make(map[string]int)
 	locations, _ := GetLocationRepository().GetAll(organizationID)
 	for _, location := range locations {
@@ -252,7 +313,7 @@ func (r *SpaceRepository) GetFreeCountMap(organizationID string, enter, leave ti
 		if err != nil {
 			return nil, err
 		}
-		spaces, _ := r.GetAllInTime(location.ID, enterNew, leaveNew)
+		spaces, _ := r.GetAllInTime(location.ID, userID, enterNew, leaveNew)
 		res[location.ID] = 0
 		for _, space := range spaces {
 			if space.Available {
@@ -275,7 +336,7 @@ func (r *SpaceRepository) GetBookingUserIDMap(organizationID string, enter, leav
 		if err != nil {
 			return nil, err
 		}
-		spaces, _ := r.GetAllInTime(location.ID, enterNew, leaveNew)
+		spaces, _ := r.GetAllInTime(location.ID, "", enterNew, leaveNew)
 		res[location.ID] = make([]string, 0)
 		for _, space := range spaces {
 			for _, booking := range space.Bookings {
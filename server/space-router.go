@@ -3,9 +3,11 @@ package main
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
 )
 
 type SpaceRouter struct {
@@ -60,7 +62,8 @@ type GetSpaceAvailabilityBookingsResponse struct {
 
 type GetSpaceAvailabilityResponse struct {
 	GetSpaceResponse
-	Bookings []*GetSpaceAvailabilityBookingsResponse `json:"bookings"`
+	Restricted bool                                    `json:"restricted"`
+	Bookings   []*GetSpaceAvailabilityBookingsResponse `json:"bookings"`
 }
 
 type GetSpaceAvailabilityRequest struct {
@@ -69,13 +72,17 @@ type GetSpaceAvailabilityRequest struct {
 }
 
 func (router *SpaceRouter) setupRoutes(s *mux.Router) {
-	s.HandleFunc("/availability", router.getAvailability).Methods("POST")
-	s.HandleFunc("/bulk", router.bulkUpdate).Methods("POST")
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/availability", router.getAvailability).Methods("POST"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/bulk", router.bulkUpdate).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/qr", router.getCheckInQRCode).Methods("GET"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/qr/rotate", router.rotateCheckInToken).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/group/", router.getGroupRestrictions).Methods("GET"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}/group/", router.setGroupRestrictions).Methods("PUT"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionBookingsRead)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionLocationsAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionBookingsRead)
 }
 
 func (router *SpaceRouter) getOne(w http.ResponseWriter, r *http.Request) {
@@ -100,6 +107,94 @@ func (router *SpaceRouter) getOne(w http.ResponseWriter, r *http.Request) {
 	SendJSON(w, res)
 }
 
+// getCheckInQRCode renders a PNG QR code pointing to the space's check-in
+// URL, suitable for printing desk labels.
+func (router *SpaceRouter) getCheckInQRCode(w http.ResponseWriter, r *http.Request) {
+	e, _ := router.getAdminSpace(w, r)
+	if e == nil {
+		return
+	}
+	scale, err := strconv.Atoi(r.URL.Query().Get("scale"))
+	if err != nil || scale < 1 || scale > 32 {
+		scale = 8
+	}
+	url, err := getSpaceCheckInURL(e.ID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	data, err := qr.PNG(-scale)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Check-In-URL", url)
+	w.Write(data)
+}
+
+// rotateCheckInToken invalidates the QR codes printed for the space. New ones
+// have to be printed afterwards.
+func (router *SpaceRouter) rotateCheckInToken(w http.ResponseWriter, r *http.Request) {
+	e, location := router.getAdminSpace(w, r)
+	if e == nil {
+		return
+	}
+	if err := GetSpaceRepository().RotateCheckInToken(e.ID); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionRotate, AuditEntitySpace, e.ID, nil, nil)
+	SendUpdated(w)
+}
+
+func (router *SpaceRouter) getGroupRestrictions(w http.ResponseWriter, r *http.Request) {
+	e, _ := router.getAdminSpace(w, r)
+	if e == nil {
+		return
+	}
+	sendGroupRestrictions(w, e.ID, GroupRestrictionEntityTypeSpace)
+}
+
+func (router *SpaceRouter) setGroupRestrictions(w http.ResponseWriter, r *http.Request) {
+	e, location := router.getAdminSpace(w, r)
+	if e == nil {
+		return
+	}
+	setGroupRestrictions(w, r, location.OrganizationID, e.ID, GroupRestrictionEntityTypeSpace)
+}
+
+// getAdminSpace returns the space specified in the request and its
+// location if the requesting user can manage the location. Otherwise, it sends
+// an error response and returns nil.
+func (router *SpaceRouter) getAdminSpace(w http.ResponseWriter, r *http.Request) (*Space, *Location) {
+	vars := mux.Vars(r)
+	e, err := GetSpaceRepository().GetOne(vars["id"])
+	if err != nil || e.LocationID != vars["locationId"] {
+		SendNotFound(w)
+		return nil, nil
+	}
+	location, err := GetLocationRepository().GetOne(e.LocationID)
+	if err != nil {
+		SendNotFound(w)
+		return nil, nil
+	}
+	if !CanSpaceAdminLocation(GetRequestUser(r), location) {
+		SendForbidden(w)
+		return nil, nil
+	}
+	return e, location
+}
+
 func (router *SpaceRouter) getAvailability(w http.ResponseWriter, r *http.Request) {
 	var m GetSpaceAvailabilityRequest
 	if UnmarshalValidateBody(r, &m) != nil {
@@ -128,12 +223,12 @@ func (router *SpaceRouter) getAvailability(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	var showNames bool = false
-	if CanSpaceAdminOrg(user, location.OrganizationID) {
+	if CanSpaceAdminLocation(user, location) {
 		showNames = true
 	} else {
 		showNames, _ = GetSettingsRepository().GetBool(location.OrganizationID, SettingShowNames.Name)
 	}
-	list, err := GetSpaceRepository().GetAllInTime(location.ID, enterNew, leaveNew)
+	list, err := GetSpaceRepository().GetAllInTime(location.ID, user.ID, enterNew, leaveNew)
 	if err != nil {
 		log.Println(err)
 		SendInternalServerError(w)
@@ -151,6 +246,7 @@ func (router *SpaceRouter) getAvailability(w http.ResponseWriter, r *http.Reques
 		m.Height = e.Height
 		m.Rotation = e.Rotation
 		m.Available = e.Available
+		m.Restricted = e.Restricted
 		m.Bookings = []*GetSpaceAvailabilityBookingsResponse{}
 		for _, booking := range e.Bookings {
 			var showName bool = showNames
@@ -189,7 +285,7 @@ func (router *SpaceRouter) bulkUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, location.OrganizationID) {
+	if !CanSpaceAdminLocation(user, location) {
 		SendForbidden(w)
 		return
 	}
@@ -204,12 +300,14 @@ func (router *SpaceRouter) bulkUpdate(w http.ResponseWriter, r *http.Request) {
 	if m.DeleteIDs != nil {
 		for _, deleteID := range m.DeleteIDs {
 			e, err := GetSpaceRepository().GetOne(deleteID)
-			if err != nil {
+			if err != nil || !router.canAdminSpace(user, e, location) {
 				res.Deletes = append(res.Deletes, BulkUpdateItemResponse{ID: deleteID, Success: false})
 			} else {
 				if err := GetSpaceRepository().Delete(e); err != nil {
 					res.Deletes = append(res.Deletes, BulkUpdateItemResponse{ID: deleteID, Success: false})
 				} else {
+					fireSpaceWebhookEvent(e, location, WebhookEventSpaceDeleted)
+					writeAuditLog(r, location.OrganizationID, AuditActionDelete, AuditEntitySpace, e.ID, router.copyToRestModel(e), nil)
 					res.Deletes = append(res.Deletes, BulkUpdateItemResponse{ID: deleteID, Success: true})
 				}
 			}
@@ -225,6 +323,8 @@ func (router *SpaceRouter) bulkUpdate(w http.ResponseWriter, r *http.Request) {
 				log.Println(err)
 				res.Creates = append(res.Creates, BulkUpdateItemResponse{ID: "", Success: false})
 			} else {
+				fireSpaceWebhookEvent(e, location, WebhookEventSpaceCreated)
+				writeAuditLog(r, location.OrganizationID, AuditActionCreate, AuditEntitySpace, e.ID, nil, router.copyToRestModel(e))
 				res.Creates = append(res.Creates, BulkUpdateItemResponse{ID: e.ID, Success: true})
 			}
 		}
@@ -236,10 +336,17 @@ func (router *SpaceRouter) bulkUpdate(w http.ResponseWriter, r *http.Request) {
 			e := router.copyFromRestModel(&mSpace.CreateSpaceRequest)
 			e.ID = mSpace.ID
 			e.LocationID = vars["locationId"]
+			if existing, err := GetSpaceRepository().GetOne(e.ID); err != nil || !router.canAdminSpace(user, existing, location) {
+				res.Updates = append(res.Updates, BulkUpdateItemResponse{ID: "", Success: false})
+				continue
+			}
+			before := router.getAuditLogData(e.ID)
 			if err := GetSpaceRepository().Update(e); err != nil {
 				log.Println(err)
 				res.Updates = append(res.Updates, BulkUpdateItemResponse{ID: "", Success: false})
 			} else {
+				fireSpaceWebhookEvent(e, location, WebhookEventSpaceUpdated)
+				writeAuditLog(r, location.OrganizationID, AuditActionUpdate, AuditEntitySpace, e.ID, before, router.copyToRestModel(e))
 				res.Updates = append(res.Updates, BulkUpdateItemResponse{ID: e.ID, Success: true})
 			}
 		}
@@ -289,15 +396,27 @@ func (router *SpaceRouter) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, location.OrganizationID) {
+	if !CanSpaceAdminLocation(user, location) {
 		SendForbidden(w)
 		return
 	}
+	existing, err := GetSpaceRepository().GetOne(e.ID)
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	if !router.canAdminSpace(user, existing, location) {
+		SendForbidden(w)
+		return
+	}
+	before := router.getAuditLogData(e.ID)
 	if err := GetSpaceRepository().Update(e); err != nil {
 		log.Println(err)
 		SendInternalServerError(w)
 		return
 	}
+	fireSpaceWebhookEvent(e, location, WebhookEventSpaceUpdated)
+	writeAuditLog(r, location.OrganizationID, AuditActionUpdate, AuditEntitySpace, e.ID, before, router.copyToRestModel(e))
 	SendUpdated(w)
 }
 
@@ -314,7 +433,7 @@ func (router *SpaceRouter) delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, location.OrganizationID) {
+	if !CanSpaceAdminLocation(user, location) {
 		SendForbidden(w)
 		return
 	}
@@ -322,6 +441,8 @@ func (router *SpaceRouter) delete(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	fireSpaceWebhookEvent(e, location, WebhookEventSpaceDeleted)
+	writeAuditLog(r, location.OrganizationID, AuditActionDelete, AuditEntitySpace, e.ID, router.copyToRestModel(e), nil)
 	SendUpdated(w)
 }
 
@@ -340,7 +461,7 @@ func (router *SpaceRouter) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, location.OrganizationID) {
+	if !CanSpaceAdminLocation(user, location) {
 		SendForbidden(w)
 		return
 	}
@@ -349,9 +470,25 @@ func (router *SpaceRouter) create(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	fireSpaceWebhookEvent(e, location, WebhookEventSpaceCreated)
+	writeAuditLog(r, location.OrganizationID, AuditActionCreate, AuditEntitySpace, e.ID, nil, router.copyToRestModel(e))
 	SendCreated(w, e.ID)
 }
 
+// canAdminSpace returns true if the user can manage the space in its current
+// location. Spaces can only be moved to the target location by users who can
+// manage both locations.
+func (router *SpaceRouter) canAdminSpace(user *User, e *Space, target *Location) bool {
+	if e.LocationID == target.ID {
+		return true
+	}
+	location, err := GetLocationRepository().GetOne(e.LocationID)
+	if err != nil {
+		return false
+	}
+	return CanSpaceAdminLocation(user, location)
+}
+
 func (router *SpaceRouter) copyFromRestModel(m *CreateSpaceRequest) *Space {
 	e := &Space{}
 	e.Name = m.Name
@@ -363,6 +500,16 @@ func (router *SpaceRouter) copyFromRestModel(m *CreateSpaceRequest) *Space {
 	return e
 }
 
+// getAuditLogData returns the space's current state for the audit log, or nil
+// if it doesn't exist.
+func (router *SpaceRouter) getAuditLogData(id string) interface{} {
+	e, err := GetSpaceRepository().GetOne(id)
+	if err != nil {
+		return nil
+	}
+	return router.copyToRestModel(e)
+}
+
 func (router *SpaceRouter) copyToRestModel(e *Space) *GetSpaceResponse {
 	m := &GetSpaceResponse{}
 	m.ID = e.ID
@@ -412,3 +412,42 @@ func createTestSpaces(t *testing.T, loginResponse *LoginResponse) (lID, s1ID, s2
 
 	return locationID, space1ID, space2ID, space3ID
 }
+
+func TestSpacesCheckInQRCode(t *testing.T) {
+	clearTestDB()
+	org, l, s := createTestOrgWithSpace()
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("GET", "/location/"+l.ID+"/space/"+s.ID+"/qr", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/location/"+l.ID+"/space/"+s.ID+"/qr", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "image/png", res.Header().Get("Content-Type"))
+	url, _ := getSpaceCheckInURL(s.ID)
+	checkTestString(t, url, res.Header().Get("X-Check-In-URL"))
+	checkTestBool(t, true, bytes.HasPrefix(res.Body.Bytes(), []byte("\x89PNG\r\n\x1a\n")))
+}
+
+func TestSpacesRotateCheckInToken(t *testing.T) {
+	clearTestDB()
+	org, l, s := createTestOrgWithSpace()
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	token, _ := getSpaceCheckInToken(s.ID)
+
+	req := newHTTPRequest("POST", "/location/"+l.ID+"/space/"+s.ID+"/qr/rotate", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	checkTestBool(t, true, isValidSpaceCheckInToken(s.ID, token))
+
+	req = newHTTPRequest("POST", "/location/"+l.ID+"/space/"+s.ID+"/qr/rotate", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, false, isValidSpaceCheckInToken(s.ID, token))
+	newToken, _ := getSpaceCheckInToken(s.ID)
+	checkTestBool(t, true, isValidSpaceCheckInToken(s.ID, newToken))
+}
@@ -26,15 +26,11 @@ type GetStatsResponse struct {
 }
 
 func (router *StatsRouter) setupRoutes(s *mux.Router) {
-	s.HandleFunc("/", router.getStats).Methods("GET")
+	RequirePermission(s.HandleFunc("/", router.getStats).Methods("GET"), PermissionSpacesAdmin)
 }
 
 func (router *StatsRouter) getStats(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	m := &GetStatsResponse{}
 	m.NumUsers, _ = GetUserRepository().GetCount(user.OrganizationID)
 	m.NumBookings, _ = GetBookingRepository().GetCount(user.OrganizationID)
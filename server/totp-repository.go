@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type TOTPRepository struct {
+}
+
+// TOTP is a user's second factor for password logins. The secret is stored
+// encrypted. Until the user has confirmed a code, the secret is pending and
+// not enabled. LastStep is the time step of the last code accepted, so that
+// codes can't be reused.
+type TOTP struct {
+	UserID   string
+	Secret   string
+	Enabled  bool
+	LastStep int64
+	Created  time.Time
+}
+
+var totpRepository *TOTPRepository
+var totpRepositoryOnce sync.Once
+
+func GetTOTPRepository() *TOTPRepository {
+	totpRepositoryOnce.Do(func() {
+		totpRepository = &TOTPRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS user_totp (" +
+			"user_id uuid NOT NULL, " +
+			"secret VARCHAR NOT NULL, " +
+			"enabled boolean NOT NULL DEFAULT FALSE, " +
+			"last_step BIGINT NOT NULL DEFAULT 0, " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (user_id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS user_totp_recovery_codes (" +
+			"user_id uuid NOT NULL, " +
+			"code_hash VARCHAR NOT NULL, " +
+			"PRIMARY KEY (user_id, code_hash))")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return totpRepository
+}
+
+func (r *TOTPRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+// Set stores a new pending secret for the user, replacing an existing one.
+func (r *TOTPRepository) Set(e *TOTP) error {
+	_, err := GetDatabase().DB().Exec("INSERT INTO user_totp "+
+		"(user_id, secret, enabled, last_step, created) "+
+		"VALUES ($1, $2, $3, $4, $5) "+
+		"ON CONFLICT (user_id) DO UPDATE SET "+
+		"secret = $2, enabled = $3, last_step = $4, created = $5",
+		e.UserID, encryptString(e.Secret), e.Enabled, e.LastStep, e.Created.UTC())
+	return err
+}
+
+func (r *TOTPRepository) GetOne(userID string) (*TOTP, error) {
+	e := &TOTP{}
+	err := GetDatabase().DB().QueryRow("SELECT user_id, secret, enabled, last_step, created "+
+		"FROM user_totp "+
+		"WHERE user_id = $1",
+		userID).Scan(&e.UserID, &e.Secret, &e.Enabled, &e.LastStep, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	e.Secret = decryptString(e.Secret)
+	return e, nil
+}
+
+// IsEnabled returns true if the user has confirmed the enrollment.
+func (r *TOTPRepository) IsEnabled(userID string) bool {
+	var enabled bool
+	err := GetDatabase().DB().QueryRow("SELECT enabled FROM user_totp WHERE user_id = $1", userID).Scan(&enabled)
+	return err == nil && enabled
+}
+
+func (r *TOTPRepository) Enable(e *TOTP) error {
+	_, err := GetDatabase().DB().Exec("UPDATE user_totp SET enabled = TRUE WHERE user_id = $1", e.UserID)
+	return err
+}
+
+// ClaimStep marks the code of the time step as used. It returns false if a
+// code of the same or a later step has already been used, so that each code
+// is accepted only once, even with concurrent requests.
+func (r *TOTPRepository) ClaimStep(e *TOTP, step int64) (bool, error) {
+	var userID string
+	err := GetDatabase().DB().QueryRow("UPDATE user_totp SET "+
+		"last_step = $2 "+
+		"WHERE user_id = $1 AND last_step < $2 "+
+		"RETURNING user_id",
+		e.UserID, step).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.LastStep = step
+	return true, nil
+}
+
+// Delete disables two-factor authentication for the user.
+func (r *TOTPRepository) Delete(userID string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err := GetDatabase().DB().Exec("DELETE FROM user_totp WHERE user_id = $1", userID)
+	return err
+}
+
+// SetRecoveryCodes replaces the user's recovery codes. Only hashes of the
+// codes are stored.
+func (r *TOTPRepository) SetRecoveryCodes(userID string, codes []string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := GetDatabase().DB().Exec("INSERT INTO user_totp_recovery_codes "+
+			"(user_id, code_hash) "+
+			"VALUES ($1, $2) "+
+			"ON CONFLICT (user_id, code_hash) DO NOTHING",
+			userID, GetAPITokenHash(NormalizeTOTPRecoveryCode(code))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode removes the recovery code. It returns false if the code is
+// not one of the user's unused recovery codes.
+func (r *TOTPRepository) UseRecoveryCode(userID, code string) (bool, error) {
+	var codeHash string
+	err := GetDatabase().DB().QueryRow("DELETE FROM user_totp_recovery_codes "+
+		"WHERE user_id = $1 AND code_hash = $2 "+
+		"RETURNING code_hash",
+		userID, GetAPITokenHash(NormalizeTOTPRecoveryCode(code))).Scan(&codeHash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *TOTPRepository) GetRecoveryCodeCount(userID string) (int, error) {
+	var res int
+	err := GetDatabase().DB().QueryRow("SELECT COUNT(*) FROM user_totp_recovery_codes WHERE user_id = $1", userID).Scan(&res)
+	return res, err
+}
+
+// CheckCode returns true if the code is valid for the secret and hasn't been
+// used before. Once enabled, one of the user's recovery codes is accepted as
+// well.
+func (r *TOTPRepository) CheckCode(e *TOTP, code string, now time.Time) (bool, error) {
+	if step, ok := ValidateTOTPCode(e.Secret, code, now, e.LastStep); ok {
+		return r.ClaimStep(e, step)
+	}
+	if !e.Enabled {
+		return false, nil
+	}
+	return r.UseRecoveryCode(e.UserID, code)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time-based one-time passwords (RFC 6238) using the parameters supported by
+// all common authenticator apps: HMAC-SHA1, 6 digits, 30 second period.
+
+const (
+	TOTPDigits            = 6
+	TOTPPeriod            = 30
+	TOTPSkew              = 1 // Number of periods accepted before and after the current one
+	TOTPRecoveryCodeCount = 10
+	TOTPIssuer            = "Seatsurfing"
+	TOTPMaxAttempts       = 3               // Number of codes which can be entered per login challenge
+	TOTPChallengeExpiry   = 5 * time.Minute // Time to enter the code after the password login
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GetTOTPSecret returns a new random base32-encoded secret.
+func GetTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// GetTOTPStep returns the time step the specified time belongs to.
+func GetTOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// GetTOTPCode returns the code for the secret at the specified time step.
+func GetTOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTPCode checks the code against the secret at the specified time,
+// allowing for clock skew. Codes of steps up to lastStep have already been
+// used and are rejected. It returns the step of the matching code.
+func ValidateTOTPCode(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	cur := GetTOTPStep(t)
+	for step := cur - TOTPSkew; step <= cur+TOTPSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := GetTOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GetTOTPProvisioningURI returns the otpauth URI to be encoded in the QR code
+// scanned by authenticator apps.
+func GetTOTPProvisioningURI(secret, issuer, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// GetTOTPRecoveryCodes returns new random single-use recovery codes, formatted
+// as two groups of five characters.
+func GetTOTPRecoveryCodes() ([]string, error) {
+	res := []string{}
+	for i := 0; i < TOTPRecoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		res = append(res, s[:5]+"-"+s[5:])
+	}
+	return res, nil
+}
+
+// NormalizeTOTPRecoveryCode removes formatting entered by the user, so that
+// the code can be compared with the stored hash.
+func NormalizeTOTPRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+	return code
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret of the RFC 6238 SHA1 test vectors ("12345678901234567890")
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC's 8 digit codes truncated to 6 digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for ts, expected := range vectors {
+		code, err := GetTOTPCode(testTOTPSecret, GetTOTPStep(time.Unix(ts, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTestString(t, expected, code)
+	}
+}
+
+func TestTOTPValidateCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := GetTOTPStep(now)
+	code, _ := GetTOTPCode(testTOTPSecret, step)
+
+	res, ok := ValidateTOTPCode(testTOTPSecret, code, now, 0)
+	checkTestBool(t, true, ok)
+	checkTestBool(t, true, res == step)
+
+	// Clock skew of one period
+	res, ok = ValidateTOTPCode(testTOTPSecret, code, now.Add(TOTPPeriod*time.Second), 0)
+	checkTestBool(t, true, ok)
+	checkTestBool(t, true, res == step)
+	_, ok = ValidateTOTPCode(testTOTPSecret, code, now.Add(2*TOTPPeriod*time.Second), 0)
+	checkTestBool(t, false, ok)
+
+	// Already used
+	_, ok = ValidateTOTPCode(testTOTPSecret, code, now, step)
+	checkTestBool(t, false, ok)
+
+	// Invalid codes
+	_, ok = ValidateTOTPCode(testTOTPSecret, "123", now, 0)
+	checkTestBool(t, false, ok)
+	_, ok = ValidateTOTPCode(testTOTPSecret, "", now, 0)
+	checkTestBool(t, false, ok)
+	_, ok = ValidateTOTPCode("invalid!", code, now, 0)
+	checkTestBool(t, false, ok)
+}
+
+func TestTOTPSecret(t *testing.T) {
+	secret, err := GetTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 32, len(secret))
+	_, err = GetTOTPCode(secret, 1)
+	checkTestBool(t, true, err == nil)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(GetTOTPProvisioningURI(testTOTPSecret, "Seatsurfing", "foo@test.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "otpauth", uri.Scheme)
+	checkTestString(t, "totp", uri.Host)
+	checkTestString(t, "/Seatsurfing:foo@test.com", uri.Path)
+	checkTestString(t, testTOTPSecret, uri.Query().Get("secret"))
+	checkTestString(t, "Seatsurfing", uri.Query().Get("issuer"))
+	checkTestString(t, "6", uri.Query().Get("digits"))
+	checkTestString(t, "30", uri.Query().Get("period"))
+}
+
+func TestTOTPRecoveryCodes(t *testing.T) {
+	codes, err := GetTOTPRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, TOTPRecoveryCodeCount, len(codes))
+	seen := map[string]bool{}
+	for _, code := range codes {
+		checkTestInt(t, 11, len(code))
+		checkTestString(t, "-", code[5:6])
+		checkTestBool(t, false, seen[code])
+		seen[code] = true
+	}
+	checkTestString(t, strings.ReplaceAll(codes[0], "-", ""), NormalizeTOTPRecoveryCode(" "+strings.ToUpper(codes[0])+" "))
+	checkTestString(t, "abcdefghij", NormalizeTOTPRecoveryCode("ABCDE FGHIJ"))
+}
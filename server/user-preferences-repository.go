@@ -34,6 +34,18 @@ var (
 	PreferenceCalDAVUser           PreferenceName = PreferenceName{Name: "caldav_user", Type: SettingTypeString}
 	PreferenceCalDAVPass           PreferenceName = PreferenceName{Name: "caldav_pass", Type: SettingTypeEncryptedString}
 	PreferenceCalDAVPath           PreferenceName = PreferenceName{Name: "caldav_path", Type: SettingTypeString}
+	PreferenceCalendarProvider     PreferenceName = PreferenceName{Name: "calendar_provider", Type: SettingTypeInt}
+	PreferenceMSGraphCalendar      PreferenceName = PreferenceName{Name: "msgraph_calendar", Type: SettingTypeString}
+	PreferenceMailBookingCreated   PreferenceName = PreferenceName{Name: "mail_booking_created", Type: SettingTypeBool}
+	PreferenceMailBookingOnBehalf  PreferenceName = PreferenceName{Name: "mail_booking_on_behalf", Type: SettingTypeBool}
+	PreferenceMailBookingChanged   PreferenceName = PreferenceName{Name: "mail_booking_changed", Type: SettingTypeBool}
+	PreferenceMailBookingCancelled PreferenceName = PreferenceName{Name: "mail_booking_cancelled", Type: SettingTypeBool}
+	PreferenceReminderMinutes      PreferenceName = PreferenceName{Name: "reminder_minutes", Type: SettingTypeInt}
+)
+
+var (
+	// PreferenceReminderMinutesDefault uses the organization's SettingReminderMinutes
+	PreferenceReminderMinutesDefault int = -1
 )
 
 var (
@@ -143,7 +155,12 @@ func (r *UserPreferencesRepository) InitDefaultSettingsForUser(userID string) er
 		"($1, '"+PreferenceNotBookedColor.Name+"', '#30d158'), "+
 		"($1, '"+PreferenceSelfBookedColor.Name+"', '#b825de'), "+
 		"($1, '"+PreferencePartiallyBookedColor.Name+"', '#ff9100'), "+
-		"($1, '"+PreferenceBuddyBookedColor.Name+"', '#2415c5') "+
+		"($1, '"+PreferenceBuddyBookedColor.Name+"', '#2415c5'), "+
+		"($1, '"+PreferenceMailBookingCreated.Name+"', '1'), "+
+		"($1, '"+PreferenceMailBookingOnBehalf.Name+"', '1'), "+
+		"($1, '"+PreferenceMailBookingChanged.Name+"', '1'), "+
+		"($1, '"+PreferenceMailBookingCancelled.Name+"', '1'), "+
+		"($1, '"+PreferenceReminderMinutes.Name+"', '"+strconv.Itoa(PreferenceReminderMinutesDefault)+"') "+
 		"ON CONFLICT (user_id, name) DO NOTHING",
 		userID)
 	return err
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -23,8 +25,21 @@ type ListCaldavCalendarsResponse struct {
 	Name string `json:"name"`
 }
 
+type ListMSGraphCalendarsResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type GetMSGraphAuthorizeResponse struct {
+	URL string `json:"url"`
+}
+
 func (router *UserPreferencesRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/caldav/listCalendars", router.caldavListCalendars).Methods("POST")
+	s.HandleFunc("/msgraph/authorize", router.msGraphAuthorize).Methods("GET")
+	s.HandleFunc("/msgraph/callback", router.msGraphCallback).Methods("GET")
+	s.HandleFunc("/msgraph/listCalendars", router.msGraphListCalendars).Methods("GET")
+	s.HandleFunc("/msgraph", router.msGraphDisconnect).Methods("DELETE")
 	s.HandleFunc("/{name}", router.getPreference).Methods("GET")
 	s.HandleFunc("/{name}", router.setPreference).Methods("PUT")
 	s.HandleFunc("/", router.getAll).Methods("GET")
@@ -42,8 +57,8 @@ func (router *UserPreferencesRouter) caldavListCalendars(w http.ResponseWriter,
 		SendBadRequest(w)
 		return
 	}
-	caldavClient := &CalDAVClient{}
-	if err := caldavClient.Connect(m.URL, m.Username, m.Password); err != nil {
+	caldavClient := newCalDAVClient(m.URL, m.Username, m.Password)
+	if err := caldavClient.Connect(); err != nil {
 		SendNotFound(w)
 		return
 	}
@@ -54,11 +69,113 @@ func (router *UserPreferencesRouter) caldavListCalendars(w http.ResponseWriter,
 	}
 	res := make([]*ListCaldavCalendarsResponse, 0)
 	for _, calendar := range calendars {
-		res = append(res, &ListCaldavCalendarsResponse{Path: calendar.Path, Name: calendar.Name})
+		res = append(res, &ListCaldavCalendarsResponse{Path: calendar.ID, Name: calendar.Name})
 	}
 	SendJSON(w, res)
 }
 
+// msGraphAuthorize returns the URL the user needs to visit in order to grant
+// access to the user's Microsoft 365 calendar.
+func (router *UserPreferencesRouter) msGraphAuthorize(w http.ResponseWriter, r *http.Request) {
+	if !isMSGraphEnabled() {
+		SendNotFound(w)
+		return
+	}
+	authState := &AuthState{
+		AuthProviderID: GetSettingsRepository().getNullUUID(),
+		Expiry:         time.Now().Add(time.Minute * 10),
+		AuthStateType:  AuthMSGraphConnect,
+		Payload:        GetRequestUserID(r),
+	}
+	if err := GetAuthStateRepository().Create(authState); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := &GetMSGraphAuthorizeResponse{
+		URL: getMSGraphOAuthConfig().AuthCodeURL(authState.ID),
+	}
+	SendJSON(w, res)
+}
+
+func (router *UserPreferencesRouter) msGraphCallback(w http.ResponseWriter, r *http.Request) {
+	authState, err := GetAuthStateRepository().GetOne(r.FormValue("state"))
+	if err != nil || authState.AuthStateType != AuthMSGraphConnect {
+		SendTemporaryRedirect(w, router.getMSGraphRedirectUrl(false))
+		return
+	}
+	GetAuthStateRepository().Delete(authState)
+	if authState.Expiry.Before(time.Now()) || r.FormValue("code") == "" {
+		SendTemporaryRedirect(w, router.getMSGraphRedirectUrl(false))
+		return
+	}
+	token, err := getMSGraphOAuthConfig().Exchange(context.Background(), r.FormValue("code"))
+	if err != nil {
+		log.Println(err)
+		SendTemporaryRedirect(w, router.getMSGraphRedirectUrl(false))
+		return
+	}
+	userID := authState.Payload
+	calendarKey := getCalendarKey(userID)
+	if err := saveMSGraphToken(userID, token); err != nil {
+		log.Println(err)
+		SendTemporaryRedirect(w, router.getMSGraphRedirectUrl(false))
+		return
+	}
+	if err := router.doSetOne(userID, PreferenceCalendarProvider.Name, strconv.Itoa(int(CalendarProviderMSGraph))); err != nil {
+		log.Println(err)
+		SendTemporaryRedirect(w, router.getMSGraphRedirectUrl(false))
+		return
+	}
+	if err := router.onCalendarChanged(userID, calendarKey); err != nil {
+		log.Println(err)
+	}
+	SendTemporaryRedirect(w, router.getMSGraphRedirectUrl(true))
+}
+
+func (router *UserPreferencesRouter) msGraphListCalendars(w http.ResponseWriter, r *http.Request) {
+	if !isMSGraphEnabled() {
+		SendNotFound(w)
+		return
+	}
+	client := newMSGraphClient(GetRequestUserID(r))
+	if err := client.Connect(); err != nil {
+		SendNotFound(w)
+		return
+	}
+	calendars, err := client.ListCalendars()
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	res := make([]*ListMSGraphCalendarsResponse, 0)
+	for _, calendar := range calendars {
+		res = append(res, &ListMSGraphCalendarsResponse{ID: calendar.ID, Name: calendar.Name})
+	}
+	SendJSON(w, res)
+}
+
+func (router *UserPreferencesRouter) msGraphDisconnect(w http.ResponseWriter, r *http.Request) {
+	userID := GetRequestUserID(r)
+	calendarKey := getCalendarKey(userID)
+	if err := GetCalendarTokenRepository().Delete(userID, CalendarProviderMSGraph); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if err := router.onCalendarChanged(userID, calendarKey); err != nil {
+		log.Println(err)
+	}
+	SendUpdated(w)
+}
+
+func (router *UserPreferencesRouter) getMSGraphRedirectUrl(success bool) string {
+	if success {
+		return GetConfig().FrontendURL + "ui/preferences?msgraph=success"
+	}
+	return GetConfig().FrontendURL + "ui/preferences?msgraph=failed"
+}
+
 func (router *UserPreferencesRouter) getPreference(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
 	vars := mux.Vars(r)
@@ -95,12 +212,16 @@ func (router *UserPreferencesRouter) setPreference(w http.ResponseWriter, r *htt
 		SendBadRequest(w)
 		return
 	}
+	calendarKey := getCalendarKey(user.ID)
 	err := router.doSetOne(user.ID, vars["name"], value.Value)
 	if err != nil {
 		log.Println(err)
 		SendInternalServerError(w)
 		return
 	}
+	if err := router.onCalendarChanged(user.ID, calendarKey); err != nil {
+		log.Println(err)
+	}
 	SendUpdated(w)
 }
 
@@ -128,6 +249,7 @@ func (router *UserPreferencesRouter) setAll(w http.ResponseWriter, r *http.Reque
 		SendBadRequest(w)
 		return
 	}
+	calendarKey := getCalendarKey(user.ID)
 	for _, e := range list {
 		if !router.isValidPreferenceName(e.Name) {
 			SendNotFound(w)
@@ -148,9 +270,39 @@ func (router *UserPreferencesRouter) setAll(w http.ResponseWriter, r *http.Reque
 			return
 		}
 	}
+	if err := router.onCalendarChanged(user.ID, calendarKey); err != nil {
+		log.Println(err)
+	}
 	SendUpdated(w)
 }
 
+// onCalendarChanged restarts the synchronisation of the user's bookings if
+// the calendar differs from the one identified by previousKey. Event IDs of
+// the previous calendar are forgotten, so that reconciliation doesn't consider
+// these events as deleted, and upcoming bookings are created in the new one.
+func (router *UserPreferencesRouter) onCalendarChanged(userID, previousKey string) error {
+	if getCalendarKey(userID) == previousKey {
+		return nil
+	}
+	if err := GetCalDAVSyncRepository().DeleteAllByUser(userID); err != nil {
+		return err
+	}
+	if err := GetBookingRepository().ResetCalDavIDs(userID); err != nil {
+		return err
+	}
+	list, err := GetBookingRepository().GetAllByUser(userID, time.Now().UTC().Add(time.Hour*-14))
+	if err != nil {
+		return err
+	}
+	bookingRouter := &BookingRouter{}
+	for _, e := range list {
+		if err := bookingRouter.enqueueCalDavSync(&e.Booking, CalDAVSyncOperationUpdate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (router *UserPreferencesRouter) doSetOne(userID, name, value string) error {
 	if router.getPreferenceType(name) == SettingTypeEncryptedString {
 		value = encryptString(value)
@@ -173,7 +325,14 @@ func (router *UserPreferencesRouter) isValidPreferenceName(name string) bool {
 		name == PreferenceCalDAVURL.Name ||
 		name == PreferenceCalDAVUser.Name ||
 		name == PreferenceCalDAVPass.Name ||
-		name == PreferenceCalDAVPath.Name {
+		name == PreferenceCalDAVPath.Name ||
+		name == PreferenceCalendarProvider.Name ||
+		name == PreferenceMSGraphCalendar.Name ||
+		name == PreferenceMailBookingCreated.Name ||
+		name == PreferenceMailBookingOnBehalf.Name ||
+		name == PreferenceMailBookingChanged.Name ||
+		name == PreferenceMailBookingCancelled.Name ||
+		name == PreferenceReminderMinutes.Name {
 		return true
 	}
 	return false
@@ -222,6 +381,27 @@ func (router *UserPreferencesRouter) getPreferenceType(name string) SettingType
 	if name == PreferenceCalDAVPath.Name {
 		return PreferenceCalDAVPath.Type
 	}
+	if name == PreferenceCalendarProvider.Name {
+		return PreferenceCalendarProvider.Type
+	}
+	if name == PreferenceMSGraphCalendar.Name {
+		return PreferenceMSGraphCalendar.Type
+	}
+	if name == PreferenceMailBookingCreated.Name {
+		return PreferenceMailBookingCreated.Type
+	}
+	if name == PreferenceMailBookingOnBehalf.Name {
+		return PreferenceMailBookingOnBehalf.Type
+	}
+	if name == PreferenceMailBookingChanged.Name {
+		return PreferenceMailBookingChanged.Type
+	}
+	if name == PreferenceMailBookingCancelled.Name {
+		return PreferenceMailBookingCancelled.Type
+	}
+	if name == PreferenceReminderMinutes.Name {
+		return PreferenceReminderMinutes.Type
+	}
 	return 0
 }
 
@@ -273,6 +453,18 @@ func (router *UserPreferencesRouter) isValidPreferenceValue(name string, value s
 			return false
 		}
 	}
+	if name == PreferenceCalendarProvider.Name {
+		i, _ := strconv.Atoi(value)
+		if !(i == int(CalendarProviderCalDAV) || i == int(CalendarProviderMSGraph)) {
+			return false
+		}
+	}
+	if name == PreferenceReminderMinutes.Name {
+		i, _ := strconv.Atoi(value)
+		if i < PreferenceReminderMinutesDefault || i > BookingReminderMaxMinutes {
+			return false
+		}
+	}
 	if name == PreferenceWorkdays.Name {
 		tokens := strings.Split(value, ",")
 		ok := true
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"testing"
 )
 
@@ -77,3 +78,63 @@ func TestUserPreferencesCRUDMany(t *testing.T) {
 	checkTestString(t, "2", resBody2[0].Value)
 	checkTestString(t, "3", resBody2[1].Value)
 }
+
+func TestUserPreferencesMSGraphConnect(t *testing.T) {
+	clearTestDB()
+	server, _ := newMSGraphTestServer()
+	defer server.Close()
+	defer setMSGraphTestConfig(server)()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("GET", "/preference/msgraph/authorize", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetMSGraphAuthorizeResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	authURL, err := url.Parse(resBody.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, server.URL+"/"+msGraphTestTenant+"/oauth2/v2.0/authorize", authURL.Scheme+"://"+authURL.Host+authURL.Path)
+	state := authURL.Query().Get("state")
+
+	// Callback is public
+	req = newHTTPRequest("GET", "/preference/msgraph/callback?state="+state+"&code="+msGraphTestCode, "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusTemporaryRedirect, res.Code)
+	checkTestString(t, GetConfig().FrontendURL+"ui/preferences?msgraph=success", res.Header().Get("Location"))
+	provider, _ := GetUserPreferencesRepository().GetInt(user.ID, PreferenceCalendarProvider.Name)
+	checkTestInt(t, int(CalendarProviderMSGraph), provider)
+
+	// State can't be reused
+	req = newHTTPRequest("GET", "/preference/msgraph/callback?state="+state+"&code="+msGraphTestCode, "", nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusTemporaryRedirect, res.Code)
+	checkTestString(t, GetConfig().FrontendURL+"ui/preferences?msgraph=failed", res.Header().Get("Location"))
+
+	req = newHTTPRequest("GET", "/preference/msgraph/listCalendars", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var calendars []*ListMSGraphCalendarsResponse
+	json.Unmarshal(res.Body.Bytes(), &calendars)
+	checkTestInt(t, 1, len(calendars))
+	checkTestString(t, msGraphTestCalendar, calendars[0].ID)
+
+	req = newHTTPRequest("DELETE", "/preference/msgraph", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if _, err := getCalendarConfig(user.ID); err == nil {
+		t.Fatal("Expected calendar integration to be disabled")
+	}
+}
+
+func TestUserPreferencesMSGraphNotEnabled(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+
+	req := newHTTPRequest("GET", "/preference/msgraph/authorize", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
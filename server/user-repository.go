@@ -281,11 +281,89 @@ func (r *UserRepository) Delete(e *User) error {
 		"bookings.user_id = $1", e.ID); err != nil {
 		return err
 	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM booking_series WHERE "+
+		"booking_series.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE "+
+		"waitlist_entries.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE "+
+		"ical_feeds.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE "+
+		"caldav_sync_queue.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_logs WHERE "+
+		"caldav_logs.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM calendar_tokens WHERE "+
+		"calendar_tokens.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM api_tokens WHERE "+
+		"api_tokens.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp WHERE "+
+		"user_totp.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp_recovery_codes WHERE "+
+		"user_totp_recovery_codes.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM role_assignments WHERE "+
+		"role_assignments.user_id = $1", e.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members WHERE "+
+		"user_groups_members.user_id = $1", e.ID); err != nil {
+		return err
+	}
 	_, err := GetDatabase().DB().Exec("DELETE FROM users WHERE id = $1", e.ID)
 	return err
 }
 
 func (r *UserRepository) DeleteAll(organizationID string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE organization_id = $1", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE "+
+		"caldav_sync_queue.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_logs WHERE "+
+		"caldav_logs.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM calendar_tokens WHERE "+
+		"calendar_tokens.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM api_tokens WHERE organization_id = $1", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp WHERE "+
+		"user_totp.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp_recovery_codes WHERE "+
+		"user_totp_recovery_codes.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM role_assignments WHERE "+
+		"role_assignments.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members WHERE "+
+		"user_groups_members.user_id IN (SELECT users.id FROM users WHERE users.organization_id = $1)", organizationID); err != nil {
+		return err
+	}
 	_, err := GetDatabase().DB().Exec("DELETE FROM users WHERE organization_id = $1", organizationID)
 	return err
 }
@@ -316,6 +394,43 @@ func (r *UserRepository) mergeUsers(source, target *User) error {
 	if _, err := GetDatabase().DB().Exec("UPDATE bookings SET user_id = $2 WHERE user_id = $1", source.ID, target.ID); err != nil {
 		return err
 	}
+	if _, err := GetDatabase().DB().Exec("UPDATE booking_series SET user_id = $2 WHERE user_id = $1", source.ID, target.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("UPDATE waitlist_entries SET user_id = $2 WHERE user_id = $1", source.ID, target.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_logs WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM calendar_tokens WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM api_tokens WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp_recovery_codes WHERE user_id = $1", source.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("INSERT INTO role_assignments (user_id, location_id, role) "+
+		"SELECT $2::uuid, location_id, role FROM role_assignments WHERE user_id = $1 "+
+		"ON CONFLICT (user_id, location_id, role) DO NOTHING", source.ID, target.ID); err != nil {
+		return err
+	}
+	if _, err := GetDatabase().DB().Exec("INSERT INTO user_groups_members (group_id, user_id, from_claim) "+
+		"SELECT group_id, $2::uuid, from_claim FROM user_groups_members WHERE user_id = $1 "+
+		"ON CONFLICT (group_id, user_id) DO NOTHING", source.ID, target.ID); err != nil {
+		return err
+	}
 	if target.AtlassianID == "" {
 		target.AtlassianID = source.AtlassianID
 	}
@@ -379,6 +494,50 @@ func (r *UserRepository) DeleteObsoleteConfluenceAnonymousUsers() (int, error) {
 			"bookings.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
 			return 0, err
 		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM booking_series WHERE "+
+			"booking_series.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE "+
+			"waitlist_entries.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM ical_feeds WHERE "+
+			"ical_feeds.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_sync_queue WHERE "+
+			"caldav_sync_queue.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM caldav_logs WHERE "+
+			"caldav_logs.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM calendar_tokens WHERE "+
+			"calendar_tokens.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM api_tokens WHERE "+
+			"api_tokens.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp WHERE "+
+			"user_totp.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM user_totp_recovery_codes WHERE "+
+			"user_totp_recovery_codes.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM role_assignments WHERE "+
+			"role_assignments.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
+		if _, err := GetDatabase().DB().Exec("DELETE FROM user_groups_members WHERE "+
+			"user_groups_members.user_id = ANY($1)", pq.Array(&userIDs)); err != nil {
+			return 0, err
+		}
 	}
 	return len(userIDs), nil
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
 )
 
 type UserRouter struct {
@@ -28,6 +31,9 @@ type GetUserResponse struct {
 	SpaceAdmin      bool                    `json:"spaceAdmin"`
 	OrgAdmin        bool                    `json:"admin"`
 	SuperAdmin      bool                    `json:"superAdmin"`
+	// SpaceAdminLocationIDs lists the locations the user administrates without
+	// being an organization-wide space admin. It's only set for /user/me.
+	SpaceAdminLocationIDs []string `json:"spaceAdminLocationIds,omitempty"`
 	CreateUserRequest
 }
 
@@ -50,6 +56,27 @@ type SetPasswordRequest struct {
 	Password string `json:"password"`
 }
 
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type GetTOTPResponse struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret          string   `json:"secret"`
+	ProvisioningURI string   `json:"provisioningUri"`
+	QRCode          string   `json:"qrCode"`
+	RecoveryCodes   []string `json:"recoveryCodes"`
+}
+
+type TOTPRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
 type InitMergeUsersRequest struct {
 	Email string `json:"email"`
 }
@@ -58,15 +85,21 @@ func (router *UserRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/merge/init", router.mergeInit).Methods("POST")
 	s.HandleFunc("/merge/finish/{id}", router.mergeFinish).Methods("POST")
 	s.HandleFunc("/merge", router.getMergeRequests).Methods("GET")
-	s.HandleFunc("/count", router.getCount).Methods("GET")
-	s.HandleFunc("/me", router.getSelf).Methods("GET")
-	s.HandleFunc("/{id}", router.getOne).Methods("GET")
+	RequirePermission(s.HandleFunc("/count", router.getCount).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/me", router.getSelf).Methods("GET"), PermissionBookingsRead)
+	s.HandleFunc("/me/totp", router.getTOTP).Methods("GET")
+	s.HandleFunc("/me/totp", router.enrollTOTP).Methods("POST")
+	s.HandleFunc("/me/totp/verify", router.verifyTOTP).Methods("POST")
+	s.HandleFunc("/me/totp/disable", router.disableTOTP).Methods("POST")
+	s.HandleFunc("/me/totp/recovery", router.regenerateTOTPRecoveryCodes).Methods("POST")
+	RequirePermission(s.HandleFunc("/{id}/totp", router.resetTOTP).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionOrgAdmin)
 	s.HandleFunc("/byEmail/{email}", router.getOneByEmail).Methods("GET")
 	s.HandleFunc("/{id}/password", router.setPassword).Methods("PUT")
-	s.HandleFunc("/{id}", router.update).Methods("PUT")
-	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
-	s.HandleFunc("/", router.create).Methods("POST")
-	s.HandleFunc("/", router.getAll).Methods("GET")
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionSpacesAdmin)
 }
 
 func (router *UserRouter) getMergeRequests(w http.ResponseWriter, r *http.Request) {
@@ -135,15 +168,12 @@ func (router *UserRouter) mergeFinish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	GetAuthStateRepository().Delete(authState)
+	writeAuditLog(r, source.OrganizationID, AuditActionMerge, AuditEntityUser, source.ID, router.copyToRestModel(source, true), router.copyToRestModel(target, true))
 	SendUpdated(w)
 }
 
 func (router *UserRouter) getCount(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	num, _ := GetUserRepository().GetCount(user.OrganizationID)
 	m := &GetUserCountResponse{
 		Count: num,
@@ -178,6 +208,7 @@ func (router *UserRouter) setPassword(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionSetPassword, AuditEntityUser, e.ID, nil, nil)
 	SendUpdated(w)
 }
 
@@ -193,6 +224,19 @@ func (router *UserRouter) getSelf(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res := router.copyToRestModel(e, false)
+	if !res.SpaceAdmin {
+		list, err := GetRoleAssignmentRepository().GetAllByUser(e.ID)
+		if err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+		for _, assignment := range list {
+			if assignment.Role == UserRoleSpaceAdmin {
+				res.SpaceAdminLocationIDs = append(res.SpaceAdminLocationIDs, assignment.LocationID)
+			}
+		}
+	}
 	res.Organization = GetOrganizationResponse{
 		ID: org.ID,
 		CreateOrganizationRequest: CreateOrganizationRequest{
@@ -234,10 +278,6 @@ func (router *UserRouter) getOneByEmail(w http.ResponseWriter, r *http.Request)
 
 func (router *UserRouter) getOne(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	vars := mux.Vars(r)
 	e, err := GetUserRepository().GetOne(vars["id"])
 	if err != nil {
@@ -256,10 +296,6 @@ func (router *UserRouter) getOne(w http.ResponseWriter, r *http.Request) {
 func (router *UserRouter) getAll(w http.ResponseWriter, r *http.Request) {
 	search := r.URL.Query().Get("q")
 	user := GetRequestUser(r)
-	if !CanSpaceAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	var list []*User
 	var err error
 	if strings.TrimSpace(search) != "" {
@@ -293,7 +329,7 @@ func (router *UserRouter) update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -319,6 +355,7 @@ func (router *UserRouter) update(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityUser, e.ID, router.copyToRestModel(e, true), router.copyToRestModel(eNew, true))
 	SendUpdated(w)
 }
 
@@ -330,7 +367,7 @@ func (router *UserRouter) delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, e.OrganizationID) {
+	if !CanAccessOrg(user, e.OrganizationID) {
 		SendForbidden(w)
 		return
 	}
@@ -339,15 +376,12 @@ func (router *UserRouter) delete(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityUser, e.ID, router.copyToRestModel(e, true), nil)
 	SendUpdated(w)
 }
 
 func (router *UserRouter) create(w http.ResponseWriter, r *http.Request) {
 	user := GetRequestUser(r)
-	if !CanAdminOrg(user, user.OrganizationID) {
-		SendForbidden(w)
-		return
-	}
 	var m CreateUserRequest
 	if UnmarshalValidateBody(r, &m) != nil {
 		SendBadRequest(w)
@@ -383,6 +417,8 @@ func (router *UserRouter) create(w http.ResponseWriter, r *http.Request) {
 		SendInternalServerError(w)
 		return
 	}
+	fireUserWebhookEvent(e, WebhookEventUserCreated)
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityUser, e.ID, nil, router.copyToRestModel(e, true))
 	SendCreated(w, e.ID)
 }
 
@@ -416,3 +452,220 @@ func (router *UserRouter) copyToRestModel(e *User, admin bool) *GetUserResponse
 	}
 	return m
 }
+
+func (router *UserRouter) getTOTP(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	res := &GetTOTPResponse{
+		Enabled:  GetTOTPRepository().IsEnabled(user.ID),
+		Required: isTOTPRequired(user),
+	}
+	if res.Enabled {
+		count, err := GetTOTPRepository().GetRecoveryCodeCount(user.ID)
+		if err != nil {
+			log.Println(err)
+			SendInternalServerError(w)
+			return
+		}
+		res.RecoveryCodesLeft = count
+	}
+	SendJSON(w, res)
+}
+
+// enrollTOTP creates a pending secret for the requesting user. It's enabled
+// once the user has confirmed a code using verifyTOTP.
+func (router *UserRouter) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	if user.HashedPassword == "" {
+		SendBadRequest(w)
+		return
+	}
+	if GetTOTPRepository().IsEnabled(user.ID) {
+		SendAleadyExists(w)
+		return
+	}
+	res, err := createTOTPEnrollment(user)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	SendJSON(w, res)
+}
+
+func (router *UserRouter) verifyTOTP(w http.ResponseWriter, r *http.Request) {
+	var m TOTPCodeRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	e, err := GetTOTPRepository().GetOne(user.ID)
+	if err != nil || e.Enabled {
+		SendNotFound(w)
+		return
+	}
+	if !router.checkTOTPCode(w, e, m.Code) {
+		return
+	}
+	if err := GetTOTPRepository().Enable(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, user.OrganizationID, AuditActionEnableTOTP, AuditEntityUser, user.ID, nil, nil)
+	SendUpdated(w)
+}
+
+// disableTOTP disables two-factor authentication for the requesting user,
+// unless it's mandatory for the user's role.
+func (router *UserRouter) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	var m TOTPCodeRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	e, err := GetTOTPRepository().GetOne(user.ID)
+	if err != nil || !e.Enabled {
+		SendNotFound(w)
+		return
+	}
+	if isTOTPRequired(user) {
+		SendForbidden(w)
+		return
+	}
+	if !router.checkTOTPCode(w, e, m.Code) {
+		return
+	}
+	if err := GetTOTPRepository().Delete(user.ID); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, user.OrganizationID, AuditActionDisableTOTP, AuditEntityUser, user.ID, nil, nil)
+	SendUpdated(w)
+}
+
+func (router *UserRouter) regenerateTOTPRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var m TOTPCodeRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	e, err := GetTOTPRepository().GetOne(user.ID)
+	if err != nil || !e.Enabled {
+		SendNotFound(w)
+		return
+	}
+	if !router.checkTOTPCode(w, e, m.Code) {
+		return
+	}
+	codes, err := GetTOTPRecoveryCodes()
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	if err := GetTOTPRepository().SetRecoveryCodes(user.ID, codes); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, user.OrganizationID, AuditActionRotate, AuditEntityUser, user.ID, nil, nil)
+	SendJSON(w, &TOTPRecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// resetTOTP disables two-factor authentication for a user of the admin's
+// organization, i.e. if the user has lost access to the authenticator app and
+// the recovery codes.
+func (router *UserRouter) resetTOTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := GetUserRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	user := GetRequestUser(r)
+	if !CanAccessOrg(user, e.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	if err := GetTOTPRepository().Delete(e.ID); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDisableTOTP, AuditEntityUser, e.ID, nil, nil)
+	SendUpdated(w)
+}
+
+// checkTOTPCode checks the code entered by the requesting user. If it's
+// invalid, it sends an error response and returns false.
+func (router *UserRouter) checkTOTPCode(w http.ResponseWriter, e *TOTP, code string) bool {
+	ok, err := GetTOTPRepository().CheckCode(e, code, time.Now())
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return false
+	}
+	if !ok {
+		SendBadRequest(w)
+		return false
+	}
+	return true
+}
+
+// createTOTPEnrollment stores a new pending secret and recovery codes for the
+// user, replacing existing ones. The response contains everything needed to
+// set up an authenticator app.
+func createTOTPEnrollment(user *User) (*TOTPEnrollmentResponse, error) {
+	if !canCrypt() {
+		return nil, errors.New("two-factor authentication requires a valid crypt key (CRYPT_KEY)")
+	}
+	secret, err := GetTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	codes, err := GetTOTPRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	e := &TOTP{
+		UserID:  user.ID,
+		Secret:  secret,
+		Created: time.Now(),
+	}
+	if err := GetTOTPRepository().Set(e); err != nil {
+		return nil, err
+	}
+	if err := GetTOTPRepository().SetRecoveryCodes(user.ID, codes); err != nil {
+		return nil, err
+	}
+	uri := GetTOTPProvisioningURI(secret, TOTPIssuer, user.Email)
+	qr, err := qrcode.New(uri, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	data, err := qr.PNG(-4)
+	if err != nil {
+		return nil, err
+	}
+	res := &TOTPEnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		QRCode:          "data:image/png;base64," + base64.StdEncoding.EncodeToString(data),
+		RecoveryCodes:   codes,
+	}
+	return res, nil
+}
+
+// isTOTPRequired returns true if the user's organization makes two-factor
+// authentication mandatory for admin roles and the user has one of them.
+func isTOTPRequired(user *User) bool {
+	required, _ := GetSettingsRepository().GetBool(user.OrganizationID, SettingTOTPRequiredAdmins.Name)
+	if !required {
+		return false
+	}
+	return GetUserRepository().isSpaceAdmin(user) || GetRoleAssignmentRepository().HasAnyLocationRole(user.ID, UserRoleSpaceAdmin)
+}
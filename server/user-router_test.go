@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -228,3 +229,74 @@ func TestUserCreateForeignOrgOrgAdmin(t *testing.T) {
 	res := executeTestRequest(req)
 	checkTestResponseCode(t, http.StatusForbidden, res.Code)
 }
+
+func TestUserTOTPDisable(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	user := createTestUserInOrg(org)
+
+	// Requires a password login
+	req := newHTTPRequest("POST", "/user/me/totp", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	user.HashedPassword = NullString(GetUserRepository().GetHashedPassword("12345678"))
+	GetUserRepository().Update(user)
+	req = newHTTPRequest("POST", "/user/me/totp", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var enrollment *TOTPEnrollmentResponse
+	json.Unmarshal(res.Body.Bytes(), &enrollment)
+	code, _ := GetTOTPCode(enrollment.Secret, GetTOTPStep(time.Now()))
+	req = newHTTPRequest("POST", "/user/me/totp/verify", user.ID, bytes.NewBufferString(`{"code": "`+code+`"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Already enabled
+	req = newHTTPRequest("POST", "/user/me/totp", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	// New recovery codes replace the old ones
+	req = newHTTPRequest("POST", "/user/me/totp/recovery", user.ID, bytes.NewBufferString(`{"code": "`+enrollment.RecoveryCodes[0]+`"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var recovery *TOTPRecoveryCodesResponse
+	json.Unmarshal(res.Body.Bytes(), &recovery)
+	checkTestInt(t, TOTPRecoveryCodeCount, len(recovery.RecoveryCodes))
+
+	req = newHTTPRequest("POST", "/user/me/totp/disable", user.ID, bytes.NewBufferString(`{"code": "`+enrollment.RecoveryCodes[1]+`"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestBool(t, true, GetTOTPRepository().IsEnabled(user.ID))
+
+	req = newHTTPRequest("POST", "/user/me/totp/disable", user.ID, bytes.NewBufferString(`{"code": "`+recovery.RecoveryCodes[0]+`"}`))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, false, GetTOTPRepository().IsEnabled(user.ID))
+}
+
+func TestUserTOTPReset(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	org2 := createTestOrg("test2.com")
+	admin2 := createTestUserOrgAdmin(org2)
+	secret, _ := GetTOTPSecret()
+	GetTOTPRepository().Set(&TOTP{UserID: user.ID, Secret: secret, Enabled: true, Created: time.Now()})
+
+	req := newHTTPRequest("DELETE", "/user/"+user.ID+"/totp", user.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/user/"+user.ID+"/totp", admin2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	checkTestBool(t, true, GetTOTPRepository().IsEnabled(user.ID))
+
+	req = newHTTPRequest("DELETE", "/user/"+user.ID+"/totp", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestBool(t, false, GetTOTPRepository().IsEnabled(user.ID))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type WaitlistRepository struct {
+}
+
+type WaitlistEntry struct {
+	ID         string
+	UserID     string
+	LocationID string
+	SpaceID    NullString
+	Enter      time.Time
+	Leave      time.Time
+	Created    time.Time
+}
+
+type WaitlistEntryDetails struct {
+	UserEmail string
+	WaitlistEntry
+}
+
+var waitlistRepository *WaitlistRepository
+var waitlistRepositoryOnce sync.Once
+
+func GetWaitlistRepository() *WaitlistRepository {
+	waitlistRepositoryOnce.Do(func() {
+		waitlistRepository = &WaitlistRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS waitlist_entries (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"user_id uuid NOT NULL, " +
+			"location_id uuid NOT NULL, " +
+			"space_id uuid NULL, " +
+			"enter_time TIMESTAMP NOT NULL, " +
+			"leave_time TIMESTAMP NOT NULL, " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_waitlist_entries_location_id ON waitlist_entries(location_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return waitlistRepository
+}
+
+func (r *WaitlistRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *WaitlistRepository) Create(e *WaitlistEntry) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO waitlist_entries "+
+		"(user_id, location_id, space_id, enter_time, leave_time, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) "+
+		"RETURNING id",
+		e.UserID, e.LocationID, CheckNullString(e.SpaceID), e.Enter, e.Leave, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *WaitlistRepository) GetOne(id string) (*WaitlistEntry, error) {
+	e := &WaitlistEntry{}
+	err := GetDatabase().DB().QueryRow("SELECT id, user_id, location_id, COALESCE(space_id::text, ''), enter_time, leave_time, created "+
+		"FROM waitlist_entries "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.UserID, &e.LocationID, &e.SpaceID, &e.Enter, &e.Leave, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (r *WaitlistRepository) GetAllByUser(userID string) ([]*WaitlistEntry, error) {
+	var result []*WaitlistEntry
+	rows, err := GetDatabase().DB().Query("SELECT id, user_id, location_id, COALESCE(space_id::text, ''), enter_time, leave_time, created "+
+		"FROM waitlist_entries "+
+		"WHERE user_id = $1 "+
+		"ORDER BY enter_time", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &WaitlistEntry{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.LocationID, &e.SpaceID, &e.Enter, &e.Leave, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// GetMatching returns the waitlist entries which fit into the specified
+// time range on the specified space, either because they're waiting for
+// exactly this space or for any space in its location. The result is
+// ordered by creation time, so the first entry is the one waiting longest.
+func (r *WaitlistRepository) GetMatching(space *Space, enter time.Time, leave time.Time) ([]*WaitlistEntryDetails, error) {
+	var result []*WaitlistEntryDetails
+	rows, err := GetDatabase().DB().Query("SELECT waitlist_entries.id, waitlist_entries.user_id, waitlist_entries.location_id, COALESCE(waitlist_entries.space_id::text, ''), "+
+		"waitlist_entries.enter_time, waitlist_entries.leave_time, waitlist_entries.created, users.email "+
+		"FROM waitlist_entries "+
+		"INNER JOIN users ON waitlist_entries.user_id = users.id "+
+		"WHERE waitlist_entries.location_id = $1 AND "+
+		"(waitlist_entries.space_id IS NULL OR waitlist_entries.space_id = $2) AND "+
+		"waitlist_entries.enter_time >= $3 AND waitlist_entries.leave_time <= $4 "+
+		"ORDER BY waitlist_entries.created", space.LocationID, space.ID, enter, leave)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &WaitlistEntryDetails{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.LocationID, &e.SpaceID, &e.Enter, &e.Leave, &e.Created, &e.UserEmail)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *WaitlistRepository) Delete(e *WaitlistEntry) error {
+	_, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *WaitlistRepository) DeleteExpired() error {
+	now := time.Now().UTC()
+	_, err := GetDatabase().DB().Exec("DELETE FROM waitlist_entries WHERE leave_time < $1", now)
+	return err
+}
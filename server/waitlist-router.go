@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type WaitlistRouter struct {
+}
+
+type CreateWaitlistEntryRequest struct {
+	LocationID string    `json:"locationId"`
+	SpaceID    string    `json:"spaceId"`
+	Enter      time.Time `json:"enter" validate:"required"`
+	Leave      time.Time `json:"leave" validate:"required"`
+}
+
+type GetWaitlistEntryResponse struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	CreateWaitlistEntryRequest
+}
+
+func (router *WaitlistRouter) setupRoutes(s *mux.Router) {
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionBookingsWrite)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionBookingsRead)
+}
+
+func (router *WaitlistRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	list, err := GetWaitlistRepository().GetAllByUser(GetRequestUserID(r))
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetWaitlistEntryResponse{}
+	for _, e := range list {
+		location, err := GetLocationRepository().GetOne(e.LocationID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		m := router.copyToRestModel(e, location)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *WaitlistRouter) delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	e, err := GetWaitlistRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	location, err := GetLocationRepository().GetOne(e.LocationID)
+	if err != nil {
+		SendNotFound(w)
+		return
+	}
+	requestUser := GetRequestUser(r)
+	if e.UserID != requestUser.ID && !CanSpaceAdminOrg(requestUser, location.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	if err := GetWaitlistRepository().Delete(e); err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, location.OrganizationID, AuditActionDelete, AuditEntityWaitlist, e.ID, router.copyToRestModel(e, location), nil)
+	SendUpdated(w)
+}
+
+func (router *WaitlistRouter) create(w http.ResponseWriter, r *http.Request) {
+	var m CreateWaitlistEntryRequest
+	if UnmarshalValidateBody(r, &m) != nil {
+		SendBadRequest(w)
+		return
+	}
+	if m.SpaceID != "" {
+		space, err := GetSpaceRepository().GetOne(m.SpaceID)
+		if err != nil {
+			SendBadRequest(w)
+			return
+		}
+		if m.LocationID != "" && m.LocationID != space.LocationID {
+			SendBadRequest(w)
+			return
+		}
+		m.LocationID = space.LocationID
+	}
+	if m.LocationID == "" {
+		SendBadRequest(w)
+		return
+	}
+	location, err := GetLocationRepository().GetOne(m.LocationID)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	requestUser := GetRequestUser(r)
+	if !CanAccessOrg(requestUser, location.OrganizationID) {
+		SendForbidden(w)
+		return
+	}
+	bookingRouter := &BookingRouter{}
+	if !bookingRouter.isValidGroupRestriction(location, m.SpaceID, requestUser) {
+		SendBadRequestCode(w, ResponseCodeBookingSpaceRestricted)
+		return
+	}
+	e, err := router.copyFromRestModel(&m, location)
+	if err != nil {
+		SendInternalServerError(w)
+		return
+	}
+	if !e.Leave.After(e.Enter) || e.Leave.Before(time.Now()) {
+		SendBadRequest(w)
+		return
+	}
+	e.UserID = requestUser.ID
+	e.Created = time.Now().UTC()
+	if err := GetWaitlistRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	// Reload the entry, as copyToRestModel expects wall clock times
+	if entry, err := GetWaitlistRepository().GetOne(e.ID); err == nil {
+		writeAuditLog(r, location.OrganizationID, AuditActionCreate, AuditEntityWaitlist, e.ID, nil, router.copyToRestModel(entry, location))
+	}
+	SendCreated(w, e.ID)
+}
+
+func (router *WaitlistRouter) copyFromRestModel(m *CreateWaitlistEntryRequest, location *Location) (*WaitlistEntry, error) {
+	e := &WaitlistEntry{}
+	e.LocationID = m.LocationID
+	e.SpaceID = NullString(m.SpaceID)
+	enterNew, err := attachTimezoneInformation(m.Enter, location)
+	if err != nil {
+		return nil, err
+	}
+	e.Enter = enterNew
+	leaveNew, err := attachTimezoneInformation(m.Leave, location)
+	if err != nil {
+		return nil, err
+	}
+	e.Leave = leaveNew
+	return e, nil
+}
+
+func (router *WaitlistRouter) copyToRestModel(e *WaitlistEntry, location *Location) *GetWaitlistEntryResponse {
+	m := &GetWaitlistEntryResponse{}
+	m.ID = e.ID
+	m.Created = e.Created
+	m.LocationID = e.LocationID
+	m.SpaceID = string(e.SpaceID)
+	m.Enter, _ = attachTimezoneInformation(e.Enter, location)
+	m.Leave, _ = attachTimezoneInformation(e.Leave, location)
+	return m
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitlistCRUD(t *testing.T) {
+	clearTestDB()
+	org, l, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-01T08:30:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/waitlist/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id := res.Header().Get("X-Object-Id")
+
+	req = newHTTPRequest("GET", "/waitlist/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetWaitlistEntryResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestInt(t, 1, len(resBody))
+	checkTestString(t, id, resBody[0].ID)
+	checkTestString(t, s.ID, resBody[0].SpaceID)
+	checkTestString(t, l.ID, resBody[0].LocationID)
+	checkTestString(t, "2030-09-01T08:30:00", resBody[0].Enter.Format(JsDateTimeFormat))
+
+	user2 := createTestUserInOrg(org)
+	req = newHTTPRequest("DELETE", "/waitlist/"+id, user2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/waitlist/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	list, _ := GetWaitlistRepository().GetAllByUser(user.ID)
+	checkTestInt(t, 0, len(list))
+}
+
+func TestWaitlistSpaceDeleted(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-01T08:30:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/waitlist/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	GetSpaceRepository().Delete(s)
+	list, _ := GetWaitlistRepository().GetAllByUser(user.ID)
+	checkTestInt(t, 0, len(list))
+}
+
+func TestWaitlistCreateInvalid(t *testing.T) {
+	clearTestDB()
+	org, _, _ := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+
+	// Neither space nor location
+	payload := "{\"enter\": \"2030-09-01T08:30:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/waitlist/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Other organization
+	org2 := createTestOrg("test2.com")
+	l2 := &Location{Name: "Test", OrganizationID: org2.ID}
+	GetLocationRepository().Create(l2)
+	payload = "{\"locationId\": \"" + l2.ID + "\", \"enter\": \"2030-09-01T08:30:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req = newHTTPRequest("POST", "/waitlist/", user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestWaitlistAutoBookOnDelete(t *testing.T) {
+	clearTestDB()
+	org, l, s := createTestOrgWithSpace()
+	GetSettingsRepository().Set(org.ID, SettingWaitlistAutoBook.Name, "1")
+	user1 := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+	user3 := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-01T08:00:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user1.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	bookingID := res.Header().Get("X-Object-Id")
+
+	// user2 waits for any space in the location, user3 for the space itself
+	payload = "{\"locationId\": \"" + l.ID + "\", \"enter\": \"2030-09-01T09:00:00Z\", \"leave\": \"2030-09-01T12:00:00Z\"}"
+	req = newHTTPRequest("POST", "/waitlist/", user2.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	payload = "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-01T08:00:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req = newHTTPRequest("POST", "/waitlist/", user3.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	req = newHTTPRequest("DELETE", "/booking/"+bookingID, user1.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	list, _ := GetBookingRepository().GetAllByUser(user2.ID, time.Now().UTC())
+	checkTestInt(t, 1, len(list))
+	checkTestString(t, s.ID, list[0].SpaceID)
+	checkTestString(t, "2030-09-01T09:00:00", list[0].Enter.Format(JsDateTimeFormat))
+	checkTestString(t, "2030-09-01T12:00:00", list[0].Leave.Format(JsDateTimeFormat))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "To: "+user2.Email))
+	entries, _ := GetAuditLogRepository().GetAll(org.ID, &AuditLogFilter{EntityType: AuditEntityBooking, EntityID: list[0].ID, Action: AuditActionCreate, Limit: 10})
+	checkTestInt(t, 1, len(entries))
+
+	waiting, _ := GetWaitlistRepository().GetAllByUser(user2.ID)
+	checkTestInt(t, 0, len(waiting))
+	waiting, _ = GetWaitlistRepository().GetAllByUser(user3.ID)
+	checkTestInt(t, 1, len(waiting))
+	list, _ = GetBookingRepository().GetAllByUser(user3.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+}
+
+func TestWaitlistNotifyOnDelete(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user1 := createTestUserInOrg(org)
+	user2 := createTestUserInOrg(org)
+
+	payload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-01T08:00:00Z\", \"leave\": \"2030-09-01T17:00:00Z\"}"
+	req := newHTTPRequest("POST", "/booking/", user1.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	bookingID := res.Header().Get("X-Object-Id")
+
+	req = newHTTPRequest("POST", "/waitlist/", user2.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	SendMailMockContent = ""
+	req = newHTTPRequest("DELETE", "/booking/"+bookingID, user1.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "To: "+user2.Email))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Platz: Test 1, Test"))
+	checkTestBool(t, true, strings.Contains(SendMailMockContent, "Von: 2030-09-01 08:00"))
+
+	list, _ := GetBookingRepository().GetAllByUser(user2.ID, time.Now().UTC())
+	checkTestInt(t, 0, len(list))
+	waiting, _ := GetWaitlistRepository().GetAllByUser(user2.ID)
+	checkTestInt(t, 0, len(waiting))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type WebhookDeliveryRepository struct {
+}
+
+const (
+	WebhookDeliveryMaxAttempts    = 10
+	WebhookDeliveryBatchSize      = 50
+	WebhookDeliveryLease          = time.Minute * 5
+	WebhookDeliveryInitialBackoff = time.Second * 30
+	WebhookDeliveryMaxBackoff     = time.Hour * 6
+)
+
+const (
+	WebhookDeliveryStatusPending   = "pending"
+	WebhookDeliveryStatusSucceeded = "succeeded"
+	WebhookDeliveryStatusFailed    = "failed"
+)
+
+// WebhookDelivery is an event to be sent to a webhook. Deliveries are kept
+// after they have been processed and serve as the delivery log.
+type WebhookDelivery struct {
+	ID             string
+	WebhookID      string
+	OrganizationID string
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttempt    time.Time
+	StatusCode     int
+	LastError      string
+	Created        time.Time
+	Delivered      *time.Time
+}
+
+var webhookDeliveryRepository *WebhookDeliveryRepository
+var webhookDeliveryRepositoryOnce sync.Once
+
+func GetWebhookDeliveryRepository() *WebhookDeliveryRepository {
+	webhookDeliveryRepositoryOnce.Do(func() {
+		webhookDeliveryRepository = &WebhookDeliveryRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS webhook_deliveries (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"webhook_id uuid NOT NULL, " +
+			"organization_id uuid NOT NULL, " +
+			"event VARCHAR NOT NULL, " +
+			"payload TEXT NOT NULL, " +
+			"status VARCHAR NOT NULL, " +
+			"attempts INTEGER NOT NULL DEFAULT 0, " +
+			"next_attempt TIMESTAMP NOT NULL, " +
+			"status_code INTEGER NOT NULL DEFAULT 0, " +
+			"last_error VARCHAR NOT NULL DEFAULT '', " +
+			"created TIMESTAMP NOT NULL, " +
+			"delivered TIMESTAMP NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id)")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt ON webhook_deliveries(next_attempt)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return webhookDeliveryRepository
+}
+
+func (r *WebhookDeliveryRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *WebhookDeliveryRepository) Create(e *WebhookDelivery) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO webhook_deliveries "+
+		"(webhook_id, organization_id, event, payload, status, attempts, next_attempt, status_code, last_error, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) "+
+		"RETURNING id",
+		e.WebhookID, e.OrganizationID, e.Event, e.Payload, e.Status, e.Attempts, e.NextAttempt, e.StatusCode, e.LastError, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) GetOne(id string) (*WebhookDelivery, error) {
+	e := &WebhookDelivery{}
+	err := GetDatabase().DB().QueryRow("SELECT id, webhook_id, organization_id, event, payload, status, attempts, next_attempt, status_code, last_error, created, delivered "+
+		"FROM webhook_deliveries "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.WebhookID, &e.OrganizationID, &e.Event, &e.Payload, &e.Status, &e.Attempts, &e.NextAttempt, &e.StatusCode, &e.LastError, &e.Created, &e.Delivered)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// GetAllByWebhook returns the most recent deliveries of a webhook, optionally
+// filtered by status.
+func (r *WebhookDeliveryRepository) GetAllByWebhook(webhookID string, status string, limit int) ([]*WebhookDelivery, error) {
+	var result []*WebhookDelivery
+	args := []interface{}{webhookID, limit}
+	statusCondition := ""
+	if status != "" {
+		args = append(args, status)
+		statusCondition = "AND status = $3 "
+	}
+	rows, err := GetDatabase().DB().Query("SELECT id, webhook_id, organization_id, event, payload, status, attempts, next_attempt, status_code, last_error, created, delivered "+
+		"FROM webhook_deliveries "+
+		"WHERE webhook_id = $1 "+statusCondition+
+		"ORDER BY created DESC "+
+		"LIMIT $2", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &WebhookDelivery{}
+		err = rows.Scan(&e.ID, &e.WebhookID, &e.OrganizationID, &e.Event, &e.Payload, &e.Status, &e.Attempts, &e.NextAttempt, &e.StatusCode, &e.LastError, &e.Created, &e.Delivered)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// Claim returns up to limit pending deliveries due for processing and defers
+// their next attempt by lease, so that other workers don't pick them up
+// concurrently.
+func (r *WebhookDeliveryRepository) Claim(now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	var result []*WebhookDelivery
+	rows, err := GetDatabase().DB().Query("UPDATE webhook_deliveries SET "+
+		"next_attempt = $2 "+
+		"WHERE id IN ("+
+		"SELECT id FROM webhook_deliveries "+
+		"WHERE status = $3 AND next_attempt <= $1 "+
+		"ORDER BY created "+
+		"LIMIT $4 "+
+		"FOR UPDATE SKIP LOCKED"+
+		") "+
+		"RETURNING id, webhook_id, organization_id, event, payload, status, attempts, next_attempt, status_code, last_error, created, delivered",
+		now, now.Add(lease), WebhookDeliveryStatusPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &WebhookDelivery{}
+		err = rows.Scan(&e.ID, &e.WebhookID, &e.OrganizationID, &e.Event, &e.Payload, &e.Status, &e.Attempts, &e.NextAttempt, &e.StatusCode, &e.LastError, &e.Created, &e.Delivered)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (r *WebhookDeliveryRepository) Update(e *WebhookDelivery) error {
+	_, err := GetDatabase().DB().Exec("UPDATE webhook_deliveries SET "+
+		"status = $1, "+
+		"attempts = $2, "+
+		"next_attempt = $3, "+
+		"status_code = $4, "+
+		"last_error = $5, "+
+		"delivered = $6 "+
+		"WHERE id = $7",
+		e.Status, e.Attempts, e.NextAttempt, e.StatusCode, e.LastError, e.Delivered, e.ID)
+	return err
+}
+
+// DeleteExpired removes deliveries older than 30 days from the log.
+func (r *WebhookDeliveryRepository) DeleteExpired() error {
+	created := time.Now().UTC().Add(-time.Hour * 24 * 30)
+	_, err := GetDatabase().DB().Exec("DELETE FROM webhook_deliveries WHERE status != $1 AND created < $2", WebhookDeliveryStatusPending, created)
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type WebhookRepository struct {
+}
+
+const (
+	WebhookEventBookingCreated = "booking.created"
+	WebhookEventBookingUpdated = "booking.updated"
+	WebhookEventBookingDeleted = "booking.deleted"
+	WebhookEventUserCreated    = "user.created"
+	WebhookEventSpaceCreated   = "space.created"
+	WebhookEventSpaceUpdated   = "space.updated"
+	WebhookEventSpaceDeleted   = "space.deleted"
+)
+
+var WebhookEvents = []string{
+	WebhookEventBookingCreated,
+	WebhookEventBookingUpdated,
+	WebhookEventBookingDeleted,
+	WebhookEventUserCreated,
+	WebhookEventSpaceCreated,
+	WebhookEventSpaceUpdated,
+	WebhookEventSpaceDeleted,
+}
+
+// Webhook is an URL notified about events in an organization. Requests are
+// signed with the secret.
+type Webhook struct {
+	ID             string
+	OrganizationID string
+	URL            string
+	Secret         string
+	Events         []string
+	Enabled        bool
+	Created        time.Time
+}
+
+var webhookRepository *WebhookRepository
+var webhookRepositoryOnce sync.Once
+
+func GetWebhookRepository() *WebhookRepository {
+	webhookRepositoryOnce.Do(func() {
+		webhookRepository = &WebhookRepository{}
+		_, err := GetDatabase().DB().Exec("CREATE TABLE IF NOT EXISTS webhooks (" +
+			"id uuid DEFAULT uuid_generate_v4(), " +
+			"organization_id uuid NOT NULL, " +
+			"url VARCHAR NOT NULL, " +
+			"secret VARCHAR NOT NULL, " +
+			"events VARCHAR NOT NULL DEFAULT '', " +
+			"enabled boolean NOT NULL DEFAULT TRUE, " +
+			"created TIMESTAMP NOT NULL, " +
+			"PRIMARY KEY (id))")
+		if err != nil {
+			panic(err)
+		}
+		_, err = GetDatabase().DB().Exec("CREATE INDEX IF NOT EXISTS idx_webhooks_organization_id ON webhooks(organization_id)")
+		if err != nil {
+			panic(err)
+		}
+	})
+	return webhookRepository
+}
+
+func (r *WebhookRepository) RunSchemaUpgrade(curVersion, targetVersion int) {
+	// No updates yet
+}
+
+func (r *WebhookRepository) Create(e *Webhook) error {
+	var id string
+	err := GetDatabase().DB().QueryRow("INSERT INTO webhooks "+
+		"(organization_id, url, secret, events, enabled, created) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) "+
+		"RETURNING id",
+		e.OrganizationID, e.URL, e.Secret, strings.Join(e.Events, ","), e.Enabled, e.Created).Scan(&id)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func (r *WebhookRepository) GetOne(id string) (*Webhook, error) {
+	e := &Webhook{}
+	var events string
+	err := GetDatabase().DB().QueryRow("SELECT id, organization_id, url, secret, events, enabled, created "+
+		"FROM webhooks "+
+		"WHERE id = $1",
+		id).Scan(&e.ID, &e.OrganizationID, &e.URL, &e.Secret, &events, &e.Enabled, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+	e.Events = r.splitEvents(events)
+	return e, nil
+}
+
+func (r *WebhookRepository) GetAll(organizationID string) ([]*Webhook, error) {
+	var result []*Webhook
+	rows, err := GetDatabase().DB().Query("SELECT id, organization_id, url, secret, events, enabled, created "+
+		"FROM webhooks "+
+		"WHERE organization_id = $1 "+
+		"ORDER BY created", organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e := &Webhook{}
+		var events string
+		err = rows.Scan(&e.ID, &e.OrganizationID, &e.URL, &e.Secret, &events, &e.Enabled, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		e.Events = r.splitEvents(events)
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// GetAllForEvent returns the enabled webhooks of the organization which are
+// subscribed to the event.
+func (r *WebhookRepository) GetAllForEvent(organizationID string, event string) ([]*Webhook, error) {
+	list, err := r.GetAll(organizationID)
+	if err != nil {
+		return nil, err
+	}
+	var result []*Webhook
+	for _, e := range list {
+		if e.Enabled && e.HasEvent(event) {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+func (r *WebhookRepository) Update(e *Webhook) error {
+	_, err := GetDatabase().DB().Exec("UPDATE webhooks SET "+
+		"url = $1, "+
+		"secret = $2, "+
+		"events = $3, "+
+		"enabled = $4 "+
+		"WHERE id = $5",
+		e.URL, e.Secret, strings.Join(e.Events, ","), e.Enabled, e.ID)
+	return err
+}
+
+func (r *WebhookRepository) Delete(e *Webhook) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM webhook_deliveries WHERE webhook_id = $1", e.ID); err != nil {
+		return err
+	}
+	_, err := GetDatabase().DB().Exec("DELETE FROM webhooks WHERE id = $1", e.ID)
+	return err
+}
+
+func (r *WebhookRepository) DeleteAll(organizationID string) error {
+	if _, err := GetDatabase().DB().Exec("DELETE FROM webhook_deliveries WHERE organization_id = $1", organizationID); err != nil {
+		return err
+	}
+	_, err := GetDatabase().DB().Exec("DELETE FROM webhooks WHERE organization_id = $1", organizationID)
+	return err
+}
+
+func (r *WebhookRepository) splitEvents(events string) []string {
+	if events == "" {
+		return []string{}
+	}
+	return strings.Split(events, ",")
+}
+
+func (e *Webhook) HasEvent(event string) bool {
+	for _, s := range e.Events {
+		if s == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type WebhookRouter struct {
+}
+
+type CreateWebhookRequest struct {
+	URL     string   `json:"url" validate:"required"`
+	Secret  string   `json:"secret" validate:"required"`
+	Events  []string `json:"events" validate:"required"`
+	Enabled bool     `json:"enabled"`
+}
+
+type GetWebhookResponse struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organizationId"`
+	Created        time.Time `json:"created"`
+	CreateWebhookRequest
+}
+
+type GetWebhookDeliveryResponse struct {
+	ID          string          `json:"id"`
+	WebhookID   string          `json:"webhookId"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	StatusCode  int             `json:"statusCode"`
+	LastError   string          `json:"lastError"`
+	Created     time.Time       `json:"created"`
+	Delivered   *time.Time      `json:"delivered"`
+}
+
+func (router *WebhookRouter) setupRoutes(s *mux.Router) {
+	RequirePermission(s.HandleFunc("/{id}/delivery/", router.getDeliveries).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}/delivery/{deliveryId}/retry", router.retryDelivery).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.getOne).Methods("GET"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.update).Methods("PUT"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/{id}", router.delete).Methods("DELETE"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.create).Methods("POST"), PermissionOrgAdmin)
+	RequirePermission(s.HandleFunc("/", router.getAll).Methods("GET"), PermissionOrgAdmin)
+}
+
+func (router *WebhookRouter) getOne(w http.ResponseWriter, r *http.Request) {
+	e := router.getWebhook(w, r)
+	if e == nil {
+		return
+	}
+	SendJSON(w, router.copyToRestModel(e))
+}
+
+func (router *WebhookRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	user := GetRequestUser(r)
+	list, err := GetWebhookRepository().GetAll(user.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetWebhookResponse{}
+	for _, e := range list {
+		m := router.copyToRestModel(e)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+func (router *WebhookRouter) update(w http.ResponseWriter, r *http.Request) {
+	var m CreateWebhookRequest
+	if UnmarshalValidateBody(r, &m) != nil || !router.isValidRequest(&m) {
+		SendBadRequest(w)
+		return
+	}
+	e := router.getWebhook(w, r)
+	if e == nil {
+		return
+	}
+	eNew := router.copyFromRestModel(&m)
+	eNew.ID = e.ID
+	eNew.OrganizationID = e.OrganizationID
+	if err := GetWebhookRepository().Update(eNew); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	eNew.Created = e.Created
+	writeAuditLog(r, e.OrganizationID, AuditActionUpdate, AuditEntityWebhook, e.ID, router.getAuditLogData(e), router.getAuditLogData(eNew))
+	SendUpdated(w)
+}
+
+func (router *WebhookRouter) delete(w http.ResponseWriter, r *http.Request) {
+	e := router.getWebhook(w, r)
+	if e == nil {
+		return
+	}
+	if err := GetWebhookRepository().Delete(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionDelete, AuditEntityWebhook, e.ID, router.getAuditLogData(e), nil)
+	SendUpdated(w)
+}
+
+func (router *WebhookRouter) create(w http.ResponseWriter, r *http.Request) {
+	var m CreateWebhookRequest
+	if UnmarshalValidateBody(r, &m) != nil || !router.isValidRequest(&m) {
+		SendBadRequest(w)
+		return
+	}
+	user := GetRequestUser(r)
+	e := router.copyFromRestModel(&m)
+	e.OrganizationID = user.OrganizationID
+	e.Created = time.Now().UTC()
+	if err := GetWebhookRepository().Create(e); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	writeAuditLog(r, e.OrganizationID, AuditActionCreate, AuditEntityWebhook, e.ID, nil, router.getAuditLogData(e))
+	SendCreated(w, e.ID)
+}
+
+func (router *WebhookRouter) getDeliveries(w http.ResponseWriter, r *http.Request) {
+	e := router.getWebhook(w, r)
+	if e == nil {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != WebhookDeliveryStatusPending && status != WebhookDeliveryStatusSucceeded && status != WebhookDeliveryStatusFailed {
+		SendBadRequest(w)
+		return
+	}
+	limit := 100
+	if r.URL.Query().Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(r.URL.Query().Get("limit")); err != nil || limit < 1 || limit > 1000 {
+			SendBadRequest(w)
+			return
+		}
+	}
+	list, err := GetWebhookDeliveryRepository().GetAllByWebhook(e.ID, status, limit)
+	if err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	res := []*GetWebhookDeliveryResponse{}
+	for _, delivery := range list {
+		m := router.copyDeliveryToRestModel(delivery)
+		res = append(res, m)
+	}
+	SendJSON(w, res)
+}
+
+// retryDelivery schedules a delivery for immediate sending, regardless of its
+// current status.
+func (router *WebhookRouter) retryDelivery(w http.ResponseWriter, r *http.Request) {
+	e := router.getWebhook(w, r)
+	if e == nil {
+		return
+	}
+	vars := mux.Vars(r)
+	delivery, err := GetWebhookDeliveryRepository().GetOne(vars["deliveryId"])
+	if err != nil || delivery.WebhookID != e.ID {
+		SendNotFound(w)
+		return
+	}
+	delivery.Status = WebhookDeliveryStatusPending
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now().UTC()
+	if err := GetWebhookDeliveryRepository().Update(delivery); err != nil {
+		log.Println(err)
+		SendInternalServerError(w)
+		return
+	}
+	SendUpdated(w)
+}
+
+// getWebhook returns the webhook specified in the request if the requesting
+// user is allowed to manage it. Otherwise, it sends an error response and
+// returns nil.
+func (router *WebhookRouter) getWebhook(w http.ResponseWriter, r *http.Request) *Webhook {
+	vars := mux.Vars(r)
+	e, err := GetWebhookRepository().GetOne(vars["id"])
+	if err != nil {
+		SendNotFound(w)
+		return nil
+	}
+	user := GetRequestUser(r)
+	if !CanAccessOrg(user, e.OrganizationID) {
+		SendForbidden(w)
+		return nil
+	}
+	return e
+}
+
+func (router *WebhookRouter) isValidRequest(m *CreateWebhookRequest) bool {
+	if !isValidWebhookURL(m.URL) {
+		return false
+	}
+	if len(m.Events) == 0 {
+		return false
+	}
+	for _, event := range m.Events {
+		if !router.isValidEvent(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (router *WebhookRouter) isValidEvent(event string) bool {
+	for _, s := range WebhookEvents {
+		if s == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (router *WebhookRouter) copyFromRestModel(m *CreateWebhookRequest) *Webhook {
+	e := &Webhook{}
+	e.URL = m.URL
+	e.Secret = m.Secret
+	e.Events = m.Events
+	e.Enabled = m.Enabled
+	return e
+}
+
+// getAuditLogData returns the webhook's representation for the audit log,
+// which must not contain the signing secret.
+func (router *WebhookRouter) getAuditLogData(e *Webhook) *GetWebhookResponse {
+	m := router.copyToRestModel(e)
+	m.Secret = ""
+	return m
+}
+
+func (router *WebhookRouter) copyToRestModel(e *Webhook) *GetWebhookResponse {
+	m := &GetWebhookResponse{}
+	m.ID = e.ID
+	m.OrganizationID = e.OrganizationID
+	m.Created = e.Created
+	m.URL = e.URL
+	m.Secret = e.Secret
+	m.Events = e.Events
+	m.Enabled = e.Enabled
+	return m
+}
+
+func (router *WebhookRouter) copyDeliveryToRestModel(e *WebhookDelivery) *GetWebhookDeliveryResponse {
+	m := &GetWebhookDeliveryResponse{}
+	m.ID = e.ID
+	m.WebhookID = e.WebhookID
+	m.Event = e.Event
+	m.Payload = json.RawMessage(e.Payload)
+	m.Status = e.Status
+	m.Attempts = e.Attempts
+	m.NextAttempt = e.NextAttempt
+	m.StatusCode = e.StatusCode
+	m.LastError = e.LastError
+	m.Created = e.Created
+	m.Delivered = e.Delivered
+	return m
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookTestReceiver records the requests posted to a webhook and responds
+// with the configured status code.
+type webhookTestReceiver struct {
+	mu         sync.Mutex
+	statusCode int
+	requests   []*http.Request
+	bodies     []string
+}
+
+func newWebhookTestServer() (*httptest.Server, *webhookTestReceiver) {
+	receiver := &webhookTestReceiver{statusCode: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, string(body))
+		w.WriteHeader(receiver.statusCode)
+	}))
+	return server, receiver
+}
+
+func (receiver *webhookTestReceiver) setStatusCode(statusCode int) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	receiver.statusCode = statusCode
+}
+
+func (receiver *webhookTestReceiver) getNumRequests() int {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	return len(receiver.requests)
+}
+
+func createWebhookTestWebhook(t *testing.T, admin *User, url string, events string) string {
+	payload := `{"url": "` + url + `", "secret": "s3cr3t", "events": [` + events + `], "enabled": true}`
+	req := newHTTPRequest("POST", "/webhook/", admin.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	return res.Header().Get("X-Object-Id")
+}
+
+func getWebhookTestDeliveries(t *testing.T, admin *User, id string, status string) []*GetWebhookDeliveryResponse {
+	req := newHTTPRequest("GET", "/webhook/"+id+"/delivery/?status="+status, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody []*GetWebhookDeliveryResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	return resBody
+}
+
+func TestWebhooksForbidden(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+	user := createTestUserInOrg(org)
+	id := createWebhookTestWebhook(t, admin, "https://test.com/hook", `"booking.created"`)
+
+	payload := `{"url": "https://test.com/hook", "secret": "s3cr3t", "events": ["booking.created"], "enabled": true}`
+	req := newHTTPRequest("POST", "/webhook/", user.ID, bytes.NewBufferString(payload))
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("PUT", "/webhook/"+id, user.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("DELETE", "/webhook/"+id, user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/"+id+"/delivery/", user.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Admins of other organizations
+	admin2 := createTestUserOrgAdmin(createTestOrg("test2.com"))
+	req = newHTTPRequest("GET", "/webhook/"+id, admin2.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestWebhooksCRUD(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+
+	// 1. Create
+	id := createWebhookTestWebhook(t, admin, "https://test.com/hook", `"booking.created", "booking.deleted"`)
+
+	// 2. Read
+	req := newHTTPRequest("GET", "/webhook/"+id, admin.ID, nil)
+	res := executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody *GetWebhookResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody)
+	checkTestString(t, "https://test.com/hook", resBody.URL)
+	checkTestString(t, "s3cr3t", resBody.Secret)
+	checkTestInt(t, 2, len(resBody.Events))
+	checkTestString(t, WebhookEventBookingDeleted, resBody.Events[1])
+	checkTestBool(t, true, resBody.Enabled)
+
+	// 3. Update
+	payload := `{"url": "https://test.com/hook2", "secret": "n3w", "events": ["user.created"], "enabled": false}`
+	req = newHTTPRequest("PUT", "/webhook/"+id, admin.ID, bytes.NewBufferString(payload))
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/", admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var resBody2 []*GetWebhookResponse
+	json.Unmarshal(res.Body.Bytes(), &resBody2)
+	checkTestInt(t, 1, len(resBody2))
+	checkTestString(t, "https://test.com/hook2", resBody2[0].URL)
+	checkTestString(t, "n3w", resBody2[0].Secret)
+	checkTestInt(t, 1, len(resBody2[0].Events))
+	checkTestString(t, WebhookEventUserCreated, resBody2[0].Events[0])
+	checkTestBool(t, false, resBody2[0].Enabled)
+
+	// 4. Delete
+	req = newHTTPRequest("DELETE", "/webhook/"+id, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/webhook/"+id, admin.ID, nil)
+	res = executeTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestWebhooksInvalid(t *testing.T) {
+	clearTestDB()
+	org := createTestOrg("test.com")
+	admin := createTestUserOrgAdmin(org)
+
+	payloads := []string{
+		`{"url": "ftp://test.com/hook", "secret": "s3cr3t", "events": ["booking.created"]}`,
+		`{"url": "test.com/hook", "secret": "s3cr3t", "events": ["booking.created"]}`,
+		`{"url": "https://test.com/hook", "secret": "", "events": ["booking.created"]}`,
+		`{"url": "https://test.com/hook", "secret": "s3cr3t", "events": []}`,
+		`{"url": "https://test.com/hook", "secret": "s3cr3t", "events": ["booking.unknown"]}`,
+	}
+	for _, payload := range payloads {
+		req := newHTTPRequest("POST", "/webhook/", admin.ID, bytes.NewBufferString(payload))
+		res := executeTestRequest(req)
+		checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestWebhooksDeliverBookingEvents(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	id := createWebhookTestWebhook(t, admin, server.URL+"/hook", `"booking.created", "booking.deleted"`)
+
+	bookingID := createCalDavSyncTestBooking(t, user, s)
+	num, err := processWebhookQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestInt(t, 1, num)
+	checkTestInt(t, 1, receiver.getNumRequests())
+
+	req := receiver.requests[0]
+	body := receiver.bodies[0]
+	checkTestString(t, "/hook", req.URL.Path)
+	checkTestString(t, WebhookEventBookingCreated, req.Header.Get(WebhookHeaderEvent))
+	timestamp := req.Header.Get(WebhookHeaderTimestamp)
+	checkTestString(t, "sha256="+getWebhookSignature("s3cr3t", timestamp, body), req.Header.Get(WebhookHeaderSignature))
+
+	var payload struct {
+		Event          string             `json:"event"`
+		OrganizationID string             `json:"organizationId"`
+		Data           WebhookBookingData `json:"data"`
+	}
+	json.Unmarshal([]byte(body), &payload)
+	checkTestString(t, WebhookEventBookingCreated, payload.Event)
+	checkTestString(t, org.ID, payload.OrganizationID)
+	checkTestString(t, bookingID, payload.Data.ID)
+	checkTestString(t, user.Email, payload.Data.UserEmail)
+	checkTestString(t, "Test 1", payload.Data.SpaceName)
+	checkTestString(t, "Test", payload.Data.LocationName)
+	checkTestString(t, "2030-09-02T08:00:00+02:00", payload.Data.Enter.Format(time.RFC3339))
+
+	// Events without subscription are not delivered
+	putPayload := "{\"spaceId\": \"" + s.ID + "\", \"enter\": \"2030-09-02T09:00:00Z\", \"leave\": \"2030-09-02T17:00:00Z\"}"
+	res := executeTestRequest(newHTTPRequest("PUT", "/booking/"+bookingID, user.ID, bytes.NewBufferString(putPayload)))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	res = executeTestRequest(newHTTPRequest("DELETE", "/booking/"+bookingID, user.ID, nil))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	num, _ = processWebhookQueue()
+	checkTestInt(t, 1, num)
+	checkTestInt(t, 2, receiver.getNumRequests())
+	checkTestString(t, WebhookEventBookingDeleted, receiver.requests[1].Header.Get(WebhookHeaderEvent))
+
+	deliveries := getWebhookTestDeliveries(t, admin, id, "")
+	checkTestInt(t, 2, len(deliveries))
+	checkTestString(t, WebhookEventBookingDeleted, deliveries[0].Event)
+	checkTestString(t, WebhookDeliveryStatusSucceeded, deliveries[0].Status)
+	checkTestInt(t, http.StatusOK, deliveries[0].StatusCode)
+	checkTestString(t, WebhookEventBookingCreated, deliveries[1].Event)
+}
+
+func TestWebhooksDeliverUserAndSpaceEvents(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	createWebhookTestWebhook(t, admin, server.URL, `"user.created", "space.updated"`)
+
+	payload := `{"email": "new@test.com", "password": "12345678"}`
+	res := executeTestRequest(newHTTPRequest("POST", "/user/", admin.ID, bytes.NewBufferString(payload)))
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	payload = `{"name": "Test 2", "x": 0, "y": 0, "width": 100, "height": 100, "rotation": 0}`
+	res = executeTestRequest(newHTTPRequest("PUT", "/location/"+s.LocationID+"/space/"+s.ID, admin.ID, bytes.NewBufferString(payload)))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	num, _ := processWebhookQueue()
+	checkTestInt(t, 2, num)
+	var userPayload struct {
+		Data WebhookUserData `json:"data"`
+	}
+	json.Unmarshal([]byte(receiver.bodies[0]), &userPayload)
+	checkTestString(t, WebhookEventUserCreated, receiver.requests[0].Header.Get(WebhookHeaderEvent))
+	checkTestString(t, "new@test.com", userPayload.Data.Email)
+	var spacePayload struct {
+		Data WebhookSpaceData `json:"data"`
+	}
+	json.Unmarshal([]byte(receiver.bodies[1]), &spacePayload)
+	checkTestString(t, WebhookEventSpaceUpdated, receiver.requests[1].Header.Get(WebhookHeaderEvent))
+	checkTestString(t, s.ID, spacePayload.Data.ID)
+	checkTestString(t, "Test 2", spacePayload.Data.Name)
+}
+
+func TestWebhooksRetry(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	receiver.setStatusCode(http.StatusInternalServerError)
+	id := createWebhookTestWebhook(t, admin, server.URL, `"booking.created"`)
+	createCalDavSyncTestBooking(t, user, s)
+
+	num, _ := processWebhookQueue()
+	checkTestInt(t, 0, num)
+	deliveries := getWebhookTestDeliveries(t, admin, id, WebhookDeliveryStatusPending)
+	checkTestInt(t, 1, len(deliveries))
+	checkTestInt(t, 1, deliveries[0].Attempts)
+	checkTestInt(t, http.StatusInternalServerError, deliveries[0].StatusCode)
+	if !deliveries[0].NextAttempt.After(time.Now().UTC()) {
+		t.Fatalf("Expected next attempt in the future, got %s", deliveries[0].NextAttempt)
+	}
+
+	// Not retried before the backoff has elapsed
+	num, _ = processWebhookQueue()
+	checkTestInt(t, 0, num)
+	checkTestInt(t, 1, receiver.getNumRequests())
+
+	// Fails permanently after the maximum number of attempts
+	GetDatabase().DB().Exec("UPDATE webhook_deliveries SET attempts = $1, next_attempt = $2", WebhookDeliveryMaxAttempts-1, time.Now().UTC().Add(-time.Minute))
+	processWebhookQueue()
+	deliveries = getWebhookTestDeliveries(t, admin, id, WebhookDeliveryStatusFailed)
+	checkTestInt(t, 1, len(deliveries))
+
+	// Manual retry
+	receiver.setStatusCode(http.StatusNoContent)
+	res := executeTestRequest(newHTTPRequest("POST", "/webhook/"+id+"/delivery/"+deliveries[0].ID+"/retry", admin.ID, nil))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	num, _ = processWebhookQueue()
+	checkTestInt(t, 1, num)
+	deliveries = getWebhookTestDeliveries(t, admin, id, WebhookDeliveryStatusSucceeded)
+	checkTestInt(t, 1, len(deliveries))
+	if deliveries[0].Delivered == nil {
+		t.Fatal("Expected delivered time to be set")
+	}
+}
+
+func TestWebhooksPrivateNetworkForbidden(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	GetConfig().WebhookAllowPrivateNetworks = false
+	defer func() { GetConfig().WebhookAllowPrivateNetworks = true }()
+	id := createWebhookTestWebhook(t, admin, server.URL, `"booking.created"`)
+	createCalDavSyncTestBooking(t, user, s)
+
+	num, _ := processWebhookQueue()
+	checkTestInt(t, 0, num)
+	checkTestInt(t, 0, receiver.getNumRequests())
+	deliveries := getWebhookTestDeliveries(t, admin, id, WebhookDeliveryStatusPending)
+	checkTestInt(t, 1, len(deliveries))
+	checkTestInt(t, 0, deliveries[0].StatusCode)
+}
+
+func TestWebhooksNoRedirect(t *testing.T) {
+	clearTestDB()
+	org, _, s := createTestOrgWithSpace()
+	user := createTestUserInOrg(org)
+	admin := createTestUserOrgAdmin(org)
+	server, receiver := newWebhookTestServer()
+	defer server.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+	id := createWebhookTestWebhook(t, admin, redirect.URL, `"booking.created"`)
+	createCalDavSyncTestBooking(t, user, s)
+
+	num, _ := processWebhookQueue()
+	checkTestInt(t, 0, num)
+	checkTestInt(t, 0, receiver.getNumRequests())
+	deliveries := getWebhookTestDeliveries(t, admin, id, WebhookDeliveryStatusPending)
+	checkTestInt(t, 1, len(deliveries))
+	checkTestInt(t, http.StatusTemporaryRedirect, deliveries[0].StatusCode)
+}
+
+func TestWebhookIsPublicIP(t *testing.T) {
+	public := []string{"203.0.113.7", "8.8.8.8", "2001:4860:4860::8888"}
+	for _, s := range public {
+		checkTestBool(t, true, isPublicIP(net.ParseIP(s)))
+	}
+	internal := []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "::ffff:169.254.169.254"}
+	for _, s := range internal {
+		checkTestBool(t, false, isPublicIP(net.ParseIP(s)))
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	checkTestBool(t, true, getWebhookBackoff(1) == WebhookDeliveryInitialBackoff)
+	checkTestBool(t, true, getWebhookBackoff(2) == WebhookDeliveryInitialBackoff*2)
+	checkTestBool(t, true, getWebhookBackoff(3) == WebhookDeliveryInitialBackoff*4)
+	checkTestBool(t, true, getWebhookBackoff(20) == WebhookDeliveryMaxBackoff)
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	WebhookHeaderEvent     = "X-Seatsurfing-Event"
+	WebhookHeaderDelivery  = "X-Seatsurfing-Delivery"
+	WebhookHeaderTimestamp = "X-Seatsurfing-Timestamp"
+	WebhookHeaderSignature = "X-Seatsurfing-Signature"
+	WebhookRequestTimeout  = time.Second * 10
+)
+
+var ErrWebhookAddressForbidden = errors.New("webhook target address not allowed")
+
+// webhookForbiddenNetworks are special purpose ranges not covered by the
+// checks of net.IP, i.e. shared address space used by carrier-grade NAT.
+var webhookForbiddenNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"64:ff9b::/96",
+)
+
+// WebhookPayload is the JSON body posted to webhooks.
+type WebhookPayload struct {
+	Event          string      `json:"event"`
+	OrganizationID string      `json:"organizationId"`
+	Created        time.Time   `json:"created"`
+	Data           interface{} `json:"data"`
+}
+
+type WebhookBookingData struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"userId"`
+	UserEmail    string    `json:"userEmail"`
+	SpaceID      string    `json:"spaceId"`
+	SpaceName    string    `json:"spaceName"`
+	LocationID   string    `json:"locationId"`
+	LocationName string    `json:"locationName"`
+	Enter        time.Time `json:"enter"`
+	Leave        time.Time `json:"leave"`
+}
+
+type WebhookUserData struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Role  int    `json:"role"`
+}
+
+type WebhookSpaceData struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	LocationID string `json:"locationId"`
+}
+
+// fireWebhookEvent queues a delivery of the event for each webhook of the
+// organization subscribed to it. Deliveries are sent by processWebhookQueue.
+func fireWebhookEvent(organizationID string, event string, getData func() (interface{}, error)) {
+	list, err := GetWebhookRepository().GetAllForEvent(organizationID, event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(list) == 0 {
+		return
+	}
+	data, err := getData()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	now := time.Now().UTC()
+	payload, err := json.Marshal(&WebhookPayload{
+		Event:          event,
+		OrganizationID: organizationID,
+		Created:        now,
+		Data:           data,
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, webhook := range list {
+		delivery := &WebhookDelivery{
+			WebhookID:      webhook.ID,
+			OrganizationID: organizationID,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         WebhookDeliveryStatusPending,
+			NextAttempt:    now,
+			Created:        now,
+		}
+		if err := GetWebhookDeliveryRepository().Create(delivery); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func fireBookingWebhookEvent(e *Booking, event string) {
+	space, err := GetSpaceRepository().GetOne(e.SpaceID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	location, err := GetLocationRepository().GetOne(space.LocationID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fireWebhookEvent(location.OrganizationID, event, func() (interface{}, error) {
+		return getWebhookBookingData(e, space, location)
+	})
+}
+
+func fireUserWebhookEvent(e *User, event string) {
+	fireWebhookEvent(e.OrganizationID, event, func() (interface{}, error) {
+		return &WebhookUserData{
+			ID:    e.ID,
+			Email: e.Email,
+			Role:  int(e.Role),
+		}, nil
+	})
+}
+
+func fireSpaceWebhookEvent(e *Space, location *Location, event string) {
+	fireWebhookEvent(location.OrganizationID, event, func() (interface{}, error) {
+		return &WebhookSpaceData{
+			ID:         e.ID,
+			Name:       e.Name,
+			LocationID: location.ID,
+		}, nil
+	})
+}
+
+// getWebhookBookingData returns the event data for the booking. Bookings which
+// still exist are reloaded, so that enter and leave are always converted from
+// the stored wall clock times.
+func getWebhookBookingData(e *Booking, space *Space, location *Location) (*WebhookBookingData, error) {
+	if stored, err := GetBookingRepository().GetOne(e.ID); err == nil {
+		e = &stored.Booking
+	}
+	user, err := GetUserRepository().GetOne(e.UserID)
+	if err != nil {
+		return nil, err
+	}
+	enter, err := attachTimezoneInformation(e.Enter, location)
+	if err != nil {
+		return nil, err
+	}
+	leave, err := attachTimezoneInformation(e.Leave, location)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookBookingData{
+		ID:           e.ID,
+		UserID:       user.ID,
+		UserEmail:    user.Email,
+		SpaceID:      space.ID,
+		SpaceName:    space.Name,
+		LocationID:   location.ID,
+		LocationName: location.Name,
+		Enter:        enter,
+		Leave:        leave,
+	}, nil
+}
+
+// processWebhookQueue sends due deliveries and returns the number of
+// successful ones.
+func processWebhookQueue() (int, error) {
+	list, err := GetWebhookDeliveryRepository().Claim(time.Now().UTC(), WebhookDeliveryLease, WebhookDeliveryBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	num := 0
+	for _, delivery := range list {
+		if processWebhookDelivery(delivery) {
+			num++
+		}
+		if err := GetWebhookDeliveryRepository().Update(delivery); err != nil {
+			log.Println(err)
+		}
+	}
+	return num, nil
+}
+
+// processWebhookDelivery sends the delivery and updates its status
+// accordingly. Failed deliveries are retried with an exponential backoff.
+func processWebhookDelivery(delivery *WebhookDelivery) bool {
+	now := time.Now().UTC()
+	delivery.Attempts++
+	webhook, err := GetWebhookRepository().GetOne(delivery.WebhookID)
+	if err == nil && !webhook.Enabled {
+		err = errors.New("webhook disabled")
+	}
+	if err != nil {
+		delivery.Status = WebhookDeliveryStatusFailed
+		delivery.LastError = err.Error()
+		return false
+	}
+	delivery.StatusCode, err = sendWebhookRequest(webhook, delivery, now)
+	if err != nil {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= WebhookDeliveryMaxAttempts {
+			delivery.Status = WebhookDeliveryStatusFailed
+		} else {
+			delivery.NextAttempt = now.Add(getWebhookBackoff(delivery.Attempts))
+		}
+		return false
+	}
+	delivery.Status = WebhookDeliveryStatusSucceeded
+	delivery.LastError = ""
+	delivery.Delivered = &now
+	return true
+}
+
+func sendWebhookRequest(webhook *Webhook, delivery *WebhookDelivery, now time.Time) (int, error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Seatsurfing-Webhook")
+	req.Header.Set(WebhookHeaderEvent, delivery.Event)
+	req.Header.Set(WebhookHeaderDelivery, delivery.ID)
+	req.Header.Set(WebhookHeaderTimestamp, timestamp)
+	req.Header.Set(WebhookHeaderSignature, "sha256="+getWebhookSignature(webhook.Secret, timestamp, delivery.Payload))
+	resp, err := getWebhookHTTPClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.New("webhook returned status code " + strconv.Itoa(resp.StatusCode))
+	}
+	return resp.StatusCode, nil
+}
+
+// isValidWebhookURL returns true if s is an absolute HTTP(S) URL.
+func isValidWebhookURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// getWebhookHTTPClient returns the client for requests to URLs configured by
+// organization admins. Connections to loopback, link-local and private
+// addresses are refused once the host name has been resolved, so that internal
+// services can't be reached. Redirects are not followed for the same reason.
+func getWebhookHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: WebhookRequestTimeout,
+		Control: checkWebhookDialAddress,
+	}
+	return &http.Client{
+		Timeout: WebhookRequestTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: WebhookRequestTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func checkWebhookDialAddress(network, address string, c syscall.RawConn) error {
+	if GetConfig().WebhookAllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrWebhookAddressForbidden
+	}
+	return nil
+}
+
+// isPublicIP returns true if the address is globally routable.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range webhookForbiddenNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	res := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, network)
+	}
+	return res
+}
+
+// getWebhookSignature returns the hex encoded HMAC-SHA256 of the timestamp
+// and the payload, separated by a dot. Including the timestamp allows
+// receivers to reject replayed requests.
+func getWebhookSignature(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getWebhookBackoff returns the delay before the next attempt after the
+// specified number of failed attempts.
+func getWebhookBackoff(attempts int) time.Duration {
+	backoff := WebhookDeliveryInitialBackoff
+	for i := 1; i < attempts && backoff < WebhookDeliveryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > WebhookDeliveryMaxBackoff {
+		backoff = WebhookDeliveryMaxBackoff
+	}
+	return backoff
+}